@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"wolite/internal/auth"
+	"wolite/internal/backup"
+	"wolite/internal/env"
+	"wolite/internal/keyring"
+	"wolite/internal/store"
+)
+
+const usage = `usage: wolite [command]
+
+Without a command the server starts.
+
+commands:
+  rotate-jwt-key     make a new JWT signing key active, sessions signed with the old key stay valid until they expire
+  list-jwt-keys      list the JWT signing keys
+  rotate-master-key  encrypt the database and the JWT keyring with a new master key, the server must be stopped
+  backup FILE        write a backup archive, encrypted when BACKUP_PASSPHRASE is set, the server must be stopped
+  restore [-replace] [-dry-run] FILE
+                     merge a backup archive into the database, or replace it, the server must be stopped`
+
+// loadMasterKey returns the master key. A key file is generated on first start, but never once the
+// database holds encrypted secrets: a new key could not decrypt them.
+func loadMasterKey(config *env.Config) ([]byte, error) {
+	path := config.MasterKeyFile
+	encrypted, err := store.IsEncrypted(config.DatabasePath)
+	if err != nil {
+		return nil, err
+	}
+	if config.MasterKey == "" && withinDir(path, config.DataDir) {
+		slog.Warn("the master key file is in the data directory, so a copy of the data directory can decrypt its secrets; set MASTER_KEY or MASTER_KEY_FILE to keep the key elsewhere", "path", path)
+	}
+	if encrypted {
+		return keyring.ReadMasterKey(config.MasterKey, path)
+	}
+	return keyring.LoadMasterKey(config.MasterKey, path)
+}
+
+// withinDir reports whether path is inside dir.
+func withinDir(path, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// openStore opens the database, its secret fields encrypted with the master key.
+func openStore(config *env.Config, master []byte) (*store.Store, error) {
+	db, err := store.New(config.DatabasePath, master)
+	if errors.Is(err, keyring.ErrWrongKey) {
+		return nil, fmt.Errorf("%w: restore the original MASTER_KEY or master key file, after an interrupted rotate-master-key the new key is in the key file with .new appended", err)
+	}
+	return db, err
+}
+
+// openKeyring returns the JWT keyring: a fixed key when JWT_SECRET is set, otherwise the persisted
+// keyring in the data directory, encrypted with the master key.
+func openKeyring(config *env.Config, master []byte) (*keyring.Keyring, error) {
+	if config.JWTSecret != "" {
+		return keyring.Static([]byte(config.JWTSecret)), nil
+	}
+
+	keys, err := keyring.Open(filepath.Join(config.DataDir, "jwt_keys.json"), master)
+	if errors.Is(err, keyring.ErrWrongKey) {
+		return nil, fmt.Errorf("%w: restore the original MASTER_KEY or master key file, or delete jwt_keys.json to sign everyone out", err)
+	}
+	return keys, err
+}
+
+// rotateMasterKey encrypts the data key of the database and the JWT keyring with a new master key.
+// The encrypted values themselves stay as they are. The server must be stopped: it would write the data
+// key wrapped with the old master key back on its next change.
+func rotateMasterKey(config *env.Config) error {
+	unlock, err := store.LockDataDir(config.DataDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keyPath := config.MasterKeyFile
+	master, err := keyring.ReadMasterKey(config.MasterKey, keyPath)
+	if err != nil {
+		return err
+	}
+	db, err := openStore(config, master)
+	if err != nil {
+		return err
+	}
+	keys, err := openKeyring(config, master)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := keyring.GenerateMasterKey()
+	if err != nil {
+		return err
+	}
+	newMaster, err := keyring.ReadMasterKey(encoded, "")
+	if err != nil {
+		return err
+	}
+
+	// Keep the new key on disk before anything is encrypted with it
+	pending := keyPath + ".new"
+	if config.MasterKey == "" {
+		if err := keyring.WriteMasterKey(pending, encoded); err != nil {
+			return err
+		}
+	}
+	if err := db.RotateMasterKey(newMaster); err != nil {
+		return err
+	}
+	if err := keys.Rekey(newMaster); err != nil {
+		// Roll back so the old key opens everything again
+		if rerr := db.RotateMasterKey(master); rerr != nil {
+			return fmt.Errorf("%w, and the database is left encrypted with the new key: %v", err, rerr)
+		}
+		return err
+	}
+
+	if config.MasterKey != "" {
+		fmt.Println("master key rotated, set MASTER_KEY to the new key before starting the server:")
+		fmt.Println(encoded)
+		return nil
+	}
+	if err := os.Rename(pending, keyPath); err != nil {
+		return fmt.Errorf("data is encrypted with the new key in %s, move it to %s: %w", pending, keyPath, err)
+	}
+	fmt.Println("master key rotated, new key written to", keyPath)
+	return nil
+}
+
+// runCommand runs a maintenance subcommand.
+func runCommand(config *env.Config, args []string) error {
+	switch args[0] {
+	case "rotate-jwt-key":
+		master, err := loadMasterKey(config)
+		if err != nil {
+			return err
+		}
+		keys, err := openKeyring(config, master)
+		if err != nil {
+			return err
+		}
+		kid, err := keys.Rotate(auth.SigningKeyRetention(config.JWTExpiry))
+		if err != nil {
+			return err
+		}
+		fmt.Println("new signing key:", kid)
+		return nil
+	case "list-jwt-keys":
+		master, err := loadMasterKey(config)
+		if err != nil {
+			return err
+		}
+		keys, err := openKeyring(config, master)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys.Keys() {
+			state := "retired"
+			if k.Active {
+				state = "active"
+			}
+			fmt.Printf("%s\t%s\tcreated %s\n", k.ID, state, k.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		return nil
+	case "rotate-master-key":
+		return rotateMasterKey(config)
+	case "backup":
+		if len(args) != 2 {
+			return fmt.Errorf("missing file\n\n%s", usage)
+		}
+		return backupTo(config, args[1])
+	case "restore":
+		return restoreFrom(config, args[1:])
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command\n\n%s", usage)
+	}
+}
+
+// backupTo writes a backup archive of the database to path, readable by the owner only.
+func backupTo(config *env.Config, path string) error {
+	// Opening the store can write migrations and encryption back to the file, which must not race a running server
+	unlock, err := store.LockDataDir(config.DataDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	master, err := loadMasterKey(config)
+	if err != nil {
+		return err
+	}
+	db, err := openStore(config, master)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	passphrase := os.Getenv("BACKUP_PASSPHRASE")
+	if err := backup.Write(f, db.Snapshot(), passphrase); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if passphrase == "" {
+		fmt.Println("backup written to", path, "(unencrypted, it holds password hashes and secrets)")
+	} else {
+		fmt.Println("backup written to", path, "(encrypted)")
+	}
+	return nil
+}
+
+// restoreFrom imports a backup archive into the database.
+func restoreFrom(config *env.Config, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	replace := flags.Bool("replace", false, "replace the database instead of merging")
+	dryRun := flags.Bool("dry-run", false, "only validate the archive")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("missing file\n\n%s", usage)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	snap, archive, err := backup.Read(f, os.Getenv("BACKUP_PASSPHRASE"))
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		unlock, err := store.LockDataDir(config.DataDir)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	master, err := loadMasterKey(config)
+	if err != nil {
+		return err
+	}
+	db, err := openStore(config, master)
+	if err != nil {
+		return err
+	}
+	mode := store.ImportMerge
+	if *replace {
+		mode = store.ImportReplace
+	}
+	result, err := db.Import(*snap, mode, *dryRun, "")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("backup from %s, %s:\n", archive.CreatedAt.Format("2006-01-02 15:04:05"), mode)
+	counts := maps.Clone(result.Imported)
+	maps.Copy(counts, result.Skipped)
+	for _, name := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Printf("  %s\t%d imported, %d skipped\n", name, result.Imported[name], result.Skipped[name])
+	}
+	if *dryRun {
+		fmt.Println("dry run, nothing was changed")
+	}
+	return nil
+}
@@ -9,4 +9,7 @@ require (
 	golang.org/x/crypto v0.47.0
 )
 
-require github.com/boombuler/barcode v1.0.1-0.20190219062509-6c824513bacc // indirect
+require (
+	github.com/boombuler/barcode v1.0.1-0.20190219062509-6c824513bacc // indirect
+	golang.org/x/sys v0.40.0 // indirect
+)
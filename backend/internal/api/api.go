@@ -2,22 +2,95 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
+	"log/slog"
 	"net/http"
+	"wolite/internal/audit"
 	"wolite/internal/env"
+	"wolite/internal/keyring"
+	"wolite/internal/ldap"
+	"wolite/internal/lockout"
+	"wolite/internal/oidc"
 	"wolite/internal/store"
+	"wolite/internal/webauthn"
+	"wolite/internal/worker"
 )
 
 type API struct {
 	Context context.Context
 	store   *store.Store
 	config  *env.Config
+	runner  *worker.Runner
+	keys    *keyring.Keyring
+	audit   *audit.Logger
+
+	// Failed login tracking
+	loginIPs      *lockout.Tracker
+	loginAccounts *lockout.Tracker
+
+	ceremonies  *webauthn.Challenges // pending WebAuthn registrations and logins
+	crossOrigin *http.CrossOriginProtection
+
+	// OpenID Connect single sign-on, nil when not configured
+	oidc       *oidc.Provider
+	oidcLogins *oidc.Logins
+
+	// LDAP directory for password logins, nil when not configured
+	ldap *ldap.Directory
 }
 
-func NewAPI(ctx context.Context, store *store.Store, config *env.Config) *API {
+func NewAPI(ctx context.Context, store *store.Store, config *env.Config, runner *worker.Runner, keys *keyring.Keyring, audit *audit.Logger) *API {
+	var provider *oidc.Provider
+	if config.OIDC.Issuer != "" {
+		provider = oidc.NewProvider(oidc.Config{
+			Issuer:       config.OIDC.Issuer,
+			ClientID:     config.OIDC.ClientID,
+			ClientSecret: config.OIDC.ClientSecret,
+			Scopes:       config.OIDC.Scopes,
+		})
+	}
+
+	var directory *ldap.Directory
+	if config.LDAP.URL != "" {
+		directory = ldap.New(ldap.Config{
+			URL:      config.LDAP.URL,
+			StartTLS: config.LDAP.StartTLS,
+			TLSConfig: &tls.Config{
+				RootCAs:            config.LDAP.RootCAs,
+				InsecureSkipVerify: config.LDAP.TLSSkipVerify,
+			},
+			BindDN:         config.LDAP.BindDN,
+			BindPassword:   config.LDAP.BindPassword,
+			BaseDN:         config.LDAP.BaseDN,
+			UserFilter:     config.LDAP.UserFilter,
+			Attributes:     []string{config.LDAP.UsernameAttr},
+			GroupAttribute: config.LDAP.GroupAttr,
+			GroupBaseDN:    config.LDAP.GroupBaseDN,
+			GroupFilter:    config.LDAP.GroupFilter,
+		})
+	}
+
+	crossOrigin := http.NewCrossOriginProtection()
+	for _, origin := range config.AllowedOrigins {
+		if err := crossOrigin.AddTrustedOrigin(origin); err != nil {
+			slog.Error("invalid allowed origin", "origin", origin, "error", err)
+		}
+	}
+
 	return &API{
-		Context: ctx,
-		store:   store,
-		config:  config,
+		Context:       ctx,
+		store:         store,
+		config:        config,
+		runner:        runner,
+		keys:          keys,
+		audit:         audit,
+		loginIPs:      lockout.New(loginIPPolicy),
+		loginAccounts: lockout.New(loginAccountPolicy),
+		ceremonies:    webauthn.NewChallenges(),
+		crossOrigin:   crossOrigin,
+		oidc:          provider,
+		oidcLogins:    oidc.NewLogins(),
+		ldap:          directory,
 	}
 }
 
@@ -28,11 +101,13 @@ func (a *API) RegisterRoutesV1(mux *http.ServeMux) {
 	standard := []middleware{
 		Logger,
 		Recoverer,
+		a.CheckOrigin,
 	}
 
 	authStack := []middleware{
 		Logger,
 		Recoverer,
+		a.CheckOrigin,
 		a.Auth,
 	}
 
@@ -51,36 +126,164 @@ func (a *API) RegisterRoutesV1(mux *http.ServeMux) {
 		handle(pattern, handler, standard)
 	}
 
-	// Wrapper for auth middleware
+	// Wrapper for auth middleware (browser sessions only)
 	handleAuth := func(pattern string, handler func(http.ResponseWriter, *http.Request)) {
-		handle(pattern, handler, authStack)
+		handle(pattern, handler, append(authStack[:len(authStack):len(authStack)], a.RequireScope("")))
+	}
+
+	// Wrapper for auth middleware restricted to administrators (browser sessions only)
+	handleAdmin := func(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+		handle(pattern, handler, append(authStack[:len(authStack):len(authStack)], a.RequireScope(""), a.RequireAdmin))
+	}
+
+	// Wrapper for auth middleware that also accepts personal access tokens with at least the given scope
+	handleToken := func(pattern string, scope store.TokenScope, handler func(http.ResponseWriter, *http.Request)) {
+		handle(pattern, handler, append(authStack[:len(authStack):len(authStack)], a.RequireScope(scope)))
 	}
 
 	// User routes
-	handlePublic("POST "+p+"/users", a.handleUserCreate)             // create a new user (for initial setup)
-	handleAuth("PUT "+p+"/users", a.handleUserUpdate)                // update the user (e.g. change password)
-	handleAuth("POST "+p+"/users/otp/verify", a.handleUserOTPVerify) // verify and enable OTP
+	handlePublic("POST "+p+"/users", a.handleUserCreate)                                   // create a new user (for initial setup)
+	handleAuth("PUT "+p+"/users", a.handleUserUpdate)                                      // update the user (e.g. change password)
+	handleAuth("POST "+p+"/users/otp/verify", a.handleUserOTPVerify)                       // verify and enable OTP, returns recovery codes
+	handleAuth("POST "+p+"/users/otp/disable", a.handleUserOTPDisable)                     // disable OTP (password and OTP required)
+	handleAuth("POST "+p+"/users/otp/reenroll", a.handleUserOTPReenroll)                   // start enrolling a new authenticator (password and OTP required)
+	handleAuth("POST "+p+"/users/otp/recovery-codes", a.handleUserRecoveryCodesRegenerate) // replace recovery codes (password and OTP required)
+
+	// Passkey routes
+	handleAuth("GET "+p+"/users/webauthn/credentials", a.handleWebAuthnCredentialsGetAll)                             // list the user's passkeys
+	handleAuth("PUT "+p+"/users/webauthn/credentials/{id}", a.handleWebAuthnCredentialUpdate)                         // rename a passkey
+	handleAuth("DELETE "+p+"/users/webauthn/credentials/{id}", a.requireRecentAuth(a.handleWebAuthnCredentialDelete)) // remove a passkey
 
 	// Device routes
-	handleAuth("GET "+p+"/devices", a.handleDevicesGetAll)          // list all devices the user has access to
-	handleAuth("POST "+p+"/devices", a.handleDeviceCreate)          // create a new device to the user's account
-	handleAuth("GET "+p+"/devices/{id}", a.handleDeviceGet)         // get a specific device by ID
-	handleAuth("PUT "+p+"/devices/{id}", a.handleDeviceUpdate)      // update a specific device by ID
-	handleAuth("DELETE "+p+"/devices/{id}", a.handleDeviceDelete)   // delete a specific device by ID
-	handleAuth("PUT "+p+"/devices/reorder", a.handleDevicesReorder) // reorder devices
+	handleToken("GET "+p+"/devices", store.ScopeRead, a.handleDevicesGetAll)           // list all devices the user has access to (filter: group, tag, status, q)
+	handleAuth("POST "+p+"/devices", a.handleDeviceCreate)                             // create a new device to the user's account
+	handleToken("GET "+p+"/devices/{id}", store.ScopeRead, a.handleDeviceGet)          // get a specific device by ID
+	handleAuth("PUT "+p+"/devices/{id}", a.handleDeviceUpdate)                         // update a specific device by ID
+	handleAuth("DELETE "+p+"/devices/{id}", a.requireRecentAuth(a.handleDeviceDelete)) // delete a specific device by ID
+	handleAuth("PUT "+p+"/devices/reorder", a.handleDevicesReorder)                    // reorder devices
 
 	// Device Actions:
-	handleAuth("POST "+p+"/devices/{id}/wake", a.handleDeviceWake) // wake a specific device by ID
+	handleToken("POST "+p+"/devices/{id}/wake", store.ScopeWake, a.handleDeviceWake) // wake a specific device by ID
+
+	// Device history routes (revisions and the trash of deleted devices)
+	handleAuth("GET "+p+"/devices/{id}/revisions", a.handleDeviceRevisionsGet)                        // list configuration changes of a device
+	handleAuth("POST "+p+"/devices/{id}/revisions/{revision}/restore", a.handleDeviceRevisionRestore) // go back to the configuration of a revision
+	handleAuth("GET "+p+"/device-trash", a.handleDeletedDevicesGet)                                   // list deleted devices that can be restored
+	handleAuth("POST "+p+"/device-trash/{id}/restore", a.handleDeletedDeviceRestore)                  // restore a deleted device
+	handleAuth("DELETE "+p+"/device-trash/{id}", a.requireRecentAuth(a.handleDeletedDevicePurge))     // delete a device for good
+
+	// Device access routes (sharing a device with other users)
+	handleAuth("GET "+p+"/devices/{id}/access", a.handleDeviceAccessGet)                                       // list everyone with access to an owned device
+	handleAuth("PUT "+p+"/devices/{id}/access/{username}", a.requireRecentAuth(a.handleDeviceAccessSet))       // share a device as owner or request only
+	handleAuth("DELETE "+p+"/devices/{id}/access/{username}", a.requireRecentAuth(a.handleDeviceAccessDelete)) // take a device away from a user
+	handleAuth("GET "+p+"/devices/requestable", a.handleRequestableDevicesGet)                                 // list devices the user may request a wake of
+
+	// Wake request routes (approval workflow for users with request access)
+	handleAuth("POST "+p+"/devices/{id}/wake-requests", a.handleWakeRequestCreate)                            // ask the owners to wake a device
+	handleAuth("GET "+p+"/wake-requests", a.handleWakeRequestsGetAll)                                         // list own requests and those to decide on (filter: status)
+	handleAuth("POST "+p+"/wake-requests/{id}/approve", a.handleWakeRequestDecide(store.WakeRequestApproved)) // approve and wake the device
+	handleAuth("POST "+p+"/wake-requests/{id}/deny", a.handleWakeRequestDecide(store.WakeRequestDenied))      // deny a request
+	handleAuth("DELETE "+p+"/wake-requests/{id}", a.handleWakeRequestCancel)                                  // withdraw a pending request
+
+	// Notification routes (in-app)
+	handleAuth("GET "+p+"/notifications", a.handleNotificationsGetAll)          // list notifications (filter: unread)
+	handleAuth("POST "+p+"/notifications/read", a.handleNotificationsRead)      // mark all notifications read
+	handleAuth("POST "+p+"/notifications/{id}/read", a.handleNotificationsRead) // mark a notification read
+
+	// Group routes
+	handleToken("GET "+p+"/groups", store.ScopeRead, a.handleGroupsGetAll)                                                      // list the user's groups
+	handleAuth("POST "+p+"/groups", a.handleGroupCreate)                                                                        // create a new group
+	handleAuth("PUT "+p+"/groups/{id}", a.handleGroupUpdate)                                                                    // rename a group
+	handleAuth("DELETE "+p+"/groups/{id}", a.handleGroupDelete)                                                                 // delete a group (devices become ungrouped)
+	handleAuth("PUT "+p+"/groups/reorder", a.handleGroupsReorder)                                                               // reorder groups
+	handleToken("GET "+p+"/groups/{id}/devices", store.ScopeRead, a.handleGroupDevicesGet)                                      // list devices in a group
+	handleToken("POST "+p+"/groups/{id}/wake", store.ScopeWake, a.handleGroupWake)                                              // wake every device in a group
+	handleToken("POST "+p+"/groups/{id}/companion/action", store.ScopePower, a.requireRecentAuth(a.handleGroupCompanionAction)) // send command to every companion in a group
+
+	// Scene routes
+	handleToken("GET "+p+"/scenes", store.ScopeRead, a.handleScenesGetAll)           // list the user's scenes
+	handleAuth("POST "+p+"/scenes", a.handleSceneCreate)                             // create a new scene
+	handleToken("GET "+p+"/scenes/{id}", store.ScopeRead, a.handleSceneGet)          // get a specific scene by ID
+	handleAuth("PUT "+p+"/scenes/{id}", a.handleSceneUpdate)                         // update a specific scene by ID
+	handleAuth("DELETE "+p+"/scenes/{id}", a.handleSceneDelete)                      // delete a specific scene by ID
+	handleToken("POST "+p+"/scenes/{id}/run", store.ScopePower, a.handleSceneRun)    // run a scene in the background
+	handleToken("GET "+p+"/scenes/{id}/runs", store.ScopeRead, a.handleSceneRunsGet) // list recent runs of a scene
+
+	// Schedule routes
+	handleToken("GET "+p+"/schedules", store.ScopeRead, a.handleSchedulesGetAll)            // list the user's schedules
+	handleAuth("POST "+p+"/schedules", a.handleScheduleCreate)                              // create a new schedule
+	handleToken("GET "+p+"/schedules/{id}", store.ScopeRead, a.handleScheduleGet)           // get a specific schedule by ID
+	handleAuth("PUT "+p+"/schedules/{id}", a.handleScheduleUpdate)                          // update a specific schedule by ID
+	handleAuth("DELETE "+p+"/schedules/{id}", a.handleScheduleDelete)                       // delete a schedule and its history
+	handleAuth("POST "+p+"/schedules/{id}/enable", a.handleScheduleSetEnabled(true))        // enable a schedule
+	handleAuth("POST "+p+"/schedules/{id}/disable", a.handleScheduleSetEnabled(false))      // disable a schedule
+	handleToken("GET "+p+"/schedules/{id}/next", store.ScopeRead, a.handleScheduleNextRuns) // preview the next run times
+	handleToken("GET "+p+"/schedules/{id}/runs", store.ScopeRead, a.handleScheduleRunsGet)  // run history of a schedule
+
+	// Calendar routes (holidays and exceptions for schedules)
+	handleAuth("GET "+p+"/calendars", a.handleCalendarsGetAll)             // list the user's calendars
+	handleAuth("POST "+p+"/calendars", a.handleCalendarCreate)             // create a new calendar
+	handleAuth("GET "+p+"/calendars/{id}", a.handleCalendarGet)            // get a specific calendar by ID
+	handleAuth("PUT "+p+"/calendars/{id}", a.handleCalendarUpdate)         // update a specific calendar by ID
+	handleAuth("DELETE "+p+"/calendars/{id}", a.handleCalendarDelete)      // delete a calendar
+	handleAuth("POST "+p+"/calendars/{id}/import", a.handleCalendarImport) // import events from an .ics file
+
+	// Run routes (background step execution)
+	handleToken("GET "+p+"/runs", store.ScopeRead, a.handleRunsGetAll)             // list the user's recent runs
+	handleToken("GET "+p+"/runs/{id}", store.ScopeRead, a.handleRunGet)            // get progress and result of a run
+	handleToken("POST "+p+"/runs/{id}/cancel", store.ScopeWake, a.handleRunCancel) // cancel a run
 
 	// Companion routes
-	handleAuth("POST "+p+"/devices/{id}/companion/pair", a.handleDeviceCompanionPair)     // pair with companion
-	handleAuth("POST "+p+"/devices/{id}/companion/unpair", a.handleDeviceCompanionUnpair) // unpair from companion
-	handleAuth("POST "+p+"/devices/{id}/companion/action", a.handleDeviceCompanionAction) // send command to companion
-	handleAuth("GET "+p+"/devices/{id}/companion/status", a.handleDeviceCompanionStatus)  // get companion status
+	handleAuth("POST "+p+"/devices/{id}/companion/pair", a.handleDeviceCompanionPair)                                             // pair with companion
+	handleAuth("POST "+p+"/devices/{id}/companion/unpair", a.requireRecentAuth(a.handleDeviceCompanionUnpair))                    // unpair from companion
+	handleToken("POST "+p+"/devices/{id}/companion/action", store.ScopePower, a.requireRecentAuth(a.handleDeviceCompanionAction)) // send command to companion
+	handleToken("GET "+p+"/devices/{id}/companion/status", store.ScopeRead, a.handleDeviceCompanionStatus)                        // get companion status
+	handleToken("GET "+p+"/devices/{id}/companion/idle", store.ScopeRead, a.handleDeviceCompanionIdle)                            // get user idle time and sessions
+	handleAuth("PUT "+p+"/devices/{id}/idle-policy", a.handleDeviceIdlePolicyUpdate)                                              // set automatic idle power action
+	handleAuth("DELETE "+p+"/devices/{id}/idle-policy", a.handleDeviceIdlePolicyDelete)                                           // remove automatic idle power action
+
+	// Personal access token routes
+	handleAuth("GET "+p+"/tokens", a.handleTokensGetAll)                      // list the user's access tokens
+	handleAuth("POST "+p+"/tokens", a.requireRecentAuth(a.handleTokenCreate)) // create an access token (returned once)
+	handleAuth("DELETE "+p+"/tokens/{id}", a.handleTokenDelete)               // revoke an access token
+
+	// Share link routes (wake one device without an account)
+	handleAuth("GET "+p+"/share-links", a.handleShareLinksGetAll)                      // list the user's share links and their uses
+	handleAuth("POST "+p+"/share-links", a.requireRecentAuth(a.handleShareLinkCreate)) // create a share link (returned once)
+	handleAuth("DELETE "+p+"/share-links/{id}", a.handleShareLinkRevoke)               // revoke a share link
+	handlePublic("GET "+p+"/share/{token}", a.handleShareLinkGet)                      // describe a share link for its landing page
+	handlePublic("POST "+p+"/share/{token}/wake", a.handleShareLinkWake)               // wake the shared device (PIN if required)
+
+	// Session routes (browser logins)
+	handleAuth("GET "+p+"/sessions", a.handleSessionsGetAll)        // list the user's active sessions
+	handleAuth("DELETE "+p+"/sessions/{id}", a.handleSessionDelete) // revoke a session
+
+	// Admin routes
+	handleAdmin("GET "+p+"/admin/jwt-keys", a.handleJWTKeysGet)                                // list signing keys (no secrets)
+	handleAdmin("POST "+p+"/admin/jwt-keys/rotate", a.handleJWTKeysRotate)                     // rotate the signing key
+	handleAdmin("GET "+p+"/admin/lockouts", a.handleLockoutsGet)                               // list locked accounts and IPs
+	handleAdmin("POST "+p+"/admin/lockouts/unlock", a.handleLockoutUnlock)                     // clear failed logins of an account or IP
+	handleAdmin("POST "+p+"/admin/users/{username}/otp/reset", a.handleAdminUserOTPReset)      // remove a user's 2FA
+	handleAdmin("GET "+p+"/admin/audit", a.handleAuditGet)                                     // query the audit log
+	handleAdmin("GET "+p+"/admin/audit/export", a.handleAuditExport)                           // download the audit log as CSV or JSON lines
+	handleAdmin("POST "+p+"/admin/backup", a.requireRecentAuth(a.handleBackupExport))          // download a backup archive, encrypted with an optional passphrase
+	handleAdmin("POST "+p+"/admin/backup/restore", a.requireRecentAuth(a.handleBackupRestore)) // merge or replace the state with a backup archive
 
 	// Auth routes
-	handleAuth("GET "+p+"/auth/status", a.handleAuthStatus)             // check if the user is authenticated
-	handlePublic("POST "+p+"/auth/login", a.handleAuthLogin)            // login with username and password (optionally OTP)
-	handlePublic("GET "+p+"/auth/initialized", a.handleAuthInitialized) // check if app has users
-	handlePublic("POST "+p+"/auth/logout", a.handleAuthLogout)          // logout the user
+	handleToken("GET "+p+"/auth/status", store.ScopeRead, a.handleAuthStatus)                                   // check if the user is authenticated
+	handleAuth("GET "+p+"/auth/csrf", a.handleAuthCSRF)                                                         // get the CSRF token for state-changing requests
+	handlePublic("POST "+p+"/auth/login", a.handleAuthLogin)                                                    // login with username and password (optionally OTP)
+	handleAuth("POST "+p+"/auth/webauthn/register/begin", a.requireRecentAuth(a.handleWebAuthnRegisterBegin))   // start registering a passkey
+	handleAuth("POST "+p+"/auth/webauthn/register/finish", a.requireRecentAuth(a.handleWebAuthnRegisterFinish)) // store a verified passkey
+	handlePublic("POST "+p+"/auth/webauthn/login/begin", a.handleWebAuthnLoginBegin)                            // start a passkey login (second factor or passwordless)
+	handlePublic("POST "+p+"/auth/webauthn/login/finish", a.handleWebAuthnLoginFinish)                          // login with a verified passkey
+	handlePublic("GET "+p+"/auth/providers", a.handleAuthProviders)                                             // login methods besides password (e.g. SSO)
+	handlePublic("GET "+p+"/auth/oidc/login", a.handleOIDCLogin)                                                // redirect to the identity provider
+	handlePublic("GET "+p+"/auth/oidc/callback", a.handleOIDCCallback)                                          // complete the login from the identity provider
+	handlePublic("GET "+p+"/auth/initialized", a.handleAuthInitialized)                                         // check if app has users
+	handlePublic("POST "+p+"/auth/logout", a.handleAuthLogout)                                                  // logout the user
+	handleAuth("POST "+p+"/auth/logout-all", a.handleAuthLogoutAll)                                             // revoke every session of the user
+	handleAuth("POST "+p+"/auth/reauth", a.handleAuthReauth)                                                    // confirm identity with password (and OTP) for sensitive actions
+	handleAuth("POST "+p+"/auth/reauth/webauthn/begin", a.handleReauthWebAuthnBegin)                            // start confirming identity with a passkey
+	handleAuth("POST "+p+"/auth/reauth/webauthn/finish", a.handleReauthWebAuthnFinish)                          // confirm identity with a verified passkey
 }
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIP returns the address of the client. X-Forwarded-For is only honoured when the connection
+// comes from a trusted proxy; the header is then read right to left, skipping further trusted proxies,
+// so a client cannot spoof its address by sending the header itself.
+func (a *API) clientIP(r *http.Request) string {
+	remote := remoteAddr(r)
+	if !a.trustedProxy(remote) {
+		return remote.String()
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = addr.Unmap()
+		if !a.trustedProxy(addr) {
+			return addr.String()
+		}
+		remote = addr
+	}
+	return remote.String()
+}
+
+func (a *API) trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range a.config.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// requestScheme returns "https" when the client connected over TLS, either directly or to a trusted
+// proxy that reports it in X-Forwarded-Proto.
+func (a *API) requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if a.trustedProxy(remoteAddr(r)) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"time"
+	"wolite/internal/auth"
+)
+
+const (
+	csrfCookie = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// setCSRFCookie hands the CSRF token to the frontend. It is readable by scripts on purpose: only pages
+// of this site can read it and echo it in the X-CSRF-Token header.
+func (a *API) setCSRFCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Expires:  expires,
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+		Secure:   a.secureCookies(r),
+	})
+}
+
+// validateCSRF checks the X-CSRF-Token header of a state-changing request authenticated by a cookie.
+// Browser sessions must send their session's token (synchronizer token). With forward auth the proxy's
+// own cookie authenticates the request, so the header must match the csrf_token cookie (double submit).
+func (a *API) validateCSRF(r *http.Request, claims *auth.Claims, viaProxy bool) error {
+	if safeMethod(r.Method) {
+		return nil
+	}
+	header := r.Header.Get(csrfHeader)
+	if header == "" {
+		return ErrCSRF
+	}
+
+	var want string
+	if viaProxy {
+		c, err := r.Cookie(csrfCookie)
+		if err != nil {
+			return ErrCSRF
+		}
+		want = c.Value
+	} else {
+		session, err := a.store.GetSession(claims.ID)
+		if err != nil {
+			return ErrUnauthorized
+		}
+		want = session.CSRFToken
+	}
+	if want == "" || subtle.ConstantTimeCompare([]byte(header), []byte(want)) != 1 {
+		return ErrCSRF
+	}
+	return nil
+}
+
+// handleAuthCSRF returns the CSRF token and sets its cookie, creating one for sessions from before
+// CSRF tokens existed and for forward auth users. (jwt protected)
+func (a *API) handleAuthCSRF(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var token string
+	expires := time.Now().Add(a.config.JWTExpiry)
+	if viaForwardAuth(r.Context()) {
+		if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+			token = c.Value
+		}
+	} else {
+		session, err := a.store.GetSession(claims.ID)
+		if err != nil {
+			writeRespErr(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+		token, expires = session.CSRFToken, session.ExpiresAt
+	}
+
+	if token == "" {
+		var err error
+		if token, err = auth.GenerateCSRFToken(); err != nil {
+			writeRespErr(w, "Failed to generate token", http.StatusInternalServerError)
+			slog.Error("failed to generate csrf token", "error", err)
+			return
+		}
+		if !viaForwardAuth(r.Context()) {
+			if err := a.store.SetSessionCSRFToken(claims.ID, token); err != nil {
+				writeRespErr(w, "Failed to generate token", http.StatusInternalServerError)
+				slog.Error("failed to store csrf token", "session_id", claims.ID, "error", err)
+				return
+			}
+		}
+	}
+
+	a.setCSRFCookie(w, r, token, expires)
+	writeRespOk(w, "csrf token", map[string]string{"token": token})
+}
+
+// CheckOrigin rejects state-changing requests a browser sends from another site, using Sec-Fetch-Site or,
+// in older browsers, the Origin header. ALLOWED_ORIGINS may send them. This also covers public endpoints
+// such as the login, which have no CSRF token yet.
+func (a *API) CheckOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := a.crossOrigin.Check(r); err != nil {
+			slog.Warn("cross-origin request rejected", "path", r.URL.Path, "origin", r.Header.Get("Origin"))
+			writeRespErr(w, "Cross-origin request not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -5,4 +5,5 @@ import "errors"
 var (
 	ErrUnauthorized   = errors.New("unauthorized")
 	ErrInvalidRequest = errors.New("invalid request")
+	ErrCSRF           = errors.New("invalid csrf token")
 )
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"wolite/internal/env"
+	"wolite/internal/store"
+)
+
+func newForwardAuthAPI(t *testing.T) *API {
+	t.Helper()
+	s, err := store.New(filepath.Join(t.TempDir(), "wolite.json"), nil)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if err := s.CreateUser(store.User{Username: "fay", Provider: store.ProviderProxy}); err != nil {
+		t.Fatal(err)
+	}
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	return &API{store: s, config: &env.Config{
+		TrustedProxies: proxies,
+		ForwardAuth:    env.ForwardAuthConfig{Header: "Remote-User", Proxies: proxies, AutoProvision: true},
+	}}
+}
+
+func TestForwardAuth(t *testing.T) {
+	a := newForwardAuthAPI(t)
+	handler := a.Auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims := GetUserFromContext(r.Context()); claims == nil || claims.Username != "fay" {
+			t.Errorf("expected fay in the context, got %+v", claims)
+		}
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       int
+	}{
+		{"trusted proxy", "10.0.0.2:4000", map[string]string{"Remote-User": "fay"}, http.StatusOK},
+		{"untrusted address", "192.0.2.1:4000", map[string]string{"Remote-User": "fay"}, http.StatusUnauthorized},
+		{"spoofed forwarded for", "192.0.2.1:4000", map[string]string{"Remote-User": "fay", "X-Forwarded-For": "10.0.0.2"}, http.StatusUnauthorized},
+		{"blank username", "10.0.0.2:4000", map[string]string{"Remote-User": "   "}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/devices", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+
+	if _, err := a.store.FindUser(""); err != store.ErrUserNotFound {
+		t.Errorf("expected no user provisioned for a blank header, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"wolite/internal/audit"
+	"wolite/internal/store"
+)
+
+type deviceAccessRequest struct {
+	Access store.DeviceAccess `json:"access"`
+}
+
+// requestableDevice is what users with request access see of a device.
+type requestableDevice struct {
+	MACAddress  string       `json:"mac_address"`
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Status      store.Status `json:"status"`
+}
+
+// handleDeviceAccessGet lists everyone with access to a device the user owns. (jwt protected)
+func (a *API) handleDeviceAccessGet(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, err := a.store.GetDeviceForUser(claims.Username, id); err != nil {
+		writeRespErr(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	users, err := a.store.GetDeviceUsers(id)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve device access", http.StatusInternalServerError)
+		slog.Error("failed to retrieve device access", "username", claims.Username, "mac_address", id, "error", err)
+		return
+	}
+
+	type entry struct {
+		Username string             `json:"username"`
+		Access   store.DeviceAccess `json:"access"`
+	}
+	resp := make([]entry, len(users))
+	for i, m := range users {
+		resp[i] = entry{Username: m.Username, Access: m.Access}
+	}
+	writeRespOk(w, "device access retrieved", resp)
+}
+
+// handleDeviceAccessSet shares a device the user owns with another user, or changes their access. (jwt protected)
+func (a *API) handleDeviceAccessSet(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id, username := r.PathValue("id"), r.PathValue("username")
+	if _, err := a.store.GetDeviceForUser(claims.Username, id); err != nil {
+		writeRespErr(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	var req deviceAccessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !req.Access.Valid() {
+		writeRespErr(w, "access must be owner or request", http.StatusBadRequest)
+		return
+	}
+
+	err := a.store.SetDeviceAccess(username, id, req.Access)
+	if err != nil && err == store.ErrUserNotFound {
+		writeRespErr(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil && err == store.ErrLastOwner {
+		writeRespErr(w, "The device must keep at least one owner", http.StatusConflict)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to update device access", http.StatusInternalServerError)
+		slog.Error("failed to update device access", "username", claims.Username, "mac_address", id, "target", username, "error", err)
+		return
+	}
+
+	a.audit.Log(audit.Entry{Action: audit.ActionDeviceAccessChanged, Actor: claims.Username, Username: username, Target: id, IP: a.clientIP(r), Detail: string(req.Access)})
+	writeRespOk(w, "device access updated", nil)
+	slog.Info("device access updated", "username", claims.Username, "mac_address", id, "target", username, "access", req.Access)
+}
+
+// handleDeviceAccessDelete takes a device the user owns away from a user. (jwt protected)
+func (a *API) handleDeviceAccessDelete(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id, username := r.PathValue("id"), r.PathValue("username")
+	if _, err := a.store.GetDeviceForUser(claims.Username, id); err != nil {
+		writeRespErr(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	err := a.store.RemoveDeviceFromUser(username, id)
+	if err != nil && err == store.ErrUserDeviceMappingNotFound {
+		writeRespErr(w, "User has no access to this device", http.StatusNotFound)
+		return
+	} else if err != nil && err == store.ErrLastOwner {
+		writeRespErr(w, "The device must keep at least one owner", http.StatusConflict)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to remove device access", http.StatusInternalServerError)
+		slog.Error("failed to remove device access", "username", claims.Username, "mac_address", id, "target", username, "error", err)
+		return
+	}
+
+	a.audit.Log(audit.Entry{Action: audit.ActionDeviceAccessChanged, Actor: claims.Username, Username: username, Target: id, IP: a.clientIP(r), Detail: "removed"})
+	writeRespOk(w, "device access removed", nil)
+	slog.Info("device access removed", "username", claims.Username, "mac_address", id, "target", username)
+}
+
+// handleRequestableDevicesGet lists the devices the user may request a wake of. (jwt protected)
+func (a *API) handleRequestableDevicesGet(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	devices, err := a.store.GetRequestableDevicesForUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve devices", http.StatusInternalServerError)
+		slog.Error("failed to retrieve requestable devices", "username", claims.Username, "error", err)
+		return
+	}
+
+	resp := make([]requestableDevice, len(devices))
+	for i, d := range devices {
+		resp[i] = requestableDevice{MACAddress: d.MACAddress, Name: d.Name, Description: d.Description, Status: d.Status}
+	}
+	writeRespOk(w, "devices retrieved", resp)
+}
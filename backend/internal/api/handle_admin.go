@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"wolite/internal/audit"
+	"wolite/internal/auth"
+	"wolite/internal/keyring"
+	"wolite/internal/lockout"
+)
+
+// handleJWTKeysGet lists the JWT signing keys without their secrets. (admin only)
+func (a *API) handleJWTKeysGet(w http.ResponseWriter, r *http.Request) {
+	writeRespOk(w, "signing keys retrieved", a.keys.Keys())
+}
+
+// handleJWTKeysRotate makes a new signing key active. Sessions signed with the previous key
+// stay valid until they expire. (admin only)
+func (a *API) handleJWTKeysRotate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	kid, err := a.keys.Rotate(auth.SigningKeyRetention(a.config.JWTExpiry))
+	if err == keyring.ErrStatic {
+		writeRespErr(w, "Signing key is set by JWT_SECRET and cannot be rotated", http.StatusConflict)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to rotate signing key", http.StatusInternalServerError)
+		slog.Error("failed to rotate signing key", "error", err)
+		return
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionJWTKeyRotated, Target: kid})
+	writeRespOk(w, "signing key rotated", a.keys.Keys())
+	slog.Info("signing key rotated", "username", claims.Username, "kid", kid)
+}
+
+type lockoutsResponse struct {
+	Accounts []lockout.Lock `json:"accounts"`
+	IPs      []lockout.Lock `json:"ips"`
+}
+
+// handleLockoutsGet lists accounts and client IPs locked out after failed logins. (admin only)
+func (a *API) handleLockoutsGet(w http.ResponseWriter, r *http.Request) {
+	writeRespOk(w, "lockouts retrieved", lockoutsResponse{
+		Accounts: a.loginAccounts.Locked(),
+		IPs:      a.loginIPs.Locked(),
+	})
+}
+
+// handleLockoutUnlock clears the failed logins of an account, an IP or both. (admin only)
+func (a *API) handleLockoutUnlock(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req struct {
+		Username string `json:"username,omitempty"`
+		IP       string `json:"ip,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Username == "" && req.IP == "" {
+		writeRespErr(w, "username or ip is required", http.StatusBadRequest)
+		return
+	}
+
+	cleared := false
+	if req.Username != "" {
+		cleared = a.loginAccounts.Reset(req.Username) || cleared
+	}
+	if req.IP != "" {
+		cleared = a.loginIPs.Reset(req.IP) || cleared
+	}
+	if !cleared {
+		writeRespErr(w, "No failed logins recorded", http.StatusNotFound)
+		return
+	}
+
+	a.audit.Log(audit.Entry{Action: audit.ActionUnlock, Actor: claims.Username, Username: req.Username, IP: req.IP})
+	writeRespOk(w, "unlocked", nil)
+	slog.Info("login lockout cleared", "admin", claims.Username, "username", req.Username, "ip", req.IP)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wolite/internal/audit"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// auditEvent records an action of the authenticated user. Actor and IP are filled from the request,
+// and actions taken with a personal access token name the token.
+func (a *API) auditEvent(r *http.Request, e audit.Entry) {
+	if claims := GetUserFromContext(r.Context()); claims != nil && e.Actor == "" {
+		e.Actor = claims.Username
+	}
+	if e.IP == "" {
+		e.IP = a.clientIP(r)
+	}
+	if token := GetAccessTokenFromContext(r.Context()); token != nil {
+		if e.Detail != "" {
+			e.Detail += ", "
+		}
+		e.Detail += "access token " + token.ID
+	}
+	a.audit.Log(e)
+}
+
+// auditResult returns the audit result of an action that ended with err.
+func auditResult(err error) string {
+	if err != nil {
+		return audit.ResultFailure
+	}
+	return audit.ResultSuccess
+}
+
+// errDetail returns the error message for the detail of a failed action.
+func errDetail(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// parseAuditQuery reads the audit log filters from the query string.
+func parseAuditQuery(r *http.Request) (audit.Query, error) {
+	v := r.URL.Query()
+	q := audit.Query{
+		Actor:    v.Get("actor"),
+		Username: v.Get("username"),
+		Target:   v.Get("target"),
+		IP:       v.Get("ip"),
+		Result:   v.Get("result"),
+	}
+	for _, action := range strings.Split(v.Get("action"), ",") {
+		if action = strings.TrimSpace(action); action != "" {
+			q.Actions = append(q.Actions, action)
+		}
+	}
+	if q.Result != "" && q.Result != audit.ResultSuccess && q.Result != audit.ResultFailure {
+		return q, fmt.Errorf("result must be %q or %q", audit.ResultSuccess, audit.ResultFailure)
+	}
+
+	var err error
+	if s := v.Get("since"); s != "" {
+		if q.Since, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("since must be an RFC 3339 time")
+		}
+	}
+	if s := v.Get("until"); s != "" {
+		if q.Until, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("until must be an RFC 3339 time")
+		}
+	}
+	return q, nil
+}
+
+type auditPage struct {
+	Entries []audit.Entry `json:"entries"`
+	Total   int           `json:"total"`
+	Offset  int           `json:"offset"`
+	Limit   int           `json:"limit"`
+}
+
+// handleAuditGet returns a page of audit log entries matching the filters, newest first. (admin only)
+func (a *API) handleAuditGet(w http.ResponseWriter, r *http.Request) {
+	q, err := parseAuditQuery(r)
+	if err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q.Limit = defaultAuditPageSize
+	if l := r.URL.Query().Get("limit"); l != "" {
+		q.Limit, err = strconv.Atoi(l)
+		if err != nil || q.Limit < 1 || q.Limit > maxAuditPageSize {
+			writeRespErr(w, "limit must be between 1 and "+strconv.Itoa(maxAuditPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+	if o := r.URL.Query().Get("offset"); o != "" {
+		q.Offset, err = strconv.Atoi(o)
+		if err != nil || q.Offset < 0 {
+			writeRespErr(w, "offset must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, total, err := a.audit.Query(q)
+	if err != nil {
+		writeRespErr(w, "Failed to read audit log", http.StatusInternalServerError)
+		slog.Error("failed to read audit log", "error", err)
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+
+	writeRespOk(w, "audit log retrieved", auditPage{Entries: entries, Total: total, Offset: q.Offset, Limit: q.Limit})
+}
+
+// handleAuditExport downloads all audit log entries matching the filters as CSV or JSON lines. (admin only)
+func (a *API) handleAuditExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "jsonl" {
+		writeRespErr(w, `format must be "csv" or "jsonl"`, http.StatusBadRequest)
+		return
+	}
+	q, err := parseAuditQuery(r)
+	if err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, _, err := a.audit.Query(q)
+	if err != nil {
+		writeRespErr(w, "Failed to read audit log", http.StatusInternalServerError)
+		slog.Error("failed to read audit log", "error", err)
+		return
+	}
+	// Record the export before writing it, the export itself is security relevant
+	a.auditEvent(r, audit.Entry{Action: audit.ActionAuditExported, Detail: fmt.Sprintf("%s, %d entries", format, len(entries))})
+
+	filename := "wolite-audit-" + time.Now().UTC().Format("20060102-150405") + "." + format
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		err = audit.WriteCSV(w, entries)
+	} else {
+		w.Header().Set("Content-Type", "application/jsonl")
+		err = audit.WriteJSONL(w, entries)
+	}
+	if err != nil {
+		slog.Error("failed to write audit export", "error", err)
+	}
+}
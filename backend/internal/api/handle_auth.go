@@ -2,23 +2,58 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+	"wolite/internal/audit"
 	"wolite/internal/auth"
+	"wolite/internal/ldap"
+	"wolite/internal/lockout"
+	"wolite/internal/store"
 )
 
 type loginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	OTP      string `json:"otp,omitempty"`
+
+	RecoveryCode string `json:"recovery_code,omitempty"` // one-time replacement for the OTP
 }
 
 type authResponse struct {
 	Status string `json:"status"`
 	User   string `json:"user,omitempty"`
 	HasOTP bool   `json:"has_otp"` // Confirmed 2FA enabled?
+	Role   string `json:"role,omitempty"`
+
+	RecoveryCodesRemaining int `json:"recovery_codes_remaining,omitempty"`
 }
 
+// Failed logins are throttled per client IP and per account. The account limit is tighter since an
+// attacker can rotate IPs; the IP limit is looser since several users may share one behind NAT.
+var (
+	loginIPPolicy = lockout.Policy{
+		FreeAttempts:    10,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    50,
+		LockoutDuration: 30 * time.Minute,
+		ResetAfter:      30 * time.Minute,
+	}
+	loginAccountPolicy = lockout.Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+		ResetAfter:      15 * time.Minute,
+	}
+)
+
 func (a *API) handleAuthLogin(w http.ResponseWriter, r *http.Request) {
 	var req loginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -26,60 +61,192 @@ func (a *API) handleAuthLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := a.store.FindUser(req.Username)
-	if err != nil {
-		// Don't reveal if user exists or not, but for now standard 401
-		writeRespErr(w, "Invalid credentials", http.StatusUnauthorized)
+	// Reject before checking credentials so a locked account cannot be probed
+	ip := a.clientIP(r)
+	done, wait := a.beginAttempt(ip, req.Username)
+	if wait > 0 {
+		writeThrottled(w, wait)
+		slog.Warn("login throttled", "username", req.Username, "ip", ip, "retry_after", wait)
 		return
 	}
+	defer done()
 
-	if !auth.CheckPasswordHash(req.Password, user.Password) {
-		writeRespErr(w, "Invalid credentials", http.StatusUnauthorized)
+	loginMethod := "password"
+	user, err := a.store.FindUser(req.Username)
+	if a.usesLDAP(user, err) {
+		loginMethod = "ldap"
+		user, err = a.ldapUser(r.Context(), req.Username, req.Password, ip)
+		switch {
+		case errors.Is(err, ldap.ErrInvalidCredentials):
+			a.loginFailed(w, req.Username, ip, "wrong ldap password", "Invalid credentials")
+			return
+		case errors.Is(err, ldap.ErrUserNotFound):
+			a.loginFailed(w, req.Username, ip, "unknown user", "Invalid credentials")
+			return
+		case err == errSSOForbidden || err == errSSOUsernameTaken || err == errSSOInvalidUsername:
+			a.audit.Log(audit.Entry{Action: audit.ActionSSOFailed, Username: req.Username, IP: ip, Result: audit.ResultFailure, Detail: "ldap: " + err.Error()})
+			slog.Warn("ldap login rejected", "username", req.Username, "ip", ip, "error", err)
+			writeRespErr(w, "This account may not log in", http.StatusForbidden)
+			return
+		case err != nil:
+			writeRespErr(w, "Directory unavailable, try again later", http.StatusServiceUnavailable)
+			slog.Error("ldap authentication failed", "username", req.Username, "error", err)
+			return
+		}
+	} else {
+		if err != nil {
+			// Don't reveal if user exists or not, but for now standard 401
+			a.loginFailed(w, req.Username, ip, "unknown user", "Invalid credentials")
+			return
+		}
+
+		if !auth.CheckPasswordHash(req.Password, user.Password) {
+			a.loginFailed(w, req.Username, ip, "wrong password", "Invalid credentials")
+			return
+		}
+	}
+
+	// Users with passkeys but no OTP log in through /auth/webauthn
+	if user.OTP == "" && len(user.Credentials) > 0 {
+		writeRespErr(w, "Passkey required", http.StatusUnauthorized)
 		return
 	}
 
-	// If user has OTP enabled, verify it
+	// If user has OTP enabled, verify it or a recovery code
 	if user.OTP != "" {
-		if req.OTP == "" {
+		if req.OTP == "" && req.RecoveryCode == "" {
 			writeRespErr(w, "OTP required", http.StatusUnauthorized)
 			return
 		}
-		if !auth.Validate2FA(req.OTP, user.OTP) {
-			writeRespErr(w, "Invalid OTP", http.StatusUnauthorized)
+		if !a.checkSecondFactor(user, req.OTP, req.RecoveryCode, ip) {
+			if req.RecoveryCode != "" {
+				a.loginFailed(w, req.Username, ip, "wrong recovery code", "Invalid recovery code")
+			} else {
+				a.loginFailed(w, req.Username, ip, "wrong otp", "Invalid OTP")
+			}
 			return
 		}
 	}
 
-	tokenString, expirationTime, err := auth.GenerateJWTToken(user.Username, []byte(a.config.JWTSecret), a.config.JWTExpiry)
-	if err != nil {
+	// The IP keeps its failures, otherwise one valid account would let an attacker reset the IP limit
+	a.loginAccounts.Reset(user.Username)
+	a.upgradePasswordHash(user, req.Password)
+
+	if err := a.issueSessionCookie(w, r, user.Username, loginMethod); err != nil {
 		writeRespErr(w, "Failed to generate token", http.StatusInternalServerError)
+		slog.Error("failed to generate token", "username", user.Username, "error", err)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    tokenString,
-		Expires:  expirationTime,
-		HttpOnly: true,
-		Path:     "/",
-		SameSite: http.SameSiteStrictMode,
-		// Secure:   true, // TODO: Enable in production
+	remaining := len(user.RecoveryCodes)
+	if user.OTP != "" && req.RecoveryCode != "" {
+		remaining-- // consumed by this login
+	}
+	writeRespOk(w, "authenticated", authResponse{
+		Status:                 "authenticated",
+		User:                   user.Username,
+		HasOTP:                 user.OTP != "",
+		Role:                   string(user.Role),
+		RecoveryCodesRemaining: remaining,
 	})
+}
+
+// upgradePasswordHash rehashes a verified password when its hash uses bcrypt or older argon2id costs.
+// Failing is not fatal, the old hash keeps working.
+func (a *API) upgradePasswordHash(user store.User, password string) {
+	if user.Provider != "" || !auth.PasswordNeedsRehash(user.Password) {
+		return
+	}
+	hash, err := auth.HashPassword(password)
+	if err == nil {
+		err = a.store.RehashPassword(user.Username, user.Password, hash)
+	}
+	if err != nil {
+		slog.Error("failed to upgrade password hash", "username", user.Username, "error", err)
+		return
+	}
+	slog.Info("password hash upgraded", "username", user.Username)
+}
+
+// loginFailed counts a failed login against the IP and the account, audits it and writes the 401.
+func (a *API) loginFailed(w http.ResponseWriter, username, ip, reason, message string) {
+	a.audit.Log(audit.Entry{Action: audit.ActionLoginFailed, Username: username, IP: ip, Result: audit.ResultFailure, Detail: reason})
+	slog.Warn("login failed", "username", username, "ip", ip, "reason", reason)
+
+	if a.loginIPs.Fail(ip) {
+		a.audit.Log(audit.Entry{Action: audit.ActionLockout, IP: ip, Result: audit.ResultFailure, Detail: "too many failed logins from this IP"})
+		slog.Warn("ip locked out", "ip", ip)
+	}
+	if username != "" && a.loginAccounts.Fail(username) {
+		a.audit.Log(audit.Entry{Action: audit.ActionLockout, Username: username, IP: ip, Result: audit.ResultFailure, Detail: "too many failed logins for this account"})
+		slog.Warn("account locked out", "username", username, "ip", ip)
+	}
 
-	writeRespOk(w, "authenticated", authResponse{Status: "authenticated", User: user.Username, HasOTP: user.OTP != ""})
+	writeRespErr(w, message, http.StatusUnauthorized)
+}
+
+// beginAttempt reserves an attempt for the IP and, when known, the account before the credentials are
+// checked, so a burst of parallel requests cannot pass the throttle before the first failure is recorded.
+// It returns how long to wait when either is refused; otherwise done must be called to end the attempt.
+func (a *API) beginAttempt(ip, username string) (done func(), wait time.Duration) {
+	if wait := a.loginIPs.Begin(ip); wait > 0 {
+		return nil, wait
+	}
+	if username != "" {
+		if wait := a.loginAccounts.Begin(username); wait > 0 {
+			a.loginIPs.Done(ip)
+			return nil, wait
+		}
+	}
+	return func() {
+		a.loginIPs.Done(ip)
+		if username != "" {
+			a.loginAccounts.Done(username)
+		}
+	}, 0
+}
+
+// writeThrottled rejects an attempt made before the throttling delay has passed.
+func writeThrottled(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeRespErr(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
 }
 
 func (a *API) handleAuthLogout(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    "",
-		MaxAge:   -1,
-		HttpOnly: true,
-		Path:     "/",
-	})
+	// Revoke the session if the cookie is still valid; logging out always clears the cookie
+	if claims, err := a.validateCookies(r); err == nil {
+		if err := a.store.DeleteSession(claims.Username, claims.ID); err != nil && err != store.ErrSessionNotFound {
+			slog.Error("failed to revoke session", "username", claims.Username, "session_id", claims.ID, "error", err)
+		}
+		a.audit.Log(audit.Entry{Action: audit.ActionLogout, Actor: claims.Username, Username: claims.Username, IP: a.clientIP(r)})
+	}
+
+	clearSessionCookie(w)
 	writeRespOk(w, "logged out", nil)
 }
 
+// handleAuthLogoutAll revokes every session of the user, including the current one (jwt protected)
+func (a *API) handleAuthLogoutAll(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	revoked, err := a.store.DeleteSessionsForUser(claims.Username, "")
+	if err != nil {
+		writeRespErr(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		slog.Error("failed to revoke sessions", "username", claims.Username, "error", err)
+		return
+	}
+
+	clearSessionCookie(w)
+	a.auditEvent(r, audit.Entry{Action: audit.ActionLogoutAll, Username: claims.Username, Detail: fmt.Sprintf("%d sessions revoked", revoked)})
+	slog.Info("all sessions revoked", "username", claims.Username, "revoked", revoked)
+	writeRespOk(w, "logged out everywhere", map[string]int{"revoked": revoked})
+}
+
 func (a *API) handleAuthStatus(w http.ResponseWriter, r *http.Request) {
 	claims := GetUserFromContext(r.Context())
 	if claims == nil {
@@ -95,7 +262,13 @@ func (a *API) handleAuthStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeRespOk(w, "authenticated", authResponse{Status: "authenticated", User: user.Username, HasOTP: user.OTP != ""})
+	writeRespOk(w, "authenticated", authResponse{
+		Status:                 "authenticated",
+		User:                   user.Username,
+		HasOTP:                 user.OTP != "",
+		Role:                   string(user.Role),
+		RecoveryCodesRemaining: len(user.RecoveryCodes),
+	})
 }
 
 // handleAuthInitialized checks if the application has been initialized (has users)
@@ -103,3 +276,68 @@ func (a *API) handleAuthInitialized(w http.ResponseWriter, r *http.Request) {
 	initialized := a.store.HasUsers()
 	writeRespOk(w, "ok", map[string]bool{"initialized": initialized})
 }
+
+// issueSessionCookie creates a server-side session, signs a JWT referencing it with the active key
+// and sets it as the "token" cookie. The login is audited with the method that authenticated the user.
+func (a *API) issueSessionCookie(w http.ResponseWriter, r *http.Request, username, method string) error {
+	kid, key, err := a.keys.Active()
+	if err != nil {
+		return err
+	}
+
+	csrfToken, err := auth.GenerateCSRFToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expirationTime := now.Add(a.config.JWTExpiry)
+	session := store.NewSession(username, r.UserAgent(), a.clientIP(r), expirationTime)
+	session.CSRFToken = csrfToken
+	if err := a.store.CreateSession(session); err != nil {
+		return err
+	}
+	tokenString, err := auth.GenerateJWTToken(username, session.ID, kid, key, expirationTime, now)
+	if err != nil {
+		return err
+	}
+	a.setSessionCookie(w, r, tokenString, expirationTime)
+	a.setCSRFCookie(w, r, csrfToken, expirationTime)
+	a.audit.Log(audit.Entry{Action: audit.ActionLogin, Actor: username, Username: username, IP: session.IP, Detail: method})
+	return nil
+}
+
+// setSessionCookie stores the session JWT in the browser.
+func (a *API) setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    token,
+		Expires:  expires,
+		HttpOnly: true,
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+		Secure:   a.secureCookies(r),
+	})
+}
+
+// secureCookies reports whether cookies can be limited to HTTPS, which is the case when the browser
+// reached the server over TLS. Plain HTTP (e.g. on the LAN) would otherwise lose the cookies.
+func (a *API) secureCookies(r *http.Request) bool {
+	return a.requestScheme(r) == "https"
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     "/",
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:   csrfCookie,
+		Value:  "",
+		MaxAge: -1,
+		Path:   "/",
+	})
+}
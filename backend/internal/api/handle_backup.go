@@ -0,0 +1,121 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"wolite/internal/audit"
+	"wolite/internal/backup"
+	"wolite/internal/store"
+)
+
+const maxBackupBytes = 64 << 20
+
+type backupRequest struct {
+	Passphrase string `json:"passphrase"` // encrypts the archive, optional
+}
+
+// handleBackupExport downloads an archive of the whole state, encrypted when a passphrase is given.
+// Without one the archive holds password hashes, companion tokens and OTP secrets readable. (admin only)
+func (a *API) handleBackupExport(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The body is optional, an empty one exports without encryption
+	var req backupRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := backup.Write(&buf, a.store.Snapshot(), req.Passphrase); err != nil {
+		writeRespErr(w, "Failed to create backup", http.StatusInternalServerError)
+		slog.Error("failed to create backup", "username", claims.Username, "error", err)
+		return
+	}
+
+	detail := "unencrypted"
+	if req.Passphrase != "" {
+		detail = "encrypted"
+	}
+	a.auditEvent(r, audit.Entry{Action: audit.ActionBackupExported, Username: claims.Username, Detail: detail})
+
+	filename := "wolite-backup-" + time.Now().UTC().Format("20060102-150405") + ".json"
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+	slog.Info("backup exported", "username", claims.Username, "encrypted", req.Passphrase != "")
+}
+
+// handleBackupRestore imports an archive sent as the request body. The mode query parameter is "merge"
+// (default) or "replace", dry_run=true only validates, and an encrypted archive needs the passphrase in
+// the X-Backup-Passphrase header. (admin only)
+func (a *API) handleBackupRestore(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	mode := store.ImportMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = store.ImportMerge
+	}
+	if mode != store.ImportMerge && mode != store.ImportReplace {
+		writeRespErr(w, `mode must be "merge" or "replace"`, http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	snap, archive, err := backup.Read(http.MaxBytesReader(w, r.Body, maxBackupBytes), r.Header.Get("X-Backup-Passphrase"))
+	if err != nil {
+		writeRespErr(w, "Invalid backup: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := a.store.Import(*snap, mode, dryRun, claims.ID) // a replace signs everyone else out
+	var invalid *store.InvalidSnapshotError
+	if err != nil && errors.As(err, &invalid) {
+		writeRespWithStatus(w, "Backup failed validation, nothing was restored", invalid.Problems, http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to restore backup", http.StatusInternalServerError)
+		slog.Error("failed to restore backup", "username", claims.Username, "error", err)
+		return
+	}
+
+	if dryRun {
+		writeRespOk(w, "backup is valid", result)
+		return
+	}
+	a.auditEvent(r, audit.Entry{Action: audit.ActionBackupRestored, Username: claims.Username, Detail: fmt.Sprintf("%s, backup from %s, %s", mode, archive.CreatedAt.Format(time.RFC3339), countSummary(result.Imported))})
+	writeRespOk(w, "backup restored", result)
+	slog.Info("backup restored", "username", claims.Username, "mode", mode, "created_at", archive.CreatedAt)
+}
+
+// countSummary formats the non-zero import counts, e.g. "3 users, 5 devices".
+func countSummary(counts map[string]int) string {
+	parts := make([]string, 0, len(counts))
+	for _, name := range []string{"users", "devices", "user_device_mappings", "groups", "scenes", "schedules", "calendars", "access_tokens", "share_links"} {
+		if counts[name] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[name], strings.ReplaceAll(name, "_", " ")))
+		}
+	}
+	if len(parts) == 0 {
+		return "nothing imported"
+	}
+	return strings.Join(parts, ", ")
+}
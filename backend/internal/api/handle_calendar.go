@@ -0,0 +1,253 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"wolite/internal/ical"
+	"wolite/internal/store"
+)
+
+const (
+	maxCalendarEntries = 1000
+	maxICSBytes        = 1 << 20
+)
+
+type calendarRequest struct {
+	Name   string             `json:"name"`
+	Mode   store.CalendarMode `json:"mode"`
+	Dates  []string           `json:"dates"`
+	Ranges []store.DateRange  `json:"ranges"`
+}
+
+func (r *calendarRequest) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+	switch r.Mode {
+	case store.CalendarSkip, store.CalendarForce:
+	default:
+		return errors.New("mode must be skip or force")
+	}
+	if len(r.Dates)+len(r.Ranges) > maxCalendarEntries {
+		return fmt.Errorf("a calendar can have at most %d dates and ranges", maxCalendarEntries)
+	}
+	for _, d := range r.Dates {
+		if _, err := time.Parse(time.DateOnly, d); err != nil {
+			return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", d)
+		}
+	}
+	for _, rg := range r.Ranges {
+		start, err := time.Parse(time.DateOnly, rg.Start)
+		if err != nil {
+			return fmt.Errorf("invalid range start %q, expected YYYY-MM-DD", rg.Start)
+		}
+		end, err := time.Parse(time.DateOnly, rg.End)
+		if err != nil {
+			return fmt.Errorf("invalid range end %q, expected YYYY-MM-DD", rg.End)
+		}
+		if end.Before(start) {
+			return fmt.Errorf("range %s to %s ends before it starts", rg.Start, rg.End)
+		}
+	}
+	return nil
+}
+
+// decodeCalendarRequest decodes and validates a calendar payload.
+func decodeCalendarRequest(r *http.Request) (*calendarRequest, error) {
+	var req calendarRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errors.New("Invalid request body")
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	slices.Sort(req.Dates)
+	req.Dates = slices.Compact(req.Dates)
+	if req.Dates == nil {
+		req.Dates = make([]string, 0)
+	}
+	if req.Ranges == nil {
+		req.Ranges = make([]store.DateRange, 0)
+	}
+	return &req, nil
+}
+
+// handleCalendarsGetAll returns all calendars owned by the user. (jwt protected)
+func (a *API) handleCalendarsGetAll(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	calendars, err := a.store.GetCalendarsForUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve calendars", http.StatusInternalServerError)
+		slog.Error("failed to retrieve calendars", "username", claims.Username, "error", err)
+		return
+	}
+
+	writeRespOk(w, "calendars retrieved", calendars)
+}
+
+// handleCalendarGet returns a single calendar. (jwt protected)
+func (a *API) handleCalendarGet(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	calendar, err := a.store.GetCalendarForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Calendar not found", http.StatusNotFound)
+		return
+	}
+
+	writeRespOk(w, "calendar retrieved", calendar)
+}
+
+// handleCalendarCreate creates a new calendar for the user. (jwt protected)
+func (a *API) handleCalendarCreate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	req, err := decodeCalendarRequest(r)
+	if err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	calendar := store.NewCalendar(claims.Username, strings.TrimSpace(req.Name), req.Mode)
+	calendar.Dates = req.Dates
+	calendar.Ranges = req.Ranges
+
+	if err := a.store.CreateCalendar(calendar); err != nil {
+		writeRespErr(w, "Failed to create calendar", http.StatusInternalServerError)
+		slog.Error("failed to create calendar", "username", claims.Username, "error", err)
+		return
+	}
+
+	writeRespWithStatus(w, "calendar created", calendar, http.StatusCreated)
+	slog.Info("calendar created", "username", claims.Username, "calendar_id", calendar.ID)
+}
+
+// handleCalendarUpdate replaces a calendar's name, mode, dates and ranges. Imported events are kept. (jwt protected)
+func (a *API) handleCalendarUpdate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	calendar, err := a.store.GetCalendarForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Calendar not found", http.StatusNotFound)
+		return
+	}
+
+	req, err := decodeCalendarRequest(r)
+	if err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	calendar.Name = strings.TrimSpace(req.Name)
+	calendar.Mode = req.Mode
+	calendar.Dates = req.Dates
+	calendar.Ranges = req.Ranges
+
+	if err := a.store.UpdateCalendar(calendar); err != nil {
+		writeRespErr(w, "Failed to update calendar", http.StatusInternalServerError)
+		slog.Error("failed to update calendar", "username", claims.Username, "calendar_id", calendar.ID, "error", err)
+		return
+	}
+
+	writeRespOk(w, "calendar updated", calendar)
+	slog.Info("calendar updated", "username", claims.Username, "calendar_id", calendar.ID)
+}
+
+// handleCalendarImport replaces a calendar's imported events with those of an iCalendar (.ics) request body. (jwt protected)
+func (a *API) handleCalendarImport(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	calendar, err := a.store.GetCalendarForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Calendar not found", http.StatusNotFound)
+		return
+	}
+
+	events, err := ical.Parse(http.MaxBytesReader(w, r.Body, maxICSBytes))
+	if err != nil {
+		writeRespErr(w, "Invalid iCalendar file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(events) > maxCalendarEntries {
+		writeRespErr(w, fmt.Sprintf("a calendar can import at most %d events", maxCalendarEntries), http.StatusBadRequest)
+		return
+	}
+
+	imported := make([]store.DateRange, 0, len(events))
+	for _, e := range events {
+		imported = append(imported, store.DateRange{
+			Start:   e.Start.Format(time.DateOnly),
+			End:     e.End.Format(time.DateOnly),
+			Summary: e.Summary,
+		})
+	}
+	now := time.Now()
+	calendar.Imported = imported
+	calendar.ImportedAt = &now
+
+	if err := a.store.UpdateCalendar(calendar); err != nil {
+		writeRespErr(w, "Failed to update calendar", http.StatusInternalServerError)
+		slog.Error("failed to update calendar", "username", claims.Username, "calendar_id", calendar.ID, "error", err)
+		return
+	}
+
+	writeRespOk(w, "calendar imported", calendar)
+	slog.Info("calendar imported", "username", claims.Username, "calendar_id", calendar.ID, "events", len(imported))
+}
+
+// handleCalendarDelete deletes a calendar and detaches it from schedules. (jwt protected)
+func (a *API) handleCalendarDelete(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	err := a.store.DeleteCalendar(claims.Username, id)
+	if err != nil && err == store.ErrCalendarNotFound {
+		writeRespErr(w, "Calendar not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to delete calendar", http.StatusInternalServerError)
+		slog.Error("failed to delete calendar", "username", claims.Username, "calendar_id", id, "error", err)
+		return
+	}
+
+	writeRespOk(w, "calendar deleted", nil)
+	slog.Info("calendar deleted", "username", claims.Username, "calendar_id", id)
+}
@@ -6,7 +6,9 @@ import (
 	"log/slog"
 	"net/http"
 	"time"
+	"wolite/internal/audit"
 	"wolite/internal/companion"
+	"wolite/internal/power"
 	"wolite/internal/store"
 )
 
@@ -16,7 +18,9 @@ type pairCompanionRequest struct {
 }
 
 type companionActionRequest struct {
-	Action string `json:"action"` // shutdown, reboot, sleep, hibernate
+	Action  string `json:"action"`            // shutdown, reboot, sleep, hibernate
+	Cascade bool   `json:"cascade,omitempty"` // first run the action on online dependents, most dependent first
+	Force   bool   `json:"force,omitempty"`   // run the action even though online devices depend on the target
 }
 
 // handleDeviceCompanionStatus checks if the companion is reachable.
@@ -155,6 +159,7 @@ func (a *API) handleDeviceCompanionPair(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	a.auditEvent(r, audit.Entry{Action: audit.ActionDevicePaired, Username: claims.Username, Target: device.MACAddress, Detail: req.URL})
 	writeRespOk(w, "Companion paired successfully", device)
 	slog.Info("companion paired", "mac", device.MACAddress, "url", req.URL, "fingerprint", fingerprint)
 }
@@ -189,6 +194,7 @@ func (a *API) handleDeviceCompanionUnpair(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	a.auditEvent(r, audit.Entry{Action: audit.ActionDeviceUnpaired, Username: claims.Username, Target: device.MACAddress})
 	writeRespOk(w, "Companion unpaired", device)
 	slog.Info("companion unpaired", "mac", device.MACAddress)
 }
@@ -218,40 +224,104 @@ func (a *API) handleDeviceCompanionAction(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	action, err := companion.ParseAction(req.Action)
+	if err != nil {
+		writeRespErr(w, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
 	if device.CompanionURL == "" || device.CompanionToken == "" {
 		writeRespErr(w, "Companion not paired", http.StatusBadRequest)
 		return
 	}
 
-	client, err := companion.NewClient(device.CompanionURL, device.CompanionToken, device.CompanionAuthFingerprint)
+	// Devices that depend on the target would lose their prerequisite
+	dependents, err := a.store.ShutdownOrder(device.MACAddress)
 	if err != nil {
-		writeRespErr(w, "Invalid companion configuration", http.StatusInternalServerError)
+		writeRespErr(w, "Failed to resolve dependencies", http.StatusInternalServerError)
+		slog.Error("failed to resolve dependents", "mac", device.MACAddress, "error", err)
 		return
 	}
+	online := make([]store.Device, 0, len(dependents))
+	for _, d := range dependents {
+		if d.Status == store.StatusOnline {
+			online = append(online, d)
+		}
+	}
 
-	// Map generic action string to companion package type if needed, or just pass string if package handles it.
-	// The companion package defines PowerAction constants.
-	var action companion.PowerAction
-	switch req.Action {
-	case "shutdown":
-		action = companion.ActionShutdown
-	case "reboot":
-		action = companion.ActionReboot
-	case "sleep":
-		action = companion.ActionSleep
-	case "hibernate":
-		action = companion.ActionHibernate
-	default:
-		writeRespErr(w, "Invalid action", http.StatusBadRequest)
+	if len(online) > 0 && req.Cascade {
+		steps := make([]store.SceneStep, 0, len(online)+1)
+		for _, d := range online {
+			if _, err := a.store.GetDeviceForUser(claims.Username, d.MACAddress); err != nil {
+				// Not named, the device belongs to another user
+				writeRespErr(w, "Online dependent devices of other users cannot be shut down", http.StatusForbidden)
+				return
+			}
+			if !tokenAllowsDevice(r, d.MACAddress) {
+				writeRespErr(w, "Access token is not allowed for dependent device "+d.Name, http.StatusForbidden)
+				return
+			}
+			steps = append(steps, store.SceneStep{Type: store.StepPower, MACAddress: d.MACAddress, Action: string(action)})
+		}
+		steps = append(steps, store.SceneStep{Type: store.StepPower, MACAddress: device.MACAddress, Action: string(action)})
+
+		run := a.runner.Start(claims.Username, string(action)+" "+device.Name, "", steps)
+		writeRespWithStatus(w, "running action on dependents first", run, http.StatusAccepted)
+		slog.Info("cascading companion command started", "mac", device.MACAddress, "action", action, "dependents", len(online), "run_id", run.ID)
+		return
+	}
+	if len(online) > 0 && !req.Force {
+		writeRespWithStatus(w, "Online devices depend on this device, retry with cascade or force", a.describeDependents(claims.Username, online), http.StatusConflict)
+		slog.Warn("companion command blocked by online dependents", "mac", device.MACAddress, "action", action, "dependents", len(online))
 		return
 	}
 
-	if err := client.Power(r.Context(), action); err != nil {
+	err = power.Execute(r.Context(), *device, action)
+	entry := audit.Entry{Action: audit.ActionPower, Username: claims.Username, Target: device.MACAddress, Result: auditResult(err), Detail: string(action)}
+	if err != nil {
+		entry.Detail += ": " + err.Error()
+	} else if len(online) > 0 {
+		entry.Detail += ", forced with online dependents"
+	}
+	a.auditEvent(r, entry)
+	if err != nil {
 		writeRespErr(w, "Failed to execute command: "+err.Error(), http.StatusBadGateway)
 		slog.Error("companion command failed", "mac", device.MACAddress, "action", action, "error", err)
 		return
 	}
 
+	if len(online) > 0 {
+		// Forced: report the dependents that just lost their prerequisite
+		writeRespOk(w, "Command executed, online devices depend on this device", a.describeDependents(claims.Username, online))
+		slog.Warn("companion command forced despite online dependents", "mac", device.MACAddress, "action", action, "dependents", len(online))
+		return
+	}
+
 	writeRespOk(w, "Command executed successfully", nil)
 	slog.Info("companion command executed", "mac", device.MACAddress, "action", action)
 }
+
+type dependentDevice struct {
+	MACAddress string `json:"mac_address"`
+	Name       string `json:"name"`
+}
+
+// dependentsResponse lists online dependents. Only the caller's own devices are named.
+type dependentsResponse struct {
+	Devices []dependentDevice `json:"devices"`
+	Others  int               `json:"others"` // dependents of other users, counted but not named
+}
+
+// describeDependents names the dependents the user owns and counts the rest. Dependents can belong to
+// other users, whose devices and companion tokens are none of the caller's business.
+func (a *API) describeDependents(username string, dependents []store.Device) dependentsResponse {
+	resp := dependentsResponse{Devices: make([]dependentDevice, 0, len(dependents))}
+	for _, d := range dependents {
+		if _, err := a.store.GetDeviceForUser(username, d.MACAddress); err != nil {
+			resp.Others++
+			continue
+		}
+		resp.Devices = append(resp.Devices, dependentDevice{MACAddress: d.MACAddress, Name: d.Name})
+	}
+	return resp
+}
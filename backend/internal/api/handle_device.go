@@ -5,23 +5,33 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"wolite/internal/audit"
+	"wolite/internal/power"
 	"wolite/internal/store"
-	"wolite/internal/wol"
+	"wolite/internal/worker"
 )
 
 type createDeviceRequest struct {
-	MACAddress  string `json:"mac_address"`
-	Name        string `json:"name"`
-	Description string `json:"description,omitempty"`
-	IPAddress   string `json:"ip_address,omitempty"`
-	BroadcastIP string `json:"broadcast_ip,omitempty"`
+	MACAddress  string   `json:"mac_address"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	IPAddress   string   `json:"ip_address,omitempty"`
+	BroadcastIP string   `json:"broadcast_ip,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	GroupID     string   `json:"group_id,omitempty"`
 }
 
 type updateDeviceRequest struct {
-	Name        string `json:"name,omitempty"`
-	Description string `json:"description,omitempty"`
-	IPAddress   string `json:"ip_address,omitempty"`
-	BroadcastIP string `json:"broadcast_ip"`
+	Name        string    `json:"name,omitempty"`
+	Description string    `json:"description,omitempty"`
+	IPAddress   string    `json:"ip_address,omitempty"`
+	BroadcastIP string    `json:"broadcast_ip"`
+	Tags        *[]string `json:"tags,omitempty"`       // nil leaves tags unchanged, empty clears them
+	GroupID     *string   `json:"group_id,omitempty"`   // nil leaves group unchanged, "" ungroups
+	DependsOn   *[]string `json:"depends_on,omitempty"` // nil leaves dependencies unchanged, empty clears them
 }
 
 func (r *createDeviceRequest) Validate() error {
@@ -40,7 +50,50 @@ func (r *createDeviceRequest) Validate() error {
 	return nil
 }
 
+// normalizeTags trims, lowercases and de-duplicates tags, dropping empty ones.
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	return out
+}
+
+// matchDeviceFilter reports whether a device passes the GET /devices query filters:
+// group (ID, or "none" for ungrouped), tag (repeatable, all must match), status and q (name/description search).
+func matchDeviceFilter(d store.UserDevice, query url.Values) bool {
+	if group := query.Get("group"); group != "" {
+		if group == "none" && d.GroupID != "" {
+			return false
+		}
+		if group != "none" && d.GroupID != group {
+			return false
+		}
+	}
+	for _, tag := range query["tag"] {
+		if !slices.Contains(d.Tags, strings.ToLower(tag)) {
+			return false
+		}
+	}
+	if status := query.Get("status"); status != "" && string(d.Status) != status {
+		return false
+	}
+	if q := strings.ToLower(query.Get("q")); q != "" {
+		if !strings.Contains(strings.ToLower(d.Name), q) && !strings.Contains(strings.ToLower(d.Description), q) {
+			return false
+		}
+	}
+	return true
+}
+
 // handleDevicesGetAll returns all devices associated with a username. (jwt protected)
+// Supports filtering by group, tag, status and a name/description search through query parameters.
 func (a *API) handleDevicesGetAll(w http.ResponseWriter, r *http.Request) {
 	claims := GetUserFromContext(r.Context())
 	if claims == nil {
@@ -56,6 +109,19 @@ func (a *API) handleDevicesGetAll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query := r.URL.Query()
+	token := GetAccessTokenFromContext(r.Context())
+	filtered := make([]store.UserDevice, 0, len(devices))
+	for _, d := range devices {
+		if token != nil && !token.AllowsDevice(d.MACAddress) {
+			continue
+		}
+		if matchDeviceFilter(d, query) {
+			filtered = append(filtered, d)
+		}
+	}
+	devices = filtered
+
 	writeRespOk(w, "devices retrieved", devices)
 	slog.Info("devices retrieved", "username", claims.Username, "devices_count", len(devices))
 }
@@ -115,19 +181,25 @@ func (a *API) handleDeviceCreate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	device := store.NewDevice(req.MACAddress, req.Name, req.Description, req.IPAddress, req.BroadcastIP, store.StatusUnknown)
+	device.Tags = normalizeTags(req.Tags)
 
-	// Secure Creation: Use CreateDeviceForUser for atomic creation and assignment
-	err := a.store.CreateDeviceForUser(claims.Username, device)
+	// Secure Creation: Create, assign and file under the group in one atomic write
+	err := a.store.CreateDeviceInGroupForUser(claims.Username, device, req.GroupID)
 	if err != nil && err == store.ErrDeviceExists {
 		writeRespErr(w, "Device already exists", http.StatusBadRequest)
 		slog.Error("device already exists", "username", claims.Username, "mac_address", device.MACAddress)
 		return
+	} else if err != nil && err == store.ErrGroupNotFound {
+		writeRespErr(w, "Group not found", http.StatusBadRequest)
+		slog.Error("group not found", "username", claims.Username, "group_id", req.GroupID)
+		return
 	} else if err != nil {
 		writeRespErr(w, "Failed to add device", http.StatusInternalServerError)
 		slog.Error("failed to add device", "username", claims.Username, "mac_address", device.MACAddress, "error", err)
 		return
 	}
 
+	a.auditEvent(r, audit.Entry{Action: audit.ActionDeviceCreated, Username: claims.Username, Target: device.MACAddress, Detail: device.Name})
 	writeRespOk(w, "device added", device)
 	slog.Info("device added to user", "username", claims.Username, "mac_address", device.MACAddress)
 }
@@ -182,13 +254,41 @@ func (a *API) handleDeviceUpdate(w http.ResponseWriter, r *http.Request) {
 	if req.BroadcastIP != "" {
 		device.BroadcastIP = req.BroadcastIP
 	}
+	if req.Tags != nil {
+		device.Tags = normalizeTags(*req.Tags)
+	}
+	if req.DependsOn != nil {
+		// The user must have access to every prerequisite, otherwise they could not wake it
+		for _, dep := range *req.DependsOn {
+			if _, err := a.store.GetDeviceForUser(claims.Username, dep); err != nil {
+				writeRespErr(w, "Dependency not found: "+dep, http.StatusBadRequest)
+				return
+			}
+		}
+		err = a.store.CheckDependencies(device.MACAddress, *req.DependsOn)
+		if err != nil && err == store.ErrDependencyCycle {
+			writeRespErr(w, "Dependencies would create a cycle", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			writeRespErr(w, "Invalid dependencies", http.StatusBadRequest)
+			slog.Error("invalid dependencies", "username", claims.Username, "mac_address", device.MACAddress, "error", err)
+			return
+		}
+		device.DependsOn = slices.Compact(slices.Sorted(slices.Values(*req.DependsOn)))
+	}
 
-	err = a.store.UpdateDevice(device)
-	if err != nil {
+	// Device and group are written together, a bad group leaves the device unchanged
+	_, err = a.store.UpdateDeviceInGroupForUser(claims.Username, device, req.GroupID)
+	if err != nil && err == store.ErrGroupNotFound {
+		writeRespErr(w, "Group not found", http.StatusBadRequest)
+		slog.Error("group not found", "username", claims.Username, "group_id", *req.GroupID)
+		return
+	} else if err != nil {
 		writeRespErr(w, "Failed to update device", http.StatusInternalServerError)
 		slog.Error("failed to update device", "username", claims.Username, "mac_address", device.MACAddress, "error", err)
 		return
 	}
+	a.auditEvent(r, audit.Entry{Action: audit.ActionDeviceUpdated, Username: claims.Username, Target: device.MACAddress, Detail: device.Name})
 	writeRespOk(w, "device updated", device)
 	slog.Info("device updated", "username", claims.Username, "mac_address", device.MACAddress)
 }
@@ -221,7 +321,7 @@ func (a *API) handleDeviceDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = a.store.DeleteDevice(id)
+	err = a.store.DeleteDevice(claims.Username, id)
 	if err != nil && err == store.ErrDeviceNotFound {
 		writeRespErr(w, "Device not found", http.StatusNotFound)
 		slog.Error("device not found", "username", claims.Username, "mac_address", id)
@@ -231,6 +331,7 @@ func (a *API) handleDeviceDelete(w http.ResponseWriter, r *http.Request) {
 		slog.Error("failed to delete device", "username", claims.Username, "mac_address", id)
 		return
 	}
+	a.auditEvent(r, audit.Entry{Action: audit.ActionDeviceDeleted, Username: claims.Username, Target: id})
 	writeRespOk(w, "device deleted", nil)
 	slog.Info("device deleted", "username", claims.Username, "mac_address", id)
 }
@@ -263,15 +364,47 @@ func (a *API) handleDeviceWake(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	broadcastIP := device.BroadcastIP
-	if broadcastIP == "" {
+	// Wake prerequisites first, waiting for each to come online, unless explicitly skipped
+	if r.URL.Query().Get("ignore_dependencies") != "true" {
+		steps, err := worker.WakeSteps(a.store, *device)
+		if err != nil && err == store.ErrDependencyCycle {
+			writeRespErr(w, "Device dependencies contain a cycle", http.StatusConflict)
+			slog.Error("dependency cycle", "username", claims.Username, "mac_address", id)
+			return
+		} else if err != nil {
+			writeRespErr(w, "Failed to resolve dependencies", http.StatusInternalServerError)
+			slog.Error("failed to resolve dependencies", "username", claims.Username, "mac_address", id, "error", err)
+			return
+		}
+
+		if len(steps) > 1 {
+			for _, step := range steps {
+				if _, err := a.store.GetDeviceForUser(claims.Username, step.MACAddress); err != nil {
+					writeRespErr(w, "No access to prerequisite device "+step.MACAddress, http.StatusForbidden)
+					slog.Error("prerequisite not accessible", "username", claims.Username, "mac_address", id, "prerequisite", step.MACAddress)
+					return
+				}
+				if !tokenAllowsDevice(r, step.MACAddress) {
+					writeRespErr(w, "Access token is not allowed for prerequisite device "+step.MACAddress, http.StatusForbidden)
+					slog.Warn("access token device denied", "username", claims.Username, "mac_address", id, "prerequisite", step.MACAddress)
+					return
+				}
+			}
+
+			run := a.runner.Start(claims.Username, "Wake "+device.Name, "", steps)
+			writeRespWithStatus(w, "waking prerequisites", run, http.StatusAccepted)
+			slog.Info("dependency wake started", "username", claims.Username, "mac_address", id, "prerequisites", len(steps)-1, "run_id", run.ID)
+			return
+		}
+	}
+
+	err = power.Wake(*device)
+	a.auditEvent(r, audit.Entry{Action: audit.ActionWake, Username: claims.Username, Target: device.MACAddress, Result: auditResult(err), Detail: errDetail(err)})
+	if err != nil && err == power.ErrNoBroadcastIP {
 		writeRespErr(w, "Device missing broadcast ip configuration", http.StatusBadRequest)
 		slog.Error("broadcast ip not set for device", "username", claims.Username, "mac_address", id)
 		return
-	}
-
-	err = wol.SendMagicPacket(device.MACAddress, broadcastIP)
-	if err != nil {
+	} else if err != nil {
 		writeRespErr(w, "magic packet failed to send", http.StatusInternalServerError)
 		slog.Error("magic packet failed to send", "username", claims.Username, "device", device, "error", err)
 		return
@@ -295,8 +428,12 @@ func (a *API) handleDevicesReorder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := a.store.ReorderDevices(macAddresses)
-	if err != nil {
+	err := a.store.ReorderDevicesForUser(claims.Username, macAddresses)
+	if err != nil && err == store.ErrUserDeviceMappingNotFound {
+		writeRespErr(w, "Device not found", http.StatusNotFound)
+		slog.Error("reorder contains device not owned by user", "username", claims.Username)
+		return
+	} else if err != nil {
 		writeRespErr(w, "Failed to reorder devices", http.StatusInternalServerError)
 		slog.Error("failed to reorder devices", "username", claims.Username, "error", err)
 		return
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+	"wolite/internal/audit"
+	"wolite/internal/store"
+)
+
+// handleDeviceRevisionsGet returns the revision history of an owned device, newest first. (jwt protected)
+func (a *API) handleDeviceRevisionsGet(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, err := a.store.GetDeviceForUser(claims.Username, id); err != nil {
+		writeRespErr(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	revisions, err := a.store.GetDeviceRevisions(id)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve revisions", http.StatusInternalServerError)
+		slog.Error("failed to retrieve device revisions", "username", claims.Username, "mac_address", id, "error", err)
+		return
+	}
+
+	writeRespOk(w, "revisions retrieved", revisions)
+}
+
+// handleDeviceRevisionRestore brings an owned device back to the configuration of an earlier revision.
+// The restore is recorded as a new revision. (jwt protected)
+func (a *API) handleDeviceRevisionRestore(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, err := a.store.GetDeviceForUser(claims.Username, id); err != nil {
+		writeRespErr(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	// Going back to an enabled idle policy arms a companion power action
+	revisions, _ := a.store.GetDeviceRevisions(id)
+	for _, rev := range revisions {
+		if rev.ID == r.PathValue("revision") && idlePolicyArmed(rev.Config.IdlePolicy) && !a.checkRecentAuth(w, r) {
+			return
+		}
+	}
+
+	device, rev, err := a.store.RestoreDeviceRevision(claims.Username, id, r.PathValue("revision"))
+	if err != nil && err == store.ErrDeviceRevisionNotFound {
+		writeRespErr(w, "Revision not found", http.StatusNotFound)
+		return
+	} else if err != nil && err == store.ErrDependencyCycle {
+		writeRespErr(w, "Restored dependencies would create a cycle", http.StatusConflict)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to restore revision", http.StatusInternalServerError)
+		slog.Error("failed to restore device revision", "username", claims.Username, "mac_address", id, "error", err)
+		return
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionDeviceRestored, Username: claims.Username, Target: id, Detail: fmt.Sprintf("revision %d", rev.RestoredFrom)})
+	writeRespOk(w, "revision restored", device)
+	slog.Info("device revision restored", "username", claims.Username, "mac_address", id, "revision", rev.RestoredFrom)
+}
+
+type deletedDeviceResponse struct {
+	store.DeletedDevice
+	PurgeAt *time.Time `json:"purge_at,omitempty"` // when the device is removed for good, none if kept forever
+}
+
+// handleDeletedDevicesGet lists the devices in the trash that the user owned, most recently deleted first. (jwt protected)
+func (a *API) handleDeletedDevicesGet(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	deleted, err := a.store.GetDeletedDevicesForUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve deleted devices", http.StatusInternalServerError)
+		slog.Error("failed to retrieve deleted devices", "username", claims.Username, "error", err)
+		return
+	}
+
+	resp := make([]deletedDeviceResponse, len(deleted))
+	for i, d := range deleted {
+		// Other users' mappings and companion secrets are not the caller's business
+		d.Mappings = nil
+		d.Device.CompanionToken = ""
+		resp[i] = deletedDeviceResponse{DeletedDevice: d}
+		if a.config.DeviceTrashRetention > 0 {
+			purgeAt := d.DeletedAt.Add(a.config.DeviceTrashRetention)
+			resp[i].PurgeAt = &purgeAt
+		}
+	}
+	writeRespOk(w, "deleted devices retrieved", resp)
+}
+
+// handleDeletedDeviceRestore takes a device out of the trash for everyone who had it. (jwt protected)
+func (a *API) handleDeletedDeviceRestore(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	deleted, _ := a.store.GetDeletedDevicesForUser(claims.Username)
+	for _, d := range deleted {
+		if d.Device.MACAddress == id && idlePolicyArmed(d.Device.IdlePolicy) && !a.checkRecentAuth(w, r) {
+			return
+		}
+	}
+
+	device, err := a.store.RestoreDeletedDevice(claims.Username, id)
+	if err != nil && err == store.ErrDeletedDeviceNotFound {
+		writeRespErr(w, "Deleted device not found", http.StatusNotFound)
+		return
+	} else if err != nil && err == store.ErrDeviceExists {
+		writeRespErr(w, "A device with this MAC address exists again", http.StatusConflict)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to restore device", http.StatusInternalServerError)
+		slog.Error("failed to restore deleted device", "username", claims.Username, "mac_address", id, "error", err)
+		return
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionDeviceRestored, Username: claims.Username, Target: id, Detail: "from trash"})
+	writeRespOk(w, "device restored", device)
+	slog.Info("deleted device restored", "username", claims.Username, "mac_address", id)
+}
+
+// handleDeletedDevicePurge removes a device from the trash for good. (jwt protected)
+func (a *API) handleDeletedDevicePurge(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	err := a.store.PurgeDeletedDevice(claims.Username, id)
+	if err != nil && err == store.ErrDeletedDeviceNotFound {
+		writeRespErr(w, "Deleted device not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to purge device", http.StatusInternalServerError)
+		slog.Error("failed to purge deleted device", "username", claims.Username, "mac_address", id, "error", err)
+		return
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionDevicePurged, Username: claims.Username, Target: id})
+	writeRespOk(w, "device purged", nil)
+	slog.Info("deleted device purged", "username", claims.Username, "mac_address", id)
+}
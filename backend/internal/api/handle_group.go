@@ -0,0 +1,311 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"wolite/internal/audit"
+	"wolite/internal/companion"
+	"wolite/internal/power"
+	"wolite/internal/store"
+)
+
+type groupRequest struct {
+	Name string `json:"name"`
+}
+
+// bulkResult reports the outcome of a group action for a single device.
+type bulkResult struct {
+	MACAddress string `json:"mac_address"`
+	Name       string `json:"name"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+}
+
+// handleGroupsGetAll returns all groups owned by the user. (jwt protected)
+func (a *API) handleGroupsGetAll(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := a.store.GetGroupsForUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve groups", http.StatusInternalServerError)
+		slog.Error("failed to retrieve groups", "username", claims.Username, "error", err)
+		return
+	}
+
+	writeRespOk(w, "groups retrieved", groups)
+}
+
+// handleGroupCreate creates a new group for the user. (jwt protected)
+func (a *API) handleGroupCreate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var req groupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeRespErr(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	group := store.NewGroup(claims.Username, name)
+	if err := a.store.CreateGroup(group); err != nil {
+		writeRespErr(w, "Failed to create group", http.StatusInternalServerError)
+		slog.Error("failed to create group", "username", claims.Username, "error", err)
+		return
+	}
+
+	writeRespWithStatus(w, "group created", group, http.StatusCreated)
+	slog.Info("group created", "username", claims.Username, "group_id", group.ID)
+}
+
+// handleGroupUpdate renames a group. (jwt protected)
+func (a *API) handleGroupUpdate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	group, err := a.store.GetGroupForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Group not found", http.StatusNotFound)
+		return
+	}
+
+	var req groupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if name := strings.TrimSpace(req.Name); name != "" {
+		group.Name = name
+	}
+
+	if err := a.store.UpdateGroup(group); err != nil {
+		writeRespErr(w, "Failed to update group", http.StatusInternalServerError)
+		slog.Error("failed to update group", "username", claims.Username, "group_id", group.ID, "error", err)
+		return
+	}
+
+	writeRespOk(w, "group updated", group)
+}
+
+// handleGroupDelete deletes a group. Its devices become ungrouped. (jwt protected)
+func (a *API) handleGroupDelete(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	err := a.store.DeleteGroup(claims.Username, id)
+	if err != nil && err == store.ErrGroupNotFound {
+		writeRespErr(w, "Group not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to delete group", http.StatusInternalServerError)
+		slog.Error("failed to delete group", "username", claims.Username, "group_id", id, "error", err)
+		return
+	}
+
+	writeRespOk(w, "group deleted", nil)
+	slog.Info("group deleted", "username", claims.Username, "group_id", id)
+}
+
+// handleGroupsReorder reorders the user's groups. (jwt protected)
+func (a *API) handleGroupsReorder(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var ids []string
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := a.store.ReorderGroupsForUser(claims.Username, ids)
+	if err != nil && err == store.ErrGroupNotFound {
+		writeRespErr(w, "Group not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to reorder groups", http.StatusInternalServerError)
+		slog.Error("failed to reorder groups", "username", claims.Username, "error", err)
+		return
+	}
+
+	writeRespOk(w, "groups reordered", nil)
+}
+
+// groupDevices returns the user's devices filed under a group, or an error if the group is not theirs.
+func (a *API) groupDevices(username, groupID string) ([]store.UserDevice, error) {
+	if _, err := a.store.GetGroupForUser(username, groupID); err != nil {
+		return nil, err
+	}
+
+	devices, err := a.store.GetDevicesForUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	inGroup := make([]store.UserDevice, 0)
+	for _, d := range devices {
+		if d.GroupID == groupID {
+			inGroup = append(inGroup, d)
+		}
+	}
+	return inGroup, nil
+}
+
+// handleGroupDevicesGet returns the devices in a group. (jwt protected)
+func (a *API) handleGroupDevicesGet(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	devices, err := a.groupDevices(claims.Username, r.PathValue("id"))
+	if err != nil && err == store.ErrGroupNotFound {
+		writeRespErr(w, "Group not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to retrieve devices", http.StatusInternalServerError)
+		slog.Error("failed to retrieve group devices", "username", claims.Username, "error", err)
+		return
+	}
+
+	// Tokens restricted to devices only see those, and companion secrets are never listed
+	filtered := make([]store.UserDevice, 0, len(devices))
+	for _, d := range devices {
+		if !tokenAllowsDevice(r, d.MACAddress) {
+			continue
+		}
+		d.CompanionToken = ""
+		filtered = append(filtered, d)
+	}
+
+	writeRespOk(w, "devices retrieved", filtered)
+}
+
+// handleGroupWake sends a magic packet to every device in a group. (jwt protected)
+func (a *API) handleGroupWake(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	devices, err := a.groupDevices(claims.Username, id)
+	if err != nil && err == store.ErrGroupNotFound {
+		writeRespErr(w, "Group not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to retrieve devices", http.StatusInternalServerError)
+		slog.Error("failed to retrieve group devices", "username", claims.Username, "error", err)
+		return
+	}
+
+	results := make([]bulkResult, len(devices))
+	for i, d := range devices {
+		results[i] = bulkResult{MACAddress: d.MACAddress, Name: d.Name, Success: true}
+		entry := audit.Entry{Action: audit.ActionWake, Username: claims.Username, Target: d.MACAddress, Detail: "group " + id}
+		if err := power.Wake(d.Device); err != nil {
+			results[i].Success = false
+			results[i].Error = err.Error()
+			entry.Result = audit.ResultFailure
+			entry.Detail += ": " + err.Error()
+		}
+		a.auditEvent(r, entry)
+	}
+
+	writeRespOk(w, "wake commands sent", results)
+	slog.Info("group wake sent", "username", claims.Username, "group_id", id, "devices_count", len(devices))
+}
+
+// handleGroupCompanionAction sends a power command to every paired device in a group. (jwt protected)
+func (a *API) handleGroupCompanionAction(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var req companionActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	action, err := companion.ParseAction(req.Action)
+	if err != nil {
+		writeRespErr(w, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
+	id := r.PathValue("id")
+	devices, err := a.groupDevices(claims.Username, id)
+	if err != nil && err == store.ErrGroupNotFound {
+		writeRespErr(w, "Group not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to retrieve devices", http.StatusInternalServerError)
+		slog.Error("failed to retrieve group devices", "username", claims.Username, "error", err)
+		return
+	}
+
+	// Companions are contacted concurrently so one slow machine does not hold up the rest
+	results := make([]bulkResult, len(devices))
+	var wg sync.WaitGroup
+	for i, d := range devices {
+		results[i] = bulkResult{MACAddress: d.MACAddress, Name: d.Name, Success: true}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := power.Execute(r.Context(), d.Device, action); err != nil {
+				results[i].Success = false
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, res := range results {
+		entry := audit.Entry{Action: audit.ActionPower, Username: claims.Username, Target: res.MACAddress, Detail: string(action) + ", group " + id}
+		if !res.Success {
+			entry.Result = audit.ResultFailure
+			entry.Detail += ": " + res.Error
+		}
+		a.auditEvent(r, entry)
+	}
+	writeRespOk(w, "commands executed", results)
+	slog.Info("group companion command executed", "username", claims.Username, "group_id", id, "action", action, "devices_count", len(devices))
+}
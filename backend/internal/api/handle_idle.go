@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+	"wolite/internal/audit"
+	"wolite/internal/companion"
+	"wolite/internal/store"
+	"wolite/internal/worker"
+)
+
+const (
+	maxIdleMinutes    = 24 * 60
+	maxWarningMinutes = 60
+)
+
+type idlePolicyRequest struct {
+	Enabled        *bool  `json:"enabled,omitempty"` // defaults to true
+	IdleMinutes    int    `json:"idle_minutes"`
+	Action         string `json:"action"`
+	WindowStart    string `json:"window_start,omitempty"`
+	WindowEnd      string `json:"window_end,omitempty"`
+	Timezone       string `json:"timezone,omitempty"` // defaults to UTC
+	WarningMinutes int    `json:"warning_minutes,omitempty"`
+}
+
+func (r *idlePolicyRequest) Validate() error {
+	if r.IdleMinutes < 1 || r.IdleMinutes > maxIdleMinutes {
+		return errors.New("idle_minutes must be between 1 and 1440")
+	}
+	if _, err := companion.ParseAction(r.Action); err != nil {
+		return errors.New("action must be a companion power action")
+	}
+	if r.WarningMinutes < 0 || r.WarningMinutes > maxWarningMinutes || r.WarningMinutes >= r.IdleMinutes {
+		return errors.New("warning_minutes must be between 0 and 60 and less than idle_minutes")
+	}
+	if (r.WindowStart == "") != (r.WindowEnd == "") {
+		return errors.New("window_start and window_end must be set together")
+	}
+	if r.WindowStart != "" && r.WindowStart == r.WindowEnd {
+		return errors.New("window_start and window_end must differ")
+	}
+	// Reuse the monitor's parsing so invalid windows are rejected here rather than skipped later
+	if _, err := worker.InIdleWindow(r.policy(), time.Now()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *idlePolicyRequest) policy() store.IdlePolicy {
+	return store.IdlePolicy{
+		Enabled:        r.Enabled == nil || *r.Enabled,
+		IdleMinutes:    r.IdleMinutes,
+		Action:         r.Action,
+		WindowStart:    r.WindowStart,
+		WindowEnd:      r.WindowEnd,
+		Timezone:       r.Timezone,
+		WarningMinutes: r.WarningMinutes,
+	}
+}
+
+// handleDeviceIdlePolicyUpdate sets the automatic idle power action of a device. (jwt protected)
+func (a *API) handleDeviceIdlePolicyUpdate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	device, err := a.store.GetDeviceForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	var req idlePolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	if err := req.Validate(); err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	policy := req.policy()
+	if idlePolicyArmed(&policy) && !a.checkRecentAuth(w, r) {
+		return
+	}
+	device.IdlePolicy = &policy
+	if _, err := a.store.UpdateDeviceConfig(claims.Username, device); err != nil {
+		writeRespErr(w, "Failed to update device", http.StatusInternalServerError)
+		slog.Error("failed to update device", "mac", device.MACAddress, "error", err)
+		return
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionIdlePolicyChanged, Username: claims.Username, Target: device.MACAddress, Detail: fmt.Sprintf("%s after %d minutes, enabled %t", policy.Action, policy.IdleMinutes, policy.Enabled)})
+	writeRespOk(w, "idle policy updated", device)
+	slog.Info("idle policy updated", "username", claims.Username, "mac", device.MACAddress, "enabled", policy.Enabled)
+}
+
+// handleDeviceIdlePolicyDelete removes the idle policy of a device. (jwt protected)
+func (a *API) handleDeviceIdlePolicyDelete(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	device, err := a.store.GetDeviceForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	device.IdlePolicy = nil
+	if _, err := a.store.UpdateDeviceConfig(claims.Username, device); err != nil {
+		writeRespErr(w, "Failed to update device", http.StatusInternalServerError)
+		slog.Error("failed to update device", "mac", device.MACAddress, "error", err)
+		return
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionIdlePolicyChanged, Username: claims.Username, Target: device.MACAddress, Detail: "removed"})
+	writeRespOk(w, "idle policy removed", device)
+	slog.Info("idle policy removed", "username", claims.Username, "mac", device.MACAddress)
+}
+
+// handleDeviceCompanionIdle returns the user idle time and sessions reported by the companion. (jwt protected)
+func (a *API) handleDeviceCompanionIdle(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	device, err := a.store.GetDeviceForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	if device.CompanionURL == "" || device.CompanionToken == "" {
+		writeRespErr(w, "Companion not paired", http.StatusBadRequest)
+		return
+	}
+
+	client, err := companion.NewClient(device.CompanionURL, device.CompanionToken, device.CompanionAuthFingerprint)
+	if err != nil {
+		writeRespErr(w, "Invalid companion configuration", http.StatusInternalServerError)
+		slog.Error("failed to create client", "url", device.CompanionURL, "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	status, err := client.Idle(ctx)
+	if err != nil {
+		writeRespErr(w, "Failed to get idle status: "+err.Error(), http.StatusBadGateway)
+		slog.Warn("failed to get idle status", "mac", device.MACAddress, "error", err)
+		return
+	}
+
+	writeRespOk(w, "idle status retrieved", status)
+}
+
+// idlePolicyArmed reports whether a policy will run its companion power action. Setting one needs a
+// recent authentication like running the action now.
+func idlePolicyArmed(policy *store.IdlePolicy) bool {
+	return policy != nil && policy.Enabled
+}
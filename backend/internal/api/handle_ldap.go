@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"wolite/internal/audit"
+	"wolite/internal/auth"
+	"wolite/internal/ldap"
+	"wolite/internal/store"
+)
+
+// usesLDAP reports whether a password login is checked against the directory: users linked to it,
+// and names without a local account. Local accounts keep their own password, also when the
+// directory has a user of the same name.
+func (a *API) usesLDAP(user store.User, err error) bool {
+	if a.ldap == nil {
+		return false
+	}
+	if err == store.ErrUserNotFound {
+		return true
+	}
+	return err == nil && user.Provider == store.ProviderLDAP
+}
+
+// checkPassword verifies the password of a logged-in user, against the directory for LDAP users.
+func (a *API) checkPassword(ctx context.Context, user store.User, password string) bool {
+	if user.Provider != store.ProviderLDAP {
+		return auth.CheckPasswordHash(password, user.Password)
+	}
+	if a.ldap == nil {
+		return false
+	}
+	// Bound as the DN the user logged in with, not re-searched by name
+	err := a.ldap.Bind(ctx, user.Subject, password)
+	if err != nil && !errors.Is(err, ldap.ErrInvalidCredentials) {
+		slog.Error("ldap password check failed", "username", user.Username, "error", err)
+	}
+	return err == nil
+}
+
+// ldapUser authenticates against the directory and returns the local shadow user, creating it on
+// the first login so devices, schedules and tokens can belong to it. Roles follow the directory's
+// groups when admin groups are configured.
+func (a *API) ldapUser(ctx context.Context, username, password, ip string) (store.User, error) {
+	cfg := a.config.LDAP
+	entry, err := a.ldap.Authenticate(ctx, username, password)
+	if err != nil {
+		return store.User{}, err
+	}
+	if len(cfg.AllowedGroups) > 0 && !intersects(entry.Groups, cfg.AllowedGroups) {
+		return store.User{}, errSSOForbidden
+	}
+	role := mappedRole(cfg.AdminGroups, entry.Groups)
+
+	// The directory's spelling, so "Alice" and "alice" share one shadow user
+	name := strings.TrimSpace(entry.Get(cfg.UsernameAttr))
+	if name == "" {
+		name = username
+	}
+
+	user, err := a.store.FindUser(name)
+	if err == nil {
+		if user.Provider != store.ProviderLDAP {
+			return store.User{}, errSSOUsernameTaken
+		}
+		if (role != "" && role != user.Role) || user.Subject != entry.DN {
+			if role != "" {
+				user.Role = role
+			}
+			user.Subject = entry.DN
+			if err := a.store.UpdateUser(user); err != nil {
+				return store.User{}, err
+			}
+			slog.Info("user updated from directory", "username", user.Username, "role", user.Role)
+		}
+		return user, nil
+	}
+	if err != store.ErrUserNotFound {
+		return store.User{}, err
+	}
+	if strings.ContainsAny(name, "/\\ \t\r\n") {
+		return store.User{}, errSSOInvalidUsername
+	}
+
+	user = store.User{Username: name, Role: role, Provider: store.ProviderLDAP, Subject: entry.DN}
+	if err := a.store.CreateUser(user); err != nil {
+		if err == store.ErrUserExists {
+			return store.User{}, errSSOUsernameTaken
+		}
+		return store.User{}, err
+	}
+	a.audit.Log(audit.Entry{Action: audit.ActionUserProvisioned, Username: name, IP: ip, Detail: "ldap " + entry.DN})
+	slog.Info("user provisioned from directory", "username", name, "dn", entry.DN)
+
+	return a.store.FindUser(name)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"wolite/internal/store"
+)
+
+// handleNotificationsGetAll lists the user's notifications, only unread ones with ?unread=true. (jwt protected)
+func (a *API) handleNotificationsGetAll(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	notifications, err := a.store.GetNotificationsForUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve notifications", http.StatusInternalServerError)
+		slog.Error("failed to retrieve notifications", "username", claims.Username, "error", err)
+		return
+	}
+
+	if r.URL.Query().Get("unread") == "true" {
+		unread := make([]store.Notification, 0, len(notifications))
+		for _, n := range notifications {
+			if n.ReadAt == nil {
+				unread = append(unread, n)
+			}
+		}
+		notifications = unread
+	}
+	writeRespOk(w, "notifications retrieved", notifications)
+}
+
+// handleNotificationsRead marks one notification, or all of them without an ID, as read. (jwt protected)
+func (a *API) handleNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	err := a.store.MarkNotificationsRead(claims.Username, id)
+	if err != nil && err == store.ErrNotificationNotFound {
+		writeRespErr(w, "Notification not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to update notifications", http.StatusInternalServerError)
+		slog.Error("failed to mark notifications read", "username", claims.Username, "notification_id", id, "error", err)
+		return
+	}
+
+	writeRespOk(w, "notifications marked read", nil)
+}
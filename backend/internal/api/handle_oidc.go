@@ -0,0 +1,209 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"wolite/internal/audit"
+	"wolite/internal/oidc"
+	"wolite/internal/store"
+)
+
+const oidcStateCookie = "oidc_state"
+
+// SSO failures are reported to the login page as ?error=<code>
+var (
+	errSSOForbidden       = errors.New("sso_forbidden")
+	errSSONotProvisioned  = errors.New("sso_not_provisioned")
+	errSSOUsernameTaken   = errors.New("sso_username_taken")
+	errSSOInvalidUsername = errors.New("sso_invalid_username")
+)
+
+type providersResponse struct {
+	OIDC *oidcProviderInfo `json:"oidc,omitempty"`
+}
+
+type oidcProviderInfo struct {
+	Name     string `json:"name"`
+	LoginURL string `json:"login_url"`
+}
+
+// handleAuthProviders lists the login methods besides username and password, for the login page.
+func (a *API) handleAuthProviders(w http.ResponseWriter, r *http.Request) {
+	var resp providersResponse
+	if a.oidc != nil {
+		resp.OIDC = &oidcProviderInfo{Name: a.config.OIDC.DisplayName, LoginURL: "/api/v1/auth/oidc/login"}
+	}
+	writeRespOk(w, "ok", resp)
+}
+
+// handleOIDCLogin sends the browser to the identity provider.
+func (a *API) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		writeRespErr(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	login, err := a.oidcLogins.Begin(a.oidcRedirectURL(r))
+	if err != nil {
+		slog.Error("failed to start oidc login", "error", err)
+		redirectLoginError(w, r, "sso_unavailable")
+		return
+	}
+	authURL, err := a.oidc.AuthCodeURL(r.Context(), login.RedirectURL, login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		slog.Error("identity provider unavailable", "error", err)
+		redirectLoginError(w, r, "sso_unavailable")
+		return
+	}
+
+	// Binds the callback to this browser, so a login started elsewhere cannot be completed here.
+	// Lax, because the provider redirects back cross-site.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    login.State,
+		Path:     "/api/v1/auth/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   a.secureCookies(r),
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleOIDCCallback completes the login when the identity provider redirects back.
+func (a *API) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		writeRespErr(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/v1/auth/oidc", MaxAge: -1, HttpOnly: true})
+
+	q := r.URL.Query()
+	ip := a.clientIP(r)
+	if errCode := q.Get("error"); errCode != "" {
+		slog.Warn("identity provider returned an error", "error", errCode, "description", q.Get("error_description"))
+		redirectLoginError(w, r, "sso_denied")
+		return
+	}
+
+	c, err := r.Cookie(oidcStateCookie)
+	state := q.Get("state")
+	if err != nil || state == "" || c.Value != state {
+		redirectLoginError(w, r, "sso_state")
+		return
+	}
+	login, ok := a.oidcLogins.Finish(state)
+	if !ok {
+		redirectLoginError(w, r, "sso_state")
+		return
+	}
+
+	claims, err := a.oidc.Exchange(r.Context(), login.RedirectURL, q.Get("code"), login.Verifier, login.Nonce)
+	if err != nil {
+		a.audit.Log(audit.Entry{Action: audit.ActionSSOFailed, IP: ip, Result: audit.ResultFailure, Detail: err.Error()})
+		slog.Warn("oidc login failed", "ip", ip, "error", err)
+		redirectLoginError(w, r, "sso_failed")
+		return
+	}
+
+	user, err := a.oidcUser(claims, ip)
+	if err != nil {
+		a.audit.Log(audit.Entry{Action: audit.ActionSSOFailed, Username: claims.String(a.config.OIDC.UsernameClaim), IP: ip, Result: audit.ResultFailure, Detail: err.Error()})
+		slog.Warn("oidc login rejected", "subject", claims.Subject, "ip", ip, "error", err)
+		code := "sso_failed"
+		if slices.Contains([]error{errSSOForbidden, errSSONotProvisioned, errSSOUsernameTaken, errSSOInvalidUsername}, err) {
+			code = err.Error()
+		}
+		redirectLoginError(w, r, code)
+		return
+	}
+
+	if err := a.issueSessionCookie(w, r, user.Username, "oidc"); err != nil {
+		slog.Error("failed to generate token", "username", user.Username, "error", err)
+		redirectLoginError(w, r, "sso_failed")
+		return
+	}
+	slog.Info("oidc login", "username", user.Username, "subject", claims.Subject)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// oidcUser finds or provisions the local user of an identity and applies the role mapping.
+func (a *API) oidcUser(claims *oidc.Claims, ip string) (store.User, error) {
+	cfg := a.config.OIDC
+	groups := claims.Strings(cfg.GroupsClaim)
+	if len(cfg.AllowedGroups) > 0 && !intersects(groups, cfg.AllowedGroups) {
+		return store.User{}, errSSOForbidden
+	}
+	role := mappedRole(cfg.AdminGroups, groups)
+
+	user, err := a.store.FindUserBySubject(store.ProviderOIDC, claims.Subject)
+	if err == nil {
+		if role != "" && role != user.Role {
+			slog.Info("role updated from identity provider", "username", user.Username, "role", role)
+			user.Role = role
+			if err := a.store.UpdateUser(user); err != nil {
+				return store.User{}, err
+			}
+		}
+		return user, nil
+	}
+	if err != store.ErrUserNotFound {
+		return store.User{}, err
+	}
+	if !cfg.AutoProvision {
+		return store.User{}, errSSONotProvisioned
+	}
+
+	username := claims.String(cfg.UsernameClaim)
+	if username == "" {
+		username = claims.String("email")
+	}
+	username = strings.TrimSpace(username)
+	if username == "" || strings.ContainsAny(username, "/\\ \t\r\n") {
+		return store.User{}, errSSOInvalidUsername
+	}
+
+	// A local account with the same name is never taken over
+	user = store.User{Username: username, Role: role, Provider: store.ProviderOIDC, Subject: claims.Subject}
+	if err := a.store.CreateUser(user); err != nil {
+		if err == store.ErrUserExists {
+			return store.User{}, errSSOUsernameTaken
+		}
+		return store.User{}, err
+	}
+	a.audit.Log(audit.Entry{Action: audit.ActionUserProvisioned, Username: username, IP: ip, Detail: "oidc subject " + claims.Subject})
+	slog.Info("user provisioned from identity provider", "username", username, "subject", claims.Subject)
+
+	return a.store.FindUser(username)
+}
+
+// oidcRedirectURL is the callback URL registered at the provider.
+func (a *API) oidcRedirectURL(r *http.Request) string {
+	if a.config.OIDC.RedirectURL != "" {
+		return a.config.OIDC.RedirectURL
+	}
+	return a.requestScheme(r) + "://" + r.Host + "/api/v1/auth/oidc/callback"
+}
+
+// mappedRole returns the role for the user's groups, or "" when roles are not managed by the provider.
+func mappedRole(adminGroups, groups []string) store.Role {
+	if len(adminGroups) == 0 {
+		return ""
+	}
+	if intersects(groups, adminGroups) {
+		return store.RoleAdmin
+	}
+	return store.RoleUser
+}
+
+func intersects(a, b []string) bool {
+	return slices.ContainsFunc(a, func(s string) bool { return slices.Contains(b, s) })
+}
+
+func redirectLoginError(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, "/login?error="+url.QueryEscape(code), http.StatusFound)
+}
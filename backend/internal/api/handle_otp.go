@@ -0,0 +1,222 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"wolite/internal/audit"
+	"wolite/internal/auth"
+	"wolite/internal/store"
+)
+
+const recoveryCodeCount = 10
+
+// otpChangeRequest proves possession of both factors before 2FA settings change.
+type otpChangeRequest struct {
+	Password     string `json:"password"`
+	OTP          string `json:"otp,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"` // instead of otp, e.g. after losing the authenticator
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"` // shown once, only their hashes are stored
+}
+
+// checkSecondFactor verifies an OTP code, or consumes a recovery code when one is given.
+func (a *API) checkSecondFactor(user store.User, otp, recoveryCode, ip string) bool {
+	if recoveryCode == "" {
+		return otp != "" && auth.Validate2FA(otp, user.OTP)
+	}
+
+	err := a.store.UseRecoveryCode(user.Username, auth.HashRecoveryCode(recoveryCode))
+	if err != nil {
+		if err != store.ErrRecoveryCodeNotFound {
+			slog.Error("failed to use recovery code", "username", user.Username, "error", err)
+		}
+		return false
+	}
+	a.audit.Log(audit.Entry{Action: audit.ActionRecoveryCodeUsed, Username: user.Username, IP: ip})
+	slog.Info("recovery code used", "username", user.Username, "remaining", len(user.RecoveryCodes)-1)
+	return true
+}
+
+// checkOTPChange verifies the password and second factor of a 2FA change and writes the error response
+// if they do not match. Failures count towards the account's login lockout.
+func (a *API) checkOTPChange(w http.ResponseWriter, r *http.Request, user store.User) (otpChangeRequest, bool) {
+	var req otpChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	if user.OTP == "" {
+		writeRespErr(w, "2FA is not enabled", http.StatusBadRequest)
+		return req, false
+	}
+
+	ip := a.clientIP(r)
+	if wait := a.loginAccounts.Begin(user.Username); wait > 0 {
+		writeThrottled(w, wait)
+		return req, false
+	}
+	defer a.loginAccounts.Done(user.Username)
+	if !a.checkPassword(r.Context(), user, req.Password) {
+		a.loginFailed(w, user.Username, ip, "wrong password (2fa change)", "Invalid password")
+		return req, false
+	}
+	if !a.checkSecondFactor(user, req.OTP, req.RecoveryCode, ip) {
+		a.loginFailed(w, user.Username, ip, "wrong otp (2fa change)", "Invalid OTP")
+		return req, false
+	}
+	return req, true
+}
+
+// handleUserOTPDisable turns 2FA off. Requires the password and an OTP or recovery code. (jwt protected)
+func (a *API) handleUserOTPDisable(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := a.store.FindUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if _, ok := a.checkOTPChange(w, r, user); !ok {
+		return
+	}
+
+	// Re-read, a recovery code may have been consumed
+	user, err = a.store.FindUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "User not found", http.StatusNotFound)
+		return
+	}
+	user.OTP = ""
+	user.PendingOTP = ""
+	user.RecoveryCodes = nil
+	if err := a.store.UpdateUser(user); err != nil {
+		writeRespErr(w, "Failed to update user", http.StatusInternalServerError)
+		slog.Error("failed to disable 2FA", "username", claims.Username, "error", err)
+		return
+	}
+
+	a.audit.Log(audit.Entry{Action: audit.ActionOTPDisabled, Actor: claims.Username, Username: claims.Username, IP: a.clientIP(r)})
+	writeRespOk(w, "2FA disabled", nil)
+	slog.Info("2FA disabled", "username", claims.Username)
+}
+
+// handleUserOTPReenroll starts enrollment of a new authenticator. The current one keeps working
+// until the new one is confirmed with /users/otp/verify. (jwt protected)
+func (a *API) handleUserOTPReenroll(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := a.store.FindUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if _, ok := a.checkOTPChange(w, r, user); !ok {
+		return
+	}
+
+	secret, url, err := auth.GenerateOTPSecret(claims.Username)
+	if err != nil {
+		slog.Error("OTP secret generation failed", "error", err)
+		writeRespErr(w, "System error", http.StatusInternalServerError)
+		return
+	}
+	user, err = a.store.FindUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "User not found", http.StatusNotFound)
+		return
+	}
+	user.PendingOTP = secret
+	if err := a.store.UpdateUser(user); err != nil {
+		writeRespErr(w, "Failed to update user", http.StatusInternalServerError)
+		slog.Error("failed to start 2FA re-enrollment", "username", claims.Username, "error", err)
+		return
+	}
+
+	writeRespOk(w, "2FA re-enrollment started", map[string]string{"otp_url": url})
+	slog.Info("2FA re-enrollment started", "username", claims.Username)
+}
+
+// handleUserRecoveryCodesRegenerate replaces the user's recovery codes with new ones. (jwt protected)
+func (a *API) handleUserRecoveryCodesRegenerate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := a.store.FindUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if _, ok := a.checkOTPChange(w, r, user); !ok {
+		return
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		slog.Error("recovery code generation failed", "error", err)
+		writeRespErr(w, "System error", http.StatusInternalServerError)
+		return
+	}
+	user, err = a.store.FindUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "User not found", http.StatusNotFound)
+		return
+	}
+	user.RecoveryCodes = hashes
+	if err := a.store.UpdateUser(user); err != nil {
+		writeRespErr(w, "Failed to update user", http.StatusInternalServerError)
+		slog.Error("failed to store recovery codes", "username", claims.Username, "error", err)
+		return
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionRecoveryCodesReset, Username: claims.Username})
+	writeRespOk(w, "recovery codes regenerated", recoveryCodesResponse{RecoveryCodes: codes})
+	slog.Info("recovery codes regenerated", "username", claims.Username)
+}
+
+// handleAdminUserOTPReset removes a user's 2FA (OTP and passkeys), e.g. when they lost both the
+// authenticator and their recovery codes. (admin only)
+func (a *API) handleAdminUserOTPReset(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	username := r.PathValue("username")
+	user, err := a.store.FindUser(username)
+	if err != nil {
+		writeRespErr(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !user.HasSecondFactor() && user.PendingOTP == "" {
+		writeRespErr(w, "2FA is not enabled for this user", http.StatusBadRequest)
+		return
+	}
+
+	user.OTP = ""
+	user.PendingOTP = ""
+	user.RecoveryCodes = nil
+	user.Credentials = nil
+	if err := a.store.UpdateUser(user); err != nil {
+		writeRespErr(w, "Failed to update user", http.StatusInternalServerError)
+		slog.Error("failed to reset 2FA", "username", username, "error", err)
+		return
+	}
+
+	a.audit.Log(audit.Entry{Action: audit.ActionOTPReset, Actor: claims.Username, Username: username, IP: a.clientIP(r)})
+	writeRespOk(w, "2FA reset", nil)
+	slog.Info("2FA reset by admin", "admin", claims.Username, "username", username)
+}
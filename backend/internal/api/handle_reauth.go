@@ -0,0 +1,231 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+	"wolite/internal/audit"
+	"wolite/internal/auth"
+	"wolite/internal/store"
+	"wolite/internal/webauthn"
+)
+
+// msgReauthRequired is the 403 message the frontend answers with a re-authentication prompt.
+const msgReauthRequired = "Recent authentication required"
+
+type reauthRequest struct {
+	Password     string `json:"password"`
+	OTP          string `json:"otp,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+type reauthResponse struct {
+	AuthTime   time.Time `json:"auth_time"`
+	ValidUntil time.Time `json:"valid_until"` // sensitive actions are allowed until then
+}
+
+// requireRecentAuth guards actions a hijacked session must not be able to take right away, such as
+// powering machines off. See checkRecentAuth.
+func (a *API) requireRecentAuth(next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.checkRecentAuth(w, r) {
+			next(w, r)
+		}
+	}
+}
+
+// checkRecentAuth requires browser sessions to have logged in or re-authenticated within the configured
+// window, and writes the 403 otherwise. Personal access tokens are explicit grants for their scope, and
+// forward auth users are authenticated by the proxy on every request, so both pass.
+func (a *API) checkRecentAuth(w http.ResponseWriter, r *http.Request) bool {
+	ctx := r.Context()
+	if a.config.ReauthWindow == 0 || GetAccessTokenFromContext(ctx) != nil || viaForwardAuth(ctx) {
+		return true
+	}
+	claims := GetUserFromContext(ctx)
+	if claims != nil && claims.AuthenticatedWithin(a.config.ReauthWindow) {
+		return true
+	}
+
+	username := ""
+	if claims != nil {
+		username = claims.Username
+	}
+	slog.Info("recent authentication required", "path", r.URL.Path, "username", username)
+	writeRespErr(w, msgReauthRequired, http.StatusForbidden)
+	return false
+}
+
+// handleAuthReauth confirms the user's identity with their password and, when enabled, their OTP. (jwt protected)
+func (a *API) handleAuthReauth(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if viaForwardAuth(r.Context()) {
+		writeRespErr(w, "Re-authentication is handled by your proxy", http.StatusBadRequest)
+		return
+	}
+
+	var req reauthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ip := a.clientIP(r)
+	done, wait := a.beginAttempt(ip, claims.Username)
+	if wait > 0 {
+		writeThrottled(w, wait)
+		return
+	}
+	defer done()
+
+	user, err := a.store.FindUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.Provider == store.ProviderOIDC || user.Provider == store.ProviderProxy {
+		writeRespErr(w, "Use a passkey or log in again through single sign-on", http.StatusBadRequest)
+		return
+	}
+	// Same rule as the login: users with passkeys but no OTP confirm with a passkey
+	if user.OTP == "" && len(user.Credentials) > 0 {
+		writeRespErr(w, "Passkey required", http.StatusUnauthorized)
+		return
+	}
+
+	if !a.checkPassword(r.Context(), user, req.Password) {
+		a.loginFailed(w, user.Username, ip, "wrong password (reauth)", "Invalid password")
+		return
+	}
+	if user.OTP != "" {
+		if req.OTP == "" && req.RecoveryCode == "" {
+			writeRespErr(w, "OTP required", http.StatusUnauthorized)
+			return
+		}
+		if !a.checkSecondFactor(user, req.OTP, req.RecoveryCode, ip) {
+			a.loginFailed(w, user.Username, ip, "wrong otp (reauth)", "Invalid OTP")
+			return
+		}
+	}
+
+	a.loginAccounts.Reset(user.Username)
+	a.completeReauth(w, r, claims, "password")
+}
+
+// handleReauthWebAuthnBegin returns the options for confirming the user's identity with one of their passkeys. (jwt protected)
+func (a *API) handleReauthWebAuthnBegin(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := a.store.FindUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if len(user.Credentials) == 0 {
+		writeRespErr(w, "No passkeys registered", http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := a.ceremonies.Begin(webauthn.Ceremony{Kind: ceremonyReauth, Username: user.Username, Expires: time.Now().Add(webAuthnTimeout)})
+	if err != nil {
+		writeCeremonyErr(w, err)
+		return
+	}
+
+	// The passkey is the only factor here, so it must verify the user
+	writeRespOk(w, "re-authentication started", requestOptions{
+		Challenge:        challenge,
+		RPID:             a.relyingParty(r).ID,
+		Timeout:          webAuthnTimeout.Milliseconds(),
+		AllowCredentials: credentialDescriptors(user.Credentials),
+		UserVerification: "required",
+	})
+}
+
+// handleReauthWebAuthnFinish verifies the passkey assertion and refreshes the session's authentication time. (jwt protected)
+func (a *API) handleReauthWebAuthnFinish(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		Credential authenticationResponse `json:"credential"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	resp := req.Credential.Response
+
+	challenge, err := webauthn.ChallengeFromClientData(resp.ClientDataJSON)
+	if err != nil {
+		writeRespErr(w, "Invalid client data", http.StatusBadRequest)
+		return
+	}
+	ceremony, ok := a.ceremonies.Finish(challenge)
+	if !ok || ceremony.Kind != ceremonyReauth || ceremony.Username != claims.Username {
+		writeRespErr(w, "Unknown or expired challenge", http.StatusBadRequest)
+		return
+	}
+
+	ip := a.clientIP(r)
+	done, wait := a.beginAttempt(ip, claims.Username)
+	if wait > 0 {
+		writeThrottled(w, wait)
+		return
+	}
+	defer done()
+
+	user, cred, err := a.store.FindUserByCredential(req.Credential.ID)
+	if err != nil || user.Username != claims.Username {
+		a.loginFailed(w, claims.Username, ip, "unknown passkey (reauth)", "Invalid passkey")
+		return
+	}
+	if err := a.verifyPasskey(r, user.Username, cred, challenge, resp, true); err != nil {
+		a.loginFailed(w, user.Username, ip, "passkey verification failed (reauth)", "Invalid passkey")
+		slog.Warn("webauthn re-authentication failed", "username", user.Username, "error", err)
+		return
+	}
+
+	a.loginAccounts.Reset(user.Username)
+	a.completeReauth(w, r, claims, "passkey")
+}
+
+// completeReauth reissues the session JWT of a cookie session with auth_time set to now.
+// Sessions authenticated otherwise (e.g. forward auth) have nothing to refresh.
+func (a *API) completeReauth(w http.ResponseWriter, r *http.Request, claims *auth.Claims, method string) {
+	now := time.Now()
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		kid, key, err := a.keys.Active()
+		if err != nil {
+			writeRespErr(w, "Failed to generate token", http.StatusInternalServerError)
+			slog.Error("failed to load signing key", "error", err)
+			return
+		}
+		token, err := auth.GenerateJWTToken(claims.Username, claims.ID, kid, key, claims.ExpiresAt.Time, now)
+		if err != nil {
+			writeRespErr(w, "Failed to generate token", http.StatusInternalServerError)
+			slog.Error("failed to generate token", "username", claims.Username, "error", err)
+			return
+		}
+		a.setSessionCookie(w, r, token, claims.ExpiresAt.Time)
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionReauth, Username: claims.Username, Detail: method})
+	writeRespOk(w, "re-authenticated", reauthResponse{AuthTime: now, ValidUntil: now.Add(a.config.ReauthWindow)})
+	slog.Info("re-authenticated", "username", claims.Username, "method", method)
+}
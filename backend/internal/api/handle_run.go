@@ -0,0 +1,55 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// handleRunsGetAll returns the user's recent background runs, newest first. (jwt protected)
+func (a *API) handleRunsGetAll(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeRespOk(w, "runs retrieved", a.runner.List(claims.Username, ""))
+}
+
+// handleRunGet returns the progress and result of a background run. (jwt protected)
+func (a *API) handleRunGet(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	run, ok := a.runner.Get(claims.Username, r.PathValue("id"))
+	if !ok {
+		writeRespErr(w, "Run not found", http.StatusNotFound)
+		return
+	}
+
+	writeRespOk(w, "run retrieved", run)
+}
+
+// handleRunCancel cancels a background run. Steps not yet finished are cancelled. (jwt protected)
+func (a *API) handleRunCancel(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	if !a.runner.Cancel(claims.Username, id) {
+		writeRespErr(w, "Run not found", http.StatusNotFound)
+		return
+	}
+
+	writeRespOk(w, "run cancelled", nil)
+	slog.Info("run cancelled", "username", claims.Username, "run_id", id)
+}
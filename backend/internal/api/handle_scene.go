@@ -0,0 +1,274 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"wolite/internal/audit"
+	"wolite/internal/companion"
+	"wolite/internal/store"
+)
+
+const (
+	maxSceneSteps      = 50
+	maxStepWaitSeconds = 3600
+)
+
+type sceneRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Steps       []store.SceneStep `json:"steps"`
+}
+
+func (r *sceneRequest) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+	if len(r.Steps) == 0 {
+		return errors.New("at least one step is required")
+	}
+	if len(r.Steps) > maxSceneSteps {
+		return fmt.Errorf("a scene can have at most %d steps", maxSceneSteps)
+	}
+	for i, step := range r.Steps {
+		if step.MACAddress == "" {
+			return fmt.Errorf("step %d: mac address is required", i+1)
+		}
+		switch step.Type {
+		case store.StepWake:
+		case store.StepPower:
+			if _, err := companion.ParseAction(step.Action); err != nil {
+				return fmt.Errorf("step %d: %w", i+1, err)
+			}
+			if step.WaitOnline {
+				return fmt.Errorf("step %d: wait_online is only valid for wake steps", i+1)
+			}
+		default:
+			return fmt.Errorf("step %d: type must be wake or power", i+1)
+		}
+		if step.DelaySeconds < 0 || step.DelaySeconds > maxStepWaitSeconds {
+			return fmt.Errorf("step %d: delay_seconds must be between 0 and %d", i+1, maxStepWaitSeconds)
+		}
+		if step.TimeoutSeconds < 0 || step.TimeoutSeconds > maxStepWaitSeconds {
+			return fmt.Errorf("step %d: timeout_seconds must be between 0 and %d", i+1, maxStepWaitSeconds)
+		}
+	}
+	return nil
+}
+
+// checkSceneDevices ensures every step targets a device the user has access to.
+func (a *API) checkSceneDevices(username string, steps []store.SceneStep) error {
+	for i, step := range steps {
+		if _, err := a.store.GetDeviceForUser(username, step.MACAddress); err != nil {
+			return fmt.Errorf("step %d: device %s not found", i+1, step.MACAddress)
+		}
+	}
+	return nil
+}
+
+// handleScenesGetAll returns all scenes owned by the user. (jwt protected)
+func (a *API) handleScenesGetAll(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	scenes, err := a.store.GetScenesForUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve scenes", http.StatusInternalServerError)
+		slog.Error("failed to retrieve scenes", "username", claims.Username, "error", err)
+		return
+	}
+
+	writeRespOk(w, "scenes retrieved", scenes)
+}
+
+// handleSceneGet returns a single scene. (jwt protected)
+func (a *API) handleSceneGet(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	scene, err := a.store.GetSceneForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Scene not found", http.StatusNotFound)
+		return
+	}
+
+	writeRespOk(w, "scene retrieved", scene)
+}
+
+// handleSceneCreate creates a new scene for the user. (jwt protected)
+func (a *API) handleSceneCreate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var req sceneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := a.checkSceneDevices(claims.Username, req.Steps); err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scene := store.NewScene(claims.Username, strings.TrimSpace(req.Name), req.Description, req.Steps)
+	if err := a.store.CreateScene(scene); err != nil {
+		writeRespErr(w, "Failed to create scene", http.StatusInternalServerError)
+		slog.Error("failed to create scene", "username", claims.Username, "error", err)
+		return
+	}
+
+	writeRespWithStatus(w, "scene created", scene, http.StatusCreated)
+	slog.Info("scene created", "username", claims.Username, "scene_id", scene.ID)
+}
+
+// handleSceneUpdate replaces a scene's name, description and steps. (jwt protected)
+func (a *API) handleSceneUpdate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	scene, err := a.store.GetSceneForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Scene not found", http.StatusNotFound)
+		return
+	}
+
+	var req sceneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := a.checkSceneDevices(claims.Username, req.Steps); err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Power steps added to a scene that is on a schedule will power machines off
+	if scenePowersOff(req.Steps) && a.sceneScheduled(claims.Username, scene.ID) && !a.checkRecentAuth(w, r) {
+		return
+	}
+
+	scene.Name = strings.TrimSpace(req.Name)
+	scene.Description = req.Description
+	scene.Steps = req.Steps
+
+	if err := a.store.UpdateScene(scene); err != nil {
+		writeRespErr(w, "Failed to update scene", http.StatusInternalServerError)
+		slog.Error("failed to update scene", "username", claims.Username, "scene_id", scene.ID, "error", err)
+		return
+	}
+
+	writeRespOk(w, "scene updated", scene)
+	slog.Info("scene updated", "username", claims.Username, "scene_id", scene.ID)
+}
+
+// handleSceneDelete deletes a scene. (jwt protected)
+func (a *API) handleSceneDelete(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	err := a.store.DeleteScene(claims.Username, id)
+	if err != nil && err == store.ErrSceneNotFound {
+		writeRespErr(w, "Scene not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to delete scene", http.StatusInternalServerError)
+		slog.Error("failed to delete scene", "username", claims.Username, "scene_id", id, "error", err)
+		return
+	}
+
+	writeRespOk(w, "scene deleted", nil)
+	slog.Info("scene deleted", "username", claims.Username, "scene_id", id)
+}
+
+// handleSceneRun starts a scene in the background and returns the run for progress polling. (jwt protected)
+func (a *API) handleSceneRun(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	scene, err := a.store.GetSceneForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Scene not found", http.StatusNotFound)
+		return
+	}
+	// Scenes that power machines off count as companion power actions
+	if scenePowersOff(scene.Steps) && !a.checkRecentAuth(w, r) {
+		return
+	}
+
+	run := a.runner.Start(claims.Username, scene.Name, scene.ID, scene.Steps)
+	a.auditEvent(r, audit.Entry{Action: audit.ActionSceneRun, Username: claims.Username, Target: scene.ID, Detail: scene.Name + ", run " + run.ID})
+	writeRespWithStatus(w, "scene started", run, http.StatusAccepted)
+	slog.Info("scene started", "username", claims.Username, "scene_id", scene.ID, "run_id", run.ID)
+}
+
+// handleSceneRunsGet returns the recent runs of a scene, newest first. (jwt protected)
+func (a *API) handleSceneRunsGet(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	scene, err := a.store.GetSceneForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Scene not found", http.StatusNotFound)
+		return
+	}
+
+	writeRespOk(w, "runs retrieved", a.runner.List(claims.Username, scene.ID))
+}
+
+// scenePowersOff reports whether a scene has steps that run companion power actions.
+func scenePowersOff(steps []store.SceneStep) bool {
+	return slices.ContainsFunc(steps, func(s store.SceneStep) bool { return s.Type == store.StepPower })
+}
+
+// sceneScheduled reports whether an enabled schedule of the user runs the scene.
+func (a *API) sceneScheduled(username, sceneID string) bool {
+	schedules, err := a.store.GetSchedulesForUser(username)
+	if err != nil {
+		return true // assume the worst, the caller only asks for re-authentication
+	}
+	return slices.ContainsFunc(schedules, func(s store.Schedule) bool {
+		return s.Enabled && s.TargetType == store.TargetScene && s.TargetID == sceneID
+	})
+}
@@ -0,0 +1,360 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"wolite/internal/companion"
+	"wolite/internal/cron"
+	"wolite/internal/store"
+	"wolite/internal/worker"
+)
+
+const maxNextRunPreview = 50
+
+type scheduleRequest struct {
+	Name            string                `json:"name"`
+	Cron            string                `json:"cron"`
+	Timezone        string                `json:"timezone,omitempty"` // defaults to UTC
+	TargetType      store.TargetType      `json:"target_type"`
+	TargetID        string                `json:"target_id"`
+	Action          string                `json:"action,omitempty"`            // "wake" or a companion power action, unused for scenes
+	Enabled         *bool                 `json:"enabled,omitempty"`           // defaults to true
+	MissedRunPolicy store.MissedRunPolicy `json:"missed_run_policy,omitempty"` // defaults to skip
+	CalendarIDs     []string              `json:"calendar_ids,omitempty"`
+}
+
+func (r *scheduleRequest) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+	if _, err := cron.Parse(r.Cron); err != nil {
+		return errors.New("invalid cron expression: " + err.Error())
+	}
+	if _, err := time.LoadLocation(r.Timezone); err != nil {
+		return errors.New("invalid timezone")
+	}
+	switch r.MissedRunPolicy {
+	case store.MissedSkip, store.MissedRunOnce:
+	default:
+		return errors.New("missed_run_policy must be skip or run_once")
+	}
+	switch r.TargetType {
+	case store.TargetDevice, store.TargetGroup:
+		if r.Action == "wake" {
+			return nil
+		}
+		if _, err := companion.ParseAction(r.Action); err != nil {
+			return errors.New("action must be wake or a companion power action")
+		}
+	case store.TargetScene:
+		if r.Action != "" {
+			return errors.New("action is not supported for scene targets")
+		}
+	default:
+		return errors.New("target_type must be device, group or scene")
+	}
+	return nil
+}
+
+// checkScheduleTarget ensures the schedule targets a device, group or scene the user has access to.
+func (a *API) checkScheduleTarget(username string, targetType store.TargetType, targetID string) error {
+	var err error
+	switch targetType {
+	case store.TargetDevice:
+		_, err = a.store.GetDeviceForUser(username, targetID)
+	case store.TargetGroup:
+		_, err = a.store.GetGroupForUser(username, targetID)
+	case store.TargetScene:
+		_, err = a.store.GetSceneForUser(username, targetID)
+	}
+	if err != nil {
+		return errors.New(string(targetType) + " not found")
+	}
+	return nil
+}
+
+// decodeScheduleRequest decodes and validates a schedule payload, applying defaults.
+func (a *API) decodeScheduleRequest(r *http.Request, username string) (*scheduleRequest, error) {
+	var req scheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errors.New("Invalid request body")
+	}
+
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	if req.MissedRunPolicy == "" {
+		req.MissedRunPolicy = store.MissedSkip
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if err := a.checkScheduleTarget(username, req.TargetType, req.TargetID); err != nil {
+		return nil, err
+	}
+	for _, id := range req.CalendarIDs {
+		if _, err := a.store.GetCalendarForUser(username, id); err != nil {
+			return nil, errors.New("calendar not found: " + id)
+		}
+	}
+	slices.Sort(req.CalendarIDs)
+	req.CalendarIDs = slices.Compact(req.CalendarIDs)
+	return &req, nil
+}
+
+// schedulePowersOff reports whether a schedule runs companion power actions, directly or through the
+// power steps of its scene. Such schedules need a recent authentication like running the action now.
+func (a *API) schedulePowersOff(username string, schedule *store.Schedule) bool {
+	if schedule.TargetType != store.TargetScene {
+		return schedule.Action != "wake"
+	}
+	scene, err := a.store.GetSceneForUser(username, schedule.TargetID)
+	return err != nil || scenePowersOff(scene.Steps)
+}
+
+// handleSchedulesGetAll returns all schedules owned by the user. (jwt protected)
+func (a *API) handleSchedulesGetAll(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	schedules, err := a.store.GetSchedulesForUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve schedules", http.StatusInternalServerError)
+		slog.Error("failed to retrieve schedules", "username", claims.Username, "error", err)
+		return
+	}
+
+	writeRespOk(w, "schedules retrieved", schedules)
+}
+
+// handleScheduleGet returns a single schedule. (jwt protected)
+func (a *API) handleScheduleGet(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	schedule, err := a.store.GetScheduleForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+
+	writeRespOk(w, "schedule retrieved", schedule)
+}
+
+// handleScheduleCreate creates a new schedule for the user. (jwt protected)
+func (a *API) handleScheduleCreate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	req, err := a.decodeScheduleRequest(r, claims.Username)
+	if err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedule := store.NewSchedule(claims.Username, strings.TrimSpace(req.Name))
+	schedule.Cron = req.Cron
+	schedule.Timezone = req.Timezone
+	schedule.TargetType = req.TargetType
+	schedule.TargetID = req.TargetID
+	schedule.Action = req.Action
+	schedule.Enabled = req.Enabled == nil || *req.Enabled
+	schedule.MissedRunPolicy = req.MissedRunPolicy
+	schedule.CalendarIDs = req.CalendarIDs
+	if schedule.Enabled && a.schedulePowersOff(claims.Username, schedule) && !a.checkRecentAuth(w, r) {
+		return
+	}
+
+	if err := a.store.CreateSchedule(schedule); err != nil {
+		writeRespErr(w, "Failed to create schedule", http.StatusInternalServerError)
+		slog.Error("failed to create schedule", "username", claims.Username, "error", err)
+		return
+	}
+
+	writeRespWithStatus(w, "schedule created", schedule, http.StatusCreated)
+	slog.Info("schedule created", "username", claims.Username, "schedule_id", schedule.ID)
+}
+
+// handleScheduleUpdate replaces a schedule's definition. (jwt protected)
+func (a *API) handleScheduleUpdate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	schedule, err := a.store.GetScheduleForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+
+	req, err := a.decodeScheduleRequest(r, claims.Username)
+	if err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedule.Name = strings.TrimSpace(req.Name)
+	schedule.Cron = req.Cron
+	schedule.Timezone = req.Timezone
+	schedule.TargetType = req.TargetType
+	schedule.TargetID = req.TargetID
+	schedule.Action = req.Action
+	if req.Enabled != nil {
+		schedule.Enabled = *req.Enabled
+	}
+	schedule.MissedRunPolicy = req.MissedRunPolicy
+	schedule.CalendarIDs = req.CalendarIDs
+	schedule.UpdatedAt = time.Now() // a changed definition never catches up on earlier runs
+	if schedule.Enabled && a.schedulePowersOff(claims.Username, schedule) && !a.checkRecentAuth(w, r) {
+		return
+	}
+
+	if err := a.store.UpdateSchedule(schedule); err != nil {
+		writeRespErr(w, "Failed to update schedule", http.StatusInternalServerError)
+		slog.Error("failed to update schedule", "username", claims.Username, "schedule_id", schedule.ID, "error", err)
+		return
+	}
+
+	writeRespOk(w, "schedule updated", schedule)
+	slog.Info("schedule updated", "username", claims.Username, "schedule_id", schedule.ID)
+}
+
+// handleScheduleSetEnabled returns a handler that enables or disables a schedule. (jwt protected)
+func (a *API) handleScheduleSetEnabled(enabled bool) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := GetUserFromContext(r.Context())
+		if claims == nil {
+			slog.Error("claims missing from context", "path", r.URL.Path)
+			writeRespErr(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		schedule, err := a.store.GetScheduleForUser(claims.Username, r.PathValue("id"))
+		if err != nil {
+			writeRespErr(w, "Schedule not found", http.StatusNotFound)
+			return
+		}
+
+		if enabled && a.schedulePowersOff(claims.Username, schedule) && !a.checkRecentAuth(w, r) {
+			return
+		}
+
+		if schedule.Enabled != enabled {
+			schedule.Enabled = enabled
+			schedule.UpdatedAt = time.Now() // runs while disabled are not missed runs
+			if err := a.store.UpdateSchedule(schedule); err != nil {
+				writeRespErr(w, "Failed to update schedule", http.StatusInternalServerError)
+				slog.Error("failed to update schedule", "username", claims.Username, "schedule_id", schedule.ID, "error", err)
+				return
+			}
+		}
+
+		writeRespOk(w, "schedule updated", schedule)
+		slog.Info("schedule enabled state changed", "username", claims.Username, "schedule_id", schedule.ID, "enabled", enabled)
+	}
+}
+
+// handleScheduleDelete deletes a schedule and its run history. (jwt protected)
+func (a *API) handleScheduleDelete(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	err := a.store.DeleteSchedule(claims.Username, id)
+	if err != nil && err == store.ErrScheduleNotFound {
+		writeRespErr(w, "Schedule not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to delete schedule", http.StatusInternalServerError)
+		slog.Error("failed to delete schedule", "username", claims.Username, "schedule_id", id, "error", err)
+		return
+	}
+
+	writeRespOk(w, "schedule deleted", nil)
+	slog.Info("schedule deleted", "username", claims.Username, "schedule_id", id)
+}
+
+// handleScheduleNextRuns previews the next times a schedule fires (?count=, default 5). (jwt protected)
+func (a *API) handleScheduleNextRuns(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	schedule, err := a.store.GetScheduleForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+
+	count := 5
+	if c := r.URL.Query().Get("count"); c != "" {
+		count, err = strconv.Atoi(c)
+		if err != nil || count < 1 || count > maxNextRunPreview {
+			writeRespErr(w, "count must be between 1 and "+strconv.Itoa(maxNextRunPreview), http.StatusBadRequest)
+			return
+		}
+	}
+
+	times, err := worker.NextRuns(*schedule, a.store.GetCalendarsForSchedule(*schedule), time.Now(), count)
+	if err != nil {
+		writeRespErr(w, "Invalid schedule", http.StatusInternalServerError)
+		slog.Error("failed to compute next runs", "schedule_id", schedule.ID, "error", err)
+		return
+	}
+
+	writeRespOk(w, "next runs", times)
+}
+
+// handleScheduleRunsGet returns the run history of a schedule, newest first. (jwt protected)
+func (a *API) handleScheduleRunsGet(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	schedule, err := a.store.GetScheduleForUser(claims.Username, r.PathValue("id"))
+	if err != nil {
+		writeRespErr(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+
+	runs, err := a.store.GetScheduleRuns(schedule.ID)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve runs", http.StatusInternalServerError)
+		slog.Error("failed to retrieve schedule runs", "schedule_id", schedule.ID, "error", err)
+		return
+	}
+
+	writeRespOk(w, "runs retrieved", runs)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"wolite/internal/audit"
+	"wolite/internal/store"
+)
+
+type sessionResponse struct {
+	store.Session
+	Current bool `json:"current"`
+}
+
+// handleSessionsGetAll lists the user's active browser sessions, marking the one making the request. (jwt protected)
+func (a *API) handleSessionsGetAll(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	sessions, err := a.store.GetSessionsForUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve sessions", http.StatusInternalServerError)
+		slog.Error("failed to retrieve sessions", "username", claims.Username, "error", err)
+		return
+	}
+
+	resp := make([]sessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		s.CSRFToken = ""
+		resp = append(resp, sessionResponse{Session: s, Current: s.ID == claims.ID})
+	}
+	writeRespOk(w, "sessions retrieved", resp)
+}
+
+// handleSessionDelete revokes one of the user's sessions. Revoking the current session logs the user out. (jwt protected)
+func (a *API) handleSessionDelete(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	err := a.store.DeleteSession(claims.Username, id)
+	if err != nil && err == store.ErrSessionNotFound {
+		writeRespErr(w, "Session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to revoke session", http.StatusInternalServerError)
+		slog.Error("failed to revoke session", "username", claims.Username, "session_id", id, "error", err)
+		return
+	}
+
+	if id == claims.ID {
+		clearSessionCookie(w)
+	}
+	a.auditEvent(r, audit.Entry{Action: audit.ActionSessionRevoked, Username: claims.Username, Target: id})
+	writeRespOk(w, "session revoked", nil)
+	slog.Info("session revoked", "username", claims.Username, "session_id", id)
+}
@@ -0,0 +1,382 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"wolite/internal/audit"
+	"wolite/internal/auth"
+	"wolite/internal/power"
+	"wolite/internal/store"
+)
+
+const (
+	maxShareLinksPerUser  = 50 // active links
+	maxShareLinkUses      = 100
+	defaultShareLinkUses  = 1
+	shareLinkUserAgentMax = 200
+)
+
+var sharePINPattern = regexp.MustCompile(`^[0-9]{4,12}$`)
+
+type createShareLinkRequest struct {
+	Name       string    `json:"name"` // who the link is for
+	MACAddress string    `json:"mac_address"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	MaxUses    int       `json:"max_uses,omitempty"` // one use when omitted
+	PIN        string    `json:"pin,omitempty"`      // 4 to 12 digits, shared separately from the link
+}
+
+func (r *createShareLinkRequest) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+	if r.MACAddress == "" {
+		return errors.New("mac_address is required")
+	}
+	if !r.ExpiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	if r.ExpiresAt.After(time.Now().Add(auth.MaxShareLinkLifetime)) {
+		return errors.New("expires_at must be within 90 days")
+	}
+	if r.MaxUses < 0 || r.MaxUses > maxShareLinkUses {
+		return errors.New("max_uses must be between 1 and 100")
+	}
+	if r.PIN != "" && !sharePINPattern.MatchString(r.PIN) {
+		return errors.New("pin must be 4 to 12 digits")
+	}
+	return nil
+}
+
+type shareLinkResponse struct {
+	store.ShareLink
+	HasPIN bool                  `json:"has_pin"`
+	Status store.ShareLinkStatus `json:"status"`
+}
+
+type createShareLinkResponse struct {
+	shareLinkResponse
+	Token string `json:"token"` // shown once
+	URL   string `json:"url"`   // landing page to hand out
+}
+
+// publicShareLinkInfo is what the landing page shows to whoever holds the link.
+type publicShareLinkInfo struct {
+	Name        string                `json:"name"`
+	DeviceName  string                `json:"device_name"`
+	ExpiresAt   time.Time             `json:"expires_at"`
+	UsesLeft    int                   `json:"uses_left"`
+	PINRequired bool                  `json:"pin_required"`
+	Status      store.ShareLinkStatus `json:"status"`
+}
+
+type shareLinkWakeRequest struct {
+	PIN string `json:"pin,omitempty"`
+}
+
+// publicShareLink strips the PIN hash and adds the link's current status.
+func publicShareLink(l store.ShareLink, now time.Time) shareLinkResponse {
+	resp := shareLinkResponse{HasPIN: l.PIN != "", Status: l.CurrentStatus(now)}
+	l.PIN, l.KeyID = "", ""
+	resp.ShareLink = l
+	return resp
+}
+
+// handleShareLinksGetAll lists the user's share links with their use logs. (jwt protected)
+func (a *API) handleShareLinksGetAll(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	links, err := a.store.GetShareLinksForUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve share links", http.StatusInternalServerError)
+		slog.Error("failed to retrieve share links", "username", claims.Username, "error", err)
+		return
+	}
+
+	now := time.Now()
+	resp := make([]shareLinkResponse, 0, len(links))
+	for _, l := range links {
+		resp = append(resp, publicShareLink(l, now))
+	}
+	writeRespOk(w, "share links retrieved", resp)
+}
+
+// handleShareLinkCreate creates a link that wakes one of the user's devices without an account and
+// returns it once. (jwt protected)
+func (a *API) handleShareLinkCreate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var req createShareLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = defaultShareLinkUses
+	}
+
+	device, err := a.store.GetDeviceForUser(claims.Username, req.MACAddress)
+	if err != nil {
+		writeRespErr(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	if device.BroadcastIP == "" {
+		writeRespErr(w, "Device missing broadcast ip configuration", http.StatusBadRequest)
+		return
+	}
+
+	existing, err := a.store.GetShareLinksForUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve share links", http.StatusInternalServerError)
+		slog.Error("failed to retrieve share links", "username", claims.Username, "error", err)
+		return
+	}
+	now := time.Now()
+	active := 0
+	for _, l := range existing {
+		if l.CurrentStatus(now) == store.ShareActive {
+			active++
+		}
+	}
+	if active >= maxShareLinksPerUser {
+		writeRespErr(w, "Too many active share links, revoke unused ones first", http.StatusBadRequest)
+		return
+	}
+
+	kid, key, err := a.keys.Active()
+	if err != nil {
+		writeRespErr(w, "Failed to create share link", http.StatusInternalServerError)
+		slog.Error("failed to load signing key", "error", err)
+		return
+	}
+
+	link := store.NewShareLink(claims.Username, strings.TrimSpace(req.Name), device.MACAddress, kid, req.MaxUses, req.ExpiresAt)
+	if req.PIN != "" {
+		if link.PIN, err = auth.HashPassword(req.PIN); err != nil {
+			writeRespErr(w, "Failed to create share link", http.StatusInternalServerError)
+			slog.Error("failed to hash share link pin", "error", err)
+			return
+		}
+	}
+
+	if err := a.store.CreateShareLink(link); err != nil {
+		writeRespErr(w, "Failed to create share link", http.StatusInternalServerError)
+		slog.Error("failed to create share link", "username", claims.Username, "error", err)
+		return
+	}
+
+	token := auth.ShareLinkToken(link.ID, key)
+	a.auditEvent(r, audit.Entry{Action: audit.ActionShareLinkCreated, Username: claims.Username, Target: device.MACAddress, Detail: fmt.Sprintf("share link %s for %q, %d uses until %s", link.ID, link.Name, link.MaxUses, link.ExpiresAt.UTC().Format(time.RFC3339))})
+	writeRespWithStatus(w, "share link created", createShareLinkResponse{
+		shareLinkResponse: publicShareLink(*link, now),
+		Token:             token,
+		URL:               a.requestScheme(r) + "://" + r.Host + "/share/" + token,
+	}, http.StatusCreated)
+	slog.Info("share link created", "username", claims.Username, "share_link_id", link.ID, "mac_address", device.MACAddress, "max_uses", link.MaxUses)
+}
+
+// handleShareLinkRevoke stops a share link from working. (jwt protected)
+func (a *API) handleShareLinkRevoke(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	err := a.store.RevokeShareLink(claims.Username, id)
+	if err != nil && err == store.ErrShareLinkNotFound {
+		writeRespErr(w, "Share link not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to revoke share link", http.StatusInternalServerError)
+		slog.Error("failed to revoke share link", "username", claims.Username, "share_link_id", id, "error", err)
+		return
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionShareLinkRevoked, Username: claims.Username, Detail: "share link " + id})
+	writeRespOk(w, "share link revoked", nil)
+	slog.Info("share link revoked", "username", claims.Username, "share_link_id", id)
+}
+
+// resolveShareLink returns the link a token was signed for. Tokens signed with a key that has since
+// been rotated out no longer resolve.
+func (a *API) resolveShareLink(token string) (*store.ShareLink, bool) {
+	id, ok := auth.ShareLinkID(token)
+	if !ok {
+		return nil, false
+	}
+	link, err := a.store.GetShareLink(id)
+	if err != nil {
+		return nil, false
+	}
+	key, ok := a.keys.Lookup(link.KeyID)
+	if !ok || !auth.VerifyShareLinkToken(token, key) {
+		return nil, false
+	}
+	return link, true
+}
+
+// shareLinkFailed counts a bad token or PIN against the IP, audits it and writes the error.
+func (a *API) shareLinkFailed(w http.ResponseWriter, owner, ip, reason, message string, code int) {
+	a.audit.Log(audit.Entry{Action: audit.ActionShareLinkFailed, Username: owner, IP: ip, Result: audit.ResultFailure, Detail: reason})
+	slog.Warn("share link rejected", "owner", owner, "ip", ip, "reason", reason)
+
+	if a.loginIPs.Fail(ip) {
+		a.audit.Log(audit.Entry{Action: audit.ActionLockout, IP: ip, Result: audit.ResultFailure, Detail: "too many failed share link attempts from this IP"})
+		slog.Warn("ip locked out", "ip", ip)
+	}
+	writeRespErr(w, message, code)
+}
+
+// writeShareLinkInactive explains to the holder of a link why it no longer works.
+func writeShareLinkInactive(w http.ResponseWriter, status store.ShareLinkStatus) {
+	msg := "This link is no longer valid"
+	switch status {
+	case store.ShareExpired:
+		msg = "This link has expired"
+	case store.ShareUsedUp:
+		msg = "This link has been used up"
+	case store.ShareLocked:
+		msg = "This link was locked after too many wrong PINs"
+	}
+	writeRespErr(w, msg, http.StatusGone)
+}
+
+// handleShareLinkGet describes a share link for its landing page. (public)
+func (a *API) handleShareLinkGet(w http.ResponseWriter, r *http.Request) {
+	ip := a.clientIP(r)
+	if wait := a.loginIPs.Allow(ip); wait > 0 {
+		writeThrottled(w, wait)
+		return
+	}
+
+	link, ok := a.resolveShareLink(r.PathValue("token"))
+	if !ok {
+		a.shareLinkFailed(w, "", ip, "unknown share link", "Link not found", http.StatusNotFound)
+		return
+	}
+
+	deviceName := ""
+	if device, err := a.store.GetDeviceForUser(link.Username, link.MACAddress); err == nil {
+		deviceName = device.Name
+	}
+
+	status := link.CurrentStatus(time.Now())
+	writeRespOk(w, "share link retrieved", publicShareLinkInfo{
+		Name:        link.Name,
+		DeviceName:  deviceName,
+		ExpiresAt:   link.ExpiresAt,
+		UsesLeft:    max(link.MaxUses-len(link.Uses), 0),
+		PINRequired: link.PIN != "",
+		Status:      status,
+	})
+}
+
+// handleShareLinkWake wakes the device of a share link and records the use. (public)
+func (a *API) handleShareLinkWake(w http.ResponseWriter, r *http.Request) {
+	ip := a.clientIP(r)
+	done, wait := a.beginAttempt(ip, "")
+	if wait > 0 {
+		writeThrottled(w, wait)
+		return
+	}
+	defer done()
+
+	link, ok := a.resolveShareLink(r.PathValue("token"))
+	if !ok {
+		a.shareLinkFailed(w, "", ip, "unknown share link", "Link not found", http.StatusNotFound)
+		return
+	}
+
+	// The body is optional for links without a PIN
+	var req shareLinkWakeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if status := link.CurrentStatus(time.Now()); status != store.ShareActive {
+		writeShareLinkInactive(w, status)
+		return
+	}
+	if link.PIN != "" {
+		if req.PIN == "" {
+			writeRespErr(w, "PIN required", http.StatusUnauthorized)
+			return
+		}
+		if !auth.CheckPasswordHash(req.PIN, link.PIN) {
+			left, err := a.store.FailShareLinkPIN(link.ID)
+			if err != nil {
+				slog.Error("failed to count share link pin failure", "share_link_id", link.ID, "error", err)
+			}
+			if left == 0 {
+				slog.Warn("share link locked", "owner", link.Username, "share_link_id", link.ID)
+			}
+			a.shareLinkFailed(w, link.Username, ip, "wrong pin for share link "+link.ID, "Invalid PIN", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// The owner may have lost access to the device since creating the link
+	device, err := a.store.GetDeviceForUser(link.Username, link.MACAddress)
+	if err != nil {
+		writeRespErr(w, "This link is no longer valid", http.StatusGone)
+		return
+	}
+	if device.BroadcastIP == "" {
+		writeRespErr(w, "Device missing broadcast ip configuration", http.StatusBadRequest)
+		return
+	}
+
+	// Recorded before waking, so concurrent requests cannot exceed the allowed uses
+	userAgent := r.UserAgent()
+	if len(userAgent) > shareLinkUserAgentMax {
+		userAgent = userAgent[:shareLinkUserAgentMax]
+	}
+	used, err := a.store.UseShareLink(link.ID, store.ShareLinkUse{Time: time.Now(), IP: ip, UserAgent: userAgent})
+	if err != nil && err == store.ErrShareLinkInactive {
+		writeShareLinkInactive(w, store.ShareUsedUp)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to record link use", http.StatusInternalServerError)
+		slog.Error("failed to record share link use", "share_link_id", link.ID, "error", err)
+		return
+	}
+
+	entry := audit.Entry{Action: audit.ActionShareLinkUsed, Username: link.Username, Target: device.MACAddress, IP: ip, Detail: "share link " + link.ID}
+	if err := power.Wake(*device); err != nil {
+		entry.Result = audit.ResultFailure
+		entry.Detail += ": " + err.Error()
+		a.audit.Log(entry)
+		writeRespErr(w, "magic packet failed to send", http.StatusInternalServerError)
+		slog.Error("magic packet failed to send", "share_link_id", link.ID, "mac_address", device.MACAddress, "error", err)
+		return
+	}
+
+	a.audit.Log(entry)
+	writeRespOk(w, "wake command sent", map[string]int{"uses_left": max(used.MaxUses-len(used.Uses), 0)})
+	slog.Info("wake command sent through share link", "owner", link.Username, "share_link_id", link.ID, "mac_address", device.MACAddress, "ip", ip)
+}
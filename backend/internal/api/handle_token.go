@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"wolite/internal/audit"
+	"wolite/internal/auth"
+	"wolite/internal/store"
+)
+
+const maxAccessTokensPerUser = 50
+
+type createTokenRequest struct {
+	Name      string           `json:"name"`
+	Scope     store.TokenScope `json:"scope"`
+	Devices   []string         `json:"devices,omitempty"`    // restrict the token to these MAC addresses
+	ExpiresAt *time.Time       `json:"expires_at,omitempty"` // never expires when omitted
+}
+
+func (r *createTokenRequest) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+	if !r.Scope.Valid() {
+		return errors.New("scope must be read, wake or power")
+	}
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
+
+type createTokenResponse struct {
+	store.AccessToken
+	Token string `json:"token"` // shown once, only its hash is stored
+}
+
+// publicToken strips the stored hash before a token is returned to the client.
+func publicToken(t store.AccessToken) store.AccessToken {
+	t.Hash = ""
+	return t
+}
+
+// handleTokensGetAll lists the user's personal access tokens. (jwt protected)
+func (a *API) handleTokensGetAll(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := a.store.GetAccessTokensForUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve tokens", http.StatusInternalServerError)
+		slog.Error("failed to retrieve access tokens", "username", claims.Username, "error", err)
+		return
+	}
+	for i := range tokens {
+		tokens[i] = publicToken(tokens[i])
+	}
+
+	writeRespOk(w, "tokens retrieved", tokens)
+}
+
+// handleTokenCreate creates a personal access token and returns it once. (jwt protected)
+func (a *API) handleTokenCreate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var req createTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, mac := range req.Devices {
+		if _, err := a.store.GetDeviceForUser(claims.Username, mac); err != nil {
+			writeRespErr(w, "Device not found: "+mac, http.StatusBadRequest)
+			return
+		}
+	}
+
+	existing, err := a.store.GetAccessTokensForUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve tokens", http.StatusInternalServerError)
+		slog.Error("failed to retrieve access tokens", "username", claims.Username, "error", err)
+		return
+	}
+	if len(existing) >= maxAccessTokensPerUser {
+		writeRespErr(w, "Too many access tokens, revoke unused ones first", http.StatusBadRequest)
+		return
+	}
+
+	raw, hash, err := auth.GenerateAccessToken()
+	if err != nil {
+		writeRespErr(w, "Failed to generate token", http.StatusInternalServerError)
+		slog.Error("failed to generate access token", "error", err)
+		return
+	}
+
+	token := store.NewAccessToken(claims.Username, strings.TrimSpace(req.Name), hash, raw[:8], req.Scope)
+	if len(req.Devices) > 0 {
+		slices.Sort(req.Devices)
+		token.Devices = slices.Compact(req.Devices)
+	}
+	token.ExpiresAt = req.ExpiresAt
+
+	if err := a.store.CreateAccessToken(token); err != nil {
+		writeRespErr(w, "Failed to create token", http.StatusInternalServerError)
+		slog.Error("failed to create access token", "username", claims.Username, "error", err)
+		return
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionTokenCreated, Username: claims.Username, Target: token.ID, Detail: fmt.Sprintf("%q, scope %s", token.Name, token.Scope)})
+	writeRespWithStatus(w, "token created", createTokenResponse{AccessToken: publicToken(*token), Token: raw}, http.StatusCreated)
+	slog.Info("access token created", "username", claims.Username, "token_id", token.ID, "scope", token.Scope)
+}
+
+// handleTokenDelete revokes a personal access token. (jwt protected)
+func (a *API) handleTokenDelete(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	err := a.store.DeleteAccessToken(claims.Username, id)
+	if err != nil && err == store.ErrAccessTokenNotFound {
+		writeRespErr(w, "Token not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to revoke token", http.StatusInternalServerError)
+		slog.Error("failed to revoke access token", "username", claims.Username, "token_id", id, "error", err)
+		return
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionTokenRevoked, Username: claims.Username, Target: id})
+	writeRespOk(w, "token revoked", nil)
+	slog.Info("access token revoked", "username", claims.Username, "token_id", id)
+}
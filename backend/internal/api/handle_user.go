@@ -2,8 +2,10 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"wolite/internal/audit"
 	"wolite/internal/auth"
 	"wolite/internal/store"
 )
@@ -95,20 +97,10 @@ func (a *API) handleUserCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Auto-login: Generate JWT token and set cookie
-	tokenString, expirationTime, err := auth.GenerateJWTToken(user.Username, []byte(a.config.JWTSecret), a.config.JWTExpiry)
-	if err != nil {
+	a.audit.Log(audit.Entry{Action: audit.ActionUserCreated, Actor: user.Username, Username: user.Username, IP: a.clientIP(r)})
+	if err := a.issueSessionCookie(w, r, user.Username, "signup"); err != nil {
 		slog.Error("Failed to generate token during auto-login", "error", err)
 		// Don't fail the request, just don't auto-login
-	} else {
-		http.SetCookie(w, &http.Cookie{
-			Name:     "token",
-			Value:    tokenString,
-			Expires:  expirationTime,
-			HttpOnly: true,
-			Path:     "/",
-			SameSite: http.SameSiteStrictMode,
-			// Secure:   true, // TODO: Enable in production
-		})
 	}
 
 	if payload.UseOTP {
@@ -156,16 +148,31 @@ func (a *API) handleUserUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if payload.Password != "" {
+		if user.Provider != "" {
+			writeRespErr(w, "Password is managed by your identity provider", http.StatusBadRequest)
+			return
+		}
+
 		// Secure Password Change Flow
 		if payload.OldPassword == "" {
 			writeRespErr(w, "Current password is required to set a new password", http.StatusBadRequest)
 			return
 		}
 
+		// Throttled like a login, so a stolen session cannot guess the password
+		ip := a.clientIP(r)
+		done, wait := a.beginAttempt(ip, user.Username)
+		if wait > 0 {
+			writeThrottled(w, wait)
+			return
+		}
+		defer done()
 		if !auth.CheckPasswordHash(payload.OldPassword, user.Password) {
-			writeRespErr(w, "Invalid current password", http.StatusUnauthorized)
+			a.auditEvent(r, audit.Entry{Action: audit.ActionPasswordChanged, Username: claims.Username, Result: audit.ResultFailure, Detail: "wrong current password"})
+			a.loginFailed(w, user.Username, ip, "wrong password (password change)", "Invalid current password")
 			return
 		}
+		a.loginAccounts.Reset(user.Username)
 
 		hashedPassword, err := auth.HashPassword(payload.Password)
 		if err != nil {
@@ -177,6 +184,11 @@ func (a *API) handleUserUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 	var otpUrl string
 	if payload.UseOTP {
+		// Replacing a confirmed authenticator needs the second factor, see /users/otp/reenroll
+		if user.OTP != "" {
+			writeRespErr(w, "2FA is already enabled, use re-enrollment to replace the authenticator", http.StatusConflict)
+			return
+		}
 		secret, url, err := auth.GenerateOTPSecret(payload.Username)
 		if err != nil {
 			slog.Error("OTP secret generation failed", "error", err)
@@ -193,6 +205,17 @@ func (a *API) handleUserUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A new password signs out every other session
+	if payload.Password != "" {
+		revoked, err := a.store.DeleteSessionsForUser(claims.Username, claims.ID)
+		if err != nil {
+			slog.Error("failed to revoke sessions after password change", "username", claims.Username, "error", err)
+		} else if revoked > 0 {
+			slog.Info("sessions revoked after password change", "username", claims.Username, "revoked", revoked)
+		}
+		a.auditEvent(r, audit.Entry{Action: audit.ActionPasswordChanged, Username: claims.Username, Detail: fmt.Sprintf("%d other sessions revoked", revoked)})
+	}
+
 	if payload.UseOTP {
 		writeRespWithStatus(w, "User updated with OTP", map[string]string{"otp_url": otpUrl}, http.StatusOK)
 		slog.Info("User updated with OTP", "username", payload.Username)
@@ -235,15 +258,23 @@ func (a *API) handleUserOTPVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Code is valid, promote PendingOTP to OTP
+	// Code is valid, promote PendingOTP to OTP with a fresh set of recovery codes
+	codes, hashes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		slog.Error("recovery code generation failed", "error", err)
+		writeRespErr(w, "System error", http.StatusInternalServerError)
+		return
+	}
 	user.OTP = user.PendingOTP
 	user.PendingOTP = ""
+	user.RecoveryCodes = hashes
 
 	if err := a.store.UpdateUser(user); err != nil {
 		writeRespErr(w, "Failed to update user", http.StatusInternalServerError)
 		return
 	}
 
-	writeRespOk(w, "2FA enabled successfully", nil)
+	a.auditEvent(r, audit.Entry{Action: audit.ActionOTPEnabled, Username: claims.Username})
+	writeRespOk(w, "2FA enabled successfully", recoveryCodesResponse{RecoveryCodes: codes})
 	slog.Info("2FA verified and enabled", "username", claims.Username)
 }
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"wolite/internal/audit"
+	"wolite/internal/power"
+	"wolite/internal/store"
+)
+
+const (
+	defaultWakeRequestExpiry = time.Hour
+	maxWakeRequestExpiry     = 24 * time.Hour
+	maxWakeRequestText       = 500
+)
+
+type createWakeRequestRequest struct {
+	Reason           string `json:"reason,omitempty"`
+	ExpiresInMinutes int    `json:"expires_in_minutes,omitempty"` // one hour when omitted
+}
+
+func (r *createWakeRequestRequest) Validate() error {
+	if len(r.Reason) > maxWakeRequestText {
+		return errors.New("reason must be at most 500 characters")
+	}
+	if r.ExpiresInMinutes < 0 || time.Duration(r.ExpiresInMinutes)*time.Minute > maxWakeRequestExpiry {
+		return errors.New("expires_in_minutes must be between 1 and 1440")
+	}
+	return nil
+}
+
+type decideWakeRequestRequest struct {
+	Note string `json:"note,omitempty"`
+}
+
+// publicWakeRequest reports pending requests past their expiry as expired.
+func publicWakeRequest(r store.WakeRequest, now time.Time) store.WakeRequest {
+	r.Status = r.CurrentStatus(now)
+	return r
+}
+
+// notify adds an in-app notification. Failures are logged, a notification never fails a request.
+func (a *API) notify(username string, kind store.NotificationKind, message, refID string) {
+	if err := a.store.CreateNotification(store.NewNotification(username, kind, message, refID)); err != nil {
+		slog.Error("failed to create notification", "username", username, "kind", kind, "error", err)
+	}
+}
+
+// handleWakeRequestCreate asks the owners of a device the user has request access to for a wake. (jwt protected)
+func (a *API) handleWakeRequestCreate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	device, access, err := a.store.GetDeviceAccess(claims.Username, id)
+	if err != nil {
+		writeRespErr(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	if access == store.AccessOwner {
+		writeRespErr(w, "You can wake this device directly", http.StatusBadRequest)
+		return
+	}
+
+	// The body is optional
+	var req createWakeRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeRespErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expiry := defaultWakeRequestExpiry
+	if req.ExpiresInMinutes > 0 {
+		expiry = time.Duration(req.ExpiresInMinutes) * time.Minute
+	}
+
+	request := store.NewWakeRequest(*device, claims.Username, strings.TrimSpace(req.Reason), time.Now().Add(expiry))
+	err = a.store.CreateWakeRequest(request)
+	if err != nil && err == store.ErrWakeRequestPending {
+		writeRespErr(w, "A wake request for this device is already pending", http.StatusConflict)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to create wake request", http.StatusInternalServerError)
+		slog.Error("failed to create wake request", "username", claims.Username, "mac_address", id, "error", err)
+		return
+	}
+
+	message := fmt.Sprintf("%s asks to wake %s", claims.Username, device.Name)
+	if request.Reason != "" {
+		message += ": " + request.Reason
+	}
+	for _, owner := range a.store.GetDeviceOwners(device.MACAddress) {
+		a.notify(owner, store.NotifyWakeRequested, message, request.ID)
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionWakeRequested, Username: claims.Username, Target: id, Detail: "wake request " + request.ID})
+	writeRespWithStatus(w, "wake request created", request, http.StatusCreated)
+	slog.Info("wake request created", "username", claims.Username, "mac_address", id, "wake_request_id", request.ID)
+}
+
+// handleWakeRequestsGetAll lists the requests the user made and those for devices they own. (jwt protected)
+// Supports filtering by status through the status query parameter.
+func (a *API) handleWakeRequestsGetAll(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	requests, err := a.store.GetWakeRequestsForUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "Failed to retrieve wake requests", http.StatusInternalServerError)
+		slog.Error("failed to retrieve wake requests", "username", claims.Username, "error", err)
+		return
+	}
+
+	now := time.Now()
+	status := store.WakeRequestStatus(r.URL.Query().Get("status"))
+	resp := make([]store.WakeRequest, 0, len(requests))
+	for _, req := range requests {
+		req = publicWakeRequest(req, now)
+		if status == "" || req.Status == status {
+			resp = append(resp, req)
+		}
+	}
+	writeRespOk(w, "wake requests retrieved", resp)
+}
+
+// handleWakeRequestDecide approves or denies a pending request for a device the user owns. An approval
+// wakes the device right away. (jwt protected)
+func (a *API) handleWakeRequestDecide(status store.WakeRequestStatus) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := GetUserFromContext(r.Context())
+		if claims == nil {
+			slog.Error("claims missing from context", "path", r.URL.Path)
+			writeRespErr(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		var req decideWakeRequestRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(req.Note) > maxWakeRequestText {
+			writeRespErr(w, "note must be at most 500 characters", http.StatusBadRequest)
+			return
+		}
+
+		id := r.PathValue("id")
+		request, err := a.store.DecideWakeRequest(id, claims.Username, status, strings.TrimSpace(req.Note))
+		if err != nil && err == store.ErrWakeRequestNotFound {
+			writeRespErr(w, "Wake request not found", http.StatusNotFound)
+			return
+		} else if err != nil && err == store.ErrWakeRequestClosed {
+			writeRespErr(w, "Wake request is no longer pending", http.StatusConflict)
+			return
+		} else if err != nil {
+			writeRespErr(w, "Failed to update wake request", http.StatusInternalServerError)
+			slog.Error("failed to decide wake request", "username", claims.Username, "wake_request_id", id, "error", err)
+			return
+		}
+
+		ip := a.clientIP(r)
+		if status == store.WakeRequestDenied {
+			a.audit.Log(audit.Entry{Action: audit.ActionWakeDenied, Actor: claims.Username, Username: request.Requester, Target: request.MACAddress, IP: ip, Detail: "wake request " + request.ID})
+			a.notify(request.Requester, store.NotifyWakeDenied, fmt.Sprintf("%s denied your request to wake %s", claims.Username, request.DeviceName), request.ID)
+			writeRespOk(w, "wake request denied", request)
+			slog.Info("wake request denied", "username", claims.Username, "wake_request_id", id, "requester", request.Requester)
+			return
+		}
+
+		a.audit.Log(audit.Entry{Action: audit.ActionWakeApproved, Actor: claims.Username, Username: request.Requester, Target: request.MACAddress, IP: ip, Detail: "wake request " + request.ID})
+		device, err := a.store.GetDeviceForUser(claims.Username, request.MACAddress)
+		if err == nil {
+			err = power.Wake(*device)
+			a.auditEvent(r, audit.Entry{Action: audit.ActionWake, Username: request.Requester, Target: request.MACAddress, Result: auditResult(err), Detail: "approved wake request " + request.ID})
+		}
+		if err != nil {
+			if err := a.store.SetWakeRequestError(id, err.Error()); err != nil {
+				slog.Error("failed to record wake request error", "wake_request_id", id, "error", err)
+			}
+			a.notify(request.Requester, store.NotifyWakeApproved, fmt.Sprintf("%s approved your request to wake %s, but the wake failed", claims.Username, request.DeviceName), request.ID)
+			writeRespErr(w, "Approved, but the magic packet failed to send", http.StatusInternalServerError)
+			slog.Error("approved wake failed", "username", claims.Username, "wake_request_id", id, "mac_address", request.MACAddress, "error", err)
+			return
+		}
+
+		a.notify(request.Requester, store.NotifyWakeApproved, fmt.Sprintf("%s approved your request, %s is waking up", claims.Username, request.DeviceName), request.ID)
+		writeRespOk(w, "wake request approved", request)
+		slog.Info("wake request approved", "username", claims.Username, "wake_request_id", id, "requester", request.Requester, "mac_address", request.MACAddress)
+	}
+}
+
+// handleWakeRequestCancel withdraws one of the user's pending requests. (jwt protected)
+func (a *API) handleWakeRequestCancel(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	err := a.store.CancelWakeRequest(claims.Username, id)
+	if err != nil && err == store.ErrWakeRequestNotFound {
+		writeRespErr(w, "Wake request not found", http.StatusNotFound)
+		return
+	} else if err != nil && err == store.ErrWakeRequestClosed {
+		writeRespErr(w, "Wake request is no longer pending", http.StatusConflict)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to cancel wake request", http.StatusInternalServerError)
+		slog.Error("failed to cancel wake request", "username", claims.Username, "wake_request_id", id, "error", err)
+		return
+	}
+
+	writeRespOk(w, "wake request cancelled", nil)
+	slog.Info("wake request cancelled", "username", claims.Username, "wake_request_id", id)
+}
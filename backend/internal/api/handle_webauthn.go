@@ -0,0 +1,508 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"wolite/internal/audit"
+	"wolite/internal/store"
+	"wolite/internal/webauthn"
+)
+
+const (
+	webAuthnTimeout       = 5 * time.Minute
+	webAuthnRPName        = "Wolite"
+	maxCredentialsPerUser = 20
+
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+	ceremonyReauth   = "reauth"
+)
+
+type credentialDescriptor struct {
+	Type       string             `json:"type"`
+	ID         webauthn.Base64URL `json:"id"`
+	Transports []string           `json:"transports,omitempty"`
+}
+
+type pubKeyCredParam struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// creationOptions is PublicKeyCredentialCreationOptionsJSON, see PublicKeyCredential.parseCreationOptionsFromJSON.
+type creationOptions struct {
+	RP struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          webauthn.Base64URL `json:"id"`
+		Name        string             `json:"name"`
+		DisplayName string             `json:"displayName"`
+	} `json:"user"`
+	Challenge              webauthn.Base64URL     `json:"challenge"`
+	PubKeyCredParams       []pubKeyCredParam      `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// requestOptions is PublicKeyCredentialRequestOptionsJSON, see PublicKeyCredential.parseRequestOptionsFromJSON.
+type requestOptions struct {
+	Challenge        webauthn.Base64URL     `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// registrationResponse is the RegistrationResponseJSON produced by PublicKeyCredential.toJSON().
+type registrationResponse struct {
+	ID       string `json:"id"`
+	Response struct {
+		ClientDataJSON    webauthn.Base64URL `json:"clientDataJSON"`
+		AttestationObject webauthn.Base64URL `json:"attestationObject"`
+		Transports        []string           `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// authenticationResponse is the AuthenticationResponseJSON produced by PublicKeyCredential.toJSON().
+type authenticationResponse struct {
+	ID       string        `json:"id"`
+	Response assertionData `json:"response"`
+}
+
+type assertionData struct {
+	ClientDataJSON    webauthn.Base64URL `json:"clientDataJSON"`
+	AuthenticatorData webauthn.Base64URL `json:"authenticatorData"`
+	Signature         webauthn.Base64URL `json:"signature"`
+	UserHandle        webauthn.Base64URL `json:"userHandle,omitempty"`
+}
+
+// credentialResponse is a credential as shown to its owner, without the public key.
+type credentialResponse struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	BackupEligible bool       `json:"backup_eligible"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+}
+
+func publicCredential(c store.WebAuthnCredential) credentialResponse {
+	return credentialResponse{ID: c.ID, Name: c.Name, BackupEligible: c.BackupEligible, CreatedAt: c.CreatedAt, LastUsedAt: c.LastUsedAt}
+}
+
+func credentialDescriptors(creds []store.WebAuthnCredential) []credentialDescriptor {
+	descriptors := make([]credentialDescriptor, 0, len(creds))
+	for _, c := range creds {
+		id, err := base64.RawURLEncoding.DecodeString(c.ID)
+		if err != nil {
+			continue
+		}
+		descriptors = append(descriptors, credentialDescriptor{Type: "public-key", ID: id, Transports: c.Transports})
+	}
+	return descriptors
+}
+
+// relyingParty returns the configured relying party, or one derived from the host the browser used.
+func (a *API) relyingParty(r *http.Request) webauthn.RelyingParty {
+	rp := webauthn.RelyingParty{ID: a.config.WebAuthnRPID, Origins: a.config.WebAuthnOrigins}
+	if rp.ID == "" {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		rp.ID = strings.Trim(host, "[]")
+	}
+	if len(rp.Origins) == 0 {
+		rp.Origins = []string{a.requestScheme(r) + "://" + r.Host}
+	}
+	return rp
+}
+
+// handleWebAuthnRegisterBegin returns the options for navigator.credentials.create(). (jwt protected)
+func (a *API) handleWebAuthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := a.store.FindUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if len(user.Credentials) >= maxCredentialsPerUser {
+		writeRespErr(w, "Too many passkeys, remove unused ones first", http.StatusBadRequest)
+		return
+	}
+
+	// The user handle is random so authenticators never learn the username from it
+	if user.WebAuthnID == nil {
+		user.WebAuthnID = make([]byte, 32)
+		if _, err := rand.Read(user.WebAuthnID); err != nil {
+			writeRespErr(w, "System error", http.StatusInternalServerError)
+			slog.Error("failed to generate user handle", "error", err)
+			return
+		}
+		if err := a.store.UpdateUser(user); err != nil {
+			writeRespErr(w, "Failed to update user", http.StatusInternalServerError)
+			slog.Error("failed to store user handle", "username", user.Username, "error", err)
+			return
+		}
+	}
+
+	challenge, err := a.ceremonies.Begin(webauthn.Ceremony{Kind: ceremonyRegister, Username: user.Username, Expires: time.Now().Add(webAuthnTimeout)})
+	if err != nil {
+		writeCeremonyErr(w, err)
+		return
+	}
+
+	opts := creationOptions{
+		Challenge:          challenge,
+		PubKeyCredParams:   []pubKeyCredParam{{Type: "public-key", Alg: webauthn.AlgES256}, {Type: "public-key", Alg: webauthn.AlgRS256}},
+		Timeout:            webAuthnTimeout.Milliseconds(),
+		ExcludeCredentials: credentialDescriptors(user.Credentials),
+		Attestation:        "none",
+	}
+	opts.RP.ID = a.relyingParty(r).ID
+	opts.RP.Name = webAuthnRPName
+	opts.User.ID = user.WebAuthnID
+	opts.User.Name = user.Username
+	opts.User.DisplayName = user.Username
+	opts.AuthenticatorSelection.ResidentKey = "preferred"
+	opts.AuthenticatorSelection.UserVerification = "preferred"
+
+	writeRespOk(w, "registration started", opts)
+}
+
+// handleWebAuthnRegisterFinish verifies the authenticator's response and stores the new credential. (jwt protected)
+func (a *API) handleWebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		Name       string               `json:"name"`
+		Credential registrationResponse `json:"credential"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+
+	challenge, err := webauthn.ChallengeFromClientData(req.Credential.Response.ClientDataJSON)
+	if err != nil {
+		writeRespErr(w, "Invalid client data", http.StatusBadRequest)
+		return
+	}
+	ceremony, ok := a.ceremonies.Finish(challenge)
+	if !ok || ceremony.Kind != ceremonyRegister || ceremony.Username != claims.Username {
+		writeRespErr(w, "Unknown or expired challenge", http.StatusBadRequest)
+		return
+	}
+
+	cred, err := a.relyingParty(r).VerifyRegistration(challenge, req.Credential.Response.ClientDataJSON, req.Credential.Response.AttestationObject, false)
+	if err != nil {
+		writeRespErr(w, "Passkey verification failed", http.StatusBadRequest)
+		slog.Warn("webauthn registration failed", "username", claims.Username, "error", err)
+		return
+	}
+
+	stored := store.WebAuthnCredential{
+		ID:             base64.RawURLEncoding.EncodeToString(cred.ID),
+		Name:           name,
+		PublicKey:      cred.PublicKey,
+		Algorithm:      cred.Algorithm,
+		SignCount:      cred.SignCount,
+		BackupEligible: cred.BackupEligible,
+		Transports:     req.Credential.Response.Transports,
+		CreatedAt:      time.Now(),
+	}
+	err = a.store.AddWebAuthnCredential(claims.Username, stored)
+	if err != nil && err == store.ErrCredentialExists {
+		writeRespErr(w, "Passkey already registered", http.StatusConflict)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to store passkey", http.StatusInternalServerError)
+		slog.Error("failed to store webauthn credential", "username", claims.Username, "error", err)
+		return
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionPasskeyAdded, Username: claims.Username, Target: stored.ID, Detail: stored.Name})
+	writeRespWithStatus(w, "passkey registered", publicCredential(stored), http.StatusCreated)
+	slog.Info("passkey registered", "username", claims.Username, "credential_id", stored.ID, "format", cred.Format)
+}
+
+// handleWebAuthnLoginBegin returns the options for navigator.credentials.get(). With a username and password
+// the passkey is the second factor; without a password it must verify the user (PIN or biometrics).
+// Without a username the browser offers any discoverable passkey for this site.
+func (a *API) handleWebAuthnLoginBegin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username,omitempty"`
+		Password string `json:"password,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ip := a.clientIP(r)
+	done, wait := a.beginAttempt(ip, req.Username)
+	if wait > 0 {
+		writeThrottled(w, wait)
+		slog.Warn("login throttled", "username", req.Username, "ip", ip, "retry_after", wait)
+		return
+	}
+	defer done()
+
+	ceremony := webauthn.Ceremony{Kind: ceremonyLogin, Username: req.Username, Expires: time.Now().Add(webAuthnTimeout)}
+	var creds []store.WebAuthnCredential
+	if req.Password != "" {
+		user, err := a.store.FindUser(req.Username)
+		if err != nil {
+			a.loginFailed(w, req.Username, ip, "unknown user", "Invalid credentials")
+			return
+		}
+		if !a.checkPassword(r.Context(), user, req.Password) {
+			a.loginFailed(w, req.Username, ip, "wrong password", "Invalid credentials")
+			return
+		}
+		if len(user.Credentials) == 0 {
+			writeRespErr(w, "No passkeys registered", http.StatusBadRequest)
+			return
+		}
+		ceremony.Password = true
+		creds = user.Credentials
+	} else if req.Username != "" {
+		// Unknown users get an empty list rather than an error, so usernames cannot be probed
+		if user, err := a.store.FindUser(req.Username); err == nil {
+			creds = user.Credentials
+		}
+	}
+
+	challenge, err := a.ceremonies.Begin(ceremony)
+	if err != nil {
+		writeCeremonyErr(w, err)
+		return
+	}
+
+	opts := requestOptions{
+		Challenge:        challenge,
+		RPID:             a.relyingParty(r).ID,
+		Timeout:          webAuthnTimeout.Milliseconds(),
+		AllowCredentials: credentialDescriptors(creds),
+		UserVerification: "required",
+	}
+	if ceremony.Password {
+		opts.UserVerification = "preferred"
+	}
+	writeRespOk(w, "login started", opts)
+}
+
+// handleWebAuthnLoginFinish verifies the assertion and logs the user in.
+func (a *API) handleWebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Credential authenticationResponse `json:"credential"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	resp := req.Credential.Response
+
+	challenge, err := webauthn.ChallengeFromClientData(resp.ClientDataJSON)
+	if err != nil {
+		writeRespErr(w, "Invalid client data", http.StatusBadRequest)
+		return
+	}
+	ceremony, ok := a.ceremonies.Finish(challenge)
+	if !ok || ceremony.Kind != ceremonyLogin {
+		writeRespErr(w, "Unknown or expired challenge", http.StatusBadRequest)
+		return
+	}
+
+	ip := a.clientIP(r)
+	done, wait := a.beginAttempt(ip, "")
+	if wait > 0 {
+		writeThrottled(w, wait)
+		return
+	}
+	defer done()
+
+	user, cred, err := a.store.FindUserByCredential(req.Credential.ID)
+	if err != nil {
+		a.loginFailed(w, ceremony.Username, ip, "unknown passkey", "Invalid passkey")
+		return
+	}
+	if ceremony.Username != "" && ceremony.Username != user.Username ||
+		len(resp.UserHandle) > 0 && string(resp.UserHandle) != string(user.WebAuthnID) {
+		a.loginFailed(w, user.Username, ip, "passkey of another user", "Invalid passkey")
+		return
+	}
+	if wait := a.loginAccounts.Begin(user.Username); wait > 0 {
+		writeThrottled(w, wait)
+		return
+	}
+	defer a.loginAccounts.Done(user.Username)
+
+	// Without a password the passkey is the only factor, so it must have verified the user
+	if err := a.verifyPasskey(r, user.Username, cred, challenge, resp, !ceremony.Password); err != nil {
+		a.loginFailed(w, user.Username, ip, "passkey verification failed", "Invalid passkey")
+		slog.Warn("webauthn login failed", "username", user.Username, "error", err)
+		return
+	}
+
+	a.loginAccounts.Reset(user.Username)
+	if err := a.issueSessionCookie(w, r, user.Username, "passkey"); err != nil {
+		writeRespErr(w, "Failed to generate token", http.StatusInternalServerError)
+		slog.Error("failed to generate token", "username", user.Username, "error", err)
+		return
+	}
+
+	writeRespOk(w, "authenticated", authResponse{
+		Status:                 "authenticated",
+		User:                   user.Username,
+		HasOTP:                 user.OTP != "",
+		Role:                   string(user.Role),
+		RecoveryCodesRemaining: len(user.RecoveryCodes),
+	})
+	slog.Info("passkey login", "username", user.Username, "credential_id", cred.ID, "passwordless", !ceremony.Password)
+}
+
+// verifyPasskey checks an assertion against the stored credential and records its use.
+func (a *API) verifyPasskey(r *http.Request, username string, cred store.WebAuthnCredential, challenge []byte, resp assertionData, requireUV bool) error {
+	assertion, err := a.relyingParty(r).VerifyAssertion(challenge, resp.ClientDataJSON, resp.AuthenticatorData, resp.Signature, cred.PublicKey, cred.SignCount, requireUV)
+	if err != nil {
+		if errors.Is(err, webauthn.ErrSignCount) {
+			slog.Warn("passkey counter went backwards, possible cloned authenticator", "username", username, "credential_id", cred.ID)
+		}
+		return err
+	}
+
+	now := time.Now()
+	err = a.store.UpdateWebAuthnCredential(username, cred.ID, func(c *store.WebAuthnCredential) {
+		c.SignCount = assertion.SignCount
+		c.LastUsedAt = &now
+	})
+	if err != nil {
+		slog.Error("failed to record passkey use", "username", username, "credential_id", cred.ID, "error", err)
+	}
+	return nil
+}
+
+// handleWebAuthnCredentialsGetAll lists the user's passkeys. (jwt protected)
+func (a *API) handleWebAuthnCredentialsGetAll(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := a.store.FindUser(claims.Username)
+	if err != nil {
+		writeRespErr(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	creds := make([]credentialResponse, 0, len(user.Credentials))
+	for _, c := range user.Credentials {
+		creds = append(creds, publicCredential(c))
+	}
+	writeRespOk(w, "passkeys retrieved", creds)
+}
+
+// handleWebAuthnCredentialUpdate renames a passkey. (jwt protected)
+func (a *API) handleWebAuthnCredentialUpdate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRespErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeRespErr(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	id := r.PathValue("id")
+	err := a.store.UpdateWebAuthnCredential(claims.Username, id, func(c *store.WebAuthnCredential) {
+		c.Name = name
+	})
+	if err != nil && err == store.ErrCredentialNotFound {
+		writeRespErr(w, "Passkey not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to rename passkey", http.StatusInternalServerError)
+		slog.Error("failed to rename webauthn credential", "username", claims.Username, "credential_id", id, "error", err)
+		return
+	}
+
+	writeRespOk(w, "passkey renamed", nil)
+}
+
+// handleWebAuthnCredentialDelete removes a passkey. (jwt protected)
+func (a *API) handleWebAuthnCredentialDelete(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if claims == nil {
+		slog.Error("claims missing from context", "path", r.URL.Path)
+		writeRespErr(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	err := a.store.DeleteWebAuthnCredential(claims.Username, id)
+	if err != nil && err == store.ErrCredentialNotFound {
+		writeRespErr(w, "Passkey not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeRespErr(w, "Failed to remove passkey", http.StatusInternalServerError)
+		slog.Error("failed to remove webauthn credential", "username", claims.Username, "credential_id", id, "error", err)
+		return
+	}
+
+	a.auditEvent(r, audit.Entry{Action: audit.ActionPasskeyRemoved, Username: claims.Username, Target: id})
+	writeRespOk(w, "passkey removed", nil)
+	slog.Info("passkey removed", "username", claims.Username, "credential_id", id)
+}
+
+// writeCeremonyErr answers a failure to start a WebAuthn ceremony.
+func writeCeremonyErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, webauthn.ErrTooManyCeremonies) {
+		writeRespErr(w, "Too many passkey ceremonies in progress, try again later", http.StatusServiceUnavailable)
+		slog.Warn("webauthn ceremony refused", "error", err)
+		return
+	}
+	writeRespErr(w, "System error", http.StatusInternalServerError)
+	slog.Error("failed to generate challenge", "error", err)
+}
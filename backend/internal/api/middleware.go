@@ -5,8 +5,11 @@ import (
 	"log/slog"
 	"net/http"
 	"runtime/debug"
+	"slices"
+	"strings"
 	"time"
 	"wolite/internal/auth"
+	"wolite/internal/store"
 )
 
 type middleware func(http.Handler) http.Handler
@@ -59,18 +62,44 @@ func Recoverer(next http.Handler) http.Handler {
 
 type contextKey string
 
-const userContextKey contextKey = "user"
+const (
+	userContextKey        contextKey = "user"
+	tokenContextKey       contextKey = "access_token"
+	forwardAuthContextKey contextKey = "forward_auth"
+)
 
-// Auth validates the JWT token and adds the claims to the context
+// Auth validates the JWT cookie, a personal access token (Authorization: Bearer) or the user header set by a
+// trusted forward auth proxy, and adds the claims to the context.
+// Routes must also declare what access tokens may do with RequireScope.
 func (a *API) Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		claims, err := a.validateCookies(r)
+		var (
+			claims   *auth.Claims
+			token    *store.AccessToken
+			viaProxy bool
+			err      error
+		)
+		switch {
+		case strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "):
+			claims, token, err = a.validateAccessToken(r)
+		case a.fromForwardAuthProxy(r):
+			claims, err = a.validateForwardAuth(r)
+			viaProxy = true
+		default:
+			claims, err = a.validateCookies(r)
+		}
+		// Cookies are sent along by the browser whichever site made the request, bearer tokens are not
+		if err == nil && token == nil {
+			err = a.validateCSRF(r, claims, viaProxy)
+		}
 		if err != nil {
 			switch err {
 			case ErrUnauthorized:
 				writeRespErr(w, "unauthenticated", http.StatusUnauthorized)
 			case ErrInvalidRequest:
 				writeRespErr(w, "Invalid request", http.StatusBadRequest)
+			case ErrCSRF:
+				writeRespErr(w, "Invalid CSRF token", http.StatusForbidden)
 			default:
 				writeRespErr(w, "Authentication failed", http.StatusInternalServerError)
 			}
@@ -79,10 +108,67 @@ func (a *API) Auth(next http.Handler) http.Handler {
 		}
 
 		ctx := context.WithValue(r.Context(), userContextKey, claims)
+		if token != nil {
+			ctx = context.WithValue(ctx, tokenContextKey, token)
+		}
+		if viaProxy {
+			ctx = context.WithValue(ctx, forwardAuthContextKey, true)
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// RequireScope limits what a personal access token can do on a route. The token needs at least the given scope;
+// an empty scope keeps the route for browser sessions only. On device routes ({id}) a token restricted to
+// specific devices must include the device, and such tokens cannot act on groups or scenes at all.
+func (a *API) RequireScope(scope store.TokenScope) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := GetAccessTokenFromContext(r.Context())
+			if token == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if scope == "" || !token.Scope.Allows(scope) {
+				writeRespErr(w, "Access token scope does not allow this request", http.StatusForbidden)
+				slog.Warn("access token scope denied", "path", r.URL.Path, "token_id", token.ID, "scope", token.Scope, "required", scope)
+				return
+			}
+			if len(token.Devices) > 0 {
+				isDeviceRoute := strings.HasPrefix(r.URL.Path, "/api/v1/devices/") && r.PathValue("id") != ""
+				if isDeviceRoute && !token.AllowsDevice(r.PathValue("id")) || !isDeviceRoute && scope != store.ScopeRead {
+					writeRespErr(w, "Access token is not allowed for this device", http.StatusForbidden)
+					slog.Warn("access token device denied", "path", r.URL.Path, "token_id", token.ID)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAdmin rejects users without the admin role. The role is read from the store so a demotion applies immediately.
+func (a *API) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := GetUserFromContext(r.Context())
+		if claims == nil {
+			writeRespErr(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := a.store.FindUser(claims.Username)
+		if err != nil || user.Role != store.RoleAdmin {
+			writeRespErr(w, "Admin access required", http.StatusForbidden)
+			slog.Warn("admin access denied", "path", r.URL.Path, "username", claims.Username)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // GetUserFromContext retrieves the user claims from the context
 func GetUserFromContext(ctx context.Context) *auth.Claims {
 	claims, ok := ctx.Value(userContextKey).(*auth.Claims)
@@ -92,6 +178,29 @@ func GetUserFromContext(ctx context.Context) *auth.Claims {
 	return claims
 }
 
+// viaForwardAuth reports whether the request was authenticated by a forward auth proxy.
+func viaForwardAuth(ctx context.Context) bool {
+	proxy, _ := ctx.Value(forwardAuthContextKey).(bool)
+	return proxy
+}
+
+// GetAccessTokenFromContext returns the personal access token that authenticated the request, or nil for browser sessions.
+func GetAccessTokenFromContext(ctx context.Context) *store.AccessToken {
+	token, ok := ctx.Value(tokenContextKey).(*store.AccessToken)
+	if !ok {
+		return nil
+	}
+	return token
+}
+
+// tokenAllowsDevice reports whether the request may act on a device: always for browser sessions, and for
+// an access token only if it is not restricted to other devices. RequireScope checks the device in the
+// path; handlers check this for devices they reach from there, like prerequisites and dependents.
+func tokenAllowsDevice(r *http.Request, macAddress string) bool {
+	token := GetAccessTokenFromContext(r.Context())
+	return token == nil || token.AllowsDevice(macAddress)
+}
+
 type wrappedWriter struct {
 	http.ResponseWriter
 	statusCode int
@@ -102,25 +211,29 @@ func (w *wrappedWriter) WriteHeader(statusCode int) {
 	w.statusCode = statusCode
 }
 
-// Cors handles Cross-Origin Resource Sharing for development mode
-func Cors(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Allow requests from the frontend dev server
-		origin := r.Header.Get("Origin")
-		if origin == "" {
-			origin = "*"
-		}
-		w.Header().Set("Access-Control-Allow-Origin", origin)
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// Cors allows browsers on the given origins (e.g. the frontend dev server) to call the API with cookies.
+// Other origins get no CORS headers, so their pages cannot read responses.
+func Cors(allowedOrigins []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !slices.Contains(allowedOrigins, origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+csrfHeader)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+			// Handle preflight requests
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
 
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
@@ -1,8 +1,13 @@
 package api
 
 import (
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
+	"wolite/internal/audit"
 	"wolite/internal/auth"
+	"wolite/internal/store"
 )
 
 func (a *API) validateCookies(r *http.Request) (*auth.Claims, error) {
@@ -14,9 +19,109 @@ func (a *API) validateCookies(r *http.Request) (*auth.Claims, error) {
 		return nil, ErrInvalidRequest
 	}
 
-	claims, err := auth.ValidateJWTToken(c.Value, []byte(a.config.JWTSecret))
+	claims, err := auth.ValidateJWTToken(c.Value, a.keys.Lookup)
 	if err != nil {
 		return nil, ErrUnauthorized
 	}
+
+	// The session must still exist, so a revoked session is rejected even though its JWT is valid
+	session, err := a.store.GetSession(claims.ID)
+	if err != nil || session.Username != claims.Username {
+		return nil, ErrUnauthorized
+	}
+
+	// Minute precision is enough, avoid a disk write on every request
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) > time.Minute {
+		if err := a.store.TouchSession(session.ID, now); err != nil {
+			slog.Error("failed to record session use", "session_id", session.ID, "error", err)
+		}
+	}
 	return claims, nil
 }
+
+func (a *API) validateAccessToken(r *http.Request) (*auth.Claims, *store.AccessToken, error) {
+	raw := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !auth.IsAccessToken(raw) {
+		return nil, nil, ErrUnauthorized
+	}
+
+	token, err := a.store.GetAccessTokenByHash(auth.HashAccessToken(raw))
+	if err != nil {
+		return nil, nil, ErrUnauthorized
+	}
+	now := time.Now()
+	if token.Expired(now) {
+		return nil, nil, ErrUnauthorized
+	}
+
+	// Minute precision is enough, avoid a disk write on every request
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+		if err := a.store.TouchAccessToken(token.ID, now); err != nil {
+			slog.Error("failed to record access token use", "token_id", token.ID, "error", err)
+		}
+	}
+	return &auth.Claims{Username: token.Username}, token, nil
+}
+
+// fromForwardAuthProxy reports whether the request comes straight from a proxy allowed to assert
+// the user. The connection address is used, never X-Forwarded-For, so clients cannot spoof it.
+func (a *API) fromForwardAuthProxy(r *http.Request) bool {
+	cfg := a.config.ForwardAuth
+	if cfg.Header == "" || r.Header.Get(cfg.Header) == "" {
+		return false
+	}
+	addr := remoteAddr(r)
+	for _, prefix := range cfg.Proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	slog.Warn("ignoring forward auth header from untrusted address", "ip", addr.String(), "header", cfg.Header)
+	return false
+}
+
+// validateForwardAuth accepts the user named by the proxy, provisioning it when enabled.
+func (a *API) validateForwardAuth(r *http.Request) (*auth.Claims, error) {
+	cfg := a.config.ForwardAuth
+	username := strings.TrimSpace(r.Header.Get(cfg.Header))
+	if username == "" || strings.ContainsAny(username, "/\\ \t") {
+		return nil, ErrUnauthorized
+	}
+	var groups []string
+	if cfg.GroupsHeader != "" {
+		for _, g := range strings.Split(r.Header.Get(cfg.GroupsHeader), ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				groups = append(groups, g)
+			}
+		}
+	}
+	role := mappedRole(cfg.AdminGroups, groups)
+
+	// The proxy is trusted to have authenticated the user, so existing local users are matched by name
+	user, err := a.store.FindUser(username)
+	if err == store.ErrUserNotFound {
+		if !cfg.AutoProvision {
+			slog.Warn("forward auth user not found", "username", username)
+			return nil, ErrUnauthorized
+		}
+		user = store.User{Username: username, Role: role, Provider: store.ProviderProxy}
+		if err := a.store.CreateUser(user); err != nil && err != store.ErrUserExists {
+			return nil, err
+		}
+		a.audit.Log(audit.Entry{Action: audit.ActionUserProvisioned, Username: username, IP: a.clientIP(r), Detail: "forward auth"})
+		slog.Info("user provisioned from forward auth", "username", username)
+		return &auth.Claims{Username: username}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if role != "" && role != user.Role {
+		slog.Info("role updated from forward auth", "username", username, "role", role)
+		user.Role = role
+		if err := a.store.UpdateUser(user); err != nil {
+			return nil, err
+		}
+	}
+	return &auth.Claims{Username: username}, nil
+}
@@ -0,0 +1,274 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Security-relevant events
+const (
+	ActionLogin               = "login"
+	ActionLoginFailed         = "login_failed"
+	ActionLogout              = "logout"
+	ActionLogoutAll           = "logout_all"
+	ActionSessionRevoked      = "session_revoked"
+	ActionReauth              = "reauth"
+	ActionLockout             = "lockout"
+	ActionUnlock              = "unlock"
+	ActionRecoveryCodeUsed    = "recovery_code_used"
+	ActionRecoveryCodesReset  = "recovery_codes_regenerated"
+	ActionOTPEnabled          = "otp_enabled"
+	ActionOTPDisabled         = "otp_disabled"
+	ActionOTPReset            = "otp_reset"
+	ActionPasskeyAdded        = "passkey_added"
+	ActionPasskeyRemoved      = "passkey_removed"
+	ActionUserCreated         = "user_created"
+	ActionUserProvisioned     = "user_provisioned"
+	ActionPasswordChanged     = "password_changed"
+	ActionSSOFailed           = "sso_failed"
+	ActionTokenCreated        = "token_created"
+	ActionTokenRevoked        = "token_revoked"
+	ActionJWTKeyRotated       = "jwt_key_rotated"
+	ActionAuditExported       = "audit_exported"
+	ActionBackupExported      = "backup_exported"
+	ActionBackupRestored      = "backup_restored"
+	ActionShareLinkCreated    = "share_link_created"
+	ActionShareLinkRevoked    = "share_link_revoked"
+	ActionShareLinkUsed       = "share_link_used"
+	ActionShareLinkFailed     = "share_link_failed"
+	ActionDeviceAccessChanged = "device_access_changed"
+	ActionWakeRequested       = "wake_requested"
+	ActionWakeApproved        = "wake_approved"
+	ActionWakeDenied          = "wake_denied"
+)
+
+// Device and power events
+const (
+	ActionDeviceCreated     = "device_created"
+	ActionDeviceUpdated     = "device_updated"
+	ActionDeviceDeleted     = "device_deleted"
+	ActionDeviceRestored    = "device_restored" // from an earlier revision or the trash
+	ActionDevicePurged      = "device_purged"   // removed from the trash for good
+	ActionDevicePaired      = "device_paired"
+	ActionDeviceUnpaired    = "device_unpaired"
+	ActionIdlePolicyChanged = "idle_policy_changed"
+	ActionWake              = "wake"
+	ActionPower             = "power_action" // shutdown, reboot, sleep or hibernate through a companion
+	ActionSceneRun          = "scene_run"
+)
+
+// Results of an action. Entries from before results were recorded have none.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Entry is one line of the audit log.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Actor    string    `json:"actor,omitempty"`    // user performing the action, if authenticated
+	Username string    `json:"username,omitempty"` // account the action concerns
+	Target   string    `json:"target,omitempty"`   // object acted on, e.g. a device MAC address
+	IP       string    `json:"ip,omitempty"`
+	Result   string    `json:"result,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+// Logger appends entries as JSON lines to a file. The server only ever appends to it; entries are
+// removed only by Prune, for the retention policy.
+type Logger struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// Open opens the audit log at path for appending, creating it if needed.
+func Open(path string) (*Logger, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Logger{path: path, file: f}, nil
+}
+
+// Log writes an entry, as a success unless a result is given. Failures are reported through slog,
+// an audit write never fails a request. A nil Logger discards entries.
+func (l *Logger) Log(e Entry) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.Result == "" {
+		e.Result = ResultSuccess
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("failed to encode audit entry", "action", e.Action, "error", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		slog.Error("failed to write audit entry", "action", e.Action, "error", err)
+	}
+}
+
+// Query selects entries. Empty fields match everything.
+type Query struct {
+	Actions  []string
+	Actor    string
+	Username string
+	Target   string
+	IP       string
+	Result   string
+	Since    time.Time // inclusive
+	Until    time.Time // exclusive
+	Offset   int
+	Limit    int // 0 for all entries
+}
+
+// Match reports whether an entry passes the query's filters.
+func (q Query) Match(e Entry) bool {
+	switch {
+	case len(q.Actions) > 0 && !slices.Contains(q.Actions, e.Action):
+		return false
+	case q.Actor != "" && e.Actor != q.Actor:
+		return false
+	case q.Username != "" && e.Username != q.Username:
+		return false
+	case q.Target != "" && e.Target != q.Target:
+		return false
+	case q.IP != "" && e.IP != q.IP:
+		return false
+	case q.Result != "" && e.Result != q.Result:
+		return false
+	case !q.Since.IsZero() && e.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !e.Time.Before(q.Until):
+		return false
+	}
+	return true
+}
+
+// Query returns the page of matching entries, newest first, and the number of matching entries.
+func (l *Logger) Query(q Query) ([]Entry, int, error) {
+	var matched []Entry
+	err := l.scan(func(e Entry) {
+		if q.Match(e) {
+			matched = append(matched, e)
+		}
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	slices.Reverse(matched)
+	total := len(matched)
+	start := min(max(q.Offset, 0), total)
+	end := total
+	if q.Limit > 0 {
+		end = min(start+q.Limit, total)
+	}
+	return matched[start:end], total, nil
+}
+
+// scan calls fn for every entry in file order. Lines that cannot be decoded are skipped.
+func (l *Logger) scan(fn func(Entry)) error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		fn(e)
+	}
+	return scanner.Err()
+}
+
+// Prune removes entries older than before and returns how many were removed. The log is rewritten
+// to a temporary file that replaces it, so a crash leaves either the old or the new log.
+func (l *Logger) Prune(before time.Time) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	src, err := os.Open(l.path)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), "audit-tmp-*.log")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	removed := 0
+	w := bufio.NewWriter(tmp)
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		// Undecodable lines are kept, pruning must not destroy what it does not understand
+		if err := json.Unmarshal(scanner.Bytes(), &e); err == nil && e.Time.Before(before) {
+			removed++
+			continue
+		}
+		w.Write(scanner.Bytes())
+		w.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if removed == 0 {
+		tmp.Close()
+		return 0, nil
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return 0, err
+	}
+
+	// Keep appending to the new file
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return removed, err
+	}
+	l.file.Close()
+	l.file = f
+	return removed, nil
+}
+
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
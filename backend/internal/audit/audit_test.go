@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestLogger(t *testing.T) *Logger {
+	t.Helper()
+	l, err := Open(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestLogDefaults(t *testing.T) {
+	l := newTestLogger(t)
+	l.Log(Entry{Action: ActionLogin, Username: "alice"})
+
+	entries, total, err := l.Query(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d of %d", len(entries), total)
+	}
+	if entries[0].Result != ResultSuccess {
+		t.Errorf("expected result %q, got %q", ResultSuccess, entries[0].Result)
+	}
+	if entries[0].Time.IsZero() {
+		t.Error("expected the time to be set")
+	}
+
+	var nilLogger *Logger
+	nilLogger.Log(Entry{Action: ActionLogin}) // must not panic
+}
+
+func TestQueryFiltersAndPages(t *testing.T) {
+	l := newTestLogger(t)
+	for i := range 10 {
+		e := Entry{Time: base.Add(time.Duration(i) * time.Hour), Action: ActionWake, Actor: "alice", Target: "aa:bb"}
+		if i%2 == 1 {
+			e.Action = ActionLoginFailed
+			e.Actor = ""
+			e.Result = ResultFailure
+		}
+		l.Log(e)
+	}
+
+	entries, total, err := l.Query(Query{Actions: []string{ActionWake}, Limit: 2, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 5 || len(entries) != 2 {
+		t.Fatalf("expected 2 of 5 entries, got %d of %d", len(entries), total)
+	}
+	// Newest first: hours 8, 6, 4, ... so offset 1 starts at hour 6
+	if !entries[0].Time.Equal(base.Add(6*time.Hour)) || !entries[1].Time.Equal(base.Add(4*time.Hour)) {
+		t.Errorf("unexpected page: %v, %v", entries[0].Time, entries[1].Time)
+	}
+
+	_, total, _ = l.Query(Query{Result: ResultFailure, Since: base.Add(3 * time.Hour), Until: base.Add(7 * time.Hour)})
+	if total != 2 { // hours 3 and 5; 7 is excluded
+		t.Errorf("expected 2 failures in range, got %d", total)
+	}
+
+	entries, total, _ = l.Query(Query{Actor: "alice", Offset: 100})
+	if total != 5 || len(entries) != 0 {
+		t.Errorf("expected an empty page past the end, got %d of %d", len(entries), total)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	l := newTestLogger(t)
+	for i := range 4 {
+		l.Log(Entry{Time: base.AddDate(0, 0, i), Action: ActionLogin})
+	}
+
+	removed, err := l.Prune(base.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("expected 2 entries removed, got %d", removed)
+	}
+
+	// Appends go to the rewritten file
+	l.Log(Entry{Time: base.AddDate(0, 0, 5), Action: ActionLogout})
+	entries, total, _ := l.Query(Query{})
+	if total != 3 || entries[0].Action != ActionLogout {
+		t.Fatalf("expected 3 entries ending with the new one, got %d: %+v", total, entries)
+	}
+
+	info, err := os.Stat(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600 after prune, got %v", info.Mode().Perm())
+	}
+}
+
+func TestWriteCSVEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, []Entry{{Time: base, Action: ActionLoginFailed, Username: "=HYPERLINK(\"x\")", Result: ResultFailure}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected header and one row, got %q", buf.String())
+	}
+	if !strings.Contains(lines[1], `"'=HYPERLINK(""x"")"`) {
+		t.Errorf("formula not escaped: %s", lines[1])
+	}
+}
@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+var csvHeader = []string{"time", "action", "actor", "username", "target", "ip", "result", "detail"}
+
+// WriteCSV writes entries as CSV with a header row.
+func WriteCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		record := []string{e.Time.UTC().Format(time.RFC3339Nano), e.Action, e.Actor, e.Username, e.Target, e.IP, e.Result, e.Detail}
+		for i := range record {
+			record[i] = csvSafe(record[i])
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSONL writes entries as JSON lines, the format of the log itself.
+func WriteJSONL(w io.Writer, entries []Entry) error {
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvSafe keeps spreadsheets from evaluating values as formulas. Usernames and details can come from
+// anyone who reaches the login page.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
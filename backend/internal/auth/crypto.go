@@ -2,25 +2,15 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"strings"
 
 	"github.com/pquerna/otp/totp"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", fmt.Errorf("failed to hash password: %w", err)
-	}
-	return string(hashedBytes), nil
-}
-
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
-
 func GenerateRandomString(length int) (string, error) {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@)!()"
 	b := make([]byte, length)
@@ -53,3 +43,65 @@ func Validate2FA(passcode string, userSecret string) bool {
 	// Validates the code against the secret and current time
 	return totp.Validate(passcode, userSecret)
 }
+
+// GenerateCSRFToken returns a random token that pages of this site can read from a cookie and echo
+// in a header, which other sites cannot.
+func GenerateCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate CSRF token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// accessTokenPrefix marks personal access tokens so they are easy to recognize, e.g. in secret scanners.
+const accessTokenPrefix = "wlt_"
+
+// GenerateAccessToken returns a new personal access token and the hash to store for it.
+func GenerateAccessToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate access token: %w", err)
+	}
+	token = accessTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashAccessToken(token), nil
+}
+
+// HashAccessToken returns the hex SHA-256 of a token. Tokens are random, so a fast hash is enough.
+func HashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAccessToken reports whether a bearer credential looks like a personal access token.
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, accessTokenPrefix)
+}
+
+// recoveryCodeAlphabet leaves out characters that are easily confused when written down (0/o, 1/l/i).
+const recoveryCodeAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+
+// GenerateRecoveryCodes returns n one-time recovery codes (xxxx-xxxx-xxxx) and the hashes to store for them.
+func GenerateRecoveryCodes(n int) (codes []string, hashes []string, err error) {
+	for range n {
+		b := make([]byte, 12)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		for i := range b {
+			// 256 is not a multiple of the alphabet size; the bias is negligible for a one-time code
+			b[i] = recoveryCodeAlphabet[int(b[i])%len(recoveryCodeAlphabet)]
+		}
+		code := string(b[0:4]) + "-" + string(b[4:8]) + "-" + string(b[8:12])
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the hex SHA-256 of a recovery code, ignoring case, spaces and dashes.
+func HashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
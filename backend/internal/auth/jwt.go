@@ -8,36 +8,51 @@ import (
 )
 
 type Claims struct {
-	Username string `json:"username"`
+	Username string           `json:"username"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"` // when the user last proved who they are (login or re-authentication)
 	jwt.RegisteredClaims
 }
 
-// GenerateJWTToken creates a new JWT token for the given username
-func GenerateJWTToken(username string, jwtKey []byte, expiry time.Duration) (string, time.Time, error) {
-	expirationTime := time.Now().Add(expiry)
+// AuthenticatedWithin reports whether the user authenticated within the last d.
+func (c *Claims) AuthenticatedWithin(d time.Duration) bool {
+	return c.AuthTime != nil && time.Since(c.AuthTime.Time) <= d
+}
+
+// KeyLookup returns the signing key with the given key ID (kid).
+type KeyLookup func(kid string) ([]byte, bool)
+
+// GenerateJWTToken creates a new JWT token for the given username and session ID (jti), signed with the key identified by kid
+func GenerateJWTToken(username, sessionID, kid string, jwtKey []byte, expiresAt, authTime time.Time) (string, error) {
 	claims := &Claims{
 		Username: username,
+		AuthTime: jwt.NewNumericDate(authTime),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			ID:        sessionID,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "wolite",
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
-	return tokenString, expirationTime, err
+	token.Header["kid"] = kid
+	return token.SignedString(jwtKey)
 }
 
-// ValidateJWTToken parses and validates the given token string
-func ValidateJWTToken(tokenString string, jwtKey []byte) (*Claims, error) {
+// ValidateJWTToken parses and validates the given token string against the key named by its kid header
+func ValidateJWTToken(tokenString string, keys KeyLookup) (*Claims, error) {
 	claims := &Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return jwtKey, nil
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %q", kid)
+		}
+		return key, nil
 	})
 
 	if err != nil {
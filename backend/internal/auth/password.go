@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordParams are the argon2id costs for new password hashes.
+type PasswordParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultPasswordParams follow the second recommendation of RFC 9106, with 64 MiB of memory.
+var DefaultPasswordParams = PasswordParams{Memory: 64 * 1024, Iterations: 3, Parallelism: 2}
+
+const (
+	saltLength = 16
+	keyLength  = 32
+)
+
+var (
+	paramsMu       sync.RWMutex
+	passwordParams = DefaultPasswordParams
+)
+
+// SetPasswordParams changes the costs of new hashes. Hashes with lower costs are reported by
+// PasswordNeedsRehash, so they are upgraded when their users next log in.
+func SetPasswordParams(p PasswordParams) error {
+	if p.Memory < 8*uint32(p.Parallelism) || p.Iterations < 1 || p.Parallelism < 1 {
+		return errors.New("argon2id needs at least 1 iteration, 1 thread and 8 KiB of memory per thread")
+	}
+	paramsMu.Lock()
+	defer paramsMu.Unlock()
+	passwordParams = p
+	return nil
+}
+
+func currentParams() PasswordParams {
+	paramsMu.RLock()
+	defer paramsMu.RUnlock()
+	return passwordParams
+}
+
+// HashPassword hashes a password with argon2id, encoded as
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<hash> so that the
+// algorithm and costs are stored with it.
+func HashPassword(password string) (string, error) {
+	p := currentParams()
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// CheckPasswordHash verifies a password against an argon2id or legacy bcrypt hash.
+// Users without a password (e.g. from single sign-on) have an empty hash, which never matches.
+func CheckPasswordHash(password, hash string) bool {
+	if password == "" || hash == "" {
+		return false
+	}
+	if strings.HasPrefix(hash, "$argon2id$") {
+		p, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// PasswordNeedsRehash reports whether a hash uses bcrypt or lower argon2id costs than configured.
+func PasswordNeedsRehash(hash string) bool {
+	if hash == "" {
+		return false
+	}
+	p, _, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	want := currentParams()
+	return p.Memory < want.Memory || p.Iterations < want.Iterations || p.Parallelism < want.Parallelism || len(key) < keyLength
+}
+
+func decodeArgon2id(hash string) (PasswordParams, []byte, []byte, error) {
+	var p PasswordParams
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return p, nil, nil, errors.New("not an argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	if p.Iterations < 1 || p.Parallelism < 1 {
+		return p, nil, nil, errors.New("invalid argon2id parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, errors.New("invalid argon2id hash")
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Small costs keep the tests fast
+var testParams = PasswordParams{Memory: 64, Iterations: 2, Parallelism: 1}
+
+func setTestParams(t *testing.T, p PasswordParams) {
+	t.Helper()
+	if err := SetPasswordParams(p); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetPasswordParams(DefaultPasswordParams) })
+}
+
+func TestHashPassword(t *testing.T) {
+	setTestParams(t, testParams)
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=2,p=1$") {
+		t.Errorf("unexpected hash format: %s", hash)
+	}
+	if !CheckPasswordHash("correct horse", hash) {
+		t.Error("expected password to match")
+	}
+	if CheckPasswordHash("correct horsf", hash) {
+		t.Error("expected wrong password not to match")
+	}
+	if other, _ := HashPassword("correct horse"); other == hash {
+		t.Error("expected a random salt")
+	}
+	if PasswordNeedsRehash(hash) {
+		t.Error("expected a current hash not to need a rehash")
+	}
+}
+
+func TestHashPasswordLong(t *testing.T) {
+	setTestParams(t, testParams)
+
+	// bcrypt only looks at the first 72 bytes
+	long := strings.Repeat("a", 72)
+	hash, err := HashPassword(long + "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CheckPasswordHash(long+"2", hash) {
+		t.Error("expected passwords differing after 72 bytes not to match")
+	}
+}
+
+func TestCheckPasswordHashLegacy(t *testing.T) {
+	setTestParams(t, testParams)
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPasswordHash("secret-password", string(legacy)) {
+		t.Error("expected bcrypt hash to match")
+	}
+	if !PasswordNeedsRehash(string(legacy)) {
+		t.Error("expected bcrypt hash to need a rehash")
+	}
+}
+
+func TestCheckPasswordHashEmpty(t *testing.T) {
+	setTestParams(t, testParams)
+
+	hash, _ := HashPassword("")
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+	}{
+		{"no hash", "anything", ""},
+		{"no password", "", hash},
+		{"malformed", "secret", "$argon2id$v=19$m=64,t=2,p=1$bm90$"},
+		{"wrong version", "secret", strings.Replace(hash, "v=19", "v=16", 1)},
+		{"unknown algorithm", "secret", "$argon2i$v=19$m=64,t=2,p=1$c2FsdA$a2V5"},
+	}
+	for _, tt := range tests {
+		if CheckPasswordHash(tt.password, tt.hash) {
+			t.Errorf("%s: expected no match", tt.name)
+		}
+	}
+	if PasswordNeedsRehash("") {
+		t.Error("expected users without a password not to need a rehash")
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	setTestParams(t, testParams)
+	hash, _ := HashPassword("secret")
+
+	setTestParams(t, PasswordParams{Memory: 128, Iterations: 2, Parallelism: 1})
+	if !PasswordNeedsRehash(hash) {
+		t.Error("expected a hash with less memory to need a rehash")
+	}
+	if !CheckPasswordHash("secret", hash) {
+		t.Error("expected old parameters to keep verifying")
+	}
+
+	setTestParams(t, PasswordParams{Memory: 32, Iterations: 1, Parallelism: 1})
+	if PasswordNeedsRehash(hash) {
+		t.Error("expected a stronger hash not to be downgraded")
+	}
+}
+
+func TestSetPasswordParamsRejectsInvalid(t *testing.T) {
+	for _, p := range []PasswordParams{{Memory: 64, Iterations: 0, Parallelism: 1}, {Memory: 64, Iterations: 1, Parallelism: 0}, {Memory: 8, Iterations: 1, Parallelism: 2}} {
+		if err := SetPasswordParams(p); err == nil {
+			t.Errorf("expected %+v to be rejected", p)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// shareLinkPrefix marks share link tokens. Tokens contain no dots, so the UI serves them as a page.
+const shareLinkPrefix = "wls_"
+
+// shareLinkIDLength is the length of a hex store ID.
+const shareLinkIDLength = 32
+
+// MaxShareLinkLifetime is the longest a share link can be valid.
+const MaxShareLinkLifetime = 90 * 24 * time.Hour
+
+// SigningKeyRetention returns how long a retired signing key must keep verifying: until both the
+// sessions and the share links it signed have expired.
+func SigningKeyRetention(jwtExpiry time.Duration) time.Duration {
+	return max(jwtExpiry, MaxShareLinkLifetime)
+}
+
+// ShareLinkToken returns the token for a share link: its ID followed by an HMAC-SHA256 of the ID, so
+// links cannot be forged or guessed from an ID alone.
+func ShareLinkToken(id string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("share-link:" + id))
+	return shareLinkPrefix + id + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ShareLinkID returns the link ID a token claims. Check the claim with VerifyShareLinkToken.
+func ShareLinkID(token string) (string, bool) {
+	rest, ok := strings.CutPrefix(token, shareLinkPrefix)
+	if !ok || len(rest) <= shareLinkIDLength {
+		return "", false
+	}
+	id := rest[:shareLinkIDLength]
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// VerifyShareLinkToken reports whether the token was signed with key.
+func VerifyShareLinkToken(token string, key []byte) bool {
+	id, ok := ShareLinkID(token)
+	return ok && hmac.Equal([]byte(token), []byte(ShareLinkToken(id, key)))
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"wolite/internal/keyring"
+)
+
+func TestShareLinkToken(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	id := "8e8fb4b6d9b19cfd596e43039f0d1c2a"
+
+	token := ShareLinkToken(id, key)
+	if strings.Contains(token, ".") {
+		t.Errorf("token must not contain dots: %s", token)
+	}
+	if got, ok := ShareLinkID(token); !ok || got != id {
+		t.Fatalf("expected id %s, got %q", id, got)
+	}
+	if !VerifyShareLinkToken(token, key) {
+		t.Error("expected token to verify")
+	}
+	if VerifyShareLinkToken(token, []byte("another key")) {
+		t.Error("expected token not to verify with another key")
+	}
+
+	// Another ID with the signature of the first
+	forged := ShareLinkToken("0000000000000000000000000000000a", key)[:4+32] + token[4+32:]
+	if VerifyShareLinkToken(forged, key) {
+		t.Error("expected forged token not to verify")
+	}
+}
+
+func TestShareLinkIDRejectsMalformed(t *testing.T) {
+	for _, token := range []string{"", "wls_", "wlt_8e8fb4b6d9b19cfd596e43039f0d1c2aSIG", "wls_8e8fb4b6d9b19cfd596e43039f0d1c2a", "wls_zz8fb4b6d9b19cfd596e43039f0d1c2aSIG"} {
+		if _, ok := ShareLinkID(token); ok {
+			t.Errorf("expected %q to be rejected", token)
+		}
+	}
+}
+
+func TestShareLinkSurvivesRotation(t *testing.T) {
+	dir := t.TempDir()
+	master, err := keyring.LoadMasterKey("", filepath.Join(dir, "master.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "jwt_keys.json")
+	keys, err := keyring.Open(path, master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, key, err := keys.Active()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := ShareLinkToken("8e8fb4b6d9b19cfd596e43039f0d1c2a", key)
+	retain := SigningKeyRetention(7 * 24 * time.Hour)
+
+	// retiredAgo backdates the retirement of the link's key
+	retiredAgo := func(ago time.Duration) {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var file map[string]any
+		if err := json.Unmarshal(data, &file); err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range file["keys"].([]any) {
+			if k := k.(map[string]any); k["id"] == kid {
+				k["retired_at"] = time.Now().Add(-ago)
+			}
+		}
+		if data, err = json.Marshal(file); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := keys.Rotate(retain); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retired for longer than a session lasts, the key still verifies the link on the next rotation
+	retiredAgo(30 * 24 * time.Hour)
+	if _, err := keys.Rotate(retain); err != nil {
+		t.Fatal(err)
+	}
+	if key, ok := keys.Lookup(kid); !ok || !VerifyShareLinkToken(token, key) {
+		t.Fatal("expected the share link to verify after rotations")
+	}
+
+	// Once no link can still be valid the key is dropped
+	retiredAgo(MaxShareLinkLifetime + time.Hour)
+	if _, err := keys.Rotate(retain); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys.Lookup(kid); ok {
+		t.Error("expected the key to be dropped after the longest share link lifetime")
+	}
+}
@@ -0,0 +1,171 @@
+// Package backup reads and writes backup archives of the store: a JSON document with a format version,
+// a checksum and the snapshot, optionally encrypted with a key derived from a passphrase.
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"wolite/internal/store"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	formatName = "wolite-backup"
+	Version    = 1 // version of the archive and of the snapshot in it
+
+	kdfArgon2id = "argon2id"
+	saltSize    = 16
+)
+
+// Key derivation costs for new archives. Archives record their own, so these can be raised later.
+const (
+	kdfTime    = 3
+	kdfMemory  = 64 * 1024 // KiB
+	kdfThreads = 2
+)
+
+var (
+	ErrInvalidArchive     = errors.New("not a wolite backup archive")
+	ErrUnsupportedVersion = errors.New("unsupported backup version")
+	ErrPassphraseRequired = errors.New("backup is encrypted, a passphrase is required")
+	ErrWrongPassphrase    = errors.New("backup cannot be decrypted with this passphrase")
+	ErrChecksumMismatch   = errors.New("backup is corrupted, its checksum does not match")
+)
+
+// Archive is the on-disk form of a backup. Data holds the snapshot, unless the archive is encrypted,
+// then Sealed holds it.
+type Archive struct {
+	Format     string          `json:"format"`
+	Version    int             `json:"version"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Checksum   string          `json:"checksum"` // hex SHA-256 of the compact snapshot JSON
+	Encryption *Encryption     `json:"encryption,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	Sealed     []byte          `json:"sealed,omitempty"` // nonce followed by AES-GCM ciphertext of the snapshot
+}
+
+// Encryption describes how the key of an encrypted archive is derived from the passphrase.
+type Encryption struct {
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"` // KiB
+	Threads uint8  `json:"threads"`
+}
+
+// Write writes the snapshot as an archive, encrypted when a passphrase is given.
+func Write(w io.Writer, snap store.Snapshot, passphrase string) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	archive := Archive{
+		Format:    formatName,
+		Version:   Version,
+		CreatedAt: time.Now().UTC(),
+		Checksum:  hex.EncodeToString(sum[:]),
+	}
+
+	if passphrase == "" {
+		archive.Data = data
+	} else {
+		archive.Encryption = &Encryption{KDF: kdfArgon2id, Salt: make([]byte, saltSize), Time: kdfTime, Memory: kdfMemory, Threads: kdfThreads}
+		if _, err := rand.Read(archive.Encryption.Salt); err != nil {
+			return err
+		}
+		aead, err := archive.Encryption.aead(passphrase)
+		if err != nil {
+			return err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		archive.Sealed = aead.Seal(nonce, nonce, data, archive.additionalData())
+	}
+
+	return json.NewEncoder(w).Encode(archive)
+}
+
+// Read reads an archive and returns its snapshot. The checksum is verified; references within the
+// snapshot are checked by the store on import.
+func Read(r io.Reader, passphrase string) (*store.Snapshot, *Archive, error) {
+	var archive Archive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil || archive.Format != formatName {
+		return nil, nil, ErrInvalidArchive
+	}
+	if archive.Version != Version {
+		return nil, nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, archive.Version)
+	}
+
+	data := []byte(archive.Data)
+	if archive.Encryption != nil {
+		if passphrase == "" {
+			return nil, nil, ErrPassphraseRequired
+		}
+		if archive.Encryption.KDF != kdfArgon2id {
+			return nil, nil, fmt.Errorf("%w: unknown key derivation %q", ErrInvalidArchive, archive.Encryption.KDF)
+		}
+		aead, err := archive.Encryption.aead(passphrase)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(archive.Sealed) < aead.NonceSize() {
+			return nil, nil, ErrInvalidArchive
+		}
+		nonce, sealed := archive.Sealed[:aead.NonceSize()], archive.Sealed[aead.NonceSize():]
+		data, err = aead.Open(nil, nonce, sealed, archive.additionalData())
+		if err != nil {
+			return nil, nil, ErrWrongPassphrase
+		}
+	}
+
+	// The snapshot may have been reformatted, only whitespace outside of strings is insignificant
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return nil, nil, ErrInvalidArchive
+	}
+	sum := sha256.Sum256(compact.Bytes())
+	if hex.EncodeToString(sum[:]) != archive.Checksum {
+		return nil, nil, ErrChecksumMismatch
+	}
+
+	var snap store.Snapshot
+	if err := json.Unmarshal(compact.Bytes(), &snap); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	archive.Data, archive.Sealed = nil, nil
+	return &snap, &archive, nil
+}
+
+// additionalData binds the header to the ciphertext, so the version and checksum cannot be swapped.
+func (a *Archive) additionalData() []byte {
+	return fmt.Appendf(nil, "%s v%d %s %s", a.Format, a.Version, a.CreatedAt.Format(time.RFC3339Nano), a.Checksum)
+}
+
+func (e *Encryption) aead(passphrase string) (cipher.AEAD, error) {
+	if e.Time == 0 || e.Memory == 0 || e.Threads == 0 || len(e.Salt) < saltSize {
+		return nil, fmt.Errorf("%w: invalid key derivation parameters", ErrInvalidArchive)
+	}
+	// Bound the costs, the parameters come from the archive
+	if e.Memory > 1024*1024 || e.Time > 16 {
+		return nil, fmt.Errorf("%w: key derivation costs too high", ErrInvalidArchive)
+	}
+	key := argon2.IDKey([]byte(passphrase), e.Salt, e.Time, e.Memory, e.Threads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
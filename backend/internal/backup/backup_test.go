@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"wolite/internal/store"
+)
+
+func testSnapshot() store.Snapshot {
+	return store.Snapshot{
+		Users:   []store.User{{Username: "alice", OTP: "otp-secret"}},
+		Devices: []store.Device{{MACAddress: "aa", Name: "pc", CompanionToken: "companion-secret"}},
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testSnapshot(), ""); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	snap, archive, err := Read(bytes.NewReader(buf.Bytes()), "")
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	if archive.Version != Version || len(snap.Users) != 1 || snap.Devices[0].CompanionToken != "companion-secret" {
+		t.Errorf("unexpected archive %+v with snapshot %+v", archive, snap)
+	}
+
+	// A changed snapshot no longer matches the checksum
+	tampered := bytes.Replace(buf.Bytes(), []byte(`"pc"`), []byte(`"px"`), 1)
+	if _, _, err := Read(bytes.NewReader(tampered), ""); err != ErrChecksumMismatch {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if _, _, err := Read(bytes.NewReader([]byte(`{"users":[]}`)), ""); err != ErrInvalidArchive {
+		t.Errorf("expected ErrInvalidArchive, got %v", err)
+	}
+}
+
+func TestEncryptedArchive(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testSnapshot(), "correct horse"); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("secret")) {
+		t.Fatal("encrypted archive holds secrets in plaintext")
+	}
+
+	if _, _, err := Read(bytes.NewReader(buf.Bytes()), ""); err != ErrPassphraseRequired {
+		t.Errorf("expected ErrPassphraseRequired, got %v", err)
+	}
+	if _, _, err := Read(bytes.NewReader(buf.Bytes()), "wrong"); err != ErrWrongPassphrase {
+		t.Errorf("expected ErrWrongPassphrase, got %v", err)
+	}
+	snap, _, err := Read(bytes.NewReader(buf.Bytes()), "correct horse")
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	if snap.Users[0].OTP != "otp-secret" {
+		t.Errorf("unexpected snapshot %+v", snap)
+	}
+
+	// The version is authenticated with the ciphertext
+	newer := bytes.Replace(buf.Bytes(), []byte(`"version":1`), []byte(`"version":2`), 1)
+	if _, _, err := Read(bytes.NewReader(newer), "correct horse"); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
@@ -1,10 +1,12 @@
 package companion
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -126,6 +128,22 @@ const (
 	ActionHibernate PowerAction = "hibernate"
 )
 
+// ParseAction maps a generic action string to a PowerAction.
+func ParseAction(s string) (PowerAction, error) {
+	switch s {
+	case "shutdown":
+		return ActionShutdown, nil
+	case "reboot":
+		return ActionReboot, nil
+	case "sleep":
+		return ActionSleep, nil
+	case "hibernate":
+		return ActionHibernate, nil
+	default:
+		return "", fmt.Errorf("invalid action: %q", s)
+	}
+}
+
 // Power sends a power command to the companion.
 func (c *Client) Power(ctx context.Context, action PowerAction) error {
 	url := fmt.Sprintf("%s/api/v1/%s", c.BaseURL, action)
@@ -148,3 +166,74 @@ func (c *Client) Power(ctx context.Context, action PowerAction) error {
 	}
 	return nil
 }
+
+// Session is a user session logged in on the companion's machine.
+type Session struct {
+	User     string `json:"user"`
+	Terminal string `json:"terminal,omitempty"`
+	Remote   bool   `json:"remote"`
+	Host     string `json:"host,omitempty"`
+}
+
+// IdleStatus is the user activity reported by the companion.
+type IdleStatus struct {
+	IdleSeconds *int64    `json:"idle_seconds"` // nil when the companion cannot tell, e.g. nobody is logged in locally
+	Sessions    []Session `json:"sessions"`
+}
+
+// Idle fetches the time since the last user input and the active sessions.
+func (c *Client) Idle(ctx context.Context) (*IdleStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/api/v1/idle", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("companion error (%d): %s", resp.StatusCode, string(body))
+	}
+
+	var envelope struct {
+		Data IdleStatus `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("invalid companion response: %w", err)
+	}
+	return &envelope.Data, nil
+}
+
+// Notify shows a desktop notification to the users logged in on the companion's machine.
+func (c *Client) Notify(ctx context.Context, title, message string) error {
+	body, err := json.Marshal(map[string]string{"title": title, "message": message})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/api/v1/notify", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("companion error (%d): %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
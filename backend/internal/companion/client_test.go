@@ -77,6 +77,14 @@ func TestCompanionClient(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("shutting down"))
 	})
+	mux.HandleFunc("/api/v1/idle", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"code":200,"message":"idle status","data":{"idle_seconds":2700,"sessions":[{"user":"alice","terminal":"tty2","remote":false}]}}`))
+	})
 
 	server := httptest.NewUnstartedServer(mux)
 	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
@@ -112,6 +120,18 @@ func TestCompanionClient(t *testing.T) {
 		if err := client.Power(ctx, ActionShutdown); err != nil {
 			t.Errorf("Power failed: %v", err)
 		}
+
+		// Test Idle
+		idle, err := client.Idle(ctx)
+		if err != nil {
+			t.Fatalf("Idle failed: %v", err)
+		}
+		if idle.IdleSeconds == nil || *idle.IdleSeconds != 2700 {
+			t.Errorf("Unexpected idle seconds: %v", idle.IdleSeconds)
+		}
+		if len(idle.Sessions) != 1 || idle.Sessions[0].User != "alice" {
+			t.Errorf("Unexpected sessions: %+v", idle.Sessions)
+		}
 	})
 
 	// 4. Test NewClient with Incorrect Fingerprint
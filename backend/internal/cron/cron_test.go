@@ -0,0 +1,94 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"foo * * * *",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("failed to load location: %v", err)
+	}
+
+	tests := []struct {
+		expr  string
+		after time.Time
+		want  time.Time
+	}{
+		// Weekdays at 07:30, from a Friday evening to Monday morning
+		{"30 7 * * mon-fri", time.Date(2025, 1, 3, 19, 0, 0, 0, loc), time.Date(2025, 1, 6, 7, 30, 0, 0, loc)},
+		// Strictly after: an exact match moves on to the next occurrence
+		{"0 19 * * *", time.Date(2025, 1, 3, 19, 0, 0, 0, loc), time.Date(2025, 1, 4, 19, 0, 0, 0, loc)},
+		// Steps
+		{"*/15 * * * *", time.Date(2025, 1, 3, 10, 16, 30, 0, loc), time.Date(2025, 1, 3, 10, 30, 0, 0, loc)},
+		// Day of month and day of week are OR-ed when both are restricted
+		{"0 0 13 * fri", time.Date(2025, 1, 4, 0, 0, 0, 0, loc), time.Date(2025, 1, 10, 0, 0, 0, 0, loc)},
+		// Macros and month names
+		{"@yearly", time.Date(2025, 3, 1, 0, 0, 0, 0, loc), time.Date(2026, 1, 1, 0, 0, 0, 0, loc)},
+		{"0 12 1 feb *", time.Date(2025, 3, 1, 0, 0, 0, 0, loc), time.Date(2026, 2, 1, 12, 0, 0, 0, loc)},
+		// Sunday as 7
+		{"0 9 * * 7", time.Date(2025, 1, 6, 0, 0, 0, 0, loc), time.Date(2025, 1, 12, 9, 0, 0, 0, loc)},
+		// 02:30 does not exist on the DST switch day and is skipped
+		{"30 2 * * *", time.Date(2025, 3, 30, 0, 0, 0, 0, loc), time.Date(2025, 3, 31, 2, 30, 0, 0, loc)},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", tt.expr, err)
+		}
+		if got := s.Next(tt.after); !got.Equal(tt.want) {
+			t.Errorf("%q after %v: expected %v, got %v", tt.expr, tt.after, tt.want, got)
+		}
+	}
+}
+
+func TestNextFunc(t *testing.T) {
+	s, err := Parse("0 8 * * mon-fri")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	// Skip Monday 2025-01-06 and force Saturday 2025-01-04
+	dayOK := func(day time.Time, matches bool) bool {
+		switch day.Format(time.DateOnly) {
+		case "2025-01-06":
+			return false
+		case "2025-01-04":
+			return true
+		}
+		return matches
+	}
+
+	after := time.Date(2025, 1, 3, 9, 0, 0, 0, time.UTC)
+	want := []time.Time{
+		time.Date(2025, 1, 4, 8, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 7, 8, 0, 0, 0, time.UTC),
+	}
+	for _, w := range want {
+		after = s.NextFunc(after, dayOK)
+		if !after.Equal(w) {
+			t.Errorf("expected %v, got %v", w, after)
+		}
+	}
+}
@@ -1,22 +1,96 @@
 package env
 
 import (
+	"crypto/x509"
 	"log"
 	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
-	"wolite/internal/auth"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	JWTSecret    string
-	DatabasePath string
-	JWTExpiry    time.Duration
-	DevMode      bool
-	Port         string
+	JWTSecret     string // static signing key, disables the persisted keyring when set
+	DatabasePath  string
+	DataDir       string // directory of the database, holds the keyring
+	MasterKey     string // base64 master key, read from MasterKeyFile when empty
+	MasterKeyFile string // master key file, master.key in the data directory by default
+	JWTExpiry     time.Duration
+	ReauthWindow  time.Duration // how long a login or re-authentication allows sensitive actions, 0 disables the check
+	// How long audit log entries are kept, 0 keeps them forever
+	AuditRetention time.Duration
+	// How long deleted devices stay in the trash, 0 keeps them until they are purged
+	DeviceTrashRetention time.Duration
+	DevMode              bool
+	// Other origins allowed to call the API from a browser (CORS and cross-origin checks)
+	AllowedOrigins []string
+	Port           string
+
+	// argon2id costs for password hashes
+	Argon2Memory      uint32 // KiB
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+
+	// Reverse proxies whose X-Forwarded-For header is trusted for the client IP
+	TrustedProxies []netip.Prefix
+
+	// WebAuthn relying party, derived from the request host when empty
+	WebAuthnRPID    string
+	WebAuthnOrigins []string
+
+	OIDC        OIDCConfig
+	ForwardAuth ForwardAuthConfig
+	LDAP        LDAPConfig
+}
+
+// OIDCConfig configures OpenID Connect single sign-on, disabled when Issuer is empty.
+type OIDCConfig struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string // derived from the request host when empty
+	Scopes        []string
+	DisplayName   string // shown on the login button
+	UsernameClaim string
+	GroupsClaim   string
+	AdminGroups   []string // members become admins; roles are left alone when empty
+	AllowedGroups []string // only members may log in; anyone may when empty
+	AutoProvision bool     // create users on their first login
+}
+
+// ForwardAuthConfig configures login through a reverse proxy (e.g. Authelia, Authentik) that passes
+// the authenticated user in a header. Disabled when Header is empty.
+type ForwardAuthConfig struct {
+	Header        string         // e.g. Remote-User
+	GroupsHeader  string         // comma-separated groups, e.g. Remote-Groups
+	Proxies       []netip.Prefix // only requests from these addresses may set the headers
+	AdminGroups   []string
+	AutoProvision bool
+}
+
+// LDAPConfig configures password login against an LDAP directory, disabled when URL is empty.
+type LDAPConfig struct {
+	URL           string // ldap:// or ldaps://
+	StartTLS      bool
+	TLSSkipVerify bool
+	RootCAs       *x509.CertPool // from LDAP_CA_FILE, system roots when nil
+	BindDN        string
+	BindPassword  string
+	BaseDN        string
+	UserFilter    string // {username} is replaced by the login name
+	UsernameAttr  string // attribute holding the canonical username
+	GroupAttr     string
+	GroupBaseDN   string
+	GroupFilter   string // {dn} and {username} are replaced, groups are only read from GroupAttr when empty
+	AdminGroups   []string
+	AllowedGroups []string
 }
 
 func LoadConfig() *Config {
@@ -28,13 +102,8 @@ func LoadConfig() *Config {
 
 	jwtToken := os.Getenv("JWT_SECRET")
 	var err error
-	if jwtToken == "" {
-		// if user not provided a JWT secret, generate a random one
-		jwtToken, err = auth.GenerateRandomString(32)
-		if err != nil {
-			log.Fatalf("failed to generate JWT secret: %v", err)
-		}
-		slog.Warn("JWT_SECRET not provided, generated a random one")
+	if jwtToken != "" {
+		slog.Warn("JWT_SECRET provided, signing key rotation is disabled")
 	}
 	jwtExpiryString := os.Getenv("JWT_EXPIRY_SECONDS")
 	var jwtExpiry int
@@ -52,10 +121,137 @@ func LoadConfig() *Config {
 	}
 	slog.Info("JWT_EXPIRY_SECONDS", "value", strconv.Itoa(jwtExpiry))
 
+	reauthWindow := parseUint("REAUTH_WINDOW_SECONDS", 900, 32)
+	auditRetentionDays := parseUint("AUDIT_RETENTION_DAYS", 365, 16)
+	trashRetentionDays := parseUint("DEVICE_TRASH_RETENTION_DAYS", 30, 16)
+
+	var allowedOrigins []string
+	for _, origin := range splitList("ALLOWED_ORIGINS") {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || strings.TrimRight(u.Path, "/") != "" || u.RawQuery != "" {
+			log.Fatalf("ALLOWED_ORIGINS entry %q must be scheme://host[:port]", origin)
+		}
+		allowedOrigins = append(allowedOrigins, u.Scheme+"://"+u.Host)
+	}
+
 	devMode := false
 	if os.Getenv("DEV_MODE") == "true" {
 		devMode = true
-		slog.Info("DEV_MODE enabled - CORS will be allowed")
+		if len(allowedOrigins) == 0 {
+			allowedOrigins = []string{"http://localhost:5173"} // vite dev server
+		}
+		slog.Info("DEV_MODE enabled")
+	}
+	if len(allowedOrigins) > 0 {
+		slog.Info("ALLOWED_ORIGINS - CORS will be allowed", "value", allowedOrigins)
+	}
+
+	databasePath := os.Getenv("DATABASE_PATH")
+
+	trustedProxies := parsePrefixes("TRUSTED_PROXIES")
+	if len(trustedProxies) > 0 {
+		slog.Info("TRUSTED_PROXIES", "value", trustedProxies)
+	}
+
+	var webAuthnOrigins []string
+	for _, origin := range splitList("WEBAUTHN_ORIGINS") {
+		webAuthnOrigins = append(webAuthnOrigins, strings.TrimRight(origin, "/"))
+	}
+
+	oidc := OIDCConfig{
+		Issuer:        os.Getenv("OIDC_ISSUER"),
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:        strings.Fields(os.Getenv("OIDC_SCOPES")),
+		DisplayName:   os.Getenv("OIDC_DISPLAY_NAME"),
+		UsernameClaim: os.Getenv("OIDC_USERNAME_CLAIM"),
+		GroupsClaim:   os.Getenv("OIDC_GROUPS_CLAIM"),
+		AdminGroups:   splitList("OIDC_ADMIN_GROUPS"),
+		AllowedGroups: splitList("OIDC_ALLOWED_GROUPS"),
+		AutoProvision: os.Getenv("OIDC_AUTO_PROVISION") != "false",
+	}
+	if oidc.Issuer != "" {
+		if oidc.ClientID == "" {
+			log.Fatalf("OIDC_CLIENT_ID is required when OIDC_ISSUER is set")
+		}
+		if oidc.DisplayName == "" {
+			oidc.DisplayName = "Single sign-on"
+		}
+		if oidc.UsernameClaim == "" {
+			oidc.UsernameClaim = "preferred_username"
+		}
+		if oidc.GroupsClaim == "" {
+			oidc.GroupsClaim = "groups"
+		}
+		slog.Info("OIDC single sign-on enabled", "issuer", oidc.Issuer, "auto_provision", oidc.AutoProvision)
+	}
+
+	forwardAuth := ForwardAuthConfig{
+		Header:        http.CanonicalHeaderKey(os.Getenv("FORWARD_AUTH_HEADER")),
+		GroupsHeader:  http.CanonicalHeaderKey(os.Getenv("FORWARD_AUTH_GROUPS_HEADER")),
+		Proxies:       parsePrefixes("FORWARD_AUTH_PROXIES"),
+		AdminGroups:   splitList("FORWARD_AUTH_ADMIN_GROUPS"),
+		AutoProvision: os.Getenv("FORWARD_AUTH_AUTO_PROVISION") == "true",
+	}
+	if forwardAuth.Header != "" {
+		if len(forwardAuth.Proxies) == 0 {
+			forwardAuth.Proxies = trustedProxies
+		}
+		if len(forwardAuth.Proxies) == 0 {
+			log.Fatalf("FORWARD_AUTH_PROXIES or TRUSTED_PROXIES is required when FORWARD_AUTH_HEADER is set")
+		}
+		slog.Info("forward auth enabled", "header", forwardAuth.Header, "proxies", forwardAuth.Proxies, "auto_provision", forwardAuth.AutoProvision)
+	}
+
+	ldap := LDAPConfig{
+		URL:           os.Getenv("LDAP_URL"),
+		StartTLS:      os.Getenv("LDAP_START_TLS") == "true",
+		TLSSkipVerify: os.Getenv("LDAP_TLS_SKIP_VERIFY") == "true",
+		BindDN:        os.Getenv("LDAP_BIND_DN"),
+		BindPassword:  os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:        os.Getenv("LDAP_BASE_DN"),
+		UserFilter:    os.Getenv("LDAP_USER_FILTER"),
+		UsernameAttr:  os.Getenv("LDAP_USERNAME_ATTRIBUTE"),
+		GroupAttr:     os.Getenv("LDAP_GROUP_ATTRIBUTE"),
+		GroupBaseDN:   os.Getenv("LDAP_GROUP_BASE_DN"),
+		GroupFilter:   os.Getenv("LDAP_GROUP_FILTER"),
+		AdminGroups:   splitList("LDAP_ADMIN_GROUPS"),
+		AllowedGroups: splitList("LDAP_ALLOWED_GROUPS"),
+	}
+	if ldap.URL != "" {
+		if ldap.BaseDN == "" {
+			log.Fatalf("LDAP_BASE_DN is required when LDAP_URL is set")
+		}
+		if caFile := os.Getenv("LDAP_CA_FILE"); caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				log.Fatalf("failed to read LDAP_CA_FILE: %v", err)
+			}
+			ldap.RootCAs = x509.NewCertPool()
+			if !ldap.RootCAs.AppendCertsFromPEM(pem) {
+				log.Fatalf("LDAP_CA_FILE contains no PEM certificates")
+			}
+		}
+		if ldap.UsernameAttr == "" {
+			ldap.UsernameAttr = "uid"
+		}
+		if strings.HasPrefix(ldap.URL, "ldap://") && !ldap.StartTLS {
+			slog.Warn("LDAP passwords are sent unencrypted, use ldaps:// or LDAP_START_TLS=true")
+		}
+		if ldap.TLSSkipVerify {
+			slog.Warn("LDAP_TLS_SKIP_VERIFY enabled, the directory's certificate is not verified")
+		}
+		slog.Info("LDAP authentication enabled", "url", ldap.URL, "base_dn", ldap.BaseDN)
+	}
+
+	argon2Memory := parseUint("ARGON2_MEMORY_KIB", 64*1024, 32)
+	argon2Iterations := parseUint("ARGON2_ITERATIONS", 3, 32)
+	argon2Parallelism := parseUint("ARGON2_PARALLELISM", 2, 8)
+
+	masterKeyFile := os.Getenv("MASTER_KEY_FILE")
+	if masterKeyFile == "" {
+		masterKeyFile = filepath.Join(filepath.Dir(databasePath), "master.key")
 	}
 
 	port := os.Getenv("PORT")
@@ -64,10 +260,72 @@ func LoadConfig() *Config {
 	}
 
 	return &Config{
-		JWTSecret:    jwtToken,
-		DatabasePath: os.Getenv("DATABASE_PATH"),
-		JWTExpiry:    time.Duration(jwtExpiry) * time.Second,
-		DevMode:      devMode,
-		Port:         port,
+		JWTSecret:            jwtToken,
+		DatabasePath:         databasePath,
+		DataDir:              filepath.Dir(databasePath),
+		MasterKey:            os.Getenv("MASTER_KEY"),
+		MasterKeyFile:        masterKeyFile,
+		JWTExpiry:            time.Duration(jwtExpiry) * time.Second,
+		ReauthWindow:         time.Duration(reauthWindow) * time.Second,
+		AuditRetention:       time.Duration(auditRetentionDays) * 24 * time.Hour,
+		DeviceTrashRetention: time.Duration(trashRetentionDays) * 24 * time.Hour,
+		DevMode:              devMode,
+
+		AllowedOrigins: allowedOrigins,
+		Port:           port,
+
+		Argon2Memory:      uint32(argon2Memory),
+		Argon2Iterations:  uint32(argon2Iterations),
+		Argon2Parallelism: uint8(argon2Parallelism),
+
+		TrustedProxies: trustedProxies,
+
+		WebAuthnRPID:    os.Getenv("WEBAUTHN_RP_ID"),
+		WebAuthnOrigins: webAuthnOrigins,
+
+		OIDC:        oidc,
+		ForwardAuth: forwardAuth,
+		LDAP:        ldap,
+	}
+}
+
+// splitList reads a comma-separated environment variable, dropping empty entries.
+func splitList(name string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseUint reads an unsigned integer that fits in bits, or returns def when the variable is not set.
+func parseUint(name string, def uint64, bits int) uint64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseUint(v, 10, bits)
+	if err != nil {
+		log.Fatalf("failed to parse %s: %v", name, err)
+	}
+	return n
+}
+
+// parsePrefixes reads a comma-separated list of CIDRs or single addresses.
+func parsePrefixes(name string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, cidr := range splitList(name) {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				log.Fatalf("failed to parse %s entry %q: %v", name, cidr, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
 	}
+	return prefixes
 }
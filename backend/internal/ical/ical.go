@@ -0,0 +1,179 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxYearlyOccurrences = 20 // cap for open-ended yearly rules
+
+// Event is a VEVENT reduced to what calendars need: a summary and the days it covers.
+type Event struct {
+	Summary string
+	Start   time.Time // first day, at midnight UTC
+	End     time.Time // last day (inclusive), at midnight UTC
+}
+
+// Parse reads an iCalendar (.ics) stream and returns its events as whole days.
+// Timed events cover every day they touch. FREQ=YEARLY recurrence rules are expanded;
+// other recurrence rules are ignored and only the first occurrence is returned.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		events  []Event
+		inEvent bool
+		props   map[string]string
+		params  map[string]string
+		sawCal  bool
+	)
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, param, _ := strings.Cut(name, ";")
+		name = strings.ToUpper(name)
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCALENDAR"):
+			sawCal = true
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			inEvent = true
+			props = make(map[string]string)
+			params = make(map[string]string)
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			inEvent = false
+			expanded, err := buildEvent(props, params)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, expanded...)
+		case inEvent:
+			if _, seen := props[name]; !seen {
+				props[name] = value
+				params[name] = strings.ToUpper(param)
+			}
+		}
+	}
+
+	if !sawCal {
+		return nil, errors.New("not an iCalendar file")
+	}
+	return events, nil
+}
+
+// unfold joins continuation lines (lines starting with a space or tab) per RFC 5545.
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func buildEvent(props, params map[string]string) ([]Event, error) {
+	start, allDay, err := parseDate(props["DTSTART"], params["DTSTART"])
+	if err != nil {
+		return nil, fmt.Errorf("DTSTART: %w", err)
+	}
+
+	// DTEND is exclusive. Without it, an all-day event lasts one day and a timed event is instantaneous.
+	end := start
+	if v, ok := props["DTEND"]; ok {
+		e, _, err := parseDate(v, params["DTEND"])
+		if err != nil {
+			return nil, fmt.Errorf("DTEND: %w", err)
+		}
+		end = e
+	}
+	last := day(end)
+	if (allDay || end.Equal(last)) && last.After(day(start)) {
+		last = last.AddDate(0, 0, -1) // exclusive end at midnight does not cover that day
+	}
+
+	summary := unescape(props["SUMMARY"])
+	first := Event{Summary: summary, Start: day(start), End: last}
+
+	rule := props["RRULE"]
+	if !strings.Contains(strings.ToUpper(rule), "FREQ=YEARLY") {
+		return []Event{first}, nil
+	}
+	return expandYearly(first, rule)
+}
+
+func expandYearly(first Event, rule string) ([]Event, error) {
+	interval, count := 1, maxYearlyOccurrences
+	var until time.Time
+	for _, part := range strings.Split(rule, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(k) {
+		case "INTERVAL":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("RRULE: invalid INTERVAL %q", v)
+			}
+			interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("RRULE: invalid COUNT %q", v)
+			}
+			count = min(n, maxYearlyOccurrences)
+		case "UNTIL":
+			u, _, err := parseDate(v, "")
+			if err != nil {
+				return nil, fmt.Errorf("RRULE: invalid UNTIL: %w", err)
+			}
+			until = day(u)
+		}
+	}
+
+	events := make([]Event, 0, count)
+	for i := 0; i < count; i++ {
+		e := Event{
+			Summary: first.Summary,
+			Start:   first.Start.AddDate(i*interval, 0, 0),
+			End:     first.End.AddDate(i*interval, 0, 0),
+		}
+		if !until.IsZero() && e.Start.After(until) {
+			break
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// parseDate parses DATE (20250101) and DATE-TIME (20250101T090000[Z]) values.
+// Times with a TZID parameter or floating times are taken at face value, only the date matters.
+func parseDate(value, param string) (time.Time, bool, error) {
+	if strings.Contains(param, "VALUE=DATE") && !strings.Contains(param, "VALUE=DATE-TIME") || len(value) == 8 {
+		t, err := time.Parse("20060102", value)
+		return t, true, err
+	}
+	t, err := time.Parse("20060102T150405", strings.TrimSuffix(value, "Z"))
+	return t, false, err
+}
+
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func unescape(s string) string {
+	return strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\N`, " ", `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,61 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const fixture = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Test//Holidays//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20251225\r\n" +
+	"DTEND;VALUE=DATE:20251226\r\n" +
+	"SUMMARY:Christmas Day\r\n" +
+	"RRULE:FREQ=YEARLY;COUNT=2\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20251227\r\n" +
+	"DTEND;VALUE=DATE:20260103\r\n" +
+	"SUMMARY:Office shutdown\\, all\r\n" +
+	"  sites\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART:20260310T220000Z\r\n" +
+	"DTEND:20260311T020000Z\r\n" +
+	"SUMMARY:Maintenance\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	events, err := Parse(strings.NewReader(fixture))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	want := []Event{
+		{Summary: "Christmas Day", Start: date(2025, 12, 25), End: date(2025, 12, 25)},
+		{Summary: "Christmas Day", Start: date(2026, 12, 25), End: date(2026, 12, 25)},
+		{Summary: "Office shutdown, all sites", Start: date(2025, 12, 27), End: date(2026, 1, 2)},
+		{Summary: "Maintenance", Start: date(2026, 3, 10), End: date(2026, 3, 11)},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %d: %+v", len(want), len(events), events)
+	}
+	for i := range want {
+		if events[i].Summary != want[i].Summary || !events[i].Start.Equal(want[i].Start) || !events[i].End.Equal(want[i].End) {
+			t.Errorf("event %d: expected %+v, got %+v", i, want[i], events[i])
+		}
+	}
+}
+
+func TestParseRejectsNonCalendar(t *testing.T) {
+	if _, err := Parse(strings.NewReader("hello world")); err == nil {
+		t.Error("expected error for non-calendar input")
+	}
+}
@@ -0,0 +1,116 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+const (
+	dataKeySize  = 32 // AES-256
+	sealedPrefix = "enc:v1:"
+	dataKeyAAD   = "wolite data key"
+)
+
+var errSealedValue = errors.New("sealed value cannot be decrypted")
+
+// Envelope encrypts secret values with a random data key. Only the data key is encrypted with the
+// master key, so rotating the master key rewraps one key instead of every value.
+type Envelope struct {
+	aead    cipher.AEAD
+	dataKey []byte
+}
+
+// NewEnvelope returns an envelope with a fresh data key.
+func NewEnvelope() (*Envelope, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	return newEnvelope(dataKey)
+}
+
+// UnwrapEnvelope decrypts a data key wrapped by Wrap. It returns ErrWrongKey if the master key does not fit.
+func UnwrapEnvelope(wrapped, master []byte) (*Envelope, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := aead.NonceSize()
+	if len(wrapped) < nonceSize {
+		return nil, errors.New("wrapped data key is truncated")
+	}
+	dataKey, err := aead.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], []byte(dataKeyAAD))
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return newEnvelope(dataKey)
+}
+
+func newEnvelope(dataKey []byte) (*Envelope, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{aead: aead, dataKey: dataKey}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Wrap returns the data key encrypted with the master key: a nonce followed by the AES-GCM ciphertext.
+func (e *Envelope) Wrap(master []byte) ([]byte, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, e.dataKey, []byte(dataKeyAAD)), nil
+}
+
+// Seal encrypts a value. The context, e.g. the field and the record it belongs to, is authenticated
+// with it, so a sealed value copied to another record does not decrypt. Empty values stay empty.
+func (e *Envelope) Seal(plaintext, context string) string {
+	if plaintext == "" {
+		return ""
+	}
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	sealed := e.aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// Open decrypts a value sealed with the same context.
+func (e *Envelope) Open(value, context string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil || !IsSealed(value) || len(sealed) < e.aead.NonceSize() {
+		return "", errSealedValue
+	}
+	nonceSize := e.aead.NonceSize()
+	plaintext, err := e.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(context))
+	if err != nil {
+		return "", errSealedValue
+	}
+	return string(plaintext), nil
+}
+
+// IsSealed reports whether a value was produced by Seal.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
@@ -0,0 +1,333 @@
+package keyring
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	signingKeySize = 32 // HS256
+	keyIDSize      = 8
+	fileVersion    = 1
+	staticKeyID    = "static"
+)
+
+var (
+	ErrStatic   = errors.New("signing key is set by JWT_SECRET and cannot be rotated")
+	ErrWrongKey = errors.New("data cannot be decrypted with this master key")
+	errNoActive = errors.New("keyring has no active key")
+)
+
+// KeyInfo describes a signing key without its secret.
+type KeyInfo struct {
+	ID        string     `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"` // no longer signs, still verifies until tokens expire
+	Active    bool       `json:"active"`
+}
+
+type key struct {
+	KeyInfo
+	secret []byte
+}
+
+// storedKey is the on-disk form of a key, its secret sealed with the master key.
+type storedKey struct {
+	ID        string     `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+	Secret    []byte     `json:"secret"` // nonce followed by AES-GCM ciphertext, the key ID is the additional data
+}
+
+// Keyring holds the JWT signing keys. The newest key signs; older keys keep verifying the tokens they signed
+// until those expire. Keys are persisted encrypted with the master key.
+type Keyring struct {
+	mu      sync.RWMutex
+	path    string
+	aead    cipher.AEAD
+	keys    []key // oldest first, the last one is active
+	modTime time.Time
+	static  []byte
+}
+
+// Static returns a keyring with a single fixed secret, as configured by JWT_SECRET. It cannot rotate.
+func Static(secret []byte) *Keyring {
+	return &Keyring{static: secret}
+}
+
+// Open loads the keyring at path, creating it with a fresh key if it does not exist.
+func Open(path string, master []byte) (*Keyring, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Keyring{path: path, aead: aead}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		k.keys = []key{}
+		if err := k.addKeyLocked(time.Now()); err != nil {
+			return nil, err
+		}
+		return k, k.saveLocked()
+	}
+	if err := k.loadLocked(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// IsStatic reports whether the keyring uses a fixed JWT_SECRET.
+func (k *Keyring) IsStatic() bool {
+	return k.static != nil
+}
+
+// Active returns the ID and secret of the key that signs new tokens.
+func (k *Keyring) Active() (string, []byte, error) {
+	if k.static != nil {
+		return staticKeyID, k.static, nil
+	}
+	k.reloadIfChanged()
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.keys) == 0 {
+		return "", nil, errNoActive
+	}
+	active := k.keys[len(k.keys)-1]
+	return active.ID, active.secret, nil
+}
+
+// Lookup returns the secret of a key by ID, for verifying tokens.
+func (k *Keyring) Lookup(id string) ([]byte, bool) {
+	if k.static != nil {
+		return k.static, true
+	}
+	// A key rotated by the CLI is only on disk
+	k.reloadIfChanged()
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key.secret, true
+		}
+	}
+	return nil, false
+}
+
+// Keys lists the keys in the keyring, oldest first.
+func (k *Keyring) Keys() []KeyInfo {
+	if k.static != nil {
+		return []KeyInfo{{ID: staticKeyID, Active: true}}
+	}
+	k.reloadIfChanged()
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	infos := make([]KeyInfo, len(k.keys))
+	for i, key := range k.keys {
+		infos[i] = key.KeyInfo
+	}
+	return infos
+}
+
+// Rotate retires the active key and adds a new one. Retired keys are dropped once they have been
+// retired for longer than retain, which should be the longest token lifetime. It returns the new key ID.
+func (k *Keyring) Rotate(retain time.Duration) (string, error) {
+	if k.static != nil {
+		return "", ErrStatic
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// Start from the file so a rotation by another process is not lost
+	if err := k.loadLocked(); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	kept := k.keys[:0]
+	for _, key := range k.keys {
+		if key.RetiredAt == nil {
+			key.RetiredAt = &now
+			key.Active = false
+		}
+		if now.Sub(*key.RetiredAt) <= retain {
+			kept = append(kept, key)
+		}
+	}
+	k.keys = kept
+	if err := k.addKeyLocked(now); err != nil {
+		return "", err
+	}
+	if err := k.saveLocked(); err != nil {
+		return "", err
+	}
+	return k.keys[len(k.keys)-1].ID, nil
+}
+
+// Rekey encrypts the keyring with a new master key, for a master key rotation.
+func (k *Keyring) Rekey(master []byte) error {
+	if k.static != nil {
+		return nil
+	}
+	aead, err := newAEAD(master)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.loadLocked(); err != nil {
+		return err
+	}
+	old := k.aead
+	k.aead = aead
+	if err := k.saveLocked(); err != nil {
+		k.aead = old
+		return err
+	}
+	return nil
+}
+
+func (k *Keyring) addKeyLocked(now time.Time) error {
+	secret := make([]byte, signingKeySize)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	id := make([]byte, keyIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	k.keys = append(k.keys, key{
+		KeyInfo: KeyInfo{ID: hex.EncodeToString(id), CreatedAt: now, Active: true},
+		secret:  secret,
+	})
+	return nil
+}
+
+func (k *Keyring) reloadIfChanged() {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return
+	}
+
+	k.mu.RLock()
+	unchanged := info.ModTime().Equal(k.modTime)
+	k.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	// On error keep the keys already loaded, the file is only ever replaced atomically
+	k.loadLocked()
+}
+
+func (k *Keyring) loadLocked() error {
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+
+	var file struct {
+		Version int         `json:"version"`
+		Keys    []storedKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid keyring file: %w", err)
+	}
+	if file.Version != fileVersion {
+		return fmt.Errorf("unsupported keyring version: %d", file.Version)
+	}
+
+	keys := make([]key, 0, len(file.Keys))
+	for i, sk := range file.Keys {
+		nonceSize := k.aead.NonceSize()
+		if len(sk.Secret) < nonceSize {
+			return fmt.Errorf("invalid keyring file: key %s is truncated", sk.ID)
+		}
+		secret, err := k.aead.Open(nil, sk.Secret[:nonceSize], sk.Secret[nonceSize:], []byte(sk.ID))
+		if err != nil {
+			return ErrWrongKey
+		}
+		keys = append(keys, key{
+			KeyInfo: KeyInfo{ID: sk.ID, CreatedAt: sk.CreatedAt, RetiredAt: sk.RetiredAt, Active: i == len(file.Keys)-1},
+			secret:  secret,
+		})
+	}
+	if len(keys) == 0 {
+		return errNoActive
+	}
+
+	k.keys = keys
+	k.modTime = info.ModTime()
+	return nil
+}
+
+// saveLocked writes the keyring atomically with owner-only permissions.
+func (k *Keyring) saveLocked() error {
+	file := struct {
+		Version int         `json:"version"`
+		Keys    []storedKey `json:"keys"`
+	}{Version: fileVersion, Keys: make([]storedKey, 0, len(k.keys))}
+
+	for _, key := range k.keys {
+		nonce := make([]byte, k.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		file.Keys = append(file.Keys, storedKey{
+			ID:        key.ID,
+			CreatedAt: key.CreatedAt,
+			RetiredAt: key.RetiredAt,
+			Secret:    k.aead.Seal(nonce, nonce, key.secret, []byte(key.ID)),
+		})
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(k.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(k.path), "keyring-tmp-*.json")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), k.path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	k.modTime = info.ModTime()
+	return nil
+}
@@ -0,0 +1,154 @@
+package keyring
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyringRotate(t *testing.T) {
+	dir := t.TempDir()
+	master, err := LoadMasterKey("", filepath.Join(dir, "master.key"))
+	if err != nil {
+		t.Fatalf("failed to create master key: %v", err)
+	}
+
+	path := filepath.Join(dir, "jwt_keys.json")
+	k, err := Open(path, master)
+	if err != nil {
+		t.Fatalf("failed to open keyring: %v", err)
+	}
+	oldID, oldSecret, err := k.Active()
+	if err != nil {
+		t.Fatalf("no active key: %v", err)
+	}
+
+	newID, err := k.Rotate(time.Hour)
+	if err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	if newID == oldID {
+		t.Fatal("rotation kept the same key ID")
+	}
+	if id, _, _ := k.Active(); id != newID {
+		t.Errorf("expected active key %s, got %s", newID, id)
+	}
+
+	// The previous key still verifies, also after reopening
+	reopened, err := Open(path, master)
+	if err != nil {
+		t.Fatalf("failed to reopen keyring: %v", err)
+	}
+	secret, ok := reopened.Lookup(oldID)
+	if !ok || !bytes.Equal(secret, oldSecret) {
+		t.Error("previous key lost after rotation")
+	}
+
+	// Without retention the retired key is dropped on the next rotation
+	if _, err := reopened.Rotate(0); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	if _, ok := reopened.Lookup(oldID); ok {
+		t.Error("expired key kept after rotation")
+	}
+	if keys := reopened.Keys(); len(keys) != 2 || keys[0].ID != newID || !keys[1].Active {
+		t.Errorf("expected the just retired key and a new active key, got %+v", keys)
+	}
+}
+
+func TestKeyringWrongMasterKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "jwt_keys.json")
+	master, _ := LoadMasterKey("", filepath.Join(dir, "a.key"))
+	if _, err := Open(path, master); err != nil {
+		t.Fatalf("failed to open keyring: %v", err)
+	}
+
+	other, _ := LoadMasterKey("", filepath.Join(dir, "b.key"))
+	if _, err := Open(path, other); err != ErrWrongKey {
+		t.Errorf("expected ErrWrongKey, got %v", err)
+	}
+}
+
+func TestStaticKeyring(t *testing.T) {
+	k := Static([]byte("secret"))
+	if _, err := k.Rotate(time.Hour); err != ErrStatic {
+		t.Errorf("expected ErrStatic, got %v", err)
+	}
+	if _, ok := k.Lookup("anything"); !ok {
+		t.Error("static keyring must verify any key ID")
+	}
+}
+
+func TestLoadMasterKeyFromEnv(t *testing.T) {
+	encoded, err := GenerateMasterKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	if _, err := LoadMasterKey(encoded, ""); err != nil {
+		t.Errorf("valid key rejected: %v", err)
+	}
+	if _, err := LoadMasterKey("dG9vIHNob3J0", ""); err == nil {
+		t.Error("short key accepted")
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	dir := t.TempDir()
+	master, _ := LoadMasterKey("", filepath.Join(dir, "a.key"))
+	other, _ := LoadMasterKey("", filepath.Join(dir, "b.key"))
+
+	e, err := NewEnvelope()
+	if err != nil {
+		t.Fatalf("failed to create envelope: %v", err)
+	}
+	sealed := e.Seal("secret", "device:aa")
+	if !IsSealed(sealed) || sealed == e.Seal("secret", "device:aa") {
+		t.Fatalf("expected a sealed value with a fresh nonce, got %q", sealed)
+	}
+	if _, err := e.Open(sealed, "device:bb"); err == nil {
+		t.Error("sealed value opened in another context")
+	}
+
+	wrapped, err := e.Wrap(master)
+	if err != nil {
+		t.Fatalf("failed to wrap data key: %v", err)
+	}
+	if _, err := UnwrapEnvelope(wrapped, other); err != ErrWrongKey {
+		t.Errorf("expected ErrWrongKey, got %v", err)
+	}
+	unwrapped, err := UnwrapEnvelope(wrapped, master)
+	if err != nil {
+		t.Fatalf("failed to unwrap data key: %v", err)
+	}
+	if plaintext, err := unwrapped.Open(sealed, "device:aa"); err != nil || plaintext != "secret" {
+		t.Errorf("expected the sealed secret, got %q, %v", plaintext, err)
+	}
+}
+
+func TestKeyringRekey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "jwt_keys.json")
+	master, _ := LoadMasterKey("", filepath.Join(dir, "a.key"))
+	k, err := Open(path, master)
+	if err != nil {
+		t.Fatalf("failed to open keyring: %v", err)
+	}
+	id, _, _ := k.Active()
+
+	other, _ := LoadMasterKey("", filepath.Join(dir, "b.key"))
+	if err := k.Rekey(other); err != nil {
+		t.Fatalf("rekey failed: %v", err)
+	}
+	if _, err := Open(path, master); err != ErrWrongKey {
+		t.Errorf("expected the old master key to be rejected, got %v", err)
+	}
+	reopened, err := Open(path, other)
+	if err != nil {
+		t.Fatalf("failed to reopen keyring: %v", err)
+	}
+	if _, ok := reopened.Lookup(id); !ok {
+		t.Error("key lost after rekey")
+	}
+}
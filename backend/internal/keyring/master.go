@@ -0,0 +1,116 @@
+package keyring
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const masterKeySize = 32 // AES-256
+
+// ErrNoMasterKey is returned by ReadMasterKey when neither MASTER_KEY nor the key file is set.
+var ErrNoMasterKey = errors.New("no master key: set MASTER_KEY or provide the master key file (MASTER_KEY_FILE)")
+
+// LoadMasterKey returns the master key that encrypts data at rest. The key is taken from encoded
+// (the MASTER_KEY environment variable, base64) when set, otherwise from the key file at path,
+// which is generated on first start.
+func LoadMasterKey(encoded, path string) ([]byte, error) {
+	key, err := ReadMasterKey(encoded, path)
+	if !errors.Is(err, ErrNoMasterKey) {
+		return key, err
+	}
+
+	key = make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// O_EXCL: never overwrite a key another process just created
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ReadMasterKey is LoadMasterKey without generating a key. It returns ErrNoMasterKey when there is none.
+func ReadMasterKey(encoded, path string) ([]byte, error) {
+	if encoded != "" {
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("MASTER_KEY: %w", err)
+		}
+		return key, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := decodeKey(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return key, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoMasterKey
+	}
+	return nil, err
+}
+
+// GenerateMasterKey returns a new random master key, base64 encoded for MASTER_KEY.
+func GenerateMasterKey() (string, error) {
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func decodeKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.New("master key must be base64 encoded")
+	}
+	if len(key) != masterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", masterKeySize, len(key))
+	}
+	return key, nil
+}
+
+// WriteMasterKey replaces the key file at path with a base64 encoded key. The file is replaced atomically,
+// so a crash leaves either the old or the new key.
+func WriteMasterKey(path, encoded string) error {
+	if _, err := decodeKey(encoded); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "master-tmp-*.key")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+	if _, err := tmp.WriteString(strings.TrimSpace(encoded) + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
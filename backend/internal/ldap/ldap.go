@@ -0,0 +1,398 @@
+// Package ldap authenticates users against an LDAP directory or Active Directory with search-then-bind:
+// a service account looks the user up, then the user's DN is bound with the given password.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Protocol operation tags (RFC 4511 4.2 - 4.12)
+const (
+	opBindRequest       = classApplication | constructed | 0
+	opBindResponse      = classApplication | constructed | 1
+	opUnbindRequest     = classApplication | 2
+	opSearchRequest     = classApplication | constructed | 3
+	opSearchResultEntry = classApplication | constructed | 4
+	opSearchResultDone  = classApplication | constructed | 5
+	opSearchResultRef   = classApplication | constructed | 19
+	opExtendedRequest   = classApplication | constructed | 23
+	opExtendedResponse  = classApplication | constructed | 24
+)
+
+const (
+	oidStartTLS = "1.3.6.1.4.1.1466.20037"
+
+	scopeWholeSubtree = 2
+	derefNever        = 0
+
+	resultSuccess            = 0
+	resultInvalidCredentials = 49
+)
+
+var (
+	ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+	ErrUserNotFound       = errors.New("ldap: user not found")
+	ErrAmbiguousUser      = errors.New("ldap: filter matched more than one user")
+)
+
+// ResultError is a non-success result returned by the server.
+type ResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// Config describes the directory and how users and their groups are found in it.
+type Config struct {
+	URL          string // ldap://host:389 or ldaps://host:636
+	StartTLS     bool   // upgrade ldap:// connections before binding
+	TLSConfig    *tls.Config
+	BindDN       string // service account for the user search, anonymous when empty
+	BindPassword string
+	BaseDN       string
+	UserFilter   string // {username} is replaced by the escaped login name
+	Attributes   []string
+	// Groups are read from GroupAttribute on the user entry (memberOf) and, when GroupFilter is set,
+	// searched under GroupBaseDN with {dn} and {username} replaced.
+	GroupAttribute string
+	GroupBaseDN    string
+	GroupFilter    string
+	Timeout        time.Duration
+}
+
+// Entry is a directory object with its attribute values.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string // lower-case attribute names
+}
+
+// Get returns the first value of an attribute.
+func (e *Entry) Get(name string) string {
+	if v := e.Attributes[strings.ToLower(name)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// User is an authenticated directory user.
+type User struct {
+	Entry
+	Groups []string // group DNs and their common names, so either can be configured
+}
+
+type Directory struct {
+	cfg Config
+}
+
+func New(cfg Config) *Directory {
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(uid={username})"
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "memberOf"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &Directory{cfg: cfg}
+}
+
+// Authenticate looks the user up with the service account and binds as them with the password.
+func (d *Directory) Authenticate(ctx context.Context, username, password string) (*User, error) {
+	// An empty password is an unauthenticated bind, which servers accept without checking anything
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	c, err := d.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	if d.cfg.BindDN != "" {
+		if err := c.bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap: service account bind: %w", err)
+		}
+	}
+
+	filter := strings.ReplaceAll(d.cfg.UserFilter, "{username}", EscapeFilter(username))
+	attrs := append([]string{d.cfg.GroupAttribute}, d.cfg.Attributes...)
+	entries, err := c.search(d.cfg.BaseDN, filter, attrs, 2)
+	if err != nil {
+		return nil, err
+	}
+	switch len(entries) {
+	case 0:
+		return nil, ErrUserNotFound
+	case 1:
+	default:
+		return nil, ErrAmbiguousUser
+	}
+	user := &User{Entry: entries[0]}
+
+	if err := c.bind(user.DN, password); err != nil {
+		return nil, err
+	}
+
+	groups := user.Attributes[strings.ToLower(d.cfg.GroupAttribute)]
+	if d.cfg.GroupFilter != "" {
+		// Users often cannot read groups, so search as the service account again
+		if d.cfg.BindDN != "" {
+			if err := c.bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+				return nil, fmt.Errorf("ldap: service account bind: %w", err)
+			}
+		}
+		filter := strings.NewReplacer("{dn}", EscapeFilter(user.DN), "{username}", EscapeFilter(username)).Replace(d.cfg.GroupFilter)
+		base := d.cfg.GroupBaseDN
+		if base == "" {
+			base = d.cfg.BaseDN
+		}
+		found, err := c.search(base, filter, []string{"cn"}, 0)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: group search: %w", err)
+		}
+		for _, g := range found {
+			groups = append(groups, g.DN)
+		}
+	}
+	for _, g := range groups {
+		user.Groups = append(user.Groups, g)
+		if cn := commonName(g); cn != "" {
+			user.Groups = append(user.Groups, cn)
+		}
+	}
+	return user, nil
+}
+
+// Bind checks a password by binding as a known DN.
+func (d *Directory) Bind(ctx context.Context, dn, password string) error {
+	if dn == "" || password == "" {
+		return ErrInvalidCredentials
+	}
+	c, err := d.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	return c.bind(dn, password)
+}
+
+// commonName returns the value of a DN's leading CN, e.g. admins for cn=admins,ou=groups,dc=example,dc=org.
+func commonName(dn string) string {
+	first, _, _ := strings.Cut(dn, ",")
+	name, value, ok := strings.Cut(first, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(name), "cn") {
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
+
+type conn struct {
+	net.Conn
+	r     *bufio.Reader
+	msgID int64
+}
+
+func (d *Directory) dial(ctx context.Context) (*conn, error) {
+	u, err := url.Parse(d.cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid url: %w", err)
+	}
+	tlsConfig := d.cfg.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	dialer := &net.Dialer{Timeout: d.cfg.Timeout}
+	var nc net.Conn
+	switch u.Scheme {
+	case "ldap":
+		nc, err = dialer.DialContext(ctx, "tcp", hostPort(u, "389"))
+	case "ldaps":
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", hostPort(u, "636"))
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap: connect: %w", err)
+	}
+
+	// One deadline for the whole exchange, so a stalled server cannot hold a login forever
+	deadline := time.Now().Add(d.cfg.Timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	nc.SetDeadline(deadline)
+
+	c := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if u.Scheme == "ldap" && d.cfg.StartTLS {
+		if err := c.startTLS(tlsConfig); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+func (c *conn) close() {
+	c.send(newPrimitive(opUnbindRequest, nil))
+	c.Close()
+}
+
+func (c *conn) send(op *packet) (int64, error) {
+	c.msgID++
+	msg := newConstructed(tagSequence, newInt(tagInteger, c.msgID), op)
+	_, err := c.Write(msg.bytes())
+	return c.msgID, err
+}
+
+// receive reads the next response to the message and returns its protocol operation.
+func (c *conn) receive(id int64) (*packet, error) {
+	for {
+		msg, err := readPacket(c.r)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: read response: %w", err)
+		}
+		if msg.tag != tagSequence || len(msg.children) < 2 {
+			return nil, errMalformed
+		}
+		// Unsolicited notifications (ID 0) mean the server is closing the connection
+		if msgID := msg.child(0).int(); msgID == 0 {
+			return nil, fmt.Errorf("ldap: server closed the connection: %w", resultError(msg.child(1)))
+		} else if msgID != id {
+			continue
+		}
+		return msg.child(1), nil
+	}
+}
+
+func resultError(op *packet) error {
+	code := op.child(0).int()
+	if code == resultSuccess {
+		return nil
+	}
+	if code == resultInvalidCredentials {
+		return ErrInvalidCredentials
+	}
+	return &ResultError{Code: code, Message: op.child(2).str()}
+}
+
+func (c *conn) startTLS(cfg *tls.Config) error {
+	id, err := c.send(newConstructed(opExtendedRequest, newString(classContext|0, oidStartTLS)))
+	if err != nil {
+		return fmt.Errorf("ldap: starttls: %w", err)
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != opExtendedResponse {
+		return fmt.Errorf("ldap: starttls: unexpected response")
+	}
+	if err := resultError(op); err != nil {
+		return fmt.Errorf("ldap: starttls: %w", err)
+	}
+
+	tc := tls.Client(c.Conn, cfg)
+	if err := tc.Handshake(); err != nil {
+		return fmt.Errorf("ldap: starttls: %w", err)
+	}
+	c.Conn, c.r = tc, bufio.NewReader(tc)
+	return nil
+}
+
+func (c *conn) bind(dn, password string) error {
+	id, err := c.send(newConstructed(opBindRequest,
+		newInt(tagInteger, 3),
+		newString(tagOctetString, dn),
+		newString(classContext|0, password),
+	))
+	if err != nil {
+		return fmt.Errorf("ldap: bind: %w", err)
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != opBindResponse {
+		return fmt.Errorf("ldap: bind: unexpected response")
+	}
+	return resultError(op)
+}
+
+// search returns the entries matching the filter under base. A sizeLimit of 0 means no limit.
+func (c *conn) search(base, filter string, attributes []string, sizeLimit int64) ([]Entry, error) {
+	f, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	attrs := newConstructed(tagSequence)
+	for _, a := range attributes {
+		attrs.append(newString(tagOctetString, a))
+	}
+	id, err := c.send(newConstructed(opSearchRequest,
+		newString(tagOctetString, base),
+		newInt(tagEnumerated, scopeWholeSubtree),
+		newInt(tagEnumerated, derefNever),
+		newInt(tagInteger, sizeLimit),
+		newInt(tagInteger, 0),
+		newBool(tagBoolean, false),
+		f,
+		attrs,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ldap: search: %w", err)
+	}
+
+	var entries []Entry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case opSearchResultEntry:
+			entry := Entry{DN: op.child(0).str(), Attributes: map[string][]string{}}
+			for _, attr := range op.child(1).children {
+				name := strings.ToLower(attr.child(0).str())
+				for _, v := range attr.child(1).children {
+					entry.Attributes[name] = append(entry.Attributes[name], v.str())
+				}
+			}
+			entries = append(entries, entry)
+		case opSearchResultRef:
+			// Referrals to other servers are not followed
+		case opSearchResultDone:
+			// Hitting the size limit still returns the entries, which is enough to detect ambiguity
+			if err := resultError(op); err != nil && !(sizeLimit > 0 && len(entries) >= int(sizeLimit)) {
+				return nil, fmt.Errorf("ldap: search: %w", err)
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("ldap: search: unexpected response")
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testServer is an in-process LDAP stand-in that answers binds, searches and StartTLS from a fixed tree.
+type testServer struct {
+	t         *testing.T
+	ln        net.Listener
+	entries   []Entry
+	passwords map[string]string // DN -> password
+	tls       *tls.Config
+	startTLS  bool // offer StartTLS and refuse binds before it
+}
+
+func newTestServer(t *testing.T, useTLS bool) *testServer {
+	t.Helper()
+	s := &testServer{
+		t: t,
+		entries: []Entry{
+			{DN: "uid=alice,ou=people,dc=example,dc=org", Attributes: map[string][]string{
+				"uid": {"alice"}, "cn": {"Alice"}, "mail": {"alice@example.org"}, "objectclass": {"person"},
+				"memberof": {"cn=wolite-admins,ou=groups,dc=example,dc=org", "cn=staff,ou=groups,dc=example,dc=org"},
+			}},
+			{DN: "uid=bob,ou=people,dc=example,dc=org", Attributes: map[string][]string{
+				"uid": {"bob"}, "cn": {"Bob"}, "objectclass": {"person"},
+			}},
+			{DN: "uid=twin,ou=a,dc=example,dc=org", Attributes: map[string][]string{"uid": {"twin"}, "objectclass": {"person"}}},
+			{DN: "uid=twin,ou=b,dc=example,dc=org", Attributes: map[string][]string{"uid": {"twin"}, "objectclass": {"person"}}},
+			{DN: "cn=operators,ou=groups,dc=example,dc=org", Attributes: map[string][]string{
+				"cn": {"operators"}, "objectclass": {"groupOfNames"}, "member": {"uid=bob,ou=people,dc=example,dc=org"},
+			}},
+		},
+		passwords: map[string]string{
+			"cn=service,dc=example,dc=org":          "service-secret",
+			"uid=alice,ou=people,dc=example,dc=org": "alice-secret",
+			"uid=bob,ou=people,dc=example,dc=org":   "bob-secret",
+		},
+		tls: testTLSConfig(t),
+	}
+
+	var err error
+	if useTLS {
+		s.ln, err = tls.Listen("tcp", "127.0.0.1:0", s.tls)
+	} else {
+		s.ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { s.ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *testServer) url(scheme string) string {
+	return scheme + "://" + s.ln.Addr().String()
+}
+
+func (s *testServer) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(nc)
+	}
+}
+
+func (s *testServer) handle(nc net.Conn) {
+	defer func() { nc.Close() }()
+	r := bufio.NewReader(nc)
+	secure := false
+	if _, ok := nc.(*tls.Conn); ok {
+		secure = true
+	}
+	bound := ""
+
+	reply := func(id int64, op *packet) {
+		nc.Write(newConstructed(tagSequence, newInt(tagInteger, id), op).bytes())
+	}
+	result := func(tag byte, code int64, msg string) *packet {
+		return newConstructed(tag, newInt(tagEnumerated, code), newString(tagOctetString, ""), newString(tagOctetString, msg))
+	}
+
+	for {
+		msg, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		id, op := msg.child(0).int(), msg.child(1)
+		switch op.tag {
+		case opBindRequest:
+			dn, password := op.child(1).str(), op.child(2).str()
+			switch want, ok := s.passwords[dn]; {
+			case s.startTLS && !secure:
+				reply(id, result(opBindResponse, 13, "confidentiality required"))
+			case password == "":
+				bound = "" // anonymous
+				reply(id, result(opBindResponse, resultSuccess, ""))
+			case !ok || want != password:
+				reply(id, result(opBindResponse, resultInvalidCredentials, "invalid credentials"))
+			default:
+				bound = dn
+				reply(id, result(opBindResponse, resultSuccess, ""))
+			}
+		case opSearchRequest:
+			if bound == "" {
+				reply(id, result(opSearchResultDone, 50, "anonymous search not allowed"))
+				continue
+			}
+			base, sizeLimit, filter := strings.ToLower(op.child(0).str()), op.child(3).int(), op.child(6)
+			var attrs []string
+			for _, a := range op.child(7).children {
+				attrs = append(attrs, strings.ToLower(a.str()))
+			}
+			sent := int64(0)
+			code := int64(resultSuccess)
+			for _, e := range s.entries {
+				if !strings.HasSuffix(strings.ToLower(e.DN), base) || !matches(filter, e) {
+					continue
+				}
+				if sizeLimit > 0 && sent == sizeLimit {
+					code = 4 // sizeLimitExceeded
+					break
+				}
+				list := newConstructed(tagSequence)
+				for name, values := range e.Attributes {
+					if !slices.Contains(attrs, name) {
+						continue
+					}
+					set := newConstructed(tagSet)
+					for _, v := range values {
+						set.append(newString(tagOctetString, v))
+					}
+					list.append(newConstructed(tagSequence, newString(tagOctetString, name), set))
+				}
+				reply(id, newConstructed(opSearchResultEntry, newString(tagOctetString, e.DN), list))
+				sent++
+			}
+			reply(id, result(opSearchResultDone, code, ""))
+		case opExtendedRequest:
+			if !s.startTLS || op.child(0).str() != oidStartTLS {
+				reply(id, result(opExtendedResponse, 2, "unsupported"))
+				continue
+			}
+			reply(id, result(opExtendedResponse, resultSuccess, ""))
+			tc := tls.Server(nc, s.tls)
+			if err := tc.Handshake(); err != nil {
+				return
+			}
+			nc, r, secure = tc, bufio.NewReader(tc), true
+		case opUnbindRequest:
+			return
+		}
+	}
+}
+
+// matches evaluates the subset of filters the tests use.
+func matches(f *packet, e Entry) bool {
+	values := func() []string { return e.Attributes[strings.ToLower(f.child(0).str())] }
+	switch f.tag {
+	case filterAnd:
+		for _, c := range f.children {
+			if !matches(c, e) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		return slices.ContainsFunc(f.children, func(c *packet) bool { return matches(c, e) })
+	case filterNot:
+		return !matches(f.child(0), e)
+	case filterPresent:
+		return len(e.Attributes[strings.ToLower(f.str())]) > 0
+	case filterEqualityMatch:
+		return slices.ContainsFunc(values(), func(v string) bool { return strings.EqualFold(v, f.child(1).str()) })
+	case filterSubstrings:
+		return slices.ContainsFunc(values(), func(v string) bool {
+			v = strings.ToLower(v)
+			for _, sub := range f.child(1).children {
+				s := strings.ToLower(sub.str())
+				switch sub.tag {
+				case substringInitial:
+					if !strings.HasPrefix(v, s) {
+						return false
+					}
+					v = v[len(s):]
+				case substringAny:
+					i := strings.Index(v, s)
+					if i < 0 {
+						return false
+					}
+					v = v[i+len(s):]
+				case substringFinal:
+					if !strings.HasSuffix(v, s) {
+						return false
+					}
+				}
+			}
+			return true
+		})
+	}
+	return false
+}
+
+var testCA *x509.CertPool
+
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldap.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	testCA = x509.NewCertPool()
+	testCA.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func testConfig(s *testServer, scheme string) Config {
+	return Config{
+		URL:          s.url(scheme),
+		TLSConfig:    &tls.Config{RootCAs: testCA},
+		BindDN:       "cn=service,dc=example,dc=org",
+		BindPassword: "service-secret",
+		BaseDN:       "dc=example,dc=org",
+		UserFilter:   "(&(objectClass=person)(uid={username}))",
+		Attributes:   []string{"mail"},
+		Timeout:      5 * time.Second,
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newTestServer(t, false)
+	d := New(testConfig(s, "ldap"))
+	ctx := context.Background()
+
+	user, err := d.Authenticate(ctx, "alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if user.DN != "uid=alice,ou=people,dc=example,dc=org" || user.Get("mail") != "alice@example.org" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	for _, g := range []string{"cn=wolite-admins,ou=groups,dc=example,dc=org", "wolite-admins", "staff"} {
+		if !slices.Contains(user.Groups, g) {
+			t.Errorf("expected group %q in %v", g, user.Groups)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     error
+	}{
+		{"wrong password", "alice", "wrong", ErrInvalidCredentials},
+		{"empty password", "alice", "", ErrInvalidCredentials},
+		{"unknown user", "mallory", "secret", ErrUserNotFound},
+		{"wildcard is escaped", "*", "alice-secret", ErrUserNotFound},
+		{"filter injection is escaped", "alice)(uid=*", "alice-secret", ErrUserNotFound},
+		{"ambiguous user", "twin", "secret", ErrAmbiguousUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := d.Authenticate(ctx, tt.username, tt.password); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateServiceAccount(t *testing.T) {
+	s := newTestServer(t, false)
+	cfg := testConfig(s, "ldap")
+	cfg.BindPassword = "wrong"
+	if _, err := New(cfg).Authenticate(context.Background(), "alice", "alice-secret"); err == nil || !strings.Contains(err.Error(), "service account") {
+		t.Errorf("expected service account bind error, got %v", err)
+	}
+
+	// The stand-in refuses anonymous searches
+	cfg.BindDN, cfg.BindPassword = "", ""
+	var resultErr *ResultError
+	if _, err := New(cfg).Authenticate(context.Background(), "alice", "alice-secret"); !errors.As(err, &resultErr) || resultErr.Code != 50 {
+		t.Errorf("expected insufficient access result, got %v", err)
+	}
+}
+
+func TestAuthenticateGroupFilter(t *testing.T) {
+	s := newTestServer(t, false)
+	cfg := testConfig(s, "ldap")
+	cfg.GroupBaseDN = "ou=groups,dc=example,dc=org"
+	cfg.GroupFilter = "(&(objectClass=groupOfNames)(member={dn}))"
+
+	user, err := New(cfg).Authenticate(context.Background(), "bob", "bob-secret")
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	want := []string{"cn=operators,ou=groups,dc=example,dc=org", "operators"}
+	if !slices.Equal(user.Groups, want) {
+		t.Errorf("expected groups %v, got %v", want, user.Groups)
+	}
+}
+
+func TestAuthenticateLDAPS(t *testing.T) {
+	s := newTestServer(t, true)
+	if _, err := New(testConfig(s, "ldaps")).Authenticate(context.Background(), "alice", "alice-secret"); err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+
+	// The certificate is verified
+	cfg := testConfig(s, "ldaps")
+	cfg.TLSConfig = nil
+	if _, err := New(cfg).Authenticate(context.Background(), "alice", "alice-secret"); err == nil {
+		t.Error("expected an untrusted certificate to fail")
+	}
+}
+
+func TestAuthenticateStartTLS(t *testing.T) {
+	s := newTestServer(t, false)
+	s.startTLS = true
+
+	cfg := testConfig(s, "ldap")
+	cfg.StartTLS = true
+	if _, err := New(cfg).Authenticate(context.Background(), "alice", "alice-secret"); err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+
+	cfg.StartTLS = false
+	var resultErr *ResultError
+	if _, err := New(cfg).Authenticate(context.Background(), "alice", "alice-secret"); !errors.As(err, &resultErr) || resultErr.Code != 13 {
+		t.Errorf("expected confidentiality required without StartTLS, got %v", err)
+	}
+}
+
+func TestAuthenticateTimeout(t *testing.T) {
+	// A server that accepts but never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	cfg := Config{URL: "ldap://" + ln.Addr().String(), BindDN: "cn=service", BindPassword: "x", Timeout: 200 * time.Millisecond}
+	start := time.Now()
+	if _, err := New(cfg).Authenticate(context.Background(), "alice", "secret"); err == nil {
+		t.Fatal("expected a timeout error")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("timeout took %v", time.Since(start))
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	entry := Entry{DN: "uid=alice", Attributes: map[string][]string{"uid": {"alice"}, "cn": {"Alice Smith"}, "objectclass": {"person"}}}
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"uid=alice", true},
+		{"(uid=ALICE)", true},
+		{"(&(objectClass=person)(uid=alice))", true},
+		{"(&(objectClass=person)(uid=bob))", false},
+		{"(|(uid=bob)(cn=Alice*))", true},
+		{"(!(uid=alice))", false},
+		{"(mail=*)", false},
+		{"(cn=*li*Sm*th)", true},
+		{"(cn=*smith)", true},
+		{"(cn=Alice\\20Smith)", true},
+	}
+	for _, tt := range tests {
+		f, err := compileFilter(tt.filter)
+		if err != nil {
+			t.Errorf("compileFilter(%q) failed: %v", tt.filter, err)
+			continue
+		}
+		if got := matches(f, entry); got != tt.want {
+			t.Errorf("filter %q: expected %v, got %v", tt.filter, tt.want, got)
+		}
+	}
+
+	for _, bad := range []string{"", "(uid=alice", "(uid=alice))", "(=alice)", "(cn:dn:=x)", "(cn=a\\zz)", "(&(uid=a)"} {
+		if _, err := compileFilter(bad); err == nil {
+			t.Errorf("compileFilter(%q): expected error", bad)
+		}
+	}
+}
+
+func TestEscapeFilter(t *testing.T) {
+	if got := EscapeFilter("a*(b)\\c\x00"); got != `a\2a\28b\29\5cc\00` {
+		t.Errorf("unexpected escape: %q", got)
+	}
+}
+
+func TestIntEncoding(t *testing.T) {
+	for _, n := range []int64{0, 1, 127, 128, 255, 256, 65535, -1, -128, -129, 1 << 40} {
+		p := newInt(tagInteger, n)
+		parsed, err := parsePacket(p.tag, p.bytes()[2:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.int() != n {
+			t.Errorf("expected %d, got %d (% x)", n, parsed.int(), p.value)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package lockout
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const maxEntries = 10000 // bound memory when an attacker sprays keys
+
+// Policy describes how failures against a key are throttled.
+type Policy struct {
+	FreeAttempts    int           // failures allowed before delays start
+	BaseDelay       time.Duration // delay after the first failure past FreeAttempts, doubled on every further failure
+	MaxDelay        time.Duration
+	LockoutAfter    int // failures that lock the key
+	LockoutDuration time.Duration
+	ResetAfter      time.Duration // failures are forgotten after this long without a new one
+}
+
+// Lock describes a key that is currently locked out.
+type Lock struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+	inFlight    int // attempts begun and not yet done
+}
+
+// Tracker counts failed attempts per key (an IP or an account) in memory.
+// Lockouts do not survive a restart.
+type Tracker struct {
+	mu      sync.Mutex
+	policy  Policy
+	entries map[string]*entry
+	now     func() time.Time
+}
+
+func New(policy Policy) *Tracker {
+	return &Tracker{
+		policy:  policy,
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+// Allow returns how long the caller must wait before the key may be tried again, zero when it may be tried now.
+func (t *Tracker) Allow(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e := t.get(key)
+	if e == nil {
+		return 0
+	}
+	now := t.now()
+	if now.Before(e.lockedUntil) {
+		return e.lockedUntil.Sub(now)
+	}
+	if next := e.lastFailure.Add(t.delay(e.failures)); now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// Begin reserves an attempt on the key before it is checked and returns zero, or how long the caller must
+// wait when the attempt is refused. Attempts still in flight count as failures, so a burst of parallel
+// requests cannot all pass before the first failure is recorded: past the free attempts only one may be
+// in flight at a time. A reserved attempt must be ended with Done, after Fail or Reset.
+func (t *Tracker) Begin(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	e := t.get(key)
+	if e == nil {
+		if len(t.entries) >= maxEntries {
+			t.prune()
+		}
+		e = &entry{}
+		t.entries[key] = e
+	}
+	if now.Before(e.lockedUntil) {
+		return e.lockedUntil.Sub(now)
+	}
+	if next := e.lastFailure.Add(t.delay(e.failures)); now.Before(next) {
+		return next.Sub(now)
+	}
+	// The outcome of the attempts in flight is unknown, wait as if they had failed just now
+	if pending := e.failures + e.inFlight; e.inFlight > 0 && (t.delay(pending) > 0 || pending >= t.policy.LockoutAfter) {
+		return max(t.delay(pending), t.policy.BaseDelay)
+	}
+	e.inFlight++
+	return 0
+}
+
+// Done ends an attempt reserved by Begin.
+func (t *Tracker) Done(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[key]; ok && e.inFlight > 0 {
+		e.inFlight--
+	}
+}
+
+// Fail records a failed attempt and reports whether it locked the key.
+func (t *Tracker) Fail(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	e := t.get(key)
+	if e == nil {
+		if len(t.entries) >= maxEntries {
+			t.prune()
+		}
+		e = &entry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+
+	if e.failures >= t.policy.LockoutAfter && !now.Before(e.lockedUntil) {
+		e.lockedUntil = now.Add(t.policy.LockoutDuration)
+		return true
+	}
+	return false
+}
+
+// Reset forgets all failures of a key and reports whether there were any.
+func (t *Tracker) Reset(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if ok && e.inFlight > 0 {
+		// Keep counting the attempts still in flight
+		t.entries[key] = &entry{inFlight: e.inFlight}
+	} else {
+		delete(t.entries, key)
+	}
+	return ok && e.failures > 0
+}
+
+// Locked returns the keys that are currently locked out, soonest unlock first.
+func (t *Tracker) Locked() []Lock {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	locks := make([]Lock, 0)
+	for key, e := range t.entries {
+		if now.Before(e.lockedUntil) {
+			locks = append(locks, Lock{Key: key, Failures: e.failures, LockedUntil: e.lockedUntil})
+		}
+	}
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].LockedUntil.Before(locks[j].LockedUntil)
+	})
+	return locks
+}
+
+// get returns the entry of a key, dropping it once it is stale.
+func (t *Tracker) get(key string) *entry {
+	e, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+	if t.stale(e, t.now()) {
+		delete(t.entries, key)
+		return nil
+	}
+	return e
+}
+
+func (t *Tracker) stale(e *entry, now time.Time) bool {
+	return e.inFlight == 0 && !now.Before(e.lockedUntil) && now.Sub(e.lastFailure) >= t.policy.ResetAfter
+}
+
+func (t *Tracker) prune() {
+	now := t.now()
+	for key, e := range t.entries {
+		if t.stale(e, now) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+func (t *Tracker) delay(failures int) time.Duration {
+	over := failures - t.policy.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	d := t.policy.BaseDelay
+	for i := 1; i < over && d < t.policy.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, t.policy.MaxDelay)
+}
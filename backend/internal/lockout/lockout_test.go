@@ -0,0 +1,119 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	FreeAttempts:    2,
+	BaseDelay:       time.Second,
+	MaxDelay:        4 * time.Second,
+	LockoutAfter:    6,
+	LockoutDuration: time.Minute,
+	ResetAfter:      10 * time.Minute,
+}
+
+func newTestTracker() (*Tracker, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	t := New(testPolicy)
+	t.now = func() time.Time { return now }
+	return t, &now
+}
+
+func TestProgressiveDelay(t *testing.T) {
+	tr, _ := newTestTracker()
+
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second}
+	for i, w := range want {
+		tr.Fail("ip")
+		if got := tr.Allow("ip"); got != w {
+			t.Errorf("after %d failures: expected wait %v, got %v", i+1, w, got)
+		}
+	}
+	if got := tr.Allow("other"); got != 0 {
+		t.Errorf("unrelated key should not wait, got %v", got)
+	}
+}
+
+func TestLockoutExpires(t *testing.T) {
+	tr, now := newTestTracker()
+
+	for i := 0; i < 5; i++ {
+		if tr.Fail("alice") {
+			t.Fatalf("locked after %d failures", i+1)
+		}
+	}
+	if !tr.Fail("alice") {
+		t.Fatal("expected lockout after 6 failures")
+	}
+	if got := tr.Allow("alice"); got != time.Minute {
+		t.Errorf("expected a one minute lockout, got %v", got)
+	}
+	if locks := tr.Locked(); len(locks) != 1 || locks[0].Key != "alice" {
+		t.Errorf("expected alice to be listed as locked, got %+v", locks)
+	}
+
+	// Lockout ends, but failures are remembered until the quiet period passes
+	*now = now.Add(2 * time.Minute)
+	if got := tr.Allow("alice"); got != 0 {
+		t.Errorf("expected lockout to have ended, got wait %v", got)
+	}
+	if len(tr.Locked()) != 0 {
+		t.Error("expected no locked keys")
+	}
+
+	*now = now.Add(10 * time.Minute)
+	tr.Fail("alice")
+	if got := tr.Allow("alice"); got != 0 {
+		t.Errorf("expected failures to be forgotten, got wait %v", got)
+	}
+}
+
+func TestReset(t *testing.T) {
+	tr, _ := newTestTracker()
+
+	for i := 0; i < 6; i++ {
+		tr.Fail("alice")
+	}
+	if !tr.Reset("alice") {
+		t.Error("expected reset to report existing failures")
+	}
+	if got := tr.Allow("alice"); got != 0 {
+		t.Errorf("expected no wait after reset, got %v", got)
+	}
+	if tr.Reset("alice") {
+		t.Error("expected second reset to report nothing")
+	}
+}
+
+func TestBeginCountsAttemptsInFlight(t *testing.T) {
+	tr, _ := newTestTracker()
+
+	// Attempts up to the first delayed one may run in parallel, the next must wait for their outcome
+	for i := 0; i <= testPolicy.FreeAttempts; i++ {
+		if got := tr.Begin("alice"); got != 0 {
+			t.Fatalf("attempt %d: expected no wait, got %v", i+1, got)
+		}
+	}
+	if got := tr.Begin("alice"); got == 0 {
+		t.Fatal("expected a parallel attempt past the free attempts to be refused")
+	}
+
+	// A success resets the failures, the attempts still in flight keep counting
+	tr.Done("alice")
+	tr.Reset("alice")
+	if got := tr.Begin("alice"); got != 0 {
+		t.Fatalf("expected no wait after reset, got %v", got)
+	}
+	if got := tr.Begin("alice"); got == 0 {
+		t.Fatal("expected the attempts in flight to survive the reset")
+	}
+
+	for i := 0; i <= testPolicy.FreeAttempts; i++ {
+		tr.Done("alice")
+	}
+	if got := tr.Begin("alice"); got != 0 {
+		t.Errorf("expected no wait once all attempts are done, got %v", got)
+	}
+}
@@ -0,0 +1,49 @@
+package oidc
+
+import (
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the validated claims of an ID token.
+type Claims struct {
+	Issuer  string
+	Subject string
+	raw     jwt.MapClaims
+}
+
+// String returns a string claim, or "" when it is missing or not a string.
+func (c *Claims) String(name string) string {
+	s, _ := c.raw[name].(string)
+	return s
+}
+
+// Bool returns a boolean claim. Some providers send booleans as strings.
+func (c *Claims) Bool(name string) bool {
+	switch v := c.raw[name].(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
+}
+
+// Strings returns a claim holding a list of strings, such as groups. A single string is returned as
+// a list of one, and a space separated string as its parts (as used by some providers for roles).
+func (c *Claims) Strings(name string) []string {
+	switch v := c.raw[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID    = "wolite"
+	testRedirectURL = "https://wolite.example/api/v1/auth/oidc/callback"
+)
+
+// stubIssuer is a minimal OpenID provider. Codes are issued with /authorize and redeemed at /token.
+type stubIssuer struct {
+	*httptest.Server
+	t *testing.T
+
+	mu        sync.Mutex
+	kid       string
+	key       any // *rsa.PrivateKey or *ecdsa.PrivateKey
+	codes     map[string]authRequest
+	jwksCalls int
+
+	// Overrides applied to issued ID tokens
+	claims jwt.MapClaims
+}
+
+type authRequest struct {
+	nonce, challenge string
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubIssuer{t: t, kid: "rsa-1", key: key, codes: make(map[string]authRequest)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 s.URL,
+			"authorization_endpoint": s.URL + "/authorize",
+			"token_endpoint":         s.URL + "/token",
+			"jwks_uri":               s.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", s.handleJWKS)
+	mux.HandleFunc("POST /token", s.handleToken)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *stubIssuer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jwksCalls++
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	var k map[string]string
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		k = map[string]string{"kty": "RSA", "kid": s.kid, "use": "sig", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())}
+	case *ecdsa.PrivateKey:
+		point, _ := key.PublicKey.Bytes()
+		k = map[string]string{"kty": "EC", "kid": s.kid, "crv": "P-256", "x": b64(point[1:33]), "y": b64(point[33:])}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"keys": []any{k}})
+}
+
+// authorize simulates the user approving the login and returns the code the provider would redirect with.
+func (s *stubIssuer) authorize(authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != testClientID || !strings.Contains(q.Get("scope"), "openid") {
+		s.t.Fatalf("unexpected authorization request: %s", authURL)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code := "code-" + q.Get("state")
+	s.codes[code] = authRequest{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	return code
+}
+
+func (s *stubIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, ok := s.codes[r.Form.Get("code")]
+	delete(s.codes, r.Form.Get("code"))
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != req.challenge || r.Form.Get("redirect_uri") != testRedirectURL {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                s.URL,
+		"sub":                "user-123",
+		"aud":                testClientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              req.nonce,
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"groups":             []string{"staff", "wolite-admins"},
+	}
+	for k, v := range s.claims {
+		claims[k] = v
+	}
+
+	method := jwt.SigningMethod(jwt.SigningMethodRS256)
+	if _, ok := s.key.(*ecdsa.PrivateKey); ok {
+		method = jwt.SigningMethodES256
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = s.kid
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": signed})
+}
+
+func login(t *testing.T, s *stubIssuer, p *Provider) (*Claims, error) {
+	t.Helper()
+	logins := NewLogins()
+	l, err := logins.Begin(testRedirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL(context.Background(), l.RedirectURL, l.State, l.Nonce, l.Verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	code := s.authorize(authURL)
+
+	l, ok := logins.Finish(l.State)
+	if !ok {
+		t.Fatal("pending login not found")
+	}
+	return p.Exchange(context.Background(), l.RedirectURL, code, l.Verifier, l.Nonce)
+}
+
+func TestLogin(t *testing.T) {
+	s := newStubIssuer(t)
+	p := NewProvider(Config{Issuer: s.URL, ClientID: testClientID})
+
+	claims, err := login(t, s, p)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if claims.Subject != "user-123" || claims.Issuer != s.URL {
+		t.Errorf("unexpected identity %q from %q", claims.Subject, claims.Issuer)
+	}
+	if claims.String("preferred_username") != "alice" || !claims.Bool("email_verified") {
+		t.Errorf("unexpected profile claims: %+v", claims.raw)
+	}
+	if groups := claims.Strings("groups"); len(groups) != 2 || groups[1] != "wolite-admins" {
+		t.Errorf("unexpected groups: %v", groups)
+	}
+}
+
+func TestLoginRejectsInvalidTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{"wrong audience", jwt.MapClaims{"aud": "someone-else"}},
+		{"wrong issuer", jwt.MapClaims{"iss": "https://evil.example"}},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}},
+		{"no subject", jwt.MapClaims{"sub": ""}},
+		{"foreign authorized party", jwt.MapClaims{"aud": []string{testClientID, "other"}, "azp": "other"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStubIssuer(t)
+			s.claims = tt.claims
+			p := NewProvider(Config{Issuer: s.URL, ClientID: testClientID})
+			if _, err := login(t, s, p); err == nil {
+				t.Error("expected the ID token to be rejected")
+			}
+		})
+	}
+}
+
+func TestLoginRejectsNonceMismatch(t *testing.T) {
+	s := newStubIssuer(t)
+	s.claims = jwt.MapClaims{"nonce": "replayed"}
+	p := NewProvider(Config{Issuer: s.URL, ClientID: testClientID})
+
+	if _, err := login(t, s, p); !errors.Is(err, ErrNonce) {
+		t.Errorf("expected ErrNonce, got %v", err)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	s := newStubIssuer(t)
+	p := NewProvider(Config{Issuer: s.URL, ClientID: testClientID})
+
+	authURL, err := p.AuthCodeURL(context.Background(), testRedirectURL, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := s.authorize(authURL)
+	if _, err := p.Exchange(context.Background(), testRedirectURL, code, "another-verifier", "nonce"); err == nil {
+		t.Error("expected the token request to fail without the right PKCE verifier")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	s := newStubIssuer(t)
+	p := NewProvider(Config{Issuer: s.URL, ClientID: testClientID})
+	if _, err := login(t, s, p); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	// The provider switches to a new EC key; the unknown kid makes the client refetch the JWKS
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.key, s.kid = ecKey, "ec-2"
+	s.mu.Unlock()
+	p.keysFetched = time.Time{} // skip the refetch rate limit
+
+	if _, err := login(t, s, p); err != nil {
+		t.Fatalf("login after key rotation failed: %v", err)
+	}
+	if s.jwksCalls != 2 {
+		t.Errorf("expected 2 JWKS fetches, got %d", s.jwksCalls)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 "https://evil.example",
+			"authorization_endpoint": "https://evil.example/authorize",
+			"token_endpoint":         "https://evil.example/token",
+			"jwks_uri":               "https://evil.example/jwks",
+		})
+	}))
+	defer srv.Close()
+
+	p := NewProvider(Config{Issuer: srv.URL, ClientID: testClientID})
+	if _, err := p.AuthCodeURL(context.Background(), testRedirectURL, "s", "n", "v"); err == nil {
+		t.Error("expected discovery to fail when the issuer does not match")
+	}
+}
+
+func TestLoginsSingleUse(t *testing.T) {
+	logins := NewLogins()
+	l, err := logins.Begin(testRedirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.State == l.Nonce || l.Nonce == l.Verifier {
+		t.Error("state, nonce and verifier must be independent")
+	}
+	if _, ok := logins.Finish(l.State); !ok {
+		t.Fatal("expected pending login")
+	}
+	if _, ok := logins.Finish(l.State); ok {
+		t.Error("a state must only be usable once")
+	}
+}
+
+func TestLoginsLimit(t *testing.T) {
+	logins := NewLogins()
+	for i := range maxPendingLogins {
+		logins.pending[strconv.Itoa(i)] = Login{expires: time.Now().Add(loginTimeout)}
+	}
+	if _, err := logins.Begin(testRedirectURL); err != ErrTooManyLogins {
+		t.Fatalf("expected ErrTooManyLogins when full, got %v", err)
+	}
+
+	logins.pending["0"] = Login{expires: time.Now().Add(-time.Second)}
+	if _, err := logins.Begin(testRedirectURL); err != nil {
+		t.Fatalf("expected expired logins to make room, got %v", err)
+	}
+}
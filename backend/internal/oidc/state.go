@@ -0,0 +1,78 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	loginTimeout     = 10 * time.Minute
+	maxPendingLogins = 10000
+)
+
+// ErrTooManyLogins is returned when the pending logins are at their limit and none has expired yet.
+var ErrTooManyLogins = errors.New("oidc: too many pending logins")
+
+// Login is an authorization request waiting for the provider to redirect back.
+type Login struct {
+	State       string
+	Nonce       string
+	Verifier    string // PKCE code verifier
+	RedirectURL string
+	expires     time.Time
+}
+
+// Logins tracks pending logins in memory by state. Each state can be used once.
+type Logins struct {
+	mu      sync.Mutex
+	pending map[string]Login
+}
+
+func NewLogins() *Logins {
+	return &Logins{pending: make(map[string]Login)}
+}
+
+// Begin creates a login with fresh state, nonce and PKCE verifier.
+func (l *Logins) Begin(redirectURL string) (Login, error) {
+	login := Login{RedirectURL: redirectURL, expires: time.Now().Add(loginTimeout)}
+	for _, s := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Login{}, err
+		}
+		*s = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.pending) >= maxPendingLogins {
+		now := time.Now()
+		for k, v := range l.pending {
+			if now.After(v.expires) {
+				delete(l.pending, k)
+			}
+		}
+		// Refuse rather than evict, so a flood of logins cannot cancel the ones in progress
+		if len(l.pending) >= maxPendingLogins {
+			return Login{}, ErrTooManyLogins
+		}
+	}
+	l.pending[login.State] = login
+	return login, nil
+}
+
+// Finish removes and returns the login of a state if it has not expired.
+func (l *Logins) Finish(state string) (Login, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	login, ok := l.pending[state]
+	delete(l.pending, state)
+	if !ok || time.Now().After(login.expires) {
+		return Login{}, false
+	}
+	return login, true
+}
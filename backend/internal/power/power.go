@@ -0,0 +1,62 @@
+package power
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wolite/internal/companion"
+	"wolite/internal/store"
+	"wolite/internal/wol"
+)
+
+var (
+	ErrNoBroadcastIP = errors.New("device missing broadcast ip configuration")
+	ErrNotPaired     = errors.New("companion not paired")
+)
+
+// Wake sends a magic packet to the device's broadcast address.
+func Wake(device store.Device) error {
+	if device.BroadcastIP == "" {
+		return ErrNoBroadcastIP
+	}
+	return wol.SendMagicPacket(device.MACAddress, device.BroadcastIP)
+}
+
+// Execute sends a power action to the device's paired companion.
+func Execute(ctx context.Context, device store.Device, action companion.PowerAction) error {
+	if device.CompanionURL == "" || device.CompanionToken == "" {
+		return ErrNotPaired
+	}
+
+	client, err := companion.NewClient(device.CompanionURL, device.CompanionToken, device.CompanionAuthFingerprint)
+	if err != nil {
+		return err
+	}
+	return client.Power(ctx, action)
+}
+
+// WaitOnline polls the device's companion until it answers or the context is done.
+// Devices without a companion cannot report their status, so ErrNotPaired is returned immediately.
+func WaitOnline(ctx context.Context, device store.Device, interval time.Duration) error {
+	if device.CompanionURL == "" || device.CompanionToken == "" {
+		return ErrNotPaired
+	}
+
+	client, err := companion.NewClient(device.CompanionURL, device.CompanionToken, device.CompanionAuthFingerprint)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := client.Ping(ctx); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+type CalendarMode string
+
+const (
+	CalendarSkip  CalendarMode = "skip"  // schedules do not fire on calendar days
+	CalendarForce CalendarMode = "force" // schedules fire on calendar days even if their cron day fields do not match
+)
+
+// DateRange is an inclusive range of days in YYYY-MM-DD form.
+type DateRange struct {
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// Calendar is a set of days that schedules referencing it skip or are forced to run on.
+type Calendar struct {
+	ID         string       `json:"id"`
+	Username   string       `json:"username"` // owner of the calendar
+	Name       string       `json:"name"`
+	Mode       CalendarMode `json:"mode"`
+	Dates      []string     `json:"dates"`                 // single days, YYYY-MM-DD
+	Ranges     []DateRange  `json:"ranges"`                // e.g. an office shutdown
+	Imported   []DateRange  `json:"imported,omitempty"`    // events from the last iCalendar import
+	ImportedAt *time.Time   `json:"imported_at,omitempty"` // time of the last iCalendar import
+}
+
+func NewCalendar(username, name string, mode CalendarMode) *Calendar {
+	return &Calendar{
+		ID:       newID(),
+		Username: username,
+		Name:     name,
+		Mode:     mode,
+		Dates:    make([]string, 0),
+		Ranges:   make([]DateRange, 0),
+	}
+}
+
+// Contains reports whether the calendar covers the given day (YYYY-MM-DD).
+func (c *Calendar) Contains(day string) bool {
+	if slices.Contains(c.Dates, day) {
+		return true
+	}
+	for _, ranges := range [][]DateRange{c.Ranges, c.Imported} {
+		for _, r := range ranges {
+			// YYYY-MM-DD strings sort chronologically
+			if r.Start <= day && day <= r.End {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetCalendarsForUser returns all calendars owned by a username, sorted by Name.
+func (s *Store) GetCalendarsForUser(username string) ([]Calendar, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	calendars := make([]Calendar, 0)
+	for _, c := range s.calendars {
+		if c.Username == username {
+			calendars = append(calendars, c)
+		}
+	}
+
+	sort.Slice(calendars, func(i, j int) bool {
+		return calendars[i].Name < calendars[j].Name
+	})
+	return calendars, nil
+}
+
+// GetCalendarForUser returns a calendar only if it is owned by the given username.
+func (s *Store) GetCalendarForUser(username, id string) (*Calendar, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.calendars[id]
+	if !ok || c.Username != username {
+		return nil, ErrCalendarNotFound
+	}
+	return &c, nil
+}
+
+// GetCalendarsForSchedule returns the calendars a schedule references that its owner still has.
+func (s *Store) GetCalendarsForSchedule(schedule Schedule) []Calendar {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	calendars := make([]Calendar, 0, len(schedule.CalendarIDs))
+	for _, id := range schedule.CalendarIDs {
+		if c, ok := s.calendars[id]; ok && c.Username == schedule.Username {
+			calendars = append(calendars, c)
+		}
+	}
+	return calendars
+}
+
+// CreateCalendar adds a new calendar.
+func (s *Store) CreateCalendar(calendar *Calendar) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Owner must exist
+	if _, exists := s.users[calendar.Username]; !exists {
+		return ErrUserNotFound
+	}
+
+	// Action: Write to map
+	s.calendars[calendar.ID] = *calendar
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// UpdateCalendar replaces an existing calendar. Ownership cannot change.
+func (s *Store) UpdateCalendar(calendar *Calendar) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and ownership
+	if c, exists := s.calendars[calendar.ID]; !exists || c.Username != calendar.Username {
+		return ErrCalendarNotFound
+	}
+
+	// Action: Write to map
+	s.calendars[calendar.ID] = *calendar
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// DeleteCalendar removes a calendar and detaches it from the schedules referencing it.
+func (s *Store) DeleteCalendar(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and ownership
+	if c, exists := s.calendars[id]; !exists || c.Username != username {
+		return ErrCalendarNotFound
+	}
+
+	// Action: Delete from map and drop references
+	delete(s.calendars, id)
+	for scID, sc := range s.schedules {
+		if slices.Contains(sc.CalendarIDs, id) {
+			sc.CalendarIDs = slices.DeleteFunc(slices.Clone(sc.CalendarIDs), func(c string) bool { return c == id })
+			s.schedules[scID] = sc
+		}
+	}
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
@@ -0,0 +1,109 @@
+package store
+
+// WakeOrder returns the transitive prerequisites of a device in the order they must be woken,
+// deepest prerequisite first. The device itself is not included.
+func (s *Store) WakeOrder(macAddress string) ([]Device, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.devices[macAddress]; !exists {
+		return nil, ErrDeviceNotFound
+	}
+
+	return s.walkLocked(macAddress, func(mac string) []string {
+		return s.devices[mac].DependsOn
+	})
+}
+
+// ShutdownOrder returns the devices that transitively depend on a device in the order they must be
+// shut down, most dependent first. The device itself is not included.
+func (s *Store) ShutdownOrder(macAddress string) ([]Device, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.devices[macAddress]; !exists {
+		return nil, ErrDeviceNotFound
+	}
+
+	dependents := make(map[string][]string)
+	for mac, d := range s.devices {
+		for _, dep := range d.DependsOn {
+			dependents[dep] = append(dependents[dep], mac)
+		}
+	}
+
+	return s.walkLocked(macAddress, func(mac string) []string {
+		return dependents[mac]
+	})
+}
+
+// CheckDependencies validates that a device may depend on the given devices:
+// they must exist, must not include the device itself and must not introduce a cycle.
+func (s *Store) CheckDependencies(macAddress string, dependsOn []string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkDependenciesLocked(macAddress, dependsOn)
+}
+
+// checkDependenciesLocked is CheckDependencies for callers that hold s.mu.
+func (s *Store) checkDependenciesLocked(macAddress string, dependsOn []string) error {
+	for _, dep := range dependsOn {
+		if dep == macAddress {
+			return ErrDependencyCycle
+		}
+		if _, exists := s.devices[dep]; !exists {
+			return ErrDeviceNotFound
+		}
+	}
+
+	// Walk the graph as if the new edges were already in place
+	_, err := s.walkLocked(macAddress, func(mac string) []string {
+		if mac == macAddress {
+			return dependsOn
+		}
+		return s.devices[mac].DependsOn
+	})
+	return err
+}
+
+// walkLocked runs a depth-first search from start along edges and returns the visited devices in
+// post-order, excluding start. It fails with ErrDependencyCycle if a cycle is reachable. Caller must hold s.mu.
+func (s *Store) walkLocked(start string, edges func(mac string) []string) ([]Device, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	order := make([]Device, 0)
+
+	var visit func(mac string) error
+	visit = func(mac string) error {
+		switch state[mac] {
+		case visiting:
+			return ErrDependencyCycle
+		case done:
+			return nil
+		}
+
+		state[mac] = visiting
+		for _, next := range edges(mac) {
+			if _, exists := s.devices[next]; !exists {
+				continue // dangling reference, nothing to wake or shut down
+			}
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		state[mac] = done
+
+		if mac != start {
+			order = append(order, s.devices[mac])
+		}
+		return nil
+	}
+
+	if err := visit(start); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
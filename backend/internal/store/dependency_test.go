@@ -0,0 +1,81 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := New(filepath.Join(t.TempDir(), "wolite.json"), nil)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	return s
+}
+
+func macs(devices []Device) []string {
+	out := make([]string, len(devices))
+	for i, d := range devices {
+		out[i] = d.MACAddress
+	}
+	return out
+}
+
+func TestDependencyOrder(t *testing.T) {
+	s := newTestStore(t)
+
+	// app -> db -> nas, app -> nas
+	for _, d := range []*Device{
+		{MACAddress: "nas", DependsOn: nil},
+		{MACAddress: "db", DependsOn: []string{"nas"}},
+		{MACAddress: "app", DependsOn: []string{"db", "nas"}},
+	} {
+		if err := s.AddDevice(d); err != nil {
+			t.Fatalf("failed to add device: %v", err)
+		}
+	}
+
+	wake, err := s.WakeOrder("app")
+	if err != nil {
+		t.Fatalf("WakeOrder failed: %v", err)
+	}
+	if got := macs(wake); len(got) != 2 || got[0] != "nas" || got[1] != "db" {
+		t.Errorf("expected wake order [nas db], got %v", got)
+	}
+
+	shutdown, err := s.ShutdownOrder("nas")
+	if err != nil {
+		t.Fatalf("ShutdownOrder failed: %v", err)
+	}
+	if got := macs(shutdown); len(got) != 2 || got[0] != "app" || got[1] != "db" {
+		t.Errorf("expected shutdown order [app db], got %v", got)
+	}
+}
+
+func TestCheckDependenciesCycle(t *testing.T) {
+	s := newTestStore(t)
+
+	for _, d := range []*Device{
+		{MACAddress: "a", DependsOn: []string{"b"}},
+		{MACAddress: "b", DependsOn: []string{"c"}},
+		{MACAddress: "c"},
+	} {
+		if err := s.AddDevice(d); err != nil {
+			t.Fatalf("failed to add device: %v", err)
+		}
+	}
+
+	if err := s.CheckDependencies("c", []string{"a"}); err != ErrDependencyCycle {
+		t.Errorf("expected ErrDependencyCycle for transitive cycle, got %v", err)
+	}
+	if err := s.CheckDependencies("c", []string{"c"}); err != ErrDependencyCycle {
+		t.Errorf("expected ErrDependencyCycle for self dependency, got %v", err)
+	}
+	if err := s.CheckDependencies("c", []string{"missing"}); err != ErrDeviceNotFound {
+		t.Errorf("expected ErrDeviceNotFound, got %v", err)
+	}
+	if err := s.CheckDependencies("a", []string{"c"}); err != nil {
+		t.Errorf("expected valid dependencies, got %v", err)
+	}
+}
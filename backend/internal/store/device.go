@@ -23,7 +23,21 @@ type Device struct {
 
 	Status Status `json:"status"` // current status of the device
 
-	Order int `json:"order"` // display order of the device
+	Tags      []string `json:"tags,omitempty"`       // free-form labels for filtering
+	DependsOn []string `json:"depends_on,omitempty"` // MAC addresses that must be online before this device wakes
+
+	IdlePolicy *IdlePolicy `json:"idle_policy,omitempty"` // automatic power action when nobody uses the device
+}
+
+// IdlePolicy powers a device down through its companion after its users have been idle for a while.
+type IdlePolicy struct {
+	Enabled        bool   `json:"enabled"`
+	IdleMinutes    int    `json:"idle_minutes"`              // idle time before the action runs
+	Action         string `json:"action"`                    // companion power action, e.g. sleep
+	WindowStart    string `json:"window_start,omitempty"`    // HH:MM, policy applies from this time of day
+	WindowEnd      string `json:"window_end,omitempty"`      // HH:MM, until this time of day; may wrap past midnight
+	Timezone       string `json:"timezone,omitempty"`        // IANA time zone of the window, defaults to UTC
+	WarningMinutes int    `json:"warning_minutes,omitempty"` // notify logged-in users this long before the action
 }
 
 func NewDevice(macAddress, name, description, ipAddress, broadcastIP string, status Status) *Device {
@@ -94,41 +108,3 @@ func (s *Store) UpdateDevice(device *Device) error {
 	// Persistence: Flush to disk
 	return s.flush()
 }
-
-// DeleteDevice removes a device from the store and all user-device mappings.
-func (s *Store) DeleteDevice(macAddress string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Guard: Check existence
-	if _, exists := s.devices[macAddress]; !exists {
-		return ErrDeviceNotFound
-	}
-
-	// Action: Delete from map
-	delete(s.devices, macAddress)
-
-	// Clean up: Remove from all user mappings to ensure consistency
-	for _, mappings := range s.userDeviceMappings {
-		delete(mappings, macAddress)
-	}
-
-	// Persistence: Flush to disk
-	return s.flush()
-}
-
-// ReorderDevices updates the order of devices based on the provided list of MAC addresses.
-func (s *Store) ReorderDevices(macAddresses []string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	for i, mac := range macAddresses {
-		if device, exists := s.devices[mac]; exists {
-			device.Order = i
-			s.devices[mac] = device
-		}
-	}
-
-	// Persistence: Flush to disk
-	return s.flush()
-}
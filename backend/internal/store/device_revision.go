@@ -0,0 +1,244 @@
+package store
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"time"
+)
+
+const maxDeviceRevisions = 50 // revisions kept per device
+
+type RevisionKind string
+
+const (
+	RevisionCreated   RevisionKind = "created"
+	RevisionUpdated   RevisionKind = "updated"
+	RevisionRestored  RevisionKind = "restored"  // configuration of an earlier revision restored
+	RevisionDeleted   RevisionKind = "deleted"   // moved to the trash
+	RevisionRecovered RevisionKind = "recovered" // restored from the trash
+)
+
+// DeviceConfig is the part of a device that is versioned. Status and the companion pairing are left
+// out: the status is not configuration, and a pairing is only valid with the token it was verified with.
+type DeviceConfig struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	IPAddress   string      `json:"ip_address"`
+	BroadcastIP string      `json:"broadcast_ip"`
+	Tags        []string    `json:"tags"`
+	DependsOn   []string    `json:"depends_on"`
+	IdlePolicy  *IdlePolicy `json:"idle_policy"`
+}
+
+// FieldChange is one changed field of a revision, with JSON values as in the device API.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// DeviceRevision records one change of a device's configuration and the configuration after it.
+type DeviceRevision struct {
+	ID           string        `json:"id"`
+	MACAddress   string        `json:"mac_address"`
+	Number       int           `json:"number"` // counts up per device, starting at 1
+	Kind         RevisionKind  `json:"kind"`
+	Username     string        `json:"username"` // who made the change
+	Time         time.Time     `json:"time"`
+	Changes      []FieldChange `json:"changes"`
+	Config       DeviceConfig  `json:"config"`
+	RestoredFrom int           `json:"restored_from,omitempty"` // number of the revision a restore went back to
+}
+
+// Config returns the versioned configuration of the device.
+func (d Device) Config() DeviceConfig {
+	return DeviceConfig{
+		Name:        d.Name,
+		Description: d.Description,
+		IPAddress:   d.IPAddress,
+		BroadcastIP: d.BroadcastIP,
+		Tags:        slices.Clone(d.Tags),
+		DependsOn:   slices.Clone(d.DependsOn),
+		IdlePolicy:  d.IdlePolicy,
+	}
+}
+
+// applyConfig overwrites the versioned fields of the device.
+func (d *Device) applyConfig(c DeviceConfig) {
+	d.Name = c.Name
+	d.Description = c.Description
+	d.IPAddress = c.IPAddress
+	d.BroadcastIP = c.BroadcastIP
+	d.Tags = slices.Clone(c.Tags)
+	d.DependsOn = slices.Clone(c.DependsOn)
+	if c.IdlePolicy != nil {
+		p := *c.IdlePolicy
+		d.IdlePolicy = &p
+	} else {
+		d.IdlePolicy = nil
+	}
+}
+
+// DiffConfig returns the fields that differ between two configurations, in field order.
+// Empty lists and missing values are treated as equal.
+func DiffConfig(old, new DeviceConfig) []FieldChange {
+	oldFields, newFields := configFields(old), configFields(new)
+	changes := make([]FieldChange, 0)
+	for i := range oldFields {
+		o, n := oldFields[i].value, newFields[i].value
+		if !reflect.DeepEqual(o, n) {
+			changes = append(changes, FieldChange{Field: oldFields[i].name, Old: o, New: n})
+		}
+	}
+	return changes
+}
+
+type configField struct {
+	name  string
+	value any
+}
+
+// configFields returns the fields of a configuration as generic JSON values, the same form they have
+// after a revision was stored and loaded again.
+func configFields(c DeviceConfig) []configField {
+	t := reflect.TypeOf(c)
+	v := reflect.ValueOf(c)
+	fields := make([]configField, t.NumField())
+	for i := range fields {
+		raw, _ := json.Marshal(v.Field(i).Interface())
+		var value any
+		json.Unmarshal(raw, &value)
+		if list, ok := value.([]any); ok && len(list) == 0 {
+			value = nil
+		}
+		if s, ok := value.(string); ok && s == "" {
+			value = nil
+		}
+		fields[i] = configField{name: t.Field(i).Tag.Get("json"), value: value}
+	}
+	return fields
+}
+
+// recordRevisionLocked appends a revision to the device's history. Updates that change nothing are
+// not recorded; nil is returned for them. Caller must hold s.mu.
+func (s *Store) recordRevisionLocked(macAddress, username string, kind RevisionKind, old, new DeviceConfig) *DeviceRevision {
+	changes := DiffConfig(old, new)
+	if kind == RevisionUpdated && len(changes) == 0 {
+		return nil
+	}
+
+	revisions := s.deviceRevisions[macAddress]
+	number := 1
+	if len(revisions) > 0 {
+		number = revisions[len(revisions)-1].Number + 1
+	}
+	rev := DeviceRevision{
+		ID:         newID(),
+		MACAddress: macAddress,
+		Number:     number,
+		Kind:       kind,
+		Username:   username,
+		Time:       time.Now(),
+		Changes:    changes,
+		Config:     new,
+	}
+
+	revisions = append(revisions, rev)
+	if len(revisions) > maxDeviceRevisions {
+		revisions = revisions[len(revisions)-maxDeviceRevisions:]
+	}
+	s.deviceRevisions[macAddress] = revisions
+	return &rev
+}
+
+// GetDeviceRevisions returns the revision history of a device, newest first.
+func (s *Store) GetDeviceRevisions(macAddress string) ([]DeviceRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revisions := slices.Clone(s.deviceRevisions[macAddress])
+	slices.Reverse(revisions)
+	if revisions == nil {
+		revisions = make([]DeviceRevision, 0)
+	}
+	return revisions, nil
+}
+
+// UpdateDeviceConfig saves a device changed by a user and records the change as a revision.
+// The revision is nil when no versioned field changed.
+func (s *Store) UpdateDeviceConfig(username string, device *Device) (*DeviceRevision, error) {
+	return s.UpdateDeviceInGroupForUser(username, device, nil)
+}
+
+// UpdateDeviceInGroupForUser is UpdateDeviceConfig that also files the device under one of the user's
+// groups, or none for an empty groupID. A nil groupID keeps the group. Nothing is written if the group
+// does not exist or the user does not own the device.
+func (s *Store) UpdateDeviceInGroupForUser(username string, device *Device, groupID *string) (*DeviceRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence
+	old, exists := s.devices[device.MACAddress]
+	if !exists {
+		return nil, ErrDeviceNotFound
+	}
+	m, mapped := s.userDeviceMappings[username][device.MACAddress]
+	if groupID != nil {
+		if !mapped || m.Access != AccessOwner {
+			return nil, ErrUserDeviceMappingNotFound
+		}
+		if g, exists := s.groups[*groupID]; *groupID != "" && (!exists || g.Username != username) {
+			return nil, ErrGroupNotFound
+		}
+	}
+
+	// Action: Write to maps and history
+	s.devices[device.MACAddress] = *device
+	if groupID != nil {
+		m.GroupID = *groupID
+		s.userDeviceMappings[username][device.MACAddress] = m
+	}
+	rev := s.recordRevisionLocked(device.MACAddress, username, RevisionUpdated, old.Config(), device.Config())
+
+	// Persistence: Flush to disk
+	return rev, s.flush()
+}
+
+// RestoreDeviceRevision brings a device back to the configuration of an earlier revision. Dependencies
+// on devices that no longer exist are dropped.
+func (s *Store) RestoreDeviceRevision(username, macAddress, revisionID string) (*Device, *DeviceRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence of the device and the revision
+	device, exists := s.devices[macAddress]
+	if !exists {
+		return nil, nil, ErrDeviceNotFound
+	}
+	i := slices.IndexFunc(s.deviceRevisions[macAddress], func(r DeviceRevision) bool { return r.ID == revisionID })
+	if i < 0 {
+		return nil, nil, ErrDeviceRevisionNotFound
+	}
+	target := s.deviceRevisions[macAddress][i]
+
+	config := target.Config
+	config.DependsOn = slices.DeleteFunc(slices.Clone(config.DependsOn), func(dep string) bool {
+		_, exists := s.devices[dep]
+		return !exists
+	})
+	if err := s.checkDependenciesLocked(macAddress, config.DependsOn); err != nil {
+		return nil, nil, err
+	}
+
+	// Action: Write to map and history
+	old := device.Config()
+	device.applyConfig(config)
+	s.devices[macAddress] = device
+	rev := s.recordRevisionLocked(macAddress, username, RevisionRestored, old, device.Config())
+	rev.RestoredFrom = target.Number
+	s.deviceRevisions[macAddress][len(s.deviceRevisions[macAddress])-1] = *rev
+
+	// Persistence: Flush to disk
+	return &device, rev, s.flush()
+}
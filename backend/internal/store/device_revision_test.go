@@ -0,0 +1,183 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestOwner(t *testing.T, s *Store, username string) {
+	t.Helper()
+	u, err := NewUser(username, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateUser(*u); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	old := DeviceConfig{Name: "pc", Tags: []string{}}
+	new := DeviceConfig{Name: "desktop", IdlePolicy: &IdlePolicy{Enabled: true, IdleMinutes: 30, Action: "sleep"}}
+
+	changes := DiffConfig(old, new)
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes (empty and missing tags are equal), got %+v", changes)
+	}
+	if changes[0].Field != "name" || changes[0].Old != "pc" || changes[0].New != "desktop" {
+		t.Errorf("unexpected name change: %+v", changes[0])
+	}
+	if changes[1].Field != "idle_policy" || changes[1].Old != nil {
+		t.Errorf("unexpected idle policy change: %+v", changes[1])
+	}
+}
+
+func TestDeviceRevisions(t *testing.T) {
+	s := newTestStore(t)
+	newTestOwner(t, s, "alice")
+
+	device := NewDevice("aa", "pc", "", "10.0.0.2", "10.0.0.255:9", StatusUnknown)
+	if err := s.CreateDeviceForUser("alice", device); err != nil {
+		t.Fatal(err)
+	}
+
+	// Status changes are not configuration
+	device.Status = StatusOnline
+	if rev, err := s.UpdateDeviceConfig("alice", device); err != nil || rev != nil {
+		t.Fatalf("expected no revision for a status change, got %+v, %v", rev, err)
+	}
+
+	device.Name = "desktop"
+	if rev, err := s.UpdateDeviceConfig("alice", device); err != nil || rev == nil || rev.Number != 2 {
+		t.Fatalf("expected revision 2, got %+v, %v", rev, err)
+	}
+
+	revisions, _ := s.GetDeviceRevisions("aa")
+	if len(revisions) != 2 || revisions[1].Kind != RevisionCreated {
+		t.Fatalf("expected created and updated revisions, newest first, got %+v", revisions)
+	}
+
+	restored, rev, err := s.RestoreDeviceRevision("bob", "aa", revisions[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Name != "pc" || restored.Status != StatusOnline {
+		t.Errorf("expected the old name and the current status, got %+v", restored)
+	}
+	if rev.Kind != RevisionRestored || rev.RestoredFrom != 1 || rev.Number != 3 || rev.Username != "bob" {
+		t.Errorf("unexpected restore revision: %+v", rev)
+	}
+}
+
+func TestDeviceTrash(t *testing.T) {
+	s := newTestStore(t)
+	newTestOwner(t, s, "alice")
+	newTestOwner(t, s, "bob")
+
+	for _, d := range []*Device{
+		NewDevice("nas", "nas", "", "10.0.0.2", "10.0.0.255:9", StatusOnline),
+		NewDevice("app", "app", "", "10.0.0.3", "10.0.0.255:9", StatusOnline),
+	} {
+		if err := s.CreateDeviceForUser("alice", d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app, _ := s.GetDeviceByMacAddress("app")
+	app.DependsOn = []string{"nas"}
+	if _, err := s.UpdateDeviceConfig("alice", app); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteDevice("alice", "nas"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetDeviceForUser("alice", "nas"); err != ErrDeviceNotFound {
+		t.Fatalf("expected the device to be gone, got %v", err)
+	}
+	if app, _ := s.GetDeviceByMacAddress("app"); len(app.DependsOn) != 0 {
+		t.Fatalf("expected the dependency to be removed, got %v", app.DependsOn)
+	}
+	if trash, _ := s.GetDeletedDevicesForUser("bob"); len(trash) != 0 {
+		t.Fatalf("bob never owned the device, got %+v", trash)
+	}
+
+	if _, err := s.RestoreDeletedDevice("alice", "nas"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetDeviceForUser("alice", "nas"); err != nil {
+		t.Fatalf("expected alice to own the device again: %v", err)
+	}
+	if app, _ := s.GetDeviceByMacAddress("app"); len(app.DependsOn) != 1 {
+		t.Fatalf("expected the dependency to be restored, got %v", app.DependsOn)
+	}
+	revisions, _ := s.GetDeviceRevisions("nas")
+	if len(revisions) != 3 || revisions[0].Kind != RevisionRecovered || revisions[1].Kind != RevisionDeleted {
+		t.Fatalf("unexpected history: %+v", revisions)
+	}
+
+	// Purging drops the history as well
+	if err := s.DeleteDevice("alice", "nas"); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := s.PurgeDeletedDevices(time.Now().Add(time.Minute)); err != nil || purged != 1 {
+		t.Fatalf("expected 1 purged device, got %d, %v", purged, err)
+	}
+	if revisions, _ := s.GetDeviceRevisions("nas"); len(revisions) != 0 {
+		t.Errorf("expected the history to be purged, got %+v", revisions)
+	}
+}
+
+func TestDeviceRecreatedStartsFreshHistory(t *testing.T) {
+	s := newTestStore(t)
+	newTestOwner(t, s, "alice")
+	newTestOwner(t, s, "bob")
+
+	device := NewDevice("aa", "pc", "", "10.0.0.2", "10.0.0.255:9", StatusUnknown)
+	if err := s.CreateDeviceForUser("alice", device); err != nil {
+		t.Fatal(err)
+	}
+	device.Description = "alice's desk"
+	if _, err := s.UpdateDeviceConfig("alice", device); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteDevice("alice", "aa"); err != nil {
+		t.Fatal(err)
+	}
+
+	// bob adds a device under the same MAC address while alice's is in the trash
+	if err := s.CreateDeviceForUser("bob", NewDevice("aa", "laptop", "", "10.0.0.9", "10.0.0.255:9", StatusUnknown)); err != nil {
+		t.Fatal(err)
+	}
+	revisions, _ := s.GetDeviceRevisions("aa")
+	if len(revisions) != 1 || revisions[0].Kind != RevisionCreated || revisions[0].Username != "bob" {
+		t.Fatalf("expected only bob's created revision, got %+v", revisions)
+	}
+	if trash, _ := s.GetDeletedDevicesForUser("alice"); len(trash) != 0 {
+		t.Errorf("expected alice's device to leave the trash, got %+v", trash)
+	}
+}
+
+func TestDeviceGroupWrittenWithDevice(t *testing.T) {
+	s := newTestStore(t)
+	newTestOwner(t, s, "alice")
+
+	device := NewDevice("aa", "pc", "", "10.0.0.2", "10.0.0.255:9", StatusUnknown)
+	if err := s.CreateDeviceInGroupForUser("alice", device, "missing"); err != ErrGroupNotFound {
+		t.Fatalf("expected ErrGroupNotFound, got %v", err)
+	}
+	if _, err := s.GetDeviceByMacAddress("aa"); err != ErrDeviceNotFound {
+		t.Fatalf("expected nothing to be written for a missing group, got %v", err)
+	}
+
+	if err := s.CreateDeviceForUser("alice", device); err != nil {
+		t.Fatal(err)
+	}
+	device.Name = "desktop"
+	missing := "missing"
+	if _, err := s.UpdateDeviceInGroupForUser("alice", device, &missing); err != ErrGroupNotFound {
+		t.Fatalf("expected ErrGroupNotFound, got %v", err)
+	}
+	if d, _ := s.GetDeviceByMacAddress("aa"); d.Name != "pc" {
+		t.Errorf("expected the device to be unchanged, got %+v", d)
+	}
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// DeletedDevice is a device in the trash. It keeps what is needed to undo the delete: the users who had
+// the device and the devices that depended on it.
+type DeletedDevice struct {
+	Device     Device              `json:"device"`
+	Mappings   []UserDeviceMapping `json:"mappings,omitempty"`
+	Dependents []string            `json:"dependents,omitempty"` // MAC addresses of devices that depended on it
+	DeletedBy  string              `json:"deleted_by"`
+	DeletedAt  time.Time           `json:"deleted_at"`
+}
+
+// ownedBy reports whether the user owned the device when it was deleted.
+func (d DeletedDevice) ownedBy(username string) bool {
+	return slices.ContainsFunc(d.Mappings, func(m UserDeviceMapping) bool {
+		return m.Username == username && m.Access == AccessOwner
+	})
+}
+
+// DeleteDevice moves a device to the trash and removes it from all user mappings and from other devices'
+// dependencies.
+func (s *Store) DeleteDevice(username, macAddress string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence
+	device, exists := s.devices[macAddress]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+
+	// Action: Move to the trash
+	deleted := DeletedDevice{
+		Device:    device,
+		Mappings:  make([]UserDeviceMapping, 0),
+		DeletedBy: username,
+		DeletedAt: time.Now(),
+	}
+	delete(s.devices, macAddress)
+
+	// Clean up: Remove from all user mappings to ensure consistency
+	for _, mappings := range s.userDeviceMappings {
+		if m, ok := mappings[macAddress]; ok {
+			deleted.Mappings = append(deleted.Mappings, m)
+			delete(mappings, macAddress)
+		}
+	}
+
+	// Clean up: Remove from other devices' dependencies
+	for mac, d := range s.devices {
+		if i := slices.Index(d.DependsOn, macAddress); i >= 0 {
+			d.DependsOn = slices.Delete(slices.Clone(d.DependsOn), i, i+1)
+			s.devices[mac] = d
+			deleted.Dependents = append(deleted.Dependents, mac)
+		}
+	}
+	slices.Sort(deleted.Dependents)
+
+	s.deletedDevices[macAddress] = deleted
+	s.recordRevisionLocked(macAddress, username, RevisionDeleted, device.Config(), device.Config())
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// GetDeletedDevicesForUser returns the devices in the trash the user owned, most recently deleted first.
+func (s *Store) GetDeletedDevicesForUser(username string) ([]DeletedDevice, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	devices := make([]DeletedDevice, 0)
+	for _, d := range s.deletedDevices {
+		if d.ownedBy(username) {
+			devices = append(devices, d)
+		}
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].DeletedAt.After(devices[j].DeletedAt)
+	})
+	return devices, nil
+}
+
+// RestoreDeletedDevice takes a device out of the trash. Users who had it get it back, and devices that
+// depended on it do so again. Groups and dependencies that were deleted in the meantime are dropped.
+func (s *Store) RestoreDeletedDevice(username, macAddress string) (*Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Only an owner may restore, and the MAC address must be free again
+	deleted, exists := s.deletedDevices[macAddress]
+	if !exists || !deleted.ownedBy(username) {
+		return nil, ErrDeletedDeviceNotFound
+	}
+	if _, exists := s.devices[macAddress]; exists {
+		return nil, ErrDeviceExists
+	}
+
+	// Action: Write device, mappings and dependencies back
+	device := deleted.Device
+	device.Status = StatusUnknown
+	device.DependsOn = slices.DeleteFunc(slices.Clone(device.DependsOn), func(dep string) bool {
+		_, exists := s.devices[dep]
+		return !exists
+	})
+	s.devices[macAddress] = device
+
+	for _, m := range deleted.Mappings {
+		if _, exists := s.users[m.Username]; !exists {
+			continue
+		}
+		if s.userDeviceMappings[m.Username] == nil {
+			s.userDeviceMappings[m.Username] = make(map[string]UserDeviceMapping)
+		}
+		if g, exists := s.groups[m.GroupID]; !exists || g.Username != m.Username {
+			m.GroupID = ""
+		}
+		m.Order = len(s.userDeviceMappings[m.Username]) // append to the end of the user's list
+		s.userDeviceMappings[m.Username][macAddress] = m
+	}
+
+	for _, mac := range deleted.Dependents {
+		d, exists := s.devices[mac]
+		if !exists || slices.Contains(d.DependsOn, macAddress) {
+			continue
+		}
+		// Skip dependencies that would now close a cycle
+		dependsOn := append(slices.Clone(d.DependsOn), macAddress)
+		if s.checkDependenciesLocked(mac, dependsOn) != nil {
+			continue
+		}
+		slices.Sort(dependsOn)
+		d.DependsOn = dependsOn
+		s.devices[mac] = d
+	}
+
+	delete(s.deletedDevices, macAddress)
+	s.recordRevisionLocked(macAddress, username, RevisionRecovered, deleted.Device.Config(), device.Config())
+
+	// Persistence: Flush to disk
+	return &device, s.flush()
+}
+
+// PurgeDeletedDevice removes a device from the trash for good, together with its revision history.
+func (s *Store) PurgeDeletedDevice(username, macAddress string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Only an owner may purge
+	deleted, exists := s.deletedDevices[macAddress]
+	if !exists || !deleted.ownedBy(username) {
+		return ErrDeletedDeviceNotFound
+	}
+
+	// Action: Delete from maps
+	s.purgeDeletedLocked(macAddress)
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// PurgeDeletedDevices removes devices deleted before the given time from the trash and returns how many.
+func (s *Store) PurgeDeletedDevices(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Action: Delete from maps
+	purged := 0
+	for mac, d := range s.deletedDevices {
+		if d.DeletedAt.Before(before) {
+			s.purgeDeletedLocked(mac)
+			purged++
+		}
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+
+	// Persistence: Flush to disk
+	return purged, s.flush()
+}
+
+// purgeDeletedLocked drops a trashed device and its history. Caller must hold s.mu.
+func (s *Store) purgeDeletedLocked(macAddress string) {
+	delete(s.deletedDevices, macAddress)
+	delete(s.deviceRevisions, macAddress)
+}
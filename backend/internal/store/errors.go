@@ -7,6 +7,27 @@ var (
 	ErrDeviceNotFound            = errors.New("device not found")
 	ErrUserExists                = errors.New("user already exists")
 	ErrDeviceExists              = errors.New("device already exists")
+	ErrDeviceRevisionNotFound    = errors.New("device revision not found")
+	ErrDeletedDeviceNotFound     = errors.New("deleted device not found")
 	ErrUserDeviceMappingExists   = errors.New("user-device mapping already exists")
 	ErrUserDeviceMappingNotFound = errors.New("user-device mapping not found")
+	ErrGroupNotFound             = errors.New("group not found")
+	ErrSceneNotFound             = errors.New("scene not found")
+	ErrDependencyCycle           = errors.New("device dependency cycle")
+	ErrScheduleNotFound          = errors.New("schedule not found")
+	ErrCalendarNotFound          = errors.New("calendar not found")
+	ErrAccessTokenNotFound       = errors.New("access token not found")
+	ErrSessionNotFound           = errors.New("session not found")
+	ErrRecoveryCodeNotFound      = errors.New("recovery code not found")
+	ErrCredentialNotFound        = errors.New("webauthn credential not found")
+	ErrCredentialExists          = errors.New("webauthn credential already registered")
+	ErrLastOwner                 = errors.New("device must keep at least one owner")
+	ErrShareLinkNotFound         = errors.New("share link not found")
+	ErrWakeRequestNotFound       = errors.New("wake request not found")
+	ErrWakeRequestPending        = errors.New("a wake request for this device is already pending")
+	ErrWakeRequestClosed         = errors.New("wake request is no longer pending")
+	ErrNotificationNotFound      = errors.New("notification not found")
+	ErrShareLinkInactive         = errors.New("share link expired, used up or revoked")
+	ErrMasterKeyRequired         = errors.New("database holds encrypted secrets, a master key is required")
+	ErrDataDirLocked             = errors.New("data directory is in use by another process, stop the server first")
 )
@@ -0,0 +1,143 @@
+package store
+
+import "sort"
+
+// Group is a user's folder of devices with its own display order.
+type Group struct {
+	ID       string `json:"id"`
+	Username string `json:"username"` // owner of the group
+	Name     string `json:"name"`
+	Order    int    `json:"order"` // display order of the group
+}
+
+func NewGroup(username, name string) *Group {
+	return &Group{
+		ID:       newID(),
+		Username: username,
+		Name:     name,
+	}
+}
+
+// GetGroupsForUser returns all groups owned by a username, sorted by Order, then Name.
+func (s *Store) GetGroupsForUser(username string) ([]Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	groups := make([]Group, 0)
+	for _, g := range s.groups {
+		if g.Username == username {
+			groups = append(groups, g)
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Order != groups[j].Order {
+			return groups[i].Order < groups[j].Order
+		}
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, nil
+}
+
+// GetGroupForUser returns a group only if it is owned by the given username.
+func (s *Store) GetGroupForUser(username, id string) (*Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	g, ok := s.groups[id]
+	if !ok || g.Username != username {
+		return nil, ErrGroupNotFound
+	}
+	return &g, nil
+}
+
+// CreateGroup adds a new group at the end of the owner's list.
+func (s *Store) CreateGroup(group *Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Owner must exist
+	if _, exists := s.users[group.Username]; !exists {
+		return ErrUserNotFound
+	}
+
+	// Append to the end of the user's list
+	group.Order = 0
+	for _, g := range s.groups {
+		if g.Username == group.Username {
+			group.Order++
+		}
+	}
+
+	// Action: Write to map
+	s.groups[group.ID] = *group
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// UpdateGroup replaces an existing group. Ownership cannot change.
+func (s *Store) UpdateGroup(group *Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and ownership
+	if g, exists := s.groups[group.ID]; !exists || g.Username != group.Username {
+		return ErrGroupNotFound
+	}
+
+	// Action: Write to map
+	s.groups[group.ID] = *group
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// DeleteGroup removes a group. Devices filed under it become ungrouped.
+func (s *Store) DeleteGroup(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and ownership
+	if g, exists := s.groups[id]; !exists || g.Username != username {
+		return ErrGroupNotFound
+	}
+
+	// Action: Delete from map
+	delete(s.groups, id)
+
+	// Clean up: Ungroup the owner's devices
+	for mac, m := range s.userDeviceMappings[username] {
+		if m.GroupID == id {
+			m.GroupID = ""
+			s.userDeviceMappings[username][mac] = m
+		}
+	}
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// ReorderGroupsForUser updates the order of a user's groups based on the provided list of IDs.
+// Every ID must belong to the user, otherwise nothing is changed.
+func (s *Store) ReorderGroupsForUser(username string, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Validate ownership of every group before writing anything
+	for _, id := range ids {
+		if g, exists := s.groups[id]; !exists || g.Username != username {
+			return ErrGroupNotFound
+		}
+	}
+
+	// Action: Write order to map
+	for i, id := range ids {
+		g := s.groups[id]
+		g.Order = i
+		s.groups[id] = g
+	}
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
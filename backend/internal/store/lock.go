@@ -0,0 +1,22 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const lockFileName = "wolite.lock"
+
+// LockDataDir takes the lock file in the data directory. The server holds it while it runs, and the
+// maintenance commands that write the data take it as well, so they refuse to run next to the server.
+// The lock is released by the returned func or when the process exits.
+func LockDataDir(dir string) (unlock func() error, err error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := lockFile(filepath.Join(dir, lockFileName))
+	if err != nil {
+		return nil, err
+	}
+	return f.Close, nil
+}
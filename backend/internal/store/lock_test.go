@@ -0,0 +1,22 @@
+package store
+
+import "testing"
+
+func TestLockDataDir(t *testing.T) {
+	dir := t.TempDir()
+	unlock, err := LockDataDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LockDataDir(dir); err != ErrDataDirLocked {
+		t.Fatalf("expected ErrDataDirLocked while the lock is held, got %v", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = LockDataDir(dir)
+	if err != nil {
+		t.Fatalf("expected the lock to be free again: %v", err)
+	}
+	unlock()
+}
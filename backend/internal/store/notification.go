@@ -0,0 +1,115 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// maxNotificationsPerUser bounds the inbox, the oldest notifications are dropped first.
+const maxNotificationsPerUser = 100
+
+type NotificationKind string
+
+const (
+	NotifyWakeRequested NotificationKind = "wake_requested" // to owners
+	NotifyWakeApproved  NotificationKind = "wake_approved"  // to the requester
+	NotifyWakeDenied    NotificationKind = "wake_denied"    // to the requester
+)
+
+// Notification is an in-app message shown to a user.
+type Notification struct {
+	ID        string           `json:"id"`
+	Username  string           `json:"username"`
+	Kind      NotificationKind `json:"kind"`
+	Message   string           `json:"message"`
+	RefID     string           `json:"ref_id,omitempty"` // e.g. the wake request
+	CreatedAt time.Time        `json:"created_at"`
+	ReadAt    *time.Time       `json:"read_at,omitempty"`
+}
+
+func NewNotification(username string, kind NotificationKind, message, refID string) *Notification {
+	return &Notification{
+		ID:        newID(),
+		Username:  username,
+		Kind:      kind,
+		Message:   message,
+		RefID:     refID,
+		CreatedAt: time.Now(),
+	}
+}
+
+// GetNotificationsForUser returns a user's notifications, newest first.
+func (s *Store) GetNotificationsForUser(username string) ([]Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	notifications := make([]Notification, 0)
+	for _, n := range s.notifications {
+		if n.Username == username {
+			notifications = append(notifications, n)
+		}
+	}
+
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].CreatedAt.After(notifications[j].CreatedAt)
+	})
+	return notifications, nil
+}
+
+// CreateNotification adds a notification, dropping the user's oldest beyond the limit.
+func (s *Store) CreateNotification(notification *Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Recipient must exist
+	if _, exists := s.users[notification.Username]; !exists {
+		return ErrUserNotFound
+	}
+
+	// Action: Write to map
+	s.notifications[notification.ID] = *notification
+
+	// Clean up: Keep the newest notifications of the user
+	own := make([]Notification, 0)
+	for _, n := range s.notifications {
+		if n.Username == notification.Username {
+			own = append(own, n)
+		}
+	}
+	if len(own) > maxNotificationsPerUser {
+		sort.Slice(own, func(i, j int) bool {
+			return own[i].CreatedAt.After(own[j].CreatedAt)
+		})
+		for _, n := range own[maxNotificationsPerUser:] {
+			delete(s.notifications, n.ID)
+		}
+	}
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// MarkNotificationsRead marks a user's notification as read, or all of them when id is empty.
+func (s *Store) MarkNotificationsRead(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and ownership
+	if id != "" {
+		if n, exists := s.notifications[id]; !exists || n.Username != username {
+			return ErrNotificationNotFound
+		}
+	}
+
+	// Action: Write to map
+	now := time.Now()
+	for nid, n := range s.notifications {
+		if n.Username == username && n.ReadAt == nil && (id == "" || nid == id) {
+			n.ReadAt = &now
+			s.notifications[nid] = n
+		}
+	}
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
@@ -0,0 +1,121 @@
+package store
+
+import "sort"
+
+type StepType string
+
+const (
+	StepWake  StepType = "wake"  // send a magic packet
+	StepPower StepType = "power" // send a power action through the companion
+)
+
+// SceneStep is a single action of a scene. Steps run in order.
+type SceneStep struct {
+	Type            StepType `json:"type"`
+	MACAddress      string   `json:"mac_address"`
+	Action          string   `json:"action,omitempty"`            // companion power action, for power steps
+	DelaySeconds    int      `json:"delay_seconds,omitempty"`     // pause before the step runs
+	WaitOnline      bool     `json:"wait_online,omitempty"`       // for wake steps: wait until the device reports online
+	TimeoutSeconds  int      `json:"timeout_seconds,omitempty"`   // max wait for online, defaults to 5 minutes
+	ContinueOnError bool     `json:"continue_on_error,omitempty"` // keep going if this step fails
+}
+
+// Scene is a named, ordered list of power steps owned by a user.
+type Scene struct {
+	ID          string      `json:"id"`
+	Username    string      `json:"username"` // owner of the scene
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Steps       []SceneStep `json:"steps"`
+}
+
+func NewScene(username, name, description string, steps []SceneStep) *Scene {
+	return &Scene{
+		ID:          newID(),
+		Username:    username,
+		Name:        name,
+		Description: description,
+		Steps:       steps,
+	}
+}
+
+// GetScenesForUser returns all scenes owned by a username, sorted by Name.
+func (s *Store) GetScenesForUser(username string) ([]Scene, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	scenes := make([]Scene, 0)
+	for _, sc := range s.scenes {
+		if sc.Username == username {
+			scenes = append(scenes, sc)
+		}
+	}
+
+	sort.Slice(scenes, func(i, j int) bool {
+		return scenes[i].Name < scenes[j].Name
+	})
+	return scenes, nil
+}
+
+// GetSceneForUser returns a scene only if it is owned by the given username.
+func (s *Store) GetSceneForUser(username, id string) (*Scene, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sc, ok := s.scenes[id]
+	if !ok || sc.Username != username {
+		return nil, ErrSceneNotFound
+	}
+	return &sc, nil
+}
+
+// CreateScene adds a new scene.
+func (s *Store) CreateScene(scene *Scene) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Owner must exist
+	if _, exists := s.users[scene.Username]; !exists {
+		return ErrUserNotFound
+	}
+
+	// Action: Write to map
+	s.scenes[scene.ID] = *scene
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// UpdateScene replaces an existing scene. Ownership cannot change.
+func (s *Store) UpdateScene(scene *Scene) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and ownership
+	if sc, exists := s.scenes[scene.ID]; !exists || sc.Username != scene.Username {
+		return ErrSceneNotFound
+	}
+
+	// Action: Write to map
+	s.scenes[scene.ID] = *scene
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// DeleteScene removes a scene.
+func (s *Store) DeleteScene(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and ownership
+	if sc, exists := s.scenes[id]; !exists || sc.Username != username {
+		return ErrSceneNotFound
+	}
+
+	// Action: Delete from map
+	delete(s.scenes, id)
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
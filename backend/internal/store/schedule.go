@@ -0,0 +1,227 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+type TargetType string
+
+const (
+	TargetDevice TargetType = "device"
+	TargetGroup  TargetType = "group"
+	TargetScene  TargetType = "scene"
+)
+
+type MissedRunPolicy string
+
+const (
+	MissedSkip    MissedRunPolicy = "skip"     // record missed runs but do not execute them
+	MissedRunOnce MissedRunPolicy = "run_once" // execute once to catch up, however many runs were missed
+)
+
+const maxScheduleRuns = 50 // history entries kept per schedule
+
+// Schedule fires a wake or companion power action on a device, group or scene according to a cron expression.
+type Schedule struct {
+	ID              string          `json:"id"`
+	Username        string          `json:"username"` // owner of the schedule
+	Name            string          `json:"name"`
+	Cron            string          `json:"cron"`     // 5-field cron expression
+	Timezone        string          `json:"timezone"` // IANA time zone the cron expression is evaluated in
+	TargetType      TargetType      `json:"target_type"`
+	TargetID        string          `json:"target_id"`        // MAC address, group ID or scene ID
+	Action          string          `json:"action,omitempty"` // "wake" or a companion power action, unused for scenes
+	Enabled         bool            `json:"enabled"`
+	MissedRunPolicy MissedRunPolicy `json:"missed_run_policy"`
+	CalendarIDs     []string        `json:"calendar_ids,omitempty"` // calendars that skip or force runs
+	LastRunAt       *time.Time      `json:"last_run_at,omitempty"`  // last scheduled time that was handled
+	UpdatedAt       time.Time       `json:"updated_at"`             // runs scheduled before this are never caught up
+}
+
+// ScheduleRun is a history entry of a schedule firing.
+type ScheduleRun struct {
+	ID           string    `json:"id"`
+	ScheduleID   string    `json:"schedule_id"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+	StartedAt    time.Time `json:"started_at"`
+	Missed       bool      `json:"missed,omitempty"` // fired late because the scheduler was not running
+	Status       string    `json:"status"`           // skipped, running, succeeded, failed, cancelled
+	Message      string    `json:"message,omitempty"`
+	RunID        string    `json:"run_id,omitempty"` // background run executing the target
+}
+
+func NewSchedule(username, name string) *Schedule {
+	return &Schedule{
+		ID:        newID(),
+		Username:  username,
+		Name:      name,
+		UpdatedAt: time.Now(),
+	}
+}
+
+func NewScheduleRun(scheduleID string, scheduledFor time.Time) *ScheduleRun {
+	return &ScheduleRun{
+		ID:           newID(),
+		ScheduleID:   scheduleID,
+		ScheduledFor: scheduledFor,
+		StartedAt:    time.Now(),
+	}
+}
+
+// GetAllSchedules returns a copy of all schedules in the store.
+func (s *Store) GetAllSchedules() ([]Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	schedules := make([]Schedule, 0, len(s.schedules))
+	for _, sc := range s.schedules {
+		schedules = append(schedules, sc)
+	}
+	return schedules, nil
+}
+
+// GetSchedulesForUser returns all schedules owned by a username, sorted by Name.
+func (s *Store) GetSchedulesForUser(username string) ([]Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	schedules := make([]Schedule, 0)
+	for _, sc := range s.schedules {
+		if sc.Username == username {
+			schedules = append(schedules, sc)
+		}
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Name < schedules[j].Name
+	})
+	return schedules, nil
+}
+
+// GetScheduleForUser returns a schedule only if it is owned by the given username.
+func (s *Store) GetScheduleForUser(username, id string) (*Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sc, ok := s.schedules[id]
+	if !ok || sc.Username != username {
+		return nil, ErrScheduleNotFound
+	}
+	return &sc, nil
+}
+
+// CreateSchedule adds a new schedule.
+func (s *Store) CreateSchedule(schedule *Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Owner must exist
+	if _, exists := s.users[schedule.Username]; !exists {
+		return ErrUserNotFound
+	}
+
+	// Action: Write to map
+	s.schedules[schedule.ID] = *schedule
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// UpdateSchedule replaces an existing schedule. Ownership cannot change.
+func (s *Store) UpdateSchedule(schedule *Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and ownership
+	if sc, exists := s.schedules[schedule.ID]; !exists || sc.Username != schedule.Username {
+		return ErrScheduleNotFound
+	}
+
+	// Action: Write to map
+	s.schedules[schedule.ID] = *schedule
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// SetScheduleLastRun records the last scheduled time handled, without touching the rest of the schedule.
+func (s *Store) SetScheduleLastRun(id string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence
+	sc, exists := s.schedules[id]
+	if !exists {
+		return ErrScheduleNotFound
+	}
+
+	// Action: Write to map
+	sc.LastRunAt = &t
+	s.schedules[id] = sc
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// DeleteSchedule removes a schedule and its run history.
+func (s *Store) DeleteSchedule(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and ownership
+	if sc, exists := s.schedules[id]; !exists || sc.Username != username {
+		return ErrScheduleNotFound
+	}
+
+	// Action: Delete from map
+	delete(s.schedules, id)
+	delete(s.scheduleRuns, id)
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// GetScheduleRuns returns the run history of a schedule, newest first.
+func (s *Store) GetScheduleRuns(scheduleID string) ([]ScheduleRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	runs := make([]ScheduleRun, len(s.scheduleRuns[scheduleID]))
+	copy(runs, s.scheduleRuns[scheduleID])
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	return runs, nil
+}
+
+// SaveScheduleRun adds or updates a history entry, keeping only the most recent entries per schedule.
+func (s *Store) SaveScheduleRun(run ScheduleRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Schedule must exist
+	if _, exists := s.schedules[run.ScheduleID]; !exists {
+		return ErrScheduleNotFound
+	}
+
+	// Action: Update in place or append, dropping the oldest entries
+	runs := s.scheduleRuns[run.ScheduleID]
+	updated := false
+	for i := range runs {
+		if runs[i].ID == run.ID {
+			runs[i] = run
+			updated = true
+		}
+	}
+	if !updated {
+		runs = append(runs, run)
+	}
+	if len(runs) > maxScheduleRuns {
+		runs = runs[len(runs)-maxScheduleRuns:]
+	}
+	s.scheduleRuns[run.ScheduleID] = runs
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"wolite/internal/keyring"
+)
+
+const encryptionVersion = 1
+
+// encryptionHeader is stored in the database once its secret fields are encrypted.
+type encryptionHeader struct {
+	Version int    `json:"version"`
+	DataKey []byte `json:"data_key"` // data key wrapped by the master key
+}
+
+// Sealed values are bound to their field and record, so a value copied elsewhere does not decrypt.
+func companionTokenContext(macAddress string) string {
+	return "device:" + macAddress + ":companion_token"
+}
+func otpContext(username string) string        { return "user:" + username + ":otp" }
+func pendingOTPContext(username string) string { return "user:" + username + ":pending_otp" }
+
+// IsEncrypted reports whether the database at path holds encrypted secrets. A missing database is not
+// encrypted.
+func IsEncrypted(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	var file struct {
+		Encryption *encryptionHeader `json:"encryption"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return false, err
+	}
+	return file.Encryption != nil, nil
+}
+
+// openEnvelope sets up encryption for a database loaded with the given header. Without a header the
+// database is new or from before encryption, and gets a fresh data key when a master key is given.
+func (s *Store) openEnvelope(header *encryptionHeader, master []byte) error {
+	if header == nil {
+		if master == nil {
+			return nil
+		}
+		envelope, err := keyring.NewEnvelope()
+		if err != nil {
+			return err
+		}
+		wrapped, err := envelope.Wrap(master)
+		if err != nil {
+			return err
+		}
+		s.envelope, s.wrappedKey = envelope, wrapped
+		return nil
+	}
+
+	if header.Version != encryptionVersion {
+		return errors.New("unsupported database encryption version")
+	}
+	if master == nil {
+		return ErrMasterKeyRequired
+	}
+	envelope, err := keyring.UnwrapEnvelope(header.DataKey, master)
+	if err != nil {
+		return err
+	}
+	s.envelope, s.wrappedKey = envelope, header.DataKey
+	return nil
+}
+
+// RotateMasterKey wraps the data key with a new master key and writes it to disk. The encrypted values
+// stay as they are. The store must have been opened with a master key.
+func (s *Store) RotateMasterKey(master []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Only an encrypted store has a data key
+	if s.envelope == nil {
+		return ErrMasterKeyRequired
+	}
+
+	// Action: Rewrap the data key
+	wrapped, err := s.envelope.Wrap(master)
+	if err != nil {
+		return err
+	}
+	old := s.wrappedKey
+	s.wrappedKey = wrapped
+
+	// Persistence: Flush to disk
+	if err := s.flush(); err != nil {
+		s.wrappedKey = old
+		return err
+	}
+	return nil
+}
+
+// sealUser returns the user as stored on disk, with secrets encrypted. Caller must hold s.mu.
+func (s *Store) sealUser(u User) User {
+	if s.envelope != nil {
+		u.OTP = s.envelope.Seal(u.OTP, otpContext(u.Username))
+		u.PendingOTP = s.envelope.Seal(u.PendingOTP, pendingOTPContext(u.Username))
+	}
+	return u
+}
+
+// sealDevice returns the device as stored on disk, with secrets encrypted. Caller must hold s.mu.
+func (s *Store) sealDevice(d Device) Device {
+	if s.envelope != nil {
+		d.CompanionToken = s.envelope.Seal(d.CompanionToken, companionTokenContext(d.MACAddress))
+	}
+	return d
+}
+
+// openUser decrypts the secrets of a user read from disk. It reports whether a secret was still stored
+// in plaintext.
+func (s *Store) openUser(u *User) (bool, error) {
+	otp, plainOTP, err := s.openSecret(u.OTP, otpContext(u.Username))
+	if err != nil {
+		return false, err
+	}
+	pending, plainPending, err := s.openSecret(u.PendingOTP, pendingOTPContext(u.Username))
+	if err != nil {
+		return false, err
+	}
+	u.OTP, u.PendingOTP = otp, pending
+	return plainOTP || plainPending, nil
+}
+
+// openDevice decrypts the secrets of a device read from disk. It reports whether a secret was still
+// stored in plaintext.
+func (s *Store) openDevice(d *Device) (bool, error) {
+	token, plain, err := s.openSecret(d.CompanionToken, companionTokenContext(d.MACAddress))
+	if err != nil {
+		return false, err
+	}
+	d.CompanionToken = token
+	return plain, nil
+}
+
+func (s *Store) openSecret(value, context string) (string, bool, error) {
+	if !keyring.IsSealed(value) {
+		return value, value != "", nil
+	}
+	if s.envelope == nil {
+		return "", false, ErrMasterKeyRequired
+	}
+	plaintext, err := s.envelope.Open(value, context)
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %w", context, err)
+	}
+	return plaintext, false, nil
+}
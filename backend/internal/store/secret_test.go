@@ -0,0 +1,82 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"wolite/internal/keyring"
+)
+
+func testMasterKey(t *testing.T) []byte {
+	t.Helper()
+	encoded, err := keyring.GenerateMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := keyring.ReadMasterKey(encoded, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSecretsEncryptedAtRest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wolite.json")
+
+	// A store from before encryption holds its secrets in plaintext
+	plain, err := New(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := NewUserWithOTP("alice", "hash", "otp-secret")
+	if err := plain.CreateUser(*u); err != nil {
+		t.Fatal(err)
+	}
+	device := NewDevice("aa", "pc", "", "10.0.0.2", "10.0.0.255:9", StatusUnknown)
+	device.CompanionToken = "companion-secret"
+	if err := plain.CreateDeviceForUser("alice", device); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening it with a master key encrypts them right away
+	master := testMasterKey(t)
+	s, err := New(path, master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if bytes.Contains(data, []byte("otp-secret")) || bytes.Contains(data, []byte("companion-secret")) {
+		t.Fatal("secrets still stored in plaintext")
+	}
+	if encrypted, err := IsEncrypted(path); err != nil || !encrypted {
+		t.Fatalf("expected the database to be encrypted, got %v, %v", encrypted, err)
+	}
+	if got, _ := s.GetDeviceByMacAddress("aa"); got.CompanionToken != "companion-secret" {
+		t.Errorf("expected the decrypted token in memory, got %q", got.CompanionToken)
+	}
+
+	if _, err := New(path, nil); err != ErrMasterKeyRequired {
+		t.Errorf("expected ErrMasterKeyRequired without a key, got %v", err)
+	}
+	if _, err := New(path, testMasterKey(t)); !errors.Is(err, keyring.ErrWrongKey) {
+		t.Errorf("expected ErrWrongKey with another key, got %v", err)
+	}
+
+	// After a rotation only the new key opens the database
+	rotated := testMasterKey(t)
+	if err := s.RotateMasterKey(rotated); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(path, master); !errors.Is(err, keyring.ErrWrongKey) {
+		t.Errorf("expected the old key to be rejected, got %v", err)
+	}
+	reopened, err := New(path, rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reopened.FindUser("alice"); got.OTP != "otp-secret" {
+		t.Errorf("expected the OTP secret after rotation, got %q", got.OTP)
+	}
+}
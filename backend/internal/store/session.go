@@ -0,0 +1,163 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// Session is a browser login. Its ID is the jti claim of the session JWT; a JWT whose session
+// no longer exists is rejected, which is how sessions are revoked.
+type Session struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	CSRFToken  string    `json:"csrf_token,omitempty"` // must be echoed in X-CSRF-Token on state-changing requests
+}
+
+func NewSession(username, userAgent, ip string, expiresAt time.Time) *Session {
+	now := time.Now()
+	return &Session{
+		ID:         newID(),
+		Username:   username,
+		CreatedAt:  now,
+		ExpiresAt:  expiresAt,
+		LastSeenAt: now,
+		UserAgent:  userAgent,
+		IP:         ip,
+	}
+}
+
+// GetSession returns a session by ID if it has not expired.
+func (s *Store) GetSession(id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sess, ok := s.sessions[id]
+	if !ok || !time.Now().Before(sess.ExpiresAt) {
+		return nil, ErrSessionNotFound
+	}
+	return &sess, nil
+}
+
+// GetSessionsForUser returns the active sessions of a user, most recently used first.
+func (s *Store) GetSessionsForUser(username string) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	sessions := make([]Session, 0)
+	for _, sess := range s.sessions {
+		if sess.Username == username && now.Before(sess.ExpiresAt) {
+			sessions = append(sessions, sess)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+// CreateSession adds a new session and drops expired ones.
+func (s *Store) CreateSession(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Owner must exist
+	if _, exists := s.users[session.Username]; !exists {
+		return ErrUserNotFound
+	}
+
+	// Action: Write to map, pruning expired sessions
+	now := time.Now()
+	for id, sess := range s.sessions {
+		if !now.Before(sess.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[session.ID] = *session
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// TouchSession records the time a session was last used.
+func (s *Store) TouchSession(id string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence
+	sess, exists := s.sessions[id]
+	if !exists {
+		return ErrSessionNotFound
+	}
+
+	// Action: Write to map
+	sess.LastSeenAt = t
+	s.sessions[id] = sess
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// SetSessionCSRFToken sets the CSRF token of a session created before sessions had one.
+func (s *Store) SetSessionCSRFToken(id, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence
+	sess, exists := s.sessions[id]
+	if !exists {
+		return ErrSessionNotFound
+	}
+
+	// Action: Write to map
+	sess.CSRFToken = token
+	s.sessions[id] = sess
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// DeleteSession revokes a session of the given user.
+func (s *Store) DeleteSession(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and ownership
+	if sess, exists := s.sessions[id]; !exists || sess.Username != username {
+		return ErrSessionNotFound
+	}
+
+	// Action: Delete from map
+	delete(s.sessions, id)
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// DeleteSessionsForUser revokes all sessions of a user except the one with the given ID (empty for all).
+// It returns the number of sessions revoked.
+func (s *Store) DeleteSessionsForUser(username, exceptID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Action: Delete from map
+	revoked := 0
+	for id, sess := range s.sessions {
+		if sess.Username == username && id != exceptID {
+			delete(s.sessions, id)
+			revoked++
+		}
+	}
+	if revoked == 0 {
+		return 0, nil
+	}
+
+	// Persistence: Flush to disk
+	return revoked, s.flush()
+}
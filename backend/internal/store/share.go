@@ -0,0 +1,209 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// MaxSharePINFailures is the number of wrong PINs after which a share link stops working.
+const MaxSharePINFailures = 5
+
+// shareLinkRetention is how long expired, used up or revoked links stay listed with their use log.
+const shareLinkRetention = 30 * 24 * time.Hour
+
+type ShareLinkStatus string
+
+const (
+	ShareActive  ShareLinkStatus = "active"
+	ShareExpired ShareLinkStatus = "expired"
+	ShareUsedUp  ShareLinkStatus = "used_up"
+	ShareRevoked ShareLinkStatus = "revoked"
+	ShareLocked  ShareLinkStatus = "locked" // too many wrong PINs
+)
+
+// ShareLink lets someone without an account wake one device of its owner. The link carries the ID and a
+// signature made with the signing key KeyID; the optional PIN is stored as a password hash.
+type ShareLink struct {
+	ID          string         `json:"id"`
+	Username    string         `json:"username"` // owner of the link
+	Name        string         `json:"name"`     // who the link is for, e.g. "Plumber"
+	MACAddress  string         `json:"mac_address"`
+	KeyID       string         `json:"key_id,omitempty"`
+	PIN         string         `json:"pin,omitempty"` // argon2id hash, empty when no PIN is needed
+	PINFailures int            `json:"pin_failures,omitempty"`
+	MaxUses     int            `json:"max_uses"`
+	Uses        []ShareLinkUse `json:"uses"`
+	CreatedAt   time.Time      `json:"created_at"`
+	ExpiresAt   time.Time      `json:"expires_at"`
+	RevokedAt   *time.Time     `json:"revoked_at,omitempty"`
+}
+
+// ShareLinkUse records one wake through a share link.
+type ShareLinkUse struct {
+	Time      time.Time `json:"time"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+func NewShareLink(username, name, macAddress, keyID string, maxUses int, expiresAt time.Time) *ShareLink {
+	return &ShareLink{
+		ID:         newID(),
+		Username:   username,
+		Name:       name,
+		MACAddress: macAddress,
+		KeyID:      keyID,
+		MaxUses:    maxUses,
+		Uses:       []ShareLinkUse{},
+		CreatedAt:  time.Now(),
+		ExpiresAt:  expiresAt,
+	}
+}
+
+// CurrentStatus reports whether the link can still be used, and why not otherwise.
+func (l *ShareLink) CurrentStatus(now time.Time) ShareLinkStatus {
+	switch {
+	case l.RevokedAt != nil:
+		return ShareRevoked
+	case l.PINFailures >= MaxSharePINFailures:
+		return ShareLocked
+	case !now.Before(l.ExpiresAt):
+		return ShareExpired
+	case len(l.Uses) >= l.MaxUses:
+		return ShareUsedUp
+	}
+	return ShareActive
+}
+
+// finishedAt returns when the link stopped working, or the zero time while it is active.
+func (l *ShareLink) finishedAt(now time.Time) time.Time {
+	switch l.CurrentStatus(now) {
+	case ShareActive:
+		return time.Time{}
+	case ShareRevoked:
+		return *l.RevokedAt
+	case ShareUsedUp:
+		return l.Uses[len(l.Uses)-1].Time
+	case ShareExpired:
+		return l.ExpiresAt
+	}
+	// Locked links have no timestamp of their last failure, keep them until they expire
+	return l.ExpiresAt
+}
+
+// GetShareLinksForUser returns all share links owned by a username, newest first.
+func (s *Store) GetShareLinksForUser(username string) ([]ShareLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	links := make([]ShareLink, 0)
+	for _, l := range s.shareLinks {
+		if l.Username == username {
+			links = append(links, l)
+		}
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt.After(links[j].CreatedAt)
+	})
+	return links, nil
+}
+
+// GetShareLink returns a share link by ID.
+func (s *Store) GetShareLink(id string) (*ShareLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l, exists := s.shareLinks[id]
+	if !exists {
+		return nil, ErrShareLinkNotFound
+	}
+	return &l, nil
+}
+
+// CreateShareLink adds a new share link.
+func (s *Store) CreateShareLink(link *ShareLink) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Owner must exist
+	if _, exists := s.users[link.Username]; !exists {
+		return ErrUserNotFound
+	}
+
+	// Action: Write to map, pruning links that stopped working a while ago
+	now := time.Now()
+	for id, l := range s.shareLinks {
+		if end := l.finishedAt(now); !end.IsZero() && now.Sub(end) > shareLinkRetention {
+			delete(s.shareLinks, id)
+		}
+	}
+	s.shareLinks[link.ID] = *link
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// UseShareLink records a use of an active share link. The check and the record happen under one lock,
+// so a link cannot be used more often than allowed by concurrent requests.
+func (s *Store) UseShareLink(id string, use ShareLinkUse) (*ShareLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and that the link is still usable
+	link, exists := s.shareLinks[id]
+	if !exists {
+		return nil, ErrShareLinkNotFound
+	}
+	if link.CurrentStatus(use.Time) != ShareActive {
+		return nil, ErrShareLinkInactive
+	}
+
+	// Action: Write to map
+	link.Uses = append(link.Uses, use)
+	s.shareLinks[id] = link
+
+	// Persistence: Flush to disk
+	return &link, s.flush()
+}
+
+// FailShareLinkPIN counts a wrong PIN and returns the number of attempts left.
+func (s *Store) FailShareLinkPIN(id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence
+	link, exists := s.shareLinks[id]
+	if !exists {
+		return 0, ErrShareLinkNotFound
+	}
+
+	// Action: Write to map
+	link.PINFailures++
+	s.shareLinks[id] = link
+
+	// Persistence: Flush to disk
+	return max(MaxSharePINFailures-link.PINFailures, 0), s.flush()
+}
+
+// RevokeShareLink stops a share link from working. It stays listed with its use log.
+func (s *Store) RevokeShareLink(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and ownership
+	link, exists := s.shareLinks[id]
+	if !exists || link.Username != username {
+		return ErrShareLinkNotFound
+	}
+	if link.RevokedAt != nil {
+		return nil
+	}
+
+	// Action: Write to map
+	now := time.Now()
+	link.RevokedAt = &now
+	s.shareLinks[id] = link
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
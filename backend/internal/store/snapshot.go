@@ -0,0 +1,420 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Snapshot is the state of the store, as written to disk and as backed up and restored. Sessions are
+// left out: they are tied to the signing keys of the host that issued them.
+type Snapshot struct {
+	Users              []User              `json:"users"`
+	Devices            []Device            `json:"devices"`
+	UserDeviceMappings []UserDeviceMapping `json:"user_device_mappings"`
+	Groups             []Group             `json:"groups"`
+	Scenes             []Scene             `json:"scenes"`
+	Schedules          []Schedule          `json:"schedules"`
+	ScheduleRuns       []ScheduleRun       `json:"schedule_runs"`
+	Calendars          []Calendar          `json:"calendars"`
+	AccessTokens       []AccessToken       `json:"access_tokens"`
+	ShareLinks         []ShareLink         `json:"share_links"`
+	WakeRequests       []WakeRequest       `json:"wake_requests"`
+	Notifications      []Notification      `json:"notifications"`
+	DeviceRevisions    []DeviceRevision    `json:"device_revisions"`
+	DeletedDevices     []DeletedDevice     `json:"deleted_devices"`
+}
+
+type ImportMode string
+
+const (
+	ImportMerge   ImportMode = "merge"   // add records that do not exist yet, existing ones are kept
+	ImportReplace ImportMode = "replace" // replace everything
+)
+
+// ImportResult counts the records of a snapshot by collection: the ones imported, and the ones a merge
+// skipped because a record with the same key exists.
+type ImportResult struct {
+	Imported map[string]int `json:"imported"`
+	Skipped  map[string]int `json:"skipped"`
+}
+
+// InvalidSnapshotError lists the broken references of a snapshot.
+type InvalidSnapshotError struct {
+	Problems []string
+}
+
+func (e *InvalidSnapshotError) Error() string {
+	const shown = 10
+	if len(e.Problems) > shown {
+		return fmt.Sprintf("invalid snapshot: %s; and %d more", strings.Join(e.Problems[:shown], "; "), len(e.Problems)-shown)
+	}
+	return "invalid snapshot: " + strings.Join(e.Problems, "; ")
+}
+
+// Snapshot returns a copy of the state of the store, with secrets in plaintext.
+func (s *Store) Snapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshotLocked()
+}
+
+// snapshotLocked converts the maps to slices. Caller must hold s.mu.
+func (s *Store) snapshotLocked() Snapshot {
+	snap := Snapshot{
+		Users:              make([]User, 0, len(s.users)),
+		Devices:            make([]Device, 0, len(s.devices)),
+		UserDeviceMappings: make([]UserDeviceMapping, 0, len(s.userDeviceMappings)),
+		Groups:             make([]Group, 0, len(s.groups)),
+		Scenes:             make([]Scene, 0, len(s.scenes)),
+		Schedules:          make([]Schedule, 0, len(s.schedules)),
+		ScheduleRuns:       make([]ScheduleRun, 0),
+		Calendars:          make([]Calendar, 0, len(s.calendars)),
+		AccessTokens:       make([]AccessToken, 0, len(s.accessTokens)),
+		ShareLinks:         make([]ShareLink, 0, len(s.shareLinks)),
+		WakeRequests:       make([]WakeRequest, 0, len(s.wakeRequests)),
+		Notifications:      make([]Notification, 0, len(s.notifications)),
+		DeviceRevisions:    make([]DeviceRevision, 0),
+		DeletedDevices:     make([]DeletedDevice, 0, len(s.deletedDevices)),
+	}
+
+	for _, u := range s.users {
+		snap.Users = append(snap.Users, u)
+	}
+	for _, d := range s.devices {
+		snap.Devices = append(snap.Devices, d)
+	}
+	for _, mappings := range s.userDeviceMappings {
+		for _, m := range mappings {
+			snap.UserDeviceMappings = append(snap.UserDeviceMappings, m)
+		}
+	}
+	for _, g := range s.groups {
+		snap.Groups = append(snap.Groups, g)
+	}
+	for _, sc := range s.scenes {
+		snap.Scenes = append(snap.Scenes, sc)
+	}
+	for _, sc := range s.schedules {
+		snap.Schedules = append(snap.Schedules, sc)
+	}
+	for _, runs := range s.scheduleRuns {
+		snap.ScheduleRuns = append(snap.ScheduleRuns, runs...)
+	}
+	for _, c := range s.calendars {
+		snap.Calendars = append(snap.Calendars, c)
+	}
+	for _, t := range s.accessTokens {
+		snap.AccessTokens = append(snap.AccessTokens, t)
+	}
+	for _, l := range s.shareLinks {
+		snap.ShareLinks = append(snap.ShareLinks, l)
+	}
+	for _, r := range s.wakeRequests {
+		snap.WakeRequests = append(snap.WakeRequests, r)
+	}
+	for _, n := range s.notifications {
+		snap.Notifications = append(snap.Notifications, n)
+	}
+	for _, revs := range s.deviceRevisions {
+		snap.DeviceRevisions = append(snap.DeviceRevisions, revs...)
+	}
+	for _, d := range s.deletedDevices {
+		snap.DeletedDevices = append(snap.DeletedDevices, d)
+	}
+	return snap
+}
+
+// hydrateLocked replaces the maps, except sessions, with the snapshot. Caller must hold s.mu.
+func (s *Store) hydrateLocked(snap Snapshot) {
+	s.users = make(map[string]User, len(snap.Users))
+	for _, u := range snap.Users {
+		s.users[u.Username] = u
+	}
+
+	s.devices = make(map[string]Device, len(snap.Devices))
+	for _, d := range snap.Devices {
+		s.devices[d.MACAddress] = d
+	}
+
+	s.userDeviceMappings = make(map[string]map[string]UserDeviceMapping)
+	for _, m := range snap.UserDeviceMappings {
+		if s.userDeviceMappings[m.Username] == nil {
+			s.userDeviceMappings[m.Username] = make(map[string]UserDeviceMapping)
+		}
+		s.userDeviceMappings[m.Username][m.MACAddress] = m
+	}
+
+	s.groups = make(map[string]Group, len(snap.Groups))
+	for _, g := range snap.Groups {
+		s.groups[g.ID] = g
+	}
+
+	s.scenes = make(map[string]Scene, len(snap.Scenes))
+	for _, sc := range snap.Scenes {
+		s.scenes[sc.ID] = sc
+	}
+
+	s.schedules = make(map[string]Schedule, len(snap.Schedules))
+	for _, sc := range snap.Schedules {
+		s.schedules[sc.ID] = sc
+	}
+
+	s.scheduleRuns = make(map[string][]ScheduleRun)
+	for _, r := range snap.ScheduleRuns {
+		s.scheduleRuns[r.ScheduleID] = append(s.scheduleRuns[r.ScheduleID], r)
+	}
+
+	s.calendars = make(map[string]Calendar, len(snap.Calendars))
+	for _, c := range snap.Calendars {
+		s.calendars[c.ID] = c
+	}
+
+	s.accessTokens = make(map[string]AccessToken, len(snap.AccessTokens))
+	for _, t := range snap.AccessTokens {
+		s.accessTokens[t.ID] = t
+	}
+
+	s.shareLinks = make(map[string]ShareLink, len(snap.ShareLinks))
+	for _, l := range snap.ShareLinks {
+		s.shareLinks[l.ID] = l
+	}
+
+	s.wakeRequests = make(map[string]WakeRequest, len(snap.WakeRequests))
+	for _, r := range snap.WakeRequests {
+		s.wakeRequests[r.ID] = r
+	}
+
+	s.notifications = make(map[string]Notification, len(snap.Notifications))
+	for _, n := range snap.Notifications {
+		s.notifications[n.ID] = n
+	}
+
+	s.deviceRevisions = make(map[string][]DeviceRevision)
+	for _, r := range snap.DeviceRevisions {
+		s.deviceRevisions[r.MACAddress] = append(s.deviceRevisions[r.MACAddress], r)
+	}
+	for _, revs := range s.deviceRevisions {
+		sort.Slice(revs, func(i, j int) bool { return revs[i].Number < revs[j].Number })
+	}
+
+	s.deletedDevices = make(map[string]DeletedDevice, len(snap.DeletedDevices))
+	for _, d := range snap.DeletedDevices {
+		s.deletedDevices[d.Device.MACAddress] = d
+	}
+}
+
+// Validate checks the snapshot's keys and references: every record has a unique key, records belong to
+// existing users, and mappings, dependencies, schedule runs and calendars point at existing records.
+// Users and mappings must have a known role and access level: the migrations of load would otherwise
+// make users without a role admins on the next start. References the store itself lets
+// dangle, like scene steps of deleted devices, are not checked.
+func (snap Snapshot) Validate() error {
+	var problems []string
+	problem := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	users := keySet(snap.Users, func(u User) string { return u.Username }, "user", problem)
+	for _, u := range snap.Users {
+		if u.Role != RoleAdmin && u.Role != RoleUser {
+			problem("user %q has unknown role %q", u.Username, u.Role)
+		}
+	}
+	devices := keySet(snap.Devices, func(d Device) string { return d.MACAddress }, "device", problem)
+	keySet(snap.UserDeviceMappings, func(m UserDeviceMapping) string { return m.Username + " " + m.MACAddress }, "mapping", problem)
+	keySet(snap.DeletedDevices, func(d DeletedDevice) string { return d.Device.MACAddress }, "deleted device", problem)
+	keySet(snap.DeviceRevisions, func(r DeviceRevision) string { return r.ID }, "device revision", problem)
+	keySet(snap.ScheduleRuns, func(r ScheduleRun) string { return r.ID }, "schedule run", problem)
+	keySet(snap.WakeRequests, func(r WakeRequest) string { return r.ID }, "wake request", problem)
+
+	groupOwners := make(map[string]string, len(snap.Groups))
+	for _, g := range snap.Groups {
+		groupOwners[g.ID] = g.Username
+	}
+	calendarOwners := make(map[string]string, len(snap.Calendars))
+	for _, c := range snap.Calendars {
+		calendarOwners[c.ID] = c.Username
+	}
+
+	owned := func(kind, id, username string) {
+		if !users[username] {
+			problem("%s %s belongs to unknown user %q", kind, id, username)
+		}
+	}
+	groups := keySet(snap.Groups, func(g Group) string { return g.ID }, "group", problem)
+	for _, g := range snap.Groups {
+		owned("group", g.ID, g.Username)
+	}
+	keySet(snap.Scenes, func(sc Scene) string { return sc.ID }, "scene", problem)
+	for _, sc := range snap.Scenes {
+		owned("scene", sc.ID, sc.Username)
+	}
+	schedules := keySet(snap.Schedules, func(sc Schedule) string { return sc.ID }, "schedule", problem)
+	for _, sc := range snap.Schedules {
+		owned("schedule", sc.ID, sc.Username)
+		for _, id := range sc.CalendarIDs {
+			if owner, ok := calendarOwners[id]; !ok || owner != sc.Username {
+				problem("schedule %s uses unknown calendar %s", sc.ID, id)
+			}
+		}
+	}
+	keySet(snap.Calendars, func(c Calendar) string { return c.ID }, "calendar", problem)
+	for _, c := range snap.Calendars {
+		owned("calendar", c.ID, c.Username)
+	}
+	keySet(snap.AccessTokens, func(t AccessToken) string { return t.ID }, "access token", problem)
+	for _, t := range snap.AccessTokens {
+		owned("access token", t.ID, t.Username)
+	}
+	keySet(snap.ShareLinks, func(l ShareLink) string { return l.ID }, "share link", problem)
+	for _, l := range snap.ShareLinks {
+		owned("share link", l.ID, l.Username)
+	}
+	keySet(snap.Notifications, func(n Notification) string { return n.ID }, "notification", problem)
+	for _, n := range snap.Notifications {
+		owned("notification", n.ID, n.Username)
+	}
+
+	for _, m := range snap.UserDeviceMappings {
+		if !users[m.Username] {
+			problem("mapping of device %s to unknown user %q", m.MACAddress, m.Username)
+		}
+		if !devices[m.MACAddress] {
+			problem("mapping of user %q to unknown device %s", m.Username, m.MACAddress)
+		}
+		if !m.Access.Valid() {
+			problem("mapping of user %q and device %s has unknown access %q", m.Username, m.MACAddress, m.Access)
+		}
+		if m.GroupID != "" && (!groups[m.GroupID] || groupOwners[m.GroupID] != m.Username) {
+			problem("mapping of user %q and device %s to unknown group %s", m.Username, m.MACAddress, m.GroupID)
+		}
+	}
+	for _, d := range snap.Devices {
+		for _, dep := range d.DependsOn {
+			if !devices[dep] {
+				problem("device %s depends on unknown device %s", d.MACAddress, dep)
+			}
+		}
+	}
+	for _, r := range snap.ScheduleRuns {
+		if !schedules[r.ScheduleID] {
+			problem("schedule run %s of unknown schedule %s", r.ID, r.ScheduleID)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &InvalidSnapshotError{Problems: problems}
+	}
+	return nil
+}
+
+// keySet returns the keys of the records, reporting empty and duplicate keys.
+func keySet[T any](records []T, key func(T) string, kind string, problem func(string, ...any)) map[string]bool {
+	keys := make(map[string]bool, len(records))
+	for _, r := range records {
+		k := key(r)
+		if strings.TrimSpace(k) == "" {
+			problem("%s without a key", kind)
+		} else if keys[k] {
+			problem("duplicate %s %s", kind, k)
+		}
+		keys[k] = true
+	}
+	return keys
+}
+
+// Import restores a snapshot. A merge adds the records whose key does not exist yet; a replace drops
+// everything first. Either way the resulting state is validated before anything is changed. After a
+// merge sessions of users that no longer exist end, after a replace every session but keepSession ends.
+// With dryRun the result is returned without changing anything.
+func (s *Store) Import(snap Snapshot, mode ImportMode, dryRun bool, keepSession string) (*ImportResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Build and validate the new state
+	result := &ImportResult{Imported: make(map[string]int), Skipped: make(map[string]int)}
+	var next Snapshot
+	switch mode {
+	case ImportReplace:
+		merge(&next, Snapshot{}, snap, result)
+	case ImportMerge:
+		next = s.snapshotLocked()
+		merge(&next, next, snap, result)
+	default:
+		return nil, errors.New("unknown import mode")
+	}
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	// Action: Replace the maps
+	s.hydrateLocked(next)
+	for id, sess := range s.sessions {
+		if _, exists := s.users[sess.Username]; !exists || mode == ImportReplace && id != keepSession {
+			delete(s.sessions, id)
+		}
+	}
+
+	// Persistence: Flush to disk
+	return result, s.flush()
+}
+
+// merge adds the records of imported whose key is not in current to next, counting them in result.
+func merge(next *Snapshot, current, imported Snapshot, result *ImportResult) {
+	next.Users = mergeRecords(current.Users, imported.Users, func(u User) string { return u.Username }, "users", result)
+	next.Devices = mergeRecords(current.Devices, imported.Devices, func(d Device) string { return d.MACAddress }, "devices", result)
+	next.UserDeviceMappings = mergeRecords(current.UserDeviceMappings, imported.UserDeviceMappings, func(m UserDeviceMapping) string { return m.Username + " " + m.MACAddress }, "user_device_mappings", result)
+	next.Groups = mergeRecords(current.Groups, imported.Groups, func(g Group) string { return g.ID }, "groups", result)
+	next.Scenes = mergeRecords(current.Scenes, imported.Scenes, func(sc Scene) string { return sc.ID }, "scenes", result)
+	next.Schedules = mergeRecords(current.Schedules, imported.Schedules, func(sc Schedule) string { return sc.ID }, "schedules", result)
+	next.ScheduleRuns = mergeRecords(current.ScheduleRuns, imported.ScheduleRuns, func(r ScheduleRun) string { return r.ID }, "schedule_runs", result)
+	next.Calendars = mergeRecords(current.Calendars, imported.Calendars, func(c Calendar) string { return c.ID }, "calendars", result)
+	next.AccessTokens = mergeRecords(current.AccessTokens, imported.AccessTokens, func(t AccessToken) string { return t.ID }, "access_tokens", result)
+	next.ShareLinks = mergeRecords(current.ShareLinks, imported.ShareLinks, func(l ShareLink) string { return l.ID }, "share_links", result)
+	next.WakeRequests = mergeRecords(current.WakeRequests, imported.WakeRequests, func(r WakeRequest) string { return r.ID }, "wake_requests", result)
+	next.Notifications = mergeRecords(current.Notifications, imported.Notifications, func(n Notification) string { return n.ID }, "notifications", result)
+	next.DeletedDevices = mergeRecords(current.DeletedDevices, imported.DeletedDevices, func(d DeletedDevice) string { return d.Device.MACAddress }, "deleted_devices", result)
+
+	// A device's history is taken as a whole, revision numbers of two histories would clash
+	hasHistory := make(map[string]bool)
+	for _, r := range current.DeviceRevisions {
+		hasHistory[r.MACAddress] = true
+	}
+	revisions := make([]DeviceRevision, 0, len(imported.DeviceRevisions))
+	for _, r := range imported.DeviceRevisions {
+		if hasHistory[r.MACAddress] {
+			result.Skipped["device_revisions"]++
+			continue
+		}
+		revisions = append(revisions, r)
+	}
+	next.DeviceRevisions = mergeRecords(current.DeviceRevisions, revisions, func(r DeviceRevision) string { return r.ID }, "device_revisions", result)
+}
+
+// mergeRecords appends the imported records whose key is not in current. Duplicates within imported
+// are kept, for Validate to report.
+func mergeRecords[T any](current, imported []T, key func(T) string, name string, result *ImportResult) []T {
+	exists := make(map[string]bool, len(current))
+	for _, r := range current {
+		exists[key(r)] = true
+	}
+	merged := slices.Clone(current)
+	if merged == nil {
+		merged = make([]T, 0, len(imported))
+	}
+	for _, r := range imported {
+		if exists[key(r)] {
+			result.Skipped[name]++
+			continue
+		}
+		merged = append(merged, r)
+		result.Imported[name]++
+	}
+	return merged
+}
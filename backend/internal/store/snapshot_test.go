@@ -0,0 +1,129 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotValidate(t *testing.T) {
+	snap := Snapshot{
+		Users:   []User{{Username: "alice", Role: RoleAdmin}},
+		Devices: []Device{{MACAddress: "aa", DependsOn: []string{"bb"}}},
+		UserDeviceMappings: []UserDeviceMapping{
+			{Username: "alice", MACAddress: "aa", Access: AccessOwner},
+			{Username: "bob", MACAddress: "aa", Access: AccessRequest},
+			{Username: "alice", MACAddress: "cc", Access: AccessOwner, GroupID: "g1"},
+		},
+		Groups: []Group{{ID: "g1", Username: "alice"}, {ID: "g1", Username: "alice"}},
+	}
+
+	var invalid *InvalidSnapshotError
+	if err := snap.Validate(); !errors.As(err, &invalid) {
+		t.Fatalf("expected an InvalidSnapshotError, got %v", err)
+	}
+	// Duplicate group, dependency, unknown user and unknown device
+	if len(invalid.Problems) != 4 {
+		t.Errorf("expected 4 problems, got %q", invalid.Problems)
+	}
+
+	// A user without a role would become an admin on the next start
+	snap = Snapshot{Users: []User{{Username: "alice", Role: RoleUser}, {Username: "mallory"}}}
+	if err := snap.Validate(); !errors.As(err, &invalid) || len(invalid.Problems) != 1 {
+		t.Errorf("expected a user without a role to be rejected, got %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	src := newTestStore(t)
+	newTestOwner(t, src, "alice")
+	for _, d := range []*Device{
+		NewDevice("aa", "pc", "", "10.0.0.2", "10.0.0.255:9", StatusUnknown),
+		NewDevice("bb", "nas", "", "10.0.0.3", "10.0.0.255:9", StatusUnknown),
+	} {
+		if err := src.CreateDeviceForUser("alice", d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snap := src.Snapshot()
+
+	dst := newTestStore(t)
+	newTestOwner(t, dst, "bob")
+	if err := dst.CreateDeviceForUser("bob", NewDevice("aa", "bob's pc", "", "10.0.0.9", "10.0.0.255:9", StatusUnknown)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run changes nothing
+	if _, err := dst.Import(snap, ImportMerge, true, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.FindUser("alice"); err != ErrUserNotFound {
+		t.Fatalf("dry run imported alice: %v", err)
+	}
+
+	// A merge keeps the existing device and adds the rest
+	result, err := dst.Import(snap, ImportMerge, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported["devices"] != 1 || result.Skipped["devices"] != 1 || result.Imported["users"] != 1 {
+		t.Errorf("unexpected merge result %+v", result)
+	}
+	if d, _ := dst.GetDeviceByMacAddress("aa"); d.Name != "bob's pc" {
+		t.Errorf("merge overwrote an existing device: %+v", d)
+	}
+	if _, err := dst.GetDeviceForUser("bob", "aa"); err != nil {
+		t.Errorf("merge lost bob's mapping: %v", err)
+	}
+
+	// A replace drops what is not in the backup, an invalid backup changes nothing
+	broken := snap
+	broken.UserDeviceMappings = append(broken.UserDeviceMappings, UserDeviceMapping{Username: "carol", MACAddress: "aa"})
+	if _, err := dst.Import(broken, ImportReplace, false, ""); err == nil {
+		t.Fatal("expected an invalid snapshot to be rejected")
+	}
+	caller := NewSession("alice", "", "", time.Now().Add(time.Hour))
+	other := NewSession("alice", "", "", time.Now().Add(time.Hour))
+	for _, sess := range []*Session{caller, other} {
+		if err := dst.CreateSession(sess); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dst.Import(snap, ImportReplace, false, caller.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.GetSession(caller.ID); err != nil {
+		t.Errorf("expected the caller's session to survive a replace: %v", err)
+	}
+	if _, err := dst.GetSession(other.ID); err != ErrSessionNotFound {
+		t.Errorf("expected other sessions to end with a replace, got %v", err)
+	}
+	if _, err := dst.FindUser("bob"); err != ErrUserNotFound {
+		t.Errorf("expected bob to be gone after a replace, got %v", err)
+	}
+	if d, _ := dst.GetDeviceByMacAddress("aa"); d.Name != "pc" {
+		t.Errorf("expected the device from the backup, got %+v", d)
+	}
+}
+
+func TestLoadRoleMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wolite.json")
+	// carol is from before roles; alice is an SSO user mapped to the user role, nobody is an admin
+	data := `{"users": [{"username": "alice", "password": "", "role": "user"}, {"username": "carol", "password": "hash"}]}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := s.FindUser("carol"); u.Role != RoleAdmin {
+		t.Errorf("expected the user from before roles to become an admin, got %q", u.Role)
+	}
+	if u, _ := s.FindUser("alice"); u.Role != RoleUser {
+		t.Errorf("expected a user with a role to keep it although there is no admin, got %q", u.Role)
+	}
+}
@@ -1,10 +1,13 @@
 package store
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"os"
 	"path/filepath"
 	"sync"
+	"wolite/internal/keyring"
 )
 
 // Store manages the JSON persistence.
@@ -15,10 +18,26 @@ type Store struct {
 	users              map[string]User                         // map for O(1) lookup
 	devices            map[string]Device                       // map for O(1) lookup
 	userDeviceMappings map[string]map[string]UserDeviceMapping // map for O(1) lookup
+	groups             map[string]Group                        // keyed by group ID
+	scenes             map[string]Scene                        // keyed by scene ID
+	schedules          map[string]Schedule                     // keyed by schedule ID
+	scheduleRuns       map[string][]ScheduleRun                // keyed by schedule ID, oldest first
+	calendars          map[string]Calendar                     // keyed by calendar ID
+	accessTokens       map[string]AccessToken                  // keyed by token ID
+	sessions           map[string]Session                      // keyed by session ID (jti)
+	shareLinks         map[string]ShareLink                    // keyed by share link ID
+	wakeRequests       map[string]WakeRequest                  // keyed by wake request ID
+	notifications      map[string]Notification                 // keyed by notification ID
+	deviceRevisions    map[string][]DeviceRevision             // keyed by MAC address, oldest first
+	deletedDevices     map[string]DeletedDevice                // keyed by MAC address
+	// Encryption of secret fields, nil when the store was opened without a master key
+	envelope   *keyring.Envelope
+	wrappedKey []byte
 }
 
-// New initializes the store.
-func New(path string) (*Store, error) {
+// New initializes the store. With a master key, secret fields are encrypted on disk and values still
+// stored in plaintext are encrypted right away. Without one, a database with encrypted secrets is refused.
+func New(path string, master []byte) (*Store, error) {
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return nil, err
 	}
@@ -28,15 +47,37 @@ func New(path string) (*Store, error) {
 		users:              make(map[string]User),
 		devices:            make(map[string]Device),
 		userDeviceMappings: make(map[string]map[string]UserDeviceMapping),
+		groups:             make(map[string]Group),
+		scenes:             make(map[string]Scene),
+		schedules:          make(map[string]Schedule),
+		scheduleRuns:       make(map[string][]ScheduleRun),
+		calendars:          make(map[string]Calendar),
+		accessTokens:       make(map[string]AccessToken),
+		sessions:           make(map[string]Session),
+		shareLinks:         make(map[string]ShareLink),
+		wakeRequests:       make(map[string]WakeRequest),
+		notifications:      make(map[string]Notification),
+		deviceRevisions:    make(map[string][]DeviceRevision),
+		deletedDevices:     make(map[string]DeletedDevice),
 	}
 
 	// Load existing data if file exists
 	if _, err := os.Stat(path); err == nil {
-		if err := s.load(); err != nil {
+		plaintext, err := s.load(master)
+		if err != nil {
 			return nil, err
 		}
+		// Migrate: encrypt secrets from before encryption, or written without a master key
+		if plaintext {
+			if err := s.flush(); err != nil {
+				return nil, err
+			}
+		}
 	} else {
 		// Initialize empty file
+		if err := s.openEnvelope(nil, master); err != nil {
+			return nil, err
+		}
 		if err := s.flush(); err != nil {
 			return nil, err
 		}
@@ -47,27 +88,31 @@ func New(path string) (*Store, error) {
 
 // flush writes the memory state to disk atomically.
 func (s *Store) flush() error {
-	// Convert maps to slices for JSON marshaling
 	data := struct {
-		Users              []User              `json:"users"`
-		Devices            []Device            `json:"devices"`
-		UserDeviceMappings []UserDeviceMapping `json:"user_device_mappings"`
+		Snapshot
+		Sessions   []Session         `json:"sessions"`
+		Encryption *encryptionHeader `json:"encryption,omitempty"`
 	}{
-		Users:              make([]User, 0, len(s.users)),
-		Devices:            make([]Device, 0, len(s.devices)),
-		UserDeviceMappings: make([]UserDeviceMapping, 0, len(s.userDeviceMappings)),
+		Snapshot: s.snapshotLocked(),
+		Sessions: make([]Session, 0, len(s.sessions)),
 	}
 
-	for _, u := range s.users {
-		data.Users = append(data.Users, u)
+	for _, sess := range s.sessions {
+		data.Sessions = append(data.Sessions, sess)
 	}
-	for _, d := range s.devices {
-		data.Devices = append(data.Devices, d)
+
+	// Encrypt secrets, the snapshot holds copies
+	for i, u := range data.Users {
+		data.Users[i] = s.sealUser(u)
 	}
-	for _, mappings := range s.userDeviceMappings {
-		for _, m := range mappings {
-			data.UserDeviceMappings = append(data.UserDeviceMappings, m)
-		}
+	for i, d := range data.Devices {
+		data.Devices[i] = s.sealDevice(d)
+	}
+	for i, d := range data.DeletedDevices {
+		data.DeletedDevices[i].Device = s.sealDevice(d.Device)
+	}
+	if s.envelope != nil {
+		data.Encryption = &encryptionHeader{Version: encryptionVersion, DataKey: s.wrappedKey}
 	}
 
 	// Atomic Write Pattern
@@ -92,43 +137,93 @@ func (s *Store) flush() error {
 	return os.Rename(tmp.Name(), s.path)
 }
 
-// load reads from disk into the maps.
-func (s *Store) load() error {
+// load reads from disk into the maps, decrypting secrets with the master key. It reports whether a
+// secret was found in plaintext while a master key is given.
+func (s *Store) load(master []byte) (bool, error) {
 	f, err := os.Open(s.path)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer f.Close()
 
-	// Temp struct for decoding
+	// Temp struct for decoding, its devices take the place of the snapshot's
 	var data struct {
-		Users             []User              `json:"users"`
-		Devices           []Device            `json:"devices"`
-		UserDeviceMapping []UserDeviceMapping `json:"user_device_mappings"`
+		Snapshot
+		Devices []struct {
+			Device
+			LegacyOrder int `json:"order"` // global order from before per-user ordering
+		} `json:"devices"`
+		Sessions   []Session         `json:"sessions"`
+		Encryption *encryptionHeader `json:"encryption"`
 	}
 
 	if err := json.NewDecoder(f).Decode(&data); err != nil {
-		return err
+		return false, err
 	}
-
-	// Hydrate maps
-	s.users = make(map[string]User, len(data.Users))
-	for _, u := range data.Users {
-		s.users[u.Username] = u
+	if err := s.openEnvelope(data.Encryption, master); err != nil {
+		return false, err
 	}
 
-	s.devices = make(map[string]Device, len(data.Devices))
+	// Decrypt secrets
+	plaintext := false
+	for i := range data.Users {
+		plain, err := s.openUser(&data.Users[i])
+		if err != nil {
+			return false, err
+		}
+		plaintext = plaintext || plain
+	}
+	legacyOrder := make(map[string]int, len(data.Devices))
+	data.Snapshot.Devices = make([]Device, 0, len(data.Devices))
 	for _, d := range data.Devices {
-		s.devices[d.MACAddress] = d
+		plain, err := s.openDevice(&d.Device)
+		if err != nil {
+			return false, err
+		}
+		plaintext = plaintext || plain
+		data.Snapshot.Devices = append(data.Snapshot.Devices, d.Device)
+		legacyOrder[d.MACAddress] = d.LegacyOrder
+	}
+	for i := range data.DeletedDevices {
+		plain, err := s.openDevice(&data.DeletedDevices[i].Device)
+		if err != nil {
+			return false, err
+		}
+		plaintext = plaintext || plain
+	}
+
+	// Migrate: carry the old global order over to mappings that have none yet, and give mappings from
+	// before access levels full control
+	for i, m := range data.UserDeviceMappings {
+		if m.Order == 0 {
+			data.UserDeviceMappings[i].Order = legacyOrder[m.MACAddress]
+		}
+		if m.Access == "" {
+			data.UserDeviceMappings[i].Access = AccessOwner
+		}
 	}
 
-	s.userDeviceMappings = make(map[string]map[string]UserDeviceMapping)
-	for _, m := range data.UserDeviceMapping {
-		if s.userDeviceMappings[m.Username] == nil {
-			s.userDeviceMappings[m.Username] = make(map[string]UserDeviceMapping)
+	// Migrate: users from before roles were equal, keep it that way by making them admins. Users created
+	// since always have a role, a store without an admin (e.g. SSO users mapped to RoleUser) stays so.
+	for i, u := range data.Users {
+		if u.Role == "" {
+			data.Users[i].Role = RoleAdmin
 		}
-		s.userDeviceMappings[m.Username][m.MACAddress] = m
 	}
 
-	return nil
+	// Hydrate maps
+	s.hydrateLocked(data.Snapshot)
+	s.sessions = make(map[string]Session, len(data.Sessions))
+	for _, sess := range data.Sessions {
+		s.sessions[sess.ID] = sess
+	}
+
+	return plaintext && s.envelope != nil, nil
+}
+
+// newID returns a random 128-bit hex identifier.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b) // crypto/rand never returns an error
+	return hex.EncodeToString(b)
 }
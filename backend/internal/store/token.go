@@ -0,0 +1,148 @@
+package store
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+type TokenScope string
+
+const (
+	ScopeRead  TokenScope = "read"  // list devices, status and runs
+	ScopeWake  TokenScope = "wake"  // read, plus wake devices and groups
+	ScopePower TokenScope = "power" // wake, plus companion power actions and scenes
+)
+
+var scopeRank = map[TokenScope]int{ScopeRead: 1, ScopeWake: 2, ScopePower: 3}
+
+// Valid reports whether the scope is known.
+func (s TokenScope) Valid() bool {
+	return scopeRank[s] > 0
+}
+
+// Allows reports whether a token with this scope may use a route requiring the given scope.
+func (s TokenScope) Allows(required TokenScope) bool {
+	return s.Valid() && scopeRank[s] >= scopeRank[required]
+}
+
+// AccessToken is a personal access token used by scripts through the Authorization header.
+// Only a SHA-256 hash of the token is stored.
+type AccessToken struct {
+	ID         string     `json:"id"`
+	Username   string     `json:"username"` // owner of the token
+	Name       string     `json:"name"`
+	Hash       string     `json:"hash,omitempty"` // hex SHA-256 of the token
+	Prefix     string     `json:"prefix"`         // first characters of the token, to recognize it
+	Scope      TokenScope `json:"scope"`
+	Devices    []string   `json:"devices,omitempty"` // MAC addresses the token is restricted to, empty for all
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func NewAccessToken(username, name, hash, prefix string, scope TokenScope) *AccessToken {
+	return &AccessToken{
+		ID:        newID(),
+		Username:  username,
+		Name:      name,
+		Hash:      hash,
+		Prefix:    prefix,
+		Scope:     scope,
+		CreatedAt: time.Now(),
+	}
+}
+
+// Expired reports whether the token is past its expiry.
+func (t *AccessToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// AllowsDevice reports whether the token may act on the given device.
+func (t *AccessToken) AllowsDevice(macAddress string) bool {
+	return len(t.Devices) == 0 || slices.Contains(t.Devices, macAddress)
+}
+
+// GetAccessTokensForUser returns all tokens owned by a username, newest first.
+func (s *Store) GetAccessTokensForUser(username string) ([]AccessToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := make([]AccessToken, 0)
+	for _, t := range s.accessTokens {
+		if t.Username == username {
+			tokens = append(tokens, t)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+	return tokens, nil
+}
+
+// GetAccessTokenByHash returns the token with the given hash.
+func (s *Store) GetAccessTokenByHash(hash string) (*AccessToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, t := range s.accessTokens {
+		if t.Hash == hash {
+			return &t, nil
+		}
+	}
+	return nil, ErrAccessTokenNotFound
+}
+
+// CreateAccessToken adds a new token.
+func (s *Store) CreateAccessToken(token *AccessToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Owner must exist
+	if _, exists := s.users[token.Username]; !exists {
+		return ErrUserNotFound
+	}
+
+	// Action: Write to map
+	s.accessTokens[token.ID] = *token
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// TouchAccessToken records the time a token was last used.
+func (s *Store) TouchAccessToken(id string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence
+	token, exists := s.accessTokens[id]
+	if !exists {
+		return ErrAccessTokenNotFound
+	}
+
+	// Action: Write to map
+	token.LastUsedAt = &t
+	s.accessTokens[id] = token
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// DeleteAccessToken revokes a token.
+func (s *Store) DeleteAccessToken(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and ownership
+	if t, exists := s.accessTokens[id]; !exists || t.Username != username {
+		return ErrAccessTokenNotFound
+	}
+
+	// Action: Delete from map
+	delete(s.accessTokens, id)
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
@@ -1,12 +1,44 @@
 package store
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+)
+
+type Role string
+
+// Identity providers of users not managed locally
+const (
+	ProviderOIDC  = "oidc"
+	ProviderProxy = "proxy" // forward auth by a reverse proxy
+	ProviderLDAP  = "ldap"  // password checked against the directory, the user is a local shadow record
+)
+
+const (
+	RoleAdmin Role = "admin" // manages server-wide settings such as signing keys
+	RoleUser  Role = "user"
+)
 
 type User struct {
 	Username   string `json:"username"`
 	Password   string `json:"password"`
 	OTP        string `json:"otp,omitempty"`
 	PendingOTP string `json:"pending_otp,omitempty"` // Temp storage for OTP verification
+	Role       Role   `json:"role,omitempty"`
+
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // hashes of unused one-time OTP recovery codes
+
+	WebAuthnID  []byte               `json:"webauthn_id,omitempty"` // random user handle given to authenticators
+	Credentials []WebAuthnCredential `json:"webauthn_credentials,omitempty"`
+
+	// External identity, empty for local users. Such users have no password.
+	Provider string `json:"provider,omitempty"`
+	Subject  string `json:"subject,omitempty"` // stable user ID at the provider
+}
+
+// HasSecondFactor reports whether a password alone is not enough to log in.
+func (u User) HasSecondFactor() bool {
+	return u.OTP != "" || len(u.Credentials) > 0
 }
 
 func NewUser(username, password string) (*User, error) {
@@ -47,6 +79,19 @@ func (s *Store) FindUser(username string) (User, error) {
 	return u, nil
 }
 
+// FindUserBySubject returns the user linked to an identity at an external provider.
+func (s *Store) FindUserBySubject(provider, subject string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.Provider == provider && u.Subject == subject {
+			return u, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
 // CreateUser adds a new user only if the username is unique.
 func (s *Store) CreateUser(u User) error {
 	s.mu.Lock()
@@ -57,7 +102,13 @@ func (s *Store) CreateUser(u User) error {
 		return ErrUserExists
 	}
 
-	// Action: Write to map
+	// Action: Write to map, the first user administers the server
+	if u.Role == "" {
+		u.Role = RoleUser
+		if len(s.users) == 0 {
+			u.Role = RoleAdmin
+		}
+	}
 	s.users[u.Username] = u
 
 	// Persistence: Flush to disk
@@ -82,6 +133,53 @@ func (s *Store) UpdateUser(u User) error {
 	return s.flush()
 }
 
+// RehashPassword replaces the user's password hash with a stronger hash of the same password.
+// It is a no-op when the password changed since oldHash was read, so a concurrent change is not undone.
+func (s *Store) RehashPassword(username, oldHash, newHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Ensure target exists and still has the old hash
+	u, exists := s.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+	if u.Password != oldHash {
+		return nil
+	}
+
+	// Action: Swap the hash
+	u.Password = newHash
+	s.users[username] = u
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// UseRecoveryCode consumes one of the user's recovery codes by hash. A code can only be used once,
+// so it is checked and removed under the same lock.
+func (s *Store) UseRecoveryCode(username, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Ensure target exists and holds the code
+	u, exists := s.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+	i := slices.Index(u.RecoveryCodes, hash)
+	if i < 0 {
+		return ErrRecoveryCodeNotFound
+	}
+
+	// Action: Remove the code
+	u.RecoveryCodes = slices.Delete(slices.Clone(u.RecoveryCodes), i, i+1)
+	s.users[username] = u
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
 // HasUsers returns true if at least one user exists in the store.
 func (s *Store) HasUsers() bool {
 	s.mu.RLock()
@@ -2,25 +2,52 @@ package store
 
 import "sort"
 
+// DeviceAccess is what a user may do with a device mapped to them.
+type DeviceAccess string
+
+const (
+	AccessOwner   DeviceAccess = "owner"   // full control, including sharing the device
+	AccessRequest DeviceAccess = "request" // may only ask an owner to wake the device
+)
+
+// Valid reports whether the access level is known.
+func (a DeviceAccess) Valid() bool {
+	return a == AccessOwner || a == AccessRequest
+}
+
 type UserDeviceMapping struct {
-	Username   string `json:"username"`
-	MACAddress string `json:"mac_address"`
+	Username   string       `json:"username"`
+	MACAddress string       `json:"mac_address"`
+	Access     DeviceAccess `json:"access"`
+	Order      int          `json:"order"`              // display order of the device on this user's dashboard
+	GroupID    string       `json:"group_id,omitempty"` // group (folder) the user filed the device under
+}
+
+// UserDevice is a device as seen by a single user, including the user's own arrangement of it.
+type UserDevice struct {
+	Device
+	GroupID string `json:"group_id,omitempty"`
+	Order   int    `json:"order"`
 }
 
-// GetDevicesForUser returns all devices associated with a username.
-func (s *Store) GetDevicesForUser(username string) ([]Device, error) {
+// GetDevicesForUser returns all devices a username owns. Devices the user may only request a wake of
+// are not included, see GetRequestableDevicesForUser.
+func (s *Store) GetDevicesForUser(username string) ([]UserDevice, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	mappings, ok := s.userDeviceMappings[username]
 	if !ok {
-		return []Device{}, nil // Return empty list if no mappings found
+		return []UserDevice{}, nil // Return empty list if no mappings found
 	}
 
-	devices := make([]Device, 0, len(mappings))
-	for mac := range mappings {
+	devices := make([]UserDevice, 0, len(mappings))
+	for mac, m := range mappings {
+		if m.Access != AccessOwner {
+			continue
+		}
 		if device, exists := s.devices[mac]; exists {
-			devices = append(devices, device)
+			devices = append(devices, UserDevice{Device: device, GroupID: m.GroupID, Order: m.Order})
 		}
 	}
 
@@ -29,8 +56,8 @@ func (s *Store) GetDevicesForUser(username string) ([]Device, error) {
 	return devices, nil
 }
 
-// sort devices by Order, then Name
-func sortDevices(devices []Device) {
+// sort devices by the user's Order, then Name
+func sortDevices(devices []UserDevice) {
 	sort.Slice(devices, func(i, j int) bool {
 		if devices[i].Order != devices[j].Order {
 			return devices[i].Order < devices[j].Order
@@ -39,6 +66,31 @@ func sortDevices(devices []Device) {
 	})
 }
 
+// ReorderDevicesForUser updates the user's display order based on the provided list of MAC addresses.
+// Every MAC address must belong to the user, otherwise nothing is changed.
+func (s *Store) ReorderDevicesForUser(username string, macAddresses []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Validate ownership of every device before writing anything
+	mappings := s.userDeviceMappings[username]
+	for _, mac := range macAddresses {
+		if m, exists := mappings[mac]; !exists || m.Access != AccessOwner {
+			return ErrUserDeviceMappingNotFound
+		}
+	}
+
+	// Action: Write order to the user's mappings
+	for i, mac := range macAddresses {
+		m := mappings[mac]
+		m.Order = i
+		mappings[mac] = m
+	}
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
 // AddDeviceToUser adds a new device to a user only if the mapping does not exist.
 func (s *Store) AddDeviceToUser(username string, device *Device) error {
 	s.mu.Lock()
@@ -66,21 +118,30 @@ func (s *Store) AddDeviceToUser(username string, device *Device) error {
 	s.userDeviceMappings[username][device.MACAddress] = UserDeviceMapping{
 		Username:   username,
 		MACAddress: device.MACAddress,
+		Access:     AccessOwner,
+		Order:      len(s.userDeviceMappings[username]), // append to the end of the user's list
 	}
 
 	// Persistence: Flush to disk
 	return s.flush()
 }
 
+// RemoveDeviceFromUser takes a device away from a user. The last owner of a device cannot be removed.
 func (s *Store) RemoveDeviceFromUser(username, macAddress string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// Ensure mapping exists
-	if _, exists := s.userDeviceMappings[username][macAddress]; !exists {
+	m, exists := s.userDeviceMappings[username][macAddress]
+	if !exists {
 		return ErrUserDeviceMappingNotFound
 	}
 
+	// Guard: Somebody must keep control of the device
+	if m.Access == AccessOwner && len(s.deviceOwnersLocked(macAddress)) == 1 {
+		return ErrLastOwner
+	}
+
 	// Action: Remove from map
 	delete(s.userDeviceMappings[username], macAddress)
 
@@ -88,7 +149,7 @@ func (s *Store) RemoveDeviceFromUser(username, macAddress string) error {
 	return s.flush()
 }
 
-// GetDeviceForUser returns a device only if it is associated with the given username.
+// GetDeviceForUser returns a device only if the given username owns it.
 func (s *Store) GetDeviceForUser(username, macAddress string) (*Device, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -104,7 +165,7 @@ func (s *Store) GetDeviceForUser(username, macAddress string) (*Device, error) {
 		return nil, ErrDeviceNotFound // Effectively not found for this user
 	}
 
-	if _, ok := mappings[macAddress]; !ok {
+	if m, ok := mappings[macAddress]; !ok || m.Access != AccessOwner {
 		return nil, ErrDeviceNotFound
 	}
 
@@ -119,6 +180,12 @@ func (s *Store) GetDeviceForUser(username, macAddress string) (*Device, error) {
 
 // CreateDeviceForUser atomically creates a device and assigns it to a user.
 func (s *Store) CreateDeviceForUser(username string, device *Device) error {
+	return s.CreateDeviceInGroupForUser(username, device, "")
+}
+
+// CreateDeviceInGroupForUser is CreateDeviceForUser that also files the device under one of the user's
+// groups. Nothing is written if the group does not exist.
+func (s *Store) CreateDeviceInGroupForUser(username string, device *Device, groupID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -127,23 +194,155 @@ func (s *Store) CreateDeviceForUser(username string, device *Device) error {
 		return ErrUserNotFound
 	}
 
-	// 2. Check device existence
+	// 2. Check device and group existence
 	if _, exists := s.devices[device.MACAddress]; exists {
 		return ErrDeviceExists
 	}
+	if groupID != "" {
+		if g, exists := s.groups[groupID]; !exists || g.Username != username {
+			return ErrGroupNotFound
+		}
+	}
 
 	// 3. Initialize mapping map if nil
 	if s.userDeviceMappings[username] == nil {
 		s.userDeviceMappings[username] = make(map[string]UserDeviceMapping)
 	}
 
-	// 4. Perform writes
+	// 4. Perform writes. A device created under the MAC address of a deleted one starts its own history,
+	// and the deleted one leaves the trash: it could not be restored next to this one anyway.
+	s.purgeDeletedLocked(device.MACAddress)
 	s.devices[device.MACAddress] = *device
 	s.userDeviceMappings[username][device.MACAddress] = UserDeviceMapping{
 		Username:   username,
 		MACAddress: device.MACAddress,
+		Access:     AccessOwner,
+		Order:      len(s.userDeviceMappings[username]), // append to the end of the user's list
+		GroupID:    groupID,
 	}
+	s.recordRevisionLocked(device.MACAddress, username, RevisionCreated, DeviceConfig{}, device.Config())
 
 	// 5. Persist
 	return s.flush()
 }
+
+// GetRequestableDevicesForUser returns the devices a username may request a wake of, sorted by name.
+func (s *Store) GetRequestableDevicesForUser(username string) ([]Device, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	devices := make([]Device, 0)
+	for mac, m := range s.userDeviceMappings[username] {
+		if m.Access != AccessRequest {
+			continue
+		}
+		if device, exists := s.devices[mac]; exists {
+			devices = append(devices, device)
+		}
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+	return devices, nil
+}
+
+// GetDeviceAccess returns a device and the user's access level to it.
+func (s *Store) GetDeviceAccess(username, macAddress string) (*Device, DeviceAccess, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, exists := s.userDeviceMappings[username][macAddress]
+	if !exists {
+		return nil, "", ErrDeviceNotFound
+	}
+	device, exists := s.devices[macAddress]
+	if !exists {
+		return nil, "", ErrDeviceNotFound
+	}
+	return &device, m.Access, nil
+}
+
+// GetDeviceUsers returns everyone with access to a device, sorted by username.
+func (s *Store) GetDeviceUsers(macAddress string) ([]UserDeviceMapping, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.devices[macAddress]; !exists {
+		return nil, ErrDeviceNotFound
+	}
+
+	users := make([]UserDeviceMapping, 0)
+	for _, mappings := range s.userDeviceMappings {
+		if m, exists := mappings[macAddress]; exists {
+			users = append(users, m)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users, nil
+}
+
+// GetDeviceOwners returns the usernames owning a device, sorted.
+func (s *Store) GetDeviceOwners(macAddress string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	owners := s.deviceOwnersLocked(macAddress)
+	sort.Strings(owners)
+	return owners
+}
+
+func (s *Store) deviceOwnersLocked(macAddress string) []string {
+	owners := make([]string, 0)
+	for username, mappings := range s.userDeviceMappings {
+		if m, exists := mappings[macAddress]; exists && m.Access == AccessOwner {
+			owners = append(owners, username)
+		}
+	}
+	return owners
+}
+
+// SetDeviceAccess shares a device with a user at the given level, or changes the level of a user who
+// already has access. The last owner of a device cannot be downgraded.
+func (s *Store) SetDeviceAccess(username, macAddress string, access DeviceAccess) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Ensure both device and username exist
+	if _, exists := s.users[username]; !exists {
+		return ErrUserNotFound
+	}
+	if _, exists := s.devices[macAddress]; !exists {
+		return ErrDeviceNotFound
+	}
+
+	if s.userDeviceMappings[username] == nil {
+		s.userDeviceMappings[username] = make(map[string]UserDeviceMapping)
+	}
+	m, exists := s.userDeviceMappings[username][macAddress]
+	if !exists {
+		m = UserDeviceMapping{
+			Username:   username,
+			MACAddress: macAddress,
+			Order:      len(s.userDeviceMappings[username]), // append to the end of the user's list
+		}
+	}
+
+	// Guard: Somebody must keep control of the device
+	if m.Access == AccessOwner && access != AccessOwner && len(s.deviceOwnersLocked(macAddress)) == 1 {
+		return ErrLastOwner
+	}
+
+	// Action: Write to map. Groups are the user's own, a device they can no longer manage leaves its group
+	m.Access = access
+	if access != AccessOwner {
+		m.GroupID = ""
+	}
+	s.userDeviceMappings[username][macAddress] = m
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// wakeRequestRetention is how long decided or expired requests stay listed.
+const wakeRequestRetention = 30 * 24 * time.Hour
+
+type WakeRequestStatus string
+
+const (
+	WakeRequestPending   WakeRequestStatus = "pending"
+	WakeRequestApproved  WakeRequestStatus = "approved"
+	WakeRequestDenied    WakeRequestStatus = "denied"
+	WakeRequestCancelled WakeRequestStatus = "cancelled"
+	WakeRequestExpired   WakeRequestStatus = "expired" // computed, pending requests are not updated when they expire
+)
+
+// WakeRequest asks the owners of a device to wake it, on behalf of a user with request access.
+type WakeRequest struct {
+	ID         string            `json:"id"`
+	MACAddress string            `json:"mac_address"`
+	DeviceName string            `json:"device_name"` // at the time of the request, the device may be renamed or deleted
+	Requester  string            `json:"requester"`
+	Reason     string            `json:"reason,omitempty"`
+	Status     WakeRequestStatus `json:"status"`
+	CreatedAt  time.Time         `json:"created_at"`
+	ExpiresAt  time.Time         `json:"expires_at"`
+	DecidedBy  string            `json:"decided_by,omitempty"` // owner who approved or denied it
+	DecidedAt  *time.Time        `json:"decided_at,omitempty"`
+	Note       string            `json:"note,omitempty"`  // from the owner, e.g. why it was denied
+	Error      string            `json:"error,omitempty"` // set when the approved wake failed
+}
+
+func NewWakeRequest(device Device, requester, reason string, expiresAt time.Time) *WakeRequest {
+	return &WakeRequest{
+		ID:         newID(),
+		MACAddress: device.MACAddress,
+		DeviceName: device.Name,
+		Requester:  requester,
+		Reason:     reason,
+		Status:     WakeRequestPending,
+		CreatedAt:  time.Now(),
+		ExpiresAt:  expiresAt,
+	}
+}
+
+// CurrentStatus returns the status, with pending requests past their expiry reported as expired.
+func (r *WakeRequest) CurrentStatus(now time.Time) WakeRequestStatus {
+	if r.Status == WakeRequestPending && !now.Before(r.ExpiresAt) {
+		return WakeRequestExpired
+	}
+	return r.Status
+}
+
+// GetWakeRequestsForUser returns the requests a username made or may decide on, newest first.
+func (s *Store) GetWakeRequestsForUser(username string) ([]WakeRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	requests := make([]WakeRequest, 0)
+	for _, r := range s.wakeRequests {
+		if r.Requester == username {
+			requests = append(requests, r)
+			continue
+		}
+		if m, exists := s.userDeviceMappings[username][r.MACAddress]; exists && m.Access == AccessOwner {
+			requests = append(requests, r)
+		}
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt.After(requests[j].CreatedAt)
+	})
+	return requests, nil
+}
+
+// GetWakeRequest returns a wake request by ID.
+func (s *Store) GetWakeRequest(id string) (*WakeRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, exists := s.wakeRequests[id]
+	if !exists {
+		return nil, ErrWakeRequestNotFound
+	}
+	return &r, nil
+}
+
+// CreateWakeRequest adds a pending request. A requester has at most one pending request per device.
+func (s *Store) CreateWakeRequest(request *WakeRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Requester must have request access to the device
+	if m, exists := s.userDeviceMappings[request.Requester][request.MACAddress]; !exists || m.Access != AccessRequest {
+		return ErrDeviceNotFound
+	}
+
+	// Guard: No duplicate pending request
+	now := time.Now()
+	for _, r := range s.wakeRequests {
+		if r.Requester == request.Requester && r.MACAddress == request.MACAddress && r.CurrentStatus(now) == WakeRequestPending {
+			return ErrWakeRequestPending
+		}
+	}
+
+	// Action: Write to map, pruning requests that ended a while ago
+	for id, r := range s.wakeRequests {
+		end := r.ExpiresAt
+		if r.DecidedAt != nil {
+			end = *r.DecidedAt
+		}
+		if r.CurrentStatus(now) != WakeRequestPending && now.Sub(end) > wakeRequestRetention {
+			delete(s.wakeRequests, id)
+		}
+	}
+	s.wakeRequests[request.ID] = *request
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// DecideWakeRequest approves or denies a pending request as one of the device's owners.
+func (s *Store) DecideWakeRequest(id, owner string, status WakeRequestStatus, note string) (*WakeRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and that the user owns the device
+	r, exists := s.wakeRequests[id]
+	if !exists {
+		return nil, ErrWakeRequestNotFound
+	}
+	if m, exists := s.userDeviceMappings[owner][r.MACAddress]; !exists || m.Access != AccessOwner {
+		return nil, ErrWakeRequestNotFound
+	}
+
+	// Guard: Only pending requests can be decided, the first decision wins
+	now := time.Now()
+	if r.CurrentStatus(now) != WakeRequestPending {
+		return nil, ErrWakeRequestClosed
+	}
+
+	// Action: Write to map
+	r.Status = status
+	r.DecidedBy = owner
+	r.DecidedAt = &now
+	r.Note = note
+	s.wakeRequests[id] = r
+
+	// Persistence: Flush to disk
+	return &r, s.flush()
+}
+
+// CancelWakeRequest withdraws a pending request of the requester.
+func (s *Store) CancelWakeRequest(requester, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence and ownership
+	r, exists := s.wakeRequests[id]
+	if !exists || r.Requester != requester {
+		return ErrWakeRequestNotFound
+	}
+	now := time.Now()
+	if r.CurrentStatus(now) != WakeRequestPending {
+		return ErrWakeRequestClosed
+	}
+
+	// Action: Write to map
+	r.Status = WakeRequestCancelled
+	r.DecidedAt = &now
+	s.wakeRequests[id] = r
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// SetWakeRequestError records that the wake of an approved request failed.
+func (s *Store) SetWakeRequestError(id, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Check existence
+	r, exists := s.wakeRequests[id]
+	if !exists {
+		return ErrWakeRequestNotFound
+	}
+
+	// Action: Write to map
+	r.Error = message
+	s.wakeRequests[id] = r
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"slices"
+	"time"
+)
+
+// WebAuthnCredential is a passkey or security key registered by a user.
+type WebAuthnCredential struct {
+	ID             string     `json:"id"` // base64url credential ID
+	Name           string     `json:"name"`
+	PublicKey      []byte     `json:"public_key"` // COSE_Key
+	Algorithm      int64      `json:"algorithm"`
+	SignCount      uint32     `json:"sign_count"`
+	BackupEligible bool       `json:"backup_eligible"` // synced passkey
+	Transports     []string   `json:"transports,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+}
+
+// FindUserByCredential returns the user owning a WebAuthn credential, and the credential.
+func (s *Store) FindUserByCredential(credentialID string) (User, WebAuthnCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		for _, c := range u.Credentials {
+			if c.ID == credentialID {
+				return u, c, nil
+			}
+		}
+	}
+	return User{}, WebAuthnCredential{}, ErrCredentialNotFound
+}
+
+// AddWebAuthnCredential registers a credential for a user. Credential IDs are unique across users.
+func (s *Store) AddWebAuthnCredential(username string, cred WebAuthnCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Ensure target exists and the credential is new
+	u, exists := s.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+	for _, other := range s.users {
+		if slices.ContainsFunc(other.Credentials, func(c WebAuthnCredential) bool { return c.ID == cred.ID }) {
+			return ErrCredentialExists
+		}
+	}
+
+	// Action: Append to the user's credentials
+	u.Credentials = append(slices.Clone(u.Credentials), cred)
+	s.users[username] = u
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// UpdateWebAuthnCredential applies fn to a user's credential.
+func (s *Store) UpdateWebAuthnCredential(username, credentialID string, fn func(*WebAuthnCredential)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Ensure target exists
+	u, exists := s.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+	i := slices.IndexFunc(u.Credentials, func(c WebAuthnCredential) bool { return c.ID == credentialID })
+	if i < 0 {
+		return ErrCredentialNotFound
+	}
+
+	// Action: Modify a copy so readers holding the old slice are unaffected
+	u.Credentials = slices.Clone(u.Credentials)
+	fn(&u.Credentials[i])
+	s.users[username] = u
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
+
+// DeleteWebAuthnCredential removes a user's credential.
+func (s *Store) DeleteWebAuthnCredential(username, credentialID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guard: Ensure target exists
+	u, exists := s.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+	i := slices.IndexFunc(u.Credentials, func(c WebAuthnCredential) bool { return c.ID == credentialID })
+	if i < 0 {
+		return ErrCredentialNotFound
+	}
+
+	// Action: Remove from the user's credentials
+	u.Credentials = slices.Delete(slices.Clone(u.Credentials), i, i+1)
+	s.users[username] = u
+
+	// Persistence: Flush to disk
+	return s.flush()
+}
@@ -0,0 +1,93 @@
+package webauthn
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxPendingCeremonies = 10000
+
+// ErrTooManyCeremonies is returned when the pending ceremonies are at their limit and none has expired yet.
+var ErrTooManyCeremonies = errors.New("webauthn: too many pending ceremonies")
+
+// Ceremony is a registration or login in progress, remembered until the browser answers its challenge.
+type Ceremony struct {
+	Kind     string // "register" or "login"
+	Username string // empty for a usernameless (discoverable credential) login
+	Password bool   // the password was checked at the start, the passkey is a second factor
+	Expires  time.Time
+}
+
+// Challenges tracks pending ceremonies in memory. Each challenge can be used once.
+type Challenges struct {
+	mu      sync.Mutex
+	pending map[string]Ceremony
+}
+
+func NewChallenges() *Challenges {
+	return &Challenges{pending: make(map[string]Ceremony)}
+}
+
+// Begin returns a new random challenge for the ceremony.
+func (c *Challenges) Begin(ceremony Ceremony) ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) >= maxPendingCeremonies {
+		now := time.Now()
+		for k, v := range c.pending {
+			if now.After(v.Expires) {
+				delete(c.pending, k)
+			}
+		}
+		// Refuse rather than evict, so a flood of logins cannot cancel the ones in progress
+		if len(c.pending) >= maxPendingCeremonies {
+			return nil, ErrTooManyCeremonies
+		}
+	}
+	c.pending[base64.RawURLEncoding.EncodeToString(challenge)] = ceremony
+	return challenge, nil
+}
+
+// Finish removes and returns the ceremony of a challenge if it has not expired.
+func (c *Challenges) Finish(challenge []byte) (Ceremony, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := base64.RawURLEncoding.EncodeToString(challenge)
+	ceremony, ok := c.pending[key]
+	delete(c.pending, key)
+	if !ok || time.Now().After(ceremony.Expires) {
+		return Ceremony{}, false
+	}
+	return ceremony, true
+}
+
+// Base64URL is binary data encoded as unpadded base64url in JSON, as used by the WebAuthn JSON types.
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
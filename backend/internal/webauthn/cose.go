@@ -0,0 +1,110 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers of the supported signature algorithms.
+const (
+	AlgES256 int64 = -7
+	AlgRS256 int64 = -257
+)
+
+// COSE key labels (RFC 9053)
+const (
+	coseKty    = 1
+	coseAlg    = 3
+	coseCrv    = -1 // EC2
+	coseX      = -2 // EC2
+	coseY      = -3 // EC2
+	coseN      = -1 // RSA
+	coseE      = -2 // RSA
+	ktyEC2     = 2
+	ktyRSA     = 3
+	crvP256    = 1
+	minRSABits = 2048
+)
+
+// PublicKey is a credential public key decoded from its COSE form.
+type PublicKey struct {
+	Alg int64
+	key crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key holding an ES256 or RS256 public key.
+func ParsePublicKey(cose []byte) (*PublicKey, error) {
+	item, rest, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, fmt.Errorf("public key: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("public key: trailing data")
+	}
+	m, ok := item.(map[any]any)
+	if !ok {
+		return nil, errors.New("public key: not a map")
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("public key: invalid P-256 key")
+		}
+		point := append(append([]byte{4}, x...), y...)
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, fmt.Errorf("public key: %w", err)
+		}
+		return &PublicKey{Alg: alg, key: key}, nil
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+		if len(n)*8 < minRSABits || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("public key: invalid RSA key")
+		}
+		exp := int(new(big.Int).SetBytes(e).Int64())
+		if exp < 3 || exp%2 == 0 {
+			return nil, errors.New("public key: invalid RSA exponent")
+		}
+		return &PublicKey{Alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}}, nil
+	default:
+		return nil, fmt.Errorf("public key: unsupported key type %d with algorithm %d", kty, alg)
+	}
+}
+
+// Verify checks a signature over data made with the key's algorithm.
+func (k *PublicKey) Verify(data, sig []byte) error {
+	return verifySignature(k.Alg, k.key, data, sig)
+}
+
+func verifySignature(alg int64, key crypto.PublicKey, data, sig []byte) error {
+	digest := sha256.Sum256(data)
+	switch alg {
+	case AlgES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return ErrSignature
+		}
+		return nil
+	case AlgRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+			return ErrSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported algorithm %d", alg)
+	}
+}
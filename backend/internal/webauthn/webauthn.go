@@ -0,0 +1,306 @@
+// Package webauthn verifies WebAuthn registration (attestation) and login (assertion) responses.
+// It supports ES256 and RS256 credentials with "none" and "packed" attestation. Attestation
+// certificates are checked for a valid signature but not against a trust store, so the
+// authenticator model is not verified.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrSignature    = errors.New("webauthn: invalid signature")
+	ErrChallenge    = errors.New("webauthn: challenge mismatch")
+	ErrOrigin       = errors.New("webauthn: origin not allowed")
+	ErrRPID         = errors.New("webauthn: relying party ID mismatch")
+	ErrUserPresence = errors.New("webauthn: user not present")
+	ErrUserVerify   = errors.New("webauthn: user not verified")
+	ErrSignCount    = errors.New("webauthn: signature counter did not increase, the credential may be cloned")
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagBackupEligible   = 0x08
+	flagAttestedCredData = 0x40
+	flagExtensionData    = 0x80
+)
+
+// RelyingParty is the server side of a ceremony: the RP ID credentials are scoped to and
+// the origins the browser may report.
+type RelyingParty struct {
+	ID      string
+	Origins []string
+}
+
+// Credential is a newly registered credential.
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte // COSE_Key
+	Algorithm      int64
+	SignCount      uint32
+	AAGUID         []byte
+	Format         string // attestation format
+	UserVerified   bool
+	BackupEligible bool // a synced passkey rather than a device-bound key
+}
+
+// Assertion is the result of a verified login.
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+
+	// Present during registration
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// ChallengeFromClientData extracts the challenge from clientDataJSON, e.g. to look up the ceremony it belongs to.
+func ChallengeFromClientData(clientDataJSON []byte) ([]byte, error) {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return nil, fmt.Errorf("webauthn: invalid client data: %w", err)
+	}
+	return base64.RawURLEncoding.DecodeString(cd.Challenge)
+}
+
+// VerifyRegistration checks a navigator.credentials.create() response against the challenge issued for it.
+func (rp RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte, requireUV bool) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	item, rest, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: attestation object: %w", err)
+	}
+	att, ok := item.(map[any]any)
+	if !ok || len(rest) != 0 {
+		return nil, errors.New("webauthn: malformed attestation object")
+	}
+	format, _ := att["fmt"].(string)
+	stmt, _ := att["attStmt"].(map[any]any)
+	rawAuthData, _ := att["authData"].([]byte)
+	if stmt == nil || rawAuthData == nil {
+		return nil, errors.New("webauthn: malformed attestation object")
+	}
+
+	ad, err := parseAuthData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthData(ad, requireUV); err != nil {
+		return nil, err
+	}
+	if ad.flags&flagAttestedCredData == 0 {
+		return nil, errors.New("webauthn: no attested credential data")
+	}
+	key, err := ParsePublicKey(ad.publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: %w", err)
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(slices.Clip(rawAuthData), clientDataHash[:]...)
+	switch format {
+	case "none":
+		if len(stmt) != 0 {
+			return nil, errors.New("webauthn: none attestation with a statement")
+		}
+	case "packed":
+		if err := verifyPacked(stmt, key, ad.aaguid, signed); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("webauthn: unsupported attestation format %q", format)
+	}
+
+	return &Credential{
+		ID:             ad.credentialID,
+		PublicKey:      ad.publicKey,
+		Algorithm:      key.Alg,
+		SignCount:      ad.signCount,
+		AAGUID:         ad.aaguid,
+		Format:         format,
+		UserVerified:   ad.flags&flagUserVerified != 0,
+		BackupEligible: ad.flags&flagBackupEligible != 0,
+	}, nil
+}
+
+// VerifyAssertion checks a navigator.credentials.get() response made with a stored credential.
+// storedCount is the last signature counter seen for the credential.
+func (rp RelyingParty) VerifyAssertion(challenge, clientDataJSON, authenticatorData, signature, publicKey []byte, storedCount uint32, requireUV bool) (*Assertion, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+	ad, err := parseAuthData(authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthData(ad, requireUV); err != nil {
+		return nil, err
+	}
+
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: %w", err)
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(slices.Clip(authenticatorData), clientDataHash[:]...)
+	if err := key.Verify(signed, signature); err != nil {
+		return nil, err
+	}
+
+	// Authenticators without a counter always report 0, e.g. synced passkeys
+	if (ad.signCount != 0 || storedCount != 0) && ad.signCount <= storedCount {
+		return nil, ErrSignCount
+	}
+	return &Assertion{SignCount: ad.signCount, UserVerified: ad.flags&flagUserVerified != 0}, nil
+}
+
+func (rp RelyingParty) verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return fmt.Errorf("webauthn: invalid client data: %w", err)
+	}
+	if cd.Type != ceremony {
+		return fmt.Errorf("webauthn: unexpected client data type %q", cd.Type)
+	}
+	got, err := base64.RawURLEncoding.DecodeString(cd.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrChallenge
+	}
+	if !slices.Contains(rp.Origins, cd.Origin) {
+		return ErrOrigin
+	}
+	return nil
+}
+
+func (rp RelyingParty) verifyAuthData(ad *authData, requireUV bool) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.rpIDHash, rpIDHash[:]) {
+		return ErrRPID
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return ErrUserPresence
+	}
+	if requireUV && ad.flags&flagUserVerified == 0 {
+		return ErrUserVerify
+	}
+	return nil
+}
+
+func parseAuthData(b []byte) (*authData, error) {
+	if len(b) < 37 {
+		return nil, errors.New("webauthn: authenticator data too short")
+	}
+	ad := &authData{
+		rpIDHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	rest := b[37:]
+
+	if ad.flags&flagAttestedCredData != 0 {
+		if len(rest) < 18 {
+			return nil, errors.New("webauthn: attested credential data too short")
+		}
+		ad.aaguid = rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen > 1023 || len(rest) < idLen {
+			return nil, errors.New("webauthn: invalid credential ID length")
+		}
+		ad.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		// The public key is a CBOR item of unknown length, decode it to find where it ends
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("webauthn: credential public key: %w", err)
+		}
+		ad.publicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+	if ad.flags&flagExtensionData != 0 {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("webauthn: extensions: %w", err)
+		}
+		rest = after
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("webauthn: trailing authenticator data")
+	}
+	return ad, nil
+}
+
+// verifyPacked checks a packed attestation statement, either self attestation signed by the
+// credential key or basic attestation signed by an attestation certificate.
+func verifyPacked(stmt map[any]any, key *PublicKey, aaguid, signed []byte) error {
+	alg, _ := stmt["alg"].(int64)
+	sig, _ := stmt["sig"].([]byte)
+	if sig == nil {
+		return errors.New("webauthn: packed attestation without signature")
+	}
+
+	x5c, hasCerts := stmt["x5c"].([]any)
+	if !hasCerts {
+		if alg != key.Alg {
+			return errors.New("webauthn: self attestation algorithm does not match the credential")
+		}
+		return key.Verify(signed, sig)
+	}
+
+	if len(x5c) == 0 {
+		return errors.New("webauthn: empty attestation certificate chain")
+	}
+	der, _ := x5c[0].([]byte)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("webauthn: attestation certificate: %w", err)
+	}
+	if err := verifySignature(alg, cert.PublicKey, signed, sig); err != nil {
+		return err
+	}
+
+	// Certificate requirements of WebAuthn §8.2.1
+	if cert.Version != 3 || cert.IsCA {
+		return errors.New("webauthn: attestation certificate must be a version 3 end-entity certificate")
+	}
+	if !slices.Contains(cert.Subject.OrganizationalUnit, "Authenticator Attestation") {
+		return errors.New("webauthn: attestation certificate has the wrong subject")
+	}
+	for _, ext := range cert.Extensions {
+		// id-fido-gen-ce-aaguid holds an OCTET STRING wrapping the AAGUID
+		if ext.Id.String() == "1.3.6.1.4.1.45724.1.1.4" {
+			if len(ext.Value) != 18 || !bytes.Equal(ext.Value[2:], aaguid) {
+				return errors.New("webauthn: attestation certificate AAGUID does not match")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,390 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	testRPID   = "wolite.example"
+	testOrigin = "https://wolite.example"
+)
+
+var testRP = RelyingParty{ID: testRPID, Origins: []string{testOrigin}}
+
+// Minimal CBOR encoder for building fixtures. cborMap keeps keys in the given order.
+type cborPair struct {
+	key, value any
+}
+type cborMap []cborPair
+
+func encodeHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 1<<8:
+		return []byte{major<<5 | 24, byte(n)}
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+}
+
+func encodeCBOR(v any) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return encodeHead(1, uint64(-1-v))
+		}
+		return encodeHead(0, uint64(v))
+	case int64:
+		return encodeCBOR(int(v))
+	case []byte:
+		return append(encodeHead(2, uint64(len(v))), v...)
+	case string:
+		return append(encodeHead(3, uint64(len(v))), v...)
+	case []any:
+		out := encodeHead(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	case cborMap:
+		out := encodeHead(5, uint64(len(v)))
+		for _, p := range v {
+			out = append(out, encodeCBOR(p.key)...)
+			out = append(out, encodeCBOR(p.value)...)
+		}
+		return out
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	}
+	panic("unsupported fixture type")
+}
+
+// authenticator is a software authenticator that produces the fixtures.
+type authenticator struct {
+	signer crypto.Signer
+	alg    int64
+	credID []byte
+	aaguid []byte
+	count  uint32
+}
+
+func newES256Authenticator(t *testing.T) *authenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{signer: key, alg: AlgES256, credID: []byte("es256-credential"), aaguid: make([]byte, 16)}
+}
+
+func newRS256Authenticator(t *testing.T) *authenticator {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{signer: key, alg: AlgRS256, credID: []byte("rs256-credential"), aaguid: make([]byte, 16)}
+}
+
+func (a *authenticator) coseKey() []byte {
+	switch pub := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		point, _ := pub.Bytes()
+		return encodeCBOR(cborMap{{1, 2}, {3, -7}, {-1, 1}, {-2, point[1:33]}, {-3, point[33:]}})
+	case *rsa.PublicKey:
+		return encodeCBOR(cborMap{{1, 3}, {3, -257}, {-1, pub.N.Bytes()}, {-2, big.NewInt(int64(pub.E)).Bytes()}})
+	}
+	panic("unsupported key")
+}
+
+func (a *authenticator) authData(rpID string, flags byte, attested bool) []byte {
+	hash := sha256.Sum256([]byte(rpID))
+	out := append(hash[:], flags)
+	out = binary.BigEndian.AppendUint32(out, a.count)
+	if attested {
+		out = append(out, a.aaguid...)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(a.credID)))
+		out = append(out, a.credID...)
+		out = append(out, a.coseKey()...)
+	}
+	return out
+}
+
+func sign(t *testing.T, signer crypto.Signer, data []byte) []byte {
+	t.Helper()
+	digest := sha256.Sum256(data)
+	var opts crypto.SignerOpts = crypto.SHA256
+	sig, err := signer.Sign(rand.Reader, digest[:], opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func clientDataJSON(typ string, challenge []byte, origin string) []byte {
+	b, _ := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    origin,
+	})
+	return b
+}
+
+func signedData(authData, clientData []byte) []byte {
+	hash := sha256.Sum256(clientData)
+	return append(append([]byte(nil), authData...), hash[:]...)
+}
+
+func TestRegistrationNone(t *testing.T) {
+	a := newES256Authenticator(t)
+	challenge := []byte("registration-challenge-0123456789")
+	cd := clientDataJSON("webauthn.create", challenge, testOrigin)
+	att := encodeCBOR(cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", a.authData(testRPID, flagUserPresent|flagUserVerified|flagBackupEligible|flagAttestedCredData, true)},
+	})
+
+	cred, err := testRP.VerifyRegistration(challenge, cd, att, true)
+	if err != nil {
+		t.Fatalf("VerifyRegistration failed: %v", err)
+	}
+	if string(cred.ID) != string(a.credID) || cred.Algorithm != AlgES256 || !cred.UserVerified || !cred.BackupEligible {
+		t.Errorf("unexpected credential: %+v", cred)
+	}
+	if _, err := ParsePublicKey(cred.PublicKey); err != nil {
+		t.Errorf("stored public key does not parse: %v", err)
+	}
+}
+
+func TestRegistrationPackedSelf(t *testing.T) {
+	a := newRS256Authenticator(t)
+	challenge := []byte("registration-challenge-0123456789")
+	cd := clientDataJSON("webauthn.create", challenge, testOrigin)
+	ad := a.authData(testRPID, flagUserPresent|flagAttestedCredData, true)
+	att := encodeCBOR(cborMap{
+		{"fmt", "packed"},
+		{"attStmt", cborMap{{"alg", -257}, {"sig", sign(t, a.signer, signedData(ad, cd))}}},
+		{"authData", ad},
+	})
+
+	cred, err := testRP.VerifyRegistration(challenge, cd, att, false)
+	if err != nil {
+		t.Fatalf("VerifyRegistration failed: %v", err)
+	}
+	if cred.Algorithm != AlgRS256 || cred.Format != "packed" {
+		t.Errorf("unexpected credential: %+v", cred)
+	}
+}
+
+func TestRegistrationPackedCertificate(t *testing.T) {
+	a := newES256Authenticator(t)
+	a.aaguid = []byte("0123456789abcdef")
+	attKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"Test Vendor"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "Test Authenticator",
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{
+			Id:    []int{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4},
+			Value: append([]byte{0x04, 0x10}, a.aaguid...),
+		}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &attKey.PublicKey, attKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	challenge := []byte("registration-challenge-0123456789")
+	cd := clientDataJSON("webauthn.create", challenge, testOrigin)
+	ad := a.authData(testRPID, flagUserPresent|flagAttestedCredData, true)
+	stmt := func(signer crypto.Signer) []byte {
+		return encodeCBOR(cborMap{
+			{"fmt", "packed"},
+			{"attStmt", cborMap{{"alg", -7}, {"sig", sign(t, signer, signedData(ad, cd))}, {"x5c", []any{der}}}},
+			{"authData", ad},
+		})
+	}
+
+	if _, err := testRP.VerifyRegistration(challenge, cd, stmt(attKey), false); err != nil {
+		t.Fatalf("VerifyRegistration failed: %v", err)
+	}
+	// Signed by the credential instead of the attestation key
+	if _, err := testRP.VerifyRegistration(challenge, cd, stmt(a.signer), false); !errors.Is(err, ErrSignature) {
+		t.Errorf("expected ErrSignature, got %v", err)
+	}
+}
+
+func TestRegistrationRejects(t *testing.T) {
+	a := newES256Authenticator(t)
+	challenge := []byte("registration-challenge-0123456789")
+	build := func(flags byte, rpID string) []byte {
+		return encodeCBOR(cborMap{{"fmt", "none"}, {"attStmt", cborMap{}}, {"authData", a.authData(rpID, flags, true)}})
+	}
+	valid := byte(flagUserPresent | flagAttestedCredData)
+
+	tests := []struct {
+		name      string
+		cd        []byte
+		att       []byte
+		requireUV bool
+		want      error
+	}{
+		{"wrong challenge", clientDataJSON("webauthn.create", []byte("other"), testOrigin), build(valid, testRPID), false, ErrChallenge},
+		{"wrong origin", clientDataJSON("webauthn.create", challenge, "https://evil.example"), build(valid, testRPID), false, ErrOrigin},
+		{"wrong rp id", clientDataJSON("webauthn.create", challenge, testOrigin), build(valid, "evil.example"), false, ErrRPID},
+		{"user not present", clientDataJSON("webauthn.create", challenge, testOrigin), build(flagAttestedCredData, testRPID), false, ErrUserPresence},
+		{"user not verified", clientDataJSON("webauthn.create", challenge, testOrigin), build(valid, testRPID), true, ErrUserVerify},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := testRP.VerifyRegistration(challenge, tt.cd, tt.att, tt.requireUV); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// A login response must not pass as a registration
+	cd := clientDataJSON("webauthn.get", challenge, testOrigin)
+	if _, err := testRP.VerifyRegistration(challenge, cd, build(valid, testRPID), false); err == nil {
+		t.Error("expected error for webauthn.get client data")
+	}
+}
+
+func TestAssertion(t *testing.T) {
+	for _, a := range []*authenticator{newES256Authenticator(t), newRS256Authenticator(t)} {
+		publicKey := a.coseKey()
+		challenge := []byte("login-challenge-0123456789abcdef")
+		cd := clientDataJSON("webauthn.get", challenge, testOrigin)
+
+		a.count = 5
+		ad := a.authData(testRPID, flagUserPresent|flagUserVerified, false)
+		sig := sign(t, a.signer, signedData(ad, cd))
+
+		res, err := testRP.VerifyAssertion(challenge, cd, ad, sig, publicKey, 4, true)
+		if err != nil {
+			t.Fatalf("alg %d: VerifyAssertion failed: %v", a.alg, err)
+		}
+		if res.SignCount != 5 || !res.UserVerified {
+			t.Errorf("alg %d: unexpected assertion: %+v", a.alg, res)
+		}
+
+		if _, err := testRP.VerifyAssertion(challenge, cd, ad, sig, publicKey, 5, true); !errors.Is(err, ErrSignCount) {
+			t.Errorf("alg %d: expected ErrSignCount for a replayed counter, got %v", a.alg, err)
+		}
+
+		tampered := append([]byte(nil), ad...)
+		tampered[36]++ // raise the counter without re-signing
+		if _, err := testRP.VerifyAssertion(challenge, cd, tampered, sig, publicKey, 4, true); !errors.Is(err, ErrSignature) {
+			t.Errorf("alg %d: expected ErrSignature for tampered data, got %v", a.alg, err)
+		}
+	}
+}
+
+func TestAssertionWithoutCounter(t *testing.T) {
+	a := newES256Authenticator(t)
+	challenge := []byte("login-challenge-0123456789abcdef")
+	cd := clientDataJSON("webauthn.get", challenge, testOrigin)
+	ad := a.authData(testRPID, flagUserPresent, false)
+
+	if _, err := testRP.VerifyAssertion(challenge, cd, ad, sign(t, a.signer, signedData(ad, cd)), a.coseKey(), 0, false); err != nil {
+		t.Errorf("expected a zero counter to be accepted, got %v", err)
+	}
+}
+
+func TestChallengeFromClientData(t *testing.T) {
+	challenge := []byte("some-challenge")
+	got, err := ChallengeFromClientData(clientDataJSON("webauthn.get", challenge, testOrigin))
+	if err != nil || string(got) != string(challenge) {
+		t.Errorf("expected %q, got %q (%v)", challenge, got, err)
+	}
+}
+
+func TestChallenges(t *testing.T) {
+	c := NewChallenges()
+	challenge, err := c.Begin(Ceremony{Kind: "login", Username: "alice", Expires: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ceremony, ok := c.Finish(challenge); !ok || ceremony.Username != "alice" {
+		t.Fatalf("expected the pending ceremony, got %+v, %v", ceremony, ok)
+	}
+	if _, ok := c.Finish(challenge); ok {
+		t.Error("a challenge must only be usable once")
+	}
+
+	expired, _ := c.Begin(Ceremony{Kind: "login", Expires: time.Now().Add(-time.Second)})
+	if _, ok := c.Finish(expired); ok {
+		t.Error("an expired challenge must be rejected")
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	in := encodeCBOR(cborMap{{1, -7}, {"list", []any{[]byte{1, 2}, "x", true}}, {-300, 70000}})
+	item, rest, err := decodeCBOR(in)
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("decode failed: %v (rest %d bytes)", err, len(rest))
+	}
+	m := item.(map[any]any)
+	if m[int64(1)] != int64(-7) || m[int64(-300)] != int64(70000) {
+		t.Errorf("unexpected integers: %v", m)
+	}
+	if list := m["list"].([]any); len(list) != 3 || list[1] != "x" || list[2] != true {
+		t.Errorf("unexpected list: %v", list)
+	}
+
+	for _, bad := range [][]byte{
+		{0x5a, 0xff, 0xff, 0xff, 0xff}, // byte string longer than the input
+		{0x9f},                         // indefinite length array
+		{0xa2, 0x01, 0x01, 0x01, 0x02}, // duplicate map key
+	} {
+		if _, _, err := decodeCBOR(bad); err == nil {
+			t.Errorf("expected error for % x", bad)
+		}
+	}
+}
+
+func TestChallengesLimit(t *testing.T) {
+	c := NewChallenges()
+	for i := range maxPendingCeremonies {
+		c.pending[strconv.Itoa(i)] = Ceremony{Kind: "login", Expires: time.Now().Add(time.Minute)}
+	}
+	if _, err := c.Begin(Ceremony{Kind: "login", Expires: time.Now().Add(time.Minute)}); err != ErrTooManyCeremonies {
+		t.Fatalf("expected ErrTooManyCeremonies when full, got %v", err)
+	}
+
+	c.pending["0"] = Ceremony{Kind: "login", Expires: time.Now().Add(-time.Second)}
+	if _, err := c.Begin(Ceremony{Kind: "login", Expires: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("expected expired ceremonies to make room, got %v", err)
+	}
+}
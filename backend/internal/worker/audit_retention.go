@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"wolite/internal/audit"
+)
+
+// AuditRetention removes audit log entries older than the retention period.
+type AuditRetention struct {
+	log      *audit.Logger
+	keep     time.Duration
+	interval time.Duration
+}
+
+func NewAuditRetention(log *audit.Logger, keep, interval time.Duration) *AuditRetention {
+	return &AuditRetention{
+		log:      log,
+		keep:     keep,
+		interval: interval,
+	}
+}
+
+func (a *AuditRetention) Start(ctx context.Context) {
+	a.prune()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.prune()
+		}
+	}
+}
+
+func (a *AuditRetention) prune() {
+	removed, err := a.log.Prune(time.Now().Add(-a.keep))
+	if err != nil {
+		slog.Error("failed to prune audit log", "error", err)
+		return
+	}
+	if removed > 0 {
+		slog.Info("audit log pruned", "removed", removed)
+	}
+}
@@ -0,0 +1,220 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+	"wolite/internal/audit"
+	"wolite/internal/companion"
+	"wolite/internal/store"
+)
+
+const idleJitter = 10 * time.Second // tolerance when matching idle reports to the same idle period
+
+// IdleMonitor polls the companions of devices with an idle policy and powers them down
+// once their users have been idle long enough, warning them first.
+type IdleMonitor struct {
+	store    *store.Store
+	audit    *audit.Logger
+	interval time.Duration
+
+	mu    sync.Mutex
+	state map[string]*idleState // keyed by MAC address
+}
+
+type idleState struct {
+	idleSince time.Time // start of the current idle period
+	warnedAt  time.Time // warning sent for the current idle period
+	actedAt   time.Time // power action sent for the current idle period
+}
+
+func NewIdleMonitor(store *store.Store, auditLog *audit.Logger, interval time.Duration) *IdleMonitor {
+	return &IdleMonitor{
+		store:    store,
+		audit:    auditLog,
+		interval: interval,
+		state:    make(map[string]*idleState),
+	}
+}
+
+func (m *IdleMonitor) Start(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.checkAll(ctx)
+		}
+	}
+}
+
+// InIdleWindow reports whether the policy applies at the given time.
+// An empty window applies all day; a window whose end is before its start wraps past midnight.
+func InIdleWindow(policy store.IdlePolicy, t time.Time) (bool, error) {
+	if policy.WindowStart == "" && policy.WindowEnd == "" {
+		return true, nil
+	}
+	tz := policy.Timezone
+	if tz == "" {
+		tz = "UTC"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return false, err
+	}
+	start, err := time.Parse("15:04", policy.WindowStart)
+	if err != nil {
+		return false, fmt.Errorf("invalid window start: %q", policy.WindowStart)
+	}
+	end, err := time.Parse("15:04", policy.WindowEnd)
+	if err != nil {
+		return false, fmt.Errorf("invalid window end: %q", policy.WindowEnd)
+	}
+
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	if from <= to {
+		return now >= from && now < to, nil
+	}
+	return now >= from || now < to, nil
+}
+
+func (m *IdleMonitor) checkAll(ctx context.Context) {
+	devices, err := m.store.GetAllDevices()
+	if err != nil {
+		slog.Error("failed to get devices for idle check", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, device := range devices {
+		p := device.IdlePolicy
+		if p == nil || !p.Enabled || device.CompanionURL == "" || device.Status != store.StatusOnline {
+			m.reset(device.MACAddress)
+			continue
+		}
+		inWindow, err := InIdleWindow(*p, now)
+		if err != nil {
+			slog.Warn("invalid idle policy", "mac", device.MACAddress, "error", err)
+			continue
+		}
+		if !inWindow {
+			m.reset(device.MACAddress)
+			continue
+		}
+
+		go m.checkDevice(ctx, device, now)
+	}
+}
+
+func (m *IdleMonitor) reset(mac string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.state, mac)
+}
+
+func (m *IdleMonitor) checkDevice(ctx context.Context, device store.Device, now time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	policy := *device.IdlePolicy
+	client, err := companion.NewClient(device.CompanionURL, device.CompanionToken, device.CompanionAuthFingerprint)
+	if err != nil {
+		slog.Warn("invalid companion config during idle check", "mac", device.MACAddress, "error", err)
+		return
+	}
+
+	status, err := client.Idle(ctx)
+	if err != nil {
+		slog.Warn("failed to get idle status", "mac", device.MACAddress, "error", err)
+		return
+	}
+
+	m.mu.Lock()
+	st := m.state[device.MACAddress]
+	if st == nil {
+		st = &idleState{}
+		m.state[device.MACAddress] = st
+	}
+	switch {
+	case status.IdleSeconds != nil:
+		// Input resets the idle period; later reports of the same period keep its start
+		since := now.Add(-time.Duration(*status.IdleSeconds) * time.Second)
+		if st.idleSince.IsZero() || since.Sub(st.idleSince) > idleJitter {
+			*st = idleState{idleSince: since}
+		}
+	case len(status.Sessions) == 0:
+		// Nobody logged in: idle since we first noticed
+		if st.idleSince.IsZero() {
+			st.idleSince = now
+		}
+	default:
+		// Sessions exist but their activity is unknown, never act on a guess
+		delete(m.state, device.MACAddress)
+		m.mu.Unlock()
+		return
+	}
+	idle := now.Sub(st.idleSince)
+	warning := time.Duration(policy.WarningMinutes) * time.Minute
+	threshold := time.Duration(policy.IdleMinutes) * time.Minute
+	sendWarning := warning > 0 && st.warnedAt.IsZero() && idle >= threshold-warning
+	act := st.actedAt.IsZero() && idle >= threshold && (warning == 0 || (!st.warnedAt.IsZero() && now.Sub(st.warnedAt) >= warning))
+	if sendWarning {
+		st.warnedAt = now
+	}
+	m.mu.Unlock()
+
+	if sendWarning {
+		msg := fmt.Sprintf("This computer has been idle and will %s in %d minute(s). Use it to cancel.", policy.Action, policy.WarningMinutes)
+		if err := client.Notify(ctx, "Wolite", msg); err != nil {
+			slog.Warn("failed to send idle warning", "mac", device.MACAddress, "error", err)
+		} else {
+			slog.Info("idle warning sent", "mac", device.MACAddress, "action", policy.Action)
+		}
+	}
+	if !act {
+		return
+	}
+
+	// Never take down a device that online devices depend on
+	dependents, err := m.store.ShutdownOrder(device.MACAddress)
+	if err != nil {
+		slog.Error("failed to resolve dependents", "mac", device.MACAddress, "error", err)
+		return
+	}
+	for _, d := range dependents {
+		if d.Status == store.StatusOnline {
+			slog.Info("idle action skipped, dependents online", "mac", device.MACAddress, "dependent", d.MACAddress)
+			return
+		}
+	}
+
+	action, err := companion.ParseAction(policy.Action)
+	if err != nil {
+		slog.Warn("invalid idle policy", "mac", device.MACAddress, "error", err)
+		return
+	}
+	entry := audit.Entry{Action: audit.ActionPower, Target: device.MACAddress, Detail: fmt.Sprintf("%s, idle policy after %s", action, idle.Round(time.Minute))}
+	if err := client.Power(ctx, action); err != nil {
+		entry.Result = audit.ResultFailure
+		entry.Detail += ": " + err.Error()
+		m.audit.Log(entry)
+		slog.Error("failed to run idle action", "mac", device.MACAddress, "action", action, "error", err)
+		return
+	}
+	// Only an action that was sent ends the idle period; skipped or failed ones are retried on the next poll
+	m.mu.Lock()
+	if st := m.state[device.MACAddress]; st != nil {
+		st.actedAt = now
+	}
+	m.mu.Unlock()
+	m.audit.Log(entry)
+	slog.Info("idle action executed", "mac", device.MACAddress, "action", action, "idle", idle.Round(time.Second))
+}
@@ -0,0 +1,374 @@
+package worker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+	"wolite/internal/audit"
+	"wolite/internal/companion"
+	"wolite/internal/power"
+	"wolite/internal/store"
+)
+
+type RunStatus string
+
+const (
+	RunPending   RunStatus = "pending"
+	RunRunning   RunStatus = "running"
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+	RunSkipped   RunStatus = "skipped"
+	RunCancelled RunStatus = "cancelled"
+)
+
+const (
+	defaultOnlineTimeout = 5 * time.Minute
+	onlinePollInterval   = 5 * time.Second
+	powerTimeout         = 10 * time.Second
+	maxFinishedRuns      = 100 // finished runs kept in memory for the result API
+)
+
+// StepResult is the progress of a single step within a run.
+type StepResult struct {
+	store.SceneStep
+	Status     RunStatus  `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Run is a background execution of an ordered list of steps, e.g. a scene.
+type Run struct {
+	ID         string       `json:"id"`
+	Username   string       `json:"username"` // user the steps run on behalf of
+	Name       string       `json:"name"`
+	SceneID    string       `json:"scene_id,omitempty"`
+	Status     RunStatus    `json:"status"`
+	Steps      []StepResult `json:"steps"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the run finishes
+}
+
+// Runner executes runs in the background and keeps their progress in memory.
+type Runner struct {
+	store *store.Store
+	audit *audit.Logger
+	ctx   context.Context
+
+	mu   sync.Mutex
+	runs map[string]*Run
+}
+
+func NewRunner(ctx context.Context, store *store.Store, auditLog *audit.Logger) *Runner {
+	return &Runner{
+		store: store,
+		audit: auditLog,
+		ctx:   ctx,
+		runs:  make(map[string]*Run),
+	}
+}
+
+// Start begins executing steps in the background and returns a snapshot of the new run.
+func (r *Runner) Start(username, name, sceneID string, steps []store.SceneStep) Run {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	run := &Run{
+		ID:        hex.EncodeToString(b),
+		Username:  username,
+		Name:      name,
+		SceneID:   sceneID,
+		Status:    RunPending,
+		Steps:     make([]StepResult, len(steps)),
+		StartedAt: time.Now(),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	for i, step := range steps {
+		run.Steps[i] = StepResult{SceneStep: step, Status: RunPending}
+	}
+
+	r.mu.Lock()
+	r.runs[run.ID] = run
+	r.pruneLocked()
+	snapshot := run.snapshot()
+	r.mu.Unlock()
+
+	go r.execute(ctx, run)
+	slog.Info("run started", "run_id", run.ID, "name", name, "username", username, "steps", len(steps))
+	return snapshot
+}
+
+// Get returns a snapshot of a run, only if it belongs to the given username.
+func (r *Runner) Get(username, id string) (Run, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	run, ok := r.runs[id]
+	if !ok || run.Username != username {
+		return Run{}, false
+	}
+	return run.snapshot(), true
+}
+
+// List returns snapshots of a user's runs, newest first. An empty sceneID returns all runs.
+func (r *Runner) List(username, sceneID string) []Run {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	runs := make([]Run, 0)
+	for _, run := range r.runs {
+		if run.Username != username || (sceneID != "" && run.SceneID != sceneID) {
+			continue
+		}
+		runs = append(runs, run.snapshot())
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	return runs
+}
+
+// Wait blocks until a run finishes or the context is done, and returns a snapshot of the run.
+func (r *Runner) Wait(ctx context.Context, id string) (Run, bool) {
+	r.mu.Lock()
+	run, ok := r.runs[id]
+	r.mu.Unlock()
+	if !ok {
+		return Run{}, false
+	}
+
+	select {
+	case <-run.done:
+	case <-ctx.Done():
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return run.snapshot(), true
+}
+
+// Cancel stops a run that belongs to the given username. Steps not yet finished are cancelled.
+func (r *Runner) Cancel(username, id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	run, ok := r.runs[id]
+	if !ok || run.Username != username {
+		return false
+	}
+	run.cancel()
+	return true
+}
+
+func (r *Runner) execute(ctx context.Context, run *Run) {
+	defer close(run.done)
+	defer run.cancel()
+
+	r.setRunStatus(run, RunRunning)
+	final := RunSucceeded
+
+	for i := range run.Steps {
+		if ctx.Err() != nil {
+			final = RunCancelled
+			r.finishStep(run, i, RunCancelled, nil)
+			continue
+		}
+		if final == RunFailed {
+			r.finishStep(run, i, RunSkipped, nil)
+			continue
+		}
+
+		r.startStep(run, i)
+		err := r.executeStep(ctx, run.Username, run.Steps[i].SceneStep)
+		r.auditStep(run, run.Steps[i].SceneStep, err)
+		switch {
+		case err == nil:
+			r.finishStep(run, i, RunSucceeded, nil)
+		case ctx.Err() != nil:
+			final = RunCancelled
+			r.finishStep(run, i, RunCancelled, err)
+		default:
+			r.finishStep(run, i, RunFailed, err)
+			if !run.Steps[i].ContinueOnError {
+				final = RunFailed
+			}
+		}
+	}
+
+	r.setRunStatus(run, final)
+	slog.Info("run finished", "run_id", run.ID, "name", run.Name, "status", final)
+}
+
+func (r *Runner) executeStep(ctx context.Context, username string, step store.SceneStep) error {
+	if step.DelaySeconds > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(step.DelaySeconds) * time.Second):
+		}
+	}
+
+	// Re-check access at run time, the user may have lost the device since the steps were defined
+	device, err := r.store.GetDeviceForUser(username, step.MACAddress)
+	if err != nil {
+		return err
+	}
+
+	switch step.Type {
+	case store.StepWake:
+		if err := power.Wake(*device); err != nil {
+			return err
+		}
+		if !step.WaitOnline {
+			return nil
+		}
+
+		timeout := defaultOnlineTimeout
+		if step.TimeoutSeconds > 0 {
+			timeout = time.Duration(step.TimeoutSeconds) * time.Second
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if err := power.WaitOnline(waitCtx, *device, onlinePollInterval); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("device did not come online within %s", timeout)
+			}
+			return err
+		}
+
+		// Reflect the new status right away instead of waiting for the status checker
+		if device, err := r.store.GetDeviceByMacAddress(step.MACAddress); err == nil && device.Status != store.StatusOnline {
+			device.Status = store.StatusOnline
+			if err := r.store.UpdateDevice(device); err != nil {
+				slog.Error("failed to update device status", "mac", device.MACAddress, "error", err)
+			}
+		}
+		return nil
+	case store.StepPower:
+		action, err := companion.ParseAction(step.Action)
+		if err != nil {
+			return err
+		}
+		powerCtx, cancel := context.WithTimeout(ctx, powerTimeout)
+		defer cancel()
+		return power.Execute(powerCtx, *device, action)
+	default:
+		return fmt.Errorf("unknown step type: %q", step.Type)
+	}
+}
+
+// auditStep records a wake or power action sent by a run. Failures before the action was sent, e.g. a
+// cancelled delay, are not recorded.
+func (r *Runner) auditStep(run *Run, step store.SceneStep, err error) {
+	if err != nil && errors.Is(err, context.Canceled) {
+		return
+	}
+	e := audit.Entry{Actor: run.Username, Username: run.Username, Target: step.MACAddress, Detail: "run " + run.Name}
+	switch step.Type {
+	case store.StepWake:
+		e.Action = audit.ActionWake
+	case store.StepPower:
+		e.Action = audit.ActionPower
+		e.Detail = step.Action + ", " + e.Detail
+	default:
+		return
+	}
+	if err != nil {
+		e.Result = audit.ResultFailure
+		e.Detail += ": " + err.Error()
+	}
+	r.audit.Log(e)
+}
+
+// WakeSteps returns the steps that wake a device after its prerequisites, waiting for each
+// prerequisite that can report its status to come online.
+func WakeSteps(st *store.Store, device store.Device) ([]store.SceneStep, error) {
+	prerequisites, err := st.WakeOrder(device.MACAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]store.SceneStep, 0, len(prerequisites)+1)
+	for _, p := range prerequisites {
+		// Only devices with a companion can report that they are online
+		steps = append(steps, store.SceneStep{
+			Type:       store.StepWake,
+			MACAddress: p.MACAddress,
+			WaitOnline: p.CompanionURL != "",
+		})
+	}
+	steps = append(steps, store.SceneStep{Type: store.StepWake, MACAddress: device.MACAddress})
+	return steps, nil
+}
+
+func (r *Runner) setRunStatus(run *Run, status RunStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	run.Status = status
+	if status != RunRunning {
+		now := time.Now()
+		run.FinishedAt = &now
+	}
+}
+
+func (r *Runner) startStep(run *Run, i int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	run.Steps[i].Status = RunRunning
+	run.Steps[i].StartedAt = &now
+}
+
+func (r *Runner) finishStep(run *Run, i int, status RunStatus, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	run.Steps[i].Status = status
+	run.Steps[i].FinishedAt = &now
+	if err != nil {
+		run.Steps[i].Error = err.Error()
+	}
+}
+
+// pruneLocked drops the oldest finished runs beyond maxFinishedRuns. Caller must hold r.mu.
+func (r *Runner) pruneLocked() {
+	finished := make([]*Run, 0)
+	for _, run := range r.runs {
+		if run.FinishedAt != nil {
+			finished = append(finished, run)
+		}
+	}
+	if len(finished) <= maxFinishedRuns {
+		return
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].FinishedAt.Before(*finished[j].FinishedAt)
+	})
+	for _, run := range finished[:len(finished)-maxFinishedRuns] {
+		delete(r.runs, run.ID)
+	}
+}
+
+// snapshot returns a copy of the run that is safe to hand out. Caller must hold r.mu.
+func (run *Run) snapshot() Run {
+	c := *run
+	c.Steps = append([]StepResult(nil), run.Steps...)
+	c.cancel = nil
+	c.done = nil
+	return c
+}
@@ -0,0 +1,236 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+	"wolite/internal/companion"
+	"wolite/internal/cron"
+	"wolite/internal/store"
+)
+
+// Scheduler fires schedules from the store when their cron expression is due.
+type Scheduler struct {
+	store    *store.Store
+	runner   *Runner
+	interval time.Duration
+}
+
+func NewScheduler(store *store.Store, runner *Runner, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		store:    store,
+		runner:   runner,
+		interval: interval,
+	}
+}
+
+func (s *Scheduler) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	// Check right away so runs missed while the server was down are handled on startup
+	s.checkAll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkAll(ctx)
+		}
+	}
+}
+
+// NextRuns returns the next n times a schedule fires after the given time, in the schedule's time zone.
+// Days in a skip calendar never fire; days in a force calendar fire at the cron's times of day
+// even when the cron's day fields do not match. Skip wins when a day is in both.
+func NextRuns(schedule store.Schedule, calendars []store.Calendar, after time.Time, n int) ([]time.Time, error) {
+	expr, err := cron.Parse(schedule.Cron)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	dayOK := func(day time.Time, matches bool) bool {
+		date := day.Format(time.DateOnly)
+		forced := false
+		for _, c := range calendars {
+			if !c.Contains(date) {
+				continue
+			}
+			if c.Mode == store.CalendarSkip {
+				return false
+			}
+			forced = forced || c.Mode == store.CalendarForce
+		}
+		return matches || forced
+	}
+
+	times := make([]time.Time, 0, n)
+	t := after.In(loc)
+	for len(times) < n {
+		t = expr.NextFunc(t, dayOK)
+		if t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+func (s *Scheduler) checkAll(ctx context.Context) {
+	schedules, err := s.store.GetAllSchedules()
+	if err != nil {
+		slog.Error("failed to get schedules", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, sc := range schedules {
+		if sc.Enabled {
+			s.check(ctx, sc, now)
+		}
+	}
+}
+
+func (s *Scheduler) check(ctx context.Context, sc store.Schedule, now time.Time) {
+	since := sc.UpdatedAt
+	if sc.LastRunAt != nil && sc.LastRunAt.After(since) {
+		since = *sc.LastRunAt
+	}
+
+	// Collapse every run due since the last check into the latest one
+	calendars := s.store.GetCalendarsForSchedule(sc)
+	due, err := NextRuns(sc, calendars, since, 1)
+	if err != nil {
+		slog.Error("invalid schedule", "schedule_id", sc.ID, "error", err)
+		return
+	}
+	if len(due) == 0 || due[0].After(now) {
+		return
+	}
+	latest, skipped := due[0], 0
+	for {
+		next, _ := NextRuns(sc, calendars, latest, 1)
+		if len(next) == 0 || next[0].After(now) {
+			break
+		}
+		latest = next[0]
+		skipped++
+	}
+
+	if err := s.store.SetScheduleLastRun(sc.ID, latest); err != nil {
+		slog.Error("failed to record schedule run", "schedule_id", sc.ID, "error", err)
+		return
+	}
+
+	// A run is missed when it is older than a couple of ticks, e.g. the server was down
+	missed := now.Sub(latest) > 2*s.interval
+	if missed && sc.MissedRunPolicy != store.MissedRunOnce {
+		entry := store.NewScheduleRun(sc.ID, latest)
+		entry.Missed = true
+		entry.Status = string(RunSkipped)
+		entry.Message = fmt.Sprintf("%d run(s) missed while the scheduler was not running", skipped+1)
+		if err := s.store.SaveScheduleRun(*entry); err != nil {
+			slog.Error("failed to save schedule run", "schedule_id", sc.ID, "error", err)
+		}
+		slog.Warn("schedule run missed", "schedule_id", sc.ID, "scheduled_for", latest, "missed", skipped+1)
+		return
+	}
+
+	s.fire(ctx, sc, latest, missed)
+}
+
+func (s *Scheduler) fire(ctx context.Context, sc store.Schedule, scheduledFor time.Time, missed bool) {
+	entry := store.NewScheduleRun(sc.ID, scheduledFor)
+	entry.Missed = missed
+
+	steps, sceneID, err := s.steps(sc)
+	if err != nil {
+		entry.Status = string(RunFailed)
+		entry.Message = err.Error()
+		if err := s.store.SaveScheduleRun(*entry); err != nil {
+			slog.Error("failed to save schedule run", "schedule_id", sc.ID, "error", err)
+		}
+		slog.Error("schedule target unavailable", "schedule_id", sc.ID, "error", err)
+		return
+	}
+
+	run := s.runner.Start(sc.Username, "Schedule: "+sc.Name, sceneID, steps)
+	entry.Status = string(RunRunning)
+	entry.RunID = run.ID
+	if err := s.store.SaveScheduleRun(*entry); err != nil {
+		slog.Error("failed to save schedule run", "schedule_id", sc.ID, "error", err)
+	}
+	slog.Info("schedule fired", "schedule_id", sc.ID, "scheduled_for", scheduledFor, "missed", missed, "run_id", run.ID)
+
+	// Record the outcome once the run completes
+	go func() {
+		final, ok := s.runner.Wait(ctx, run.ID)
+		if !ok {
+			return
+		}
+		entry.Status = string(final.Status)
+		if err := s.store.SaveScheduleRun(*entry); err != nil {
+			slog.Error("failed to save schedule run", "schedule_id", sc.ID, "error", err)
+		}
+	}()
+}
+
+// steps resolves a schedule's target into runnable steps, on behalf of the schedule's owner.
+func (s *Scheduler) steps(sc store.Schedule) ([]store.SceneStep, string, error) {
+	switch sc.TargetType {
+	case store.TargetDevice:
+		device, err := s.store.GetDeviceForUser(sc.Username, sc.TargetID)
+		if err != nil {
+			return nil, "", err
+		}
+		if sc.Action == "wake" {
+			steps, err := WakeSteps(s.store, *device)
+			return steps, "", err
+		}
+		if _, err := companion.ParseAction(sc.Action); err != nil {
+			return nil, "", err
+		}
+		return []store.SceneStep{{Type: store.StepPower, MACAddress: device.MACAddress, Action: sc.Action}}, "", nil
+	case store.TargetGroup:
+		if _, err := s.store.GetGroupForUser(sc.Username, sc.TargetID); err != nil {
+			return nil, "", err
+		}
+		if sc.Action != "wake" {
+			if _, err := companion.ParseAction(sc.Action); err != nil {
+				return nil, "", err
+			}
+		}
+		devices, err := s.store.GetDevicesForUser(sc.Username)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// One device failing must not stop the rest of the group
+		steps := make([]store.SceneStep, 0)
+		for _, d := range devices {
+			if d.GroupID != sc.TargetID {
+				continue
+			}
+			step := store.SceneStep{Type: store.StepWake, MACAddress: d.MACAddress, ContinueOnError: true}
+			if sc.Action != "wake" {
+				step.Type = store.StepPower
+				step.Action = sc.Action
+			}
+			steps = append(steps, step)
+		}
+		return steps, "", nil
+	case store.TargetScene:
+		scene, err := s.store.GetSceneForUser(sc.Username, sc.TargetID)
+		if err != nil {
+			return nil, "", err
+		}
+		return scene.Steps, scene.ID, nil
+	default:
+		return nil, "", fmt.Errorf("unknown target type: %q", sc.TargetType)
+	}
+}
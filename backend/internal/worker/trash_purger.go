@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"wolite/internal/store"
+)
+
+// TrashPurger removes deleted devices from the trash once the retention period has passed.
+type TrashPurger struct {
+	store    *store.Store
+	keep     time.Duration
+	interval time.Duration
+}
+
+func NewTrashPurger(store *store.Store, keep, interval time.Duration) *TrashPurger {
+	return &TrashPurger{
+		store:    store,
+		keep:     keep,
+		interval: interval,
+	}
+}
+
+func (p *TrashPurger) Start(ctx context.Context) {
+	p.purge()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.purge()
+		}
+	}
+}
+
+func (p *TrashPurger) purge() {
+	purged, err := p.store.PurgeDeletedDevices(time.Now().Add(-p.keep))
+	if err != nil {
+		slog.Error("failed to purge deleted devices", "error", err)
+		return
+	}
+	if purged > 0 {
+		slog.Info("deleted devices purged", "purged", purged)
+	}
+}
@@ -5,8 +5,13 @@ import (
 	"log"
 	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
 	"time"
+	_ "time/tzdata" // schedules need time zones, the container image has no zoneinfo
 	"wolite/internal/api"
+	"wolite/internal/audit"
+	"wolite/internal/auth"
 	"wolite/internal/env"
 	"wolite/internal/store"
 	"wolite/internal/ui"
@@ -15,17 +20,70 @@ import (
 
 func main() {
 	config := env.LoadConfig()
+	err := auth.SetPasswordParams(auth.PasswordParams{
+		Memory:      config.Argon2Memory,
+		Iterations:  config.Argon2Iterations,
+		Parallelism: config.Argon2Parallelism,
+	})
+	if err != nil {
+		log.Fatalf("invalid ARGON2_* settings: %v", err)
+	}
+
+	// Subcommands run against the data directory and exit
+	if len(os.Args) > 1 {
+		if err := runCommand(config, os.Args[1:]); err != nil {
+			log.Fatalf("%s: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	// Held while the server runs, maintenance commands that write the data refuse to run next to it
+	unlock, err := store.LockDataDir(config.DataDir)
+	if err != nil {
+		log.Fatalf("failed to lock the data directory: %v", err)
+	}
+	defer unlock()
+
 	mux := http.NewServeMux()
-	store, err := store.New(config.DatabasePath)
+	master, err := loadMasterKey(config)
+	if err != nil {
+		log.Fatalf("failed to load master key: %v", err)
+	}
+
+	store, err := openStore(config, master)
 	if err != nil {
 		log.Fatalf("failed to initialized JSON database %v", err)
 	}
 
-	apiHandler := api.NewAPI(context.Background(), store, config)
+	keys, err := openKeyring(config, master)
+	if err != nil {
+		log.Fatalf("failed to open JWT keyring: %v", err)
+	}
+
+	auditLog, err := audit.Open(filepath.Join(config.DataDir, "audit.log"))
+	if err != nil {
+		log.Fatalf("failed to open audit log: %v", err)
+	}
+	defer auditLog.Close()
+
+	runner := worker.NewRunner(context.Background(), store, auditLog)
+	apiHandler := api.NewAPI(context.Background(), store, config, runner, keys, auditLog)
 
 	// Start background workers
 	statusChecker := worker.NewStatusChecker(store, 30*time.Second)
 	go statusChecker.Start(context.Background())
+	scheduler := worker.NewScheduler(store, runner, 30*time.Second)
+	go scheduler.Start(context.Background())
+	idleMonitor := worker.NewIdleMonitor(store, auditLog, time.Minute)
+	go idleMonitor.Start(context.Background())
+	if config.AuditRetention > 0 {
+		auditRetention := worker.NewAuditRetention(auditLog, config.AuditRetention, 6*time.Hour)
+		go auditRetention.Start(context.Background())
+	}
+	if config.DeviceTrashRetention > 0 {
+		trashPurger := worker.NewTrashPurger(store, config.DeviceTrashRetention, time.Hour)
+		go trashPurger.Start(context.Background())
+	}
 
 	apiHandler.RegisterRoutesV1(mux)
 
@@ -37,8 +95,8 @@ func main() {
 	mux.Handle("/", uiHandler)
 
 	var handler http.Handler = mux
-	if config.DevMode {
-		handler = api.Cors(mux)
+	if len(config.AllowedOrigins) > 0 {
+		handler = api.Cors(config.AllowedOrigins)(mux)
 	}
 
 	slog.Info("Server starting", "port", config.Port)
@@ -61,6 +61,10 @@ func (a *API) RegisterRoutesV1(mux *http.ServeMux) {
 	handleAuth("POST "+p+"/reboot", a.handleReboot)
 	handleAuth("POST "+p+"/sleep", a.handleSleep)
 	handleAuth("POST "+p+"/hibernate", a.handleHibernate)
+
+	// user activity (protected routes)
+	handleAuth("GET "+p+"/idle", a.handleIdle)
+	handleAuth("POST "+p+"/notify", a.handleNotify)
 }
 
 func (a *API) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"wolcompanion/internal/commands"
+)
+
+const (
+	maxNotifyTitle   = 100
+	maxNotifyMessage = 500
+)
+
+type idleResponse struct {
+	IdleSeconds *int64             `json:"idle_seconds"` // null when the platform cannot tell, e.g. nobody is logged in locally
+	Sessions    []commands.Session `json:"sessions"`
+}
+
+func (a *API) handleIdle(w http.ResponseWriter, r *http.Request) {
+	var resp idleResponse
+
+	idle, err := commands.IdleTime()
+	if err == nil {
+		seconds := int64(idle.Seconds())
+		resp.IdleSeconds = &seconds
+	} else if err != commands.ErrIdleUnknown {
+		slog.Warn("failed to read idle time", "error", err)
+	}
+
+	resp.Sessions, err = commands.Sessions()
+	if err != nil {
+		writeRespErr(w, "failed to list sessions", http.StatusInternalServerError)
+		slog.Error("failed to list sessions", "error", err)
+		return
+	}
+
+	writeRespOk(w, "idle status", resp)
+}
+
+type notifyRequest struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+func (a *API) handleNotify(w http.ResponseWriter, r *http.Request) {
+	var req notifyRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeRespErr(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Title = strings.TrimSpace(req.Title)
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Title == "" || req.Message == "" {
+		writeRespErr(w, "title and message are required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Title) > maxNotifyTitle || len(req.Message) > maxNotifyMessage {
+		writeRespErr(w, "title or message too long", http.StatusBadRequest)
+		return
+	}
+
+	if err := commands.Notify(req.Title, req.Message); err != nil {
+		writeRespErr(w, "failed to show notification", http.StatusInternalServerError)
+		slog.Error("failed to show notification", "error", err, "ip", r.RemoteAddr)
+		return
+	}
+
+	writeRespOk(w, "notification shown", nil)
+	slog.Info("notification shown", "title", req.Title, "ip", r.RemoteAddr)
+}
@@ -0,0 +1,274 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrIdleUnknown is returned when the platform cannot report input idle time, e.g. no user is logged in.
+var ErrIdleUnknown = errors.New("idle time unknown")
+
+// Session is a logged-in user session.
+type Session struct {
+	User     string `json:"user"`
+	Terminal string `json:"terminal,omitempty"` // tty, display or Windows session name
+	Remote   bool   `json:"remote"`             // e.g. SSH or RDP
+	Host     string `json:"host,omitempty"`     // remote host, if known
+}
+
+// IdleTime returns the time since the last keyboard or mouse input of any local user.
+func IdleTime() (time.Duration, error) {
+	switch runtime.GOOS {
+	case "windows":
+		if _, err := exec.LookPath("powershell"); err != nil {
+			return 0, fmt.Errorf("powershell not found")
+		}
+		// GetLastInputInfo only sees the session the companion runs in, which is the desktop session
+		psCmd := `Add-Type 'using System;using System.Runtime.InteropServices;public static class Idle{` +
+			`[StructLayout(LayoutKind.Sequential)]struct LII{public uint cbSize;public uint dwTime;}` +
+			`[DllImport("user32.dll")]static extern bool GetLastInputInfo(ref LII l);` +
+			`public static ulong Ms(){LII l=new LII();l.cbSize=(uint)Marshal.SizeOf(l);GetLastInputInfo(ref l);` +
+			`return (ulong)Environment.TickCount-(ulong)l.dwTime;}}'; [Idle]::Ms()`
+		out, err := exec.Command("powershell", "-NoProfile", "-Command", psCmd).Output()
+		if err != nil {
+			return 0, err
+		}
+		ms, err := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("unexpected GetLastInputInfo output: %q", out)
+		}
+		return time.Duration(ms) * time.Millisecond, nil
+	case "linux":
+		if _, err := exec.LookPath("loginctl"); err != nil {
+			return 0, fmt.Errorf("loginctl not found")
+		}
+		return loginctlIdle()
+	case "darwin":
+		if _, err := exec.LookPath("ioreg"); err != nil {
+			return 0, fmt.Errorf("ioreg not found")
+		}
+		out, err := exec.Command("ioreg", "-c", "IOHIDSystem", "-d", "4").Output()
+		if err != nil {
+			return 0, err
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if _, value, ok := strings.Cut(line, `"HIDIdleTime" = `); ok {
+				ns, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+				if err != nil {
+					return 0, fmt.Errorf("unexpected HIDIdleTime value: %q", value)
+				}
+				return time.Duration(ns), nil
+			}
+		}
+		return 0, ErrIdleUnknown
+	default:
+		return 0, fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+}
+
+// loginctlIdle returns the idle time of the most recently active local user session.
+// Desktop environments report idleness to logind through the IdleHint property.
+func loginctlIdle() (time.Duration, error) {
+	ids, err := loginctlSessions()
+	if err != nil {
+		return 0, err
+	}
+
+	idle, found := time.Duration(0), false
+	for _, id := range ids {
+		props, err := loginctlShow(id, "Class", "Remote", "IdleHint", "IdleSinceHint")
+		if err != nil || props["Class"] != "user" || props["Remote"] == "yes" {
+			continue
+		}
+
+		d := time.Duration(0)
+		if props["IdleHint"] == "yes" {
+			usec, err := strconv.ParseInt(props["IdleSinceHint"], 10, 64)
+			if err != nil || usec == 0 {
+				continue
+			}
+			d = time.Since(time.UnixMicro(usec))
+		}
+		if !found || d < idle {
+			idle, found = d, true
+		}
+	}
+
+	if !found {
+		return 0, ErrIdleUnknown
+	}
+	return idle, nil
+}
+
+// Sessions returns the users currently logged in, locally or remotely.
+func Sessions() ([]Session, error) {
+	switch runtime.GOOS {
+	case "windows":
+		// query.exe exits non-zero when nobody is logged in
+		out, _ := exec.Command("query", "user").Output()
+		return parseQueryUser(out), nil
+	case "linux":
+		if _, err := exec.LookPath("loginctl"); err == nil {
+			return loginctlUserSessions()
+		}
+		fallthrough
+	case "darwin":
+		if _, err := exec.LookPath("who"); err != nil {
+			return nil, fmt.Errorf("who not found")
+		}
+		out, err := exec.Command("who").Output()
+		if err != nil {
+			return nil, err
+		}
+		return parseWho(out), nil
+	default:
+		return nil, fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+}
+
+func loginctlUserSessions() ([]Session, error) {
+	ids, err := loginctlSessions()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(ids))
+	for _, id := range ids {
+		props, err := loginctlShow(id, "Name", "Class", "TTY", "Display", "Remote", "RemoteHost")
+		if err != nil || props["Class"] != "user" {
+			continue
+		}
+		terminal := props["TTY"]
+		if terminal == "" {
+			terminal = props["Display"]
+		}
+		sessions = append(sessions, Session{
+			User:     props["Name"],
+			Terminal: terminal,
+			Remote:   props["Remote"] == "yes",
+			Host:     props["RemoteHost"],
+		})
+	}
+	return sessions, nil
+}
+
+func loginctlSessions() ([]string, error) {
+	out, err := exec.Command("loginctl", "list-sessions", "--no-legend").Output()
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			ids = append(ids, fields[0])
+		}
+	}
+	return ids, nil
+}
+
+func loginctlShow(id string, props ...string) (map[string]string, error) {
+	args := []string{"show-session", id}
+	for _, p := range props {
+		args = append(args, "--property="+p)
+	}
+	out, err := exec.Command("loginctl", args...).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(props))
+	for _, line := range strings.Split(string(out), "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			values[k] = strings.TrimSpace(v)
+		}
+	}
+	return values, nil
+}
+
+// parseWho parses `who` output, e.g. "alice    pts/0    2025-01-06 09:00 (192.168.1.20)".
+func parseWho(out []byte) []Session {
+	sessions := make([]Session, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		s := Session{User: fields[0], Terminal: fields[1]}
+		if last := fields[len(fields)-1]; strings.HasPrefix(last, "(") && strings.HasSuffix(last, ")") {
+			host := strings.Trim(last, "()")
+			// Local X displays show up as (:0)
+			if !strings.HasPrefix(host, ":") {
+				s.Remote = true
+				s.Host = host
+			}
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// parseQueryUser parses Windows `query user` output. The session name column is empty for disconnected sessions.
+//
+//	USERNAME              SESSIONNAME        ID  STATE   IDLE TIME  LOGON TIME
+//	>alice                console             1  Active      none   1/6/2025 9:00 AM
+func parseQueryUser(out []byte) []Session {
+	sessions := make([]Session, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for first := true; scanner.Scan(); first = false {
+		fields := strings.Fields(scanner.Text())
+		if first || len(fields) < 3 {
+			continue
+		}
+		s := Session{User: strings.TrimPrefix(fields[0], ">")}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			s.Terminal = fields[1]
+			s.Remote = strings.HasPrefix(strings.ToLower(fields[1]), "rdp-")
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// Notify shows a desktop notification to the logged-in users.
+func Notify(title, message string) error {
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "windows":
+		if _, err := exec.LookPath("msg"); err != nil {
+			return fmt.Errorf("msg executable not found")
+		}
+		// * = every session, /TIME = seconds before the message closes
+		cmd = exec.Command("msg", "*", "/TIME:300", title+": "+message)
+	case "linux":
+		if _, err := exec.LookPath("notify-send"); err == nil {
+			cmd = exec.Command("notify-send", "--urgency=critical", "--", title, message)
+		} else if _, err := exec.LookPath("wall"); err == nil {
+			cmd = exec.Command("wall", title+": "+message)
+		} else {
+			return fmt.Errorf("neither notify-send nor wall executables found")
+		}
+	case "darwin":
+		if _, err := exec.LookPath("osascript"); err != nil {
+			return fmt.Errorf("osascript not found")
+		}
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(message), appleScriptString(title))
+		cmd = exec.Command("osascript", "-e", script)
+	default:
+		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+
+	return cmd.Run()
+}
+
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -17,6 +17,35 @@ export interface ApiClientOptions extends RequestInit {
 	responseType?: 'json' | 'text' | 'blob' | 'arrayBuffer';
 }
 
+const CSRF_COOKIE = 'csrf_token';
+const SAFE_METHODS = ['GET', 'HEAD', 'OPTIONS'];
+
+/**
+ * Reads the CSRF token the backend sets next to the session cookie.
+ * Only available in the browser; server-side requests carry no session cookie.
+ */
+function readCsrfToken(): string | null {
+	if (typeof document === 'undefined') return null;
+	const match = document.cookie.split('; ').find((c) => c.startsWith(`${CSRF_COOKIE}=`));
+	return match ? decodeURIComponent(match.slice(CSRF_COOKIE.length + 1)) : null;
+}
+
+/**
+ * Returns the CSRF token, asking the backend for one when the cookie is missing
+ * (sessions from older versions, forward auth). Not logged in yields null.
+ */
+async function csrfToken(kitFetch: typeof fetch): Promise<string | null> {
+	const token = readCsrfToken();
+	if (token || typeof document === 'undefined') return token;
+	try {
+		const response = await kitFetch(`${BASE_URL}/auth/csrf`, { credentials: 'include' });
+		if (!response.ok) return null;
+		return (await response.json()).data?.token ?? null;
+	} catch {
+		return null;
+	}
+}
+
 export async function apiClient<T>(
 	kitFetch: typeof fetch,
 	path: string,
@@ -27,11 +56,19 @@ export async function apiClient<T>(
 	// destructure custom timeout with option
 	const { timeout = 15000, responseType = 'json', ...fetchOptions } = options;
 
+	// State-changing requests echo the CSRF token, which other sites cannot read
+	const headers = new Headers(fetchOptions.headers);
+	if (!SAFE_METHODS.includes((fetchOptions.method ?? 'GET').toUpperCase())) {
+		const token = await csrfToken(kitFetch);
+		if (token) headers.set('X-CSRF-Token', token);
+	}
+
 	const controller = new AbortController();
 	const timeoutId = setTimeout(() => controller.abort(), timeout);
 
 	const mergedOptions: RequestInit = {
 		...fetchOptions,
+		headers,
 		signal: controller.signal,
 		credentials: 'include' // Important: include cookies for auth
 	};
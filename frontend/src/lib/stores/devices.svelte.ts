@@ -185,7 +185,7 @@ class DeviceStore {
 		for (const mac of newOrder) {
 			const d = deviceMap.get(mac);
 			if (d) {
-				reordered.push({ ...d, order: reordered.length });
+				reordered.push(d);
 				deviceMap.delete(mac);
 			}
 		}
@@ -9,7 +9,21 @@ export interface Device {
 	companion_url?: string;
 	companion_token?: string;
 	companion_auth_fingerprint?: string;
-	order?: number;
+	tags?: string[];
+	group_id?: string;
+	depends_on?: string[];
+	idle_policy?: IdlePolicy;
+}
+
+// IdlePolicy powers a device down through its companion after a period of user inactivity
+export interface IdlePolicy {
+	enabled: boolean;
+	idle_minutes: number;
+	action: 'shutdown' | 'reboot' | 'sleep' | 'hibernate';
+	window_start?: string; // HH:MM
+	window_end?: string; // HH:MM
+	timezone?: string;
+	warning_minutes?: number;
 }
 
 // API Response wrapper from backend
@@ -23,6 +37,8 @@ export interface ApiResponse<T> {
 export interface User {
 	username: string;
 	has_otp: boolean;
+	role?: 'admin' | 'user';
+	recovery_codes_remaining?: number;
 }
 
 // Auth response for login/setup
@@ -30,3 +46,154 @@ export interface AuthResponse {
 	qr_code?: string; // base64 encoded QR code image for OTP setup
 	secret?: string; // OTP secret (only during setup)
 }
+
+// Session is an active browser login of the current user
+export interface Session {
+	id: string;
+	created_at: string;
+	expires_at: string;
+	last_seen_at: string;
+	user_agent?: string;
+	ip?: string;
+	current: boolean;
+}
+
+// Passkey is a WebAuthn credential registered by the current user
+export interface Passkey {
+	id: string; // base64url credential ID
+	name: string;
+	backup_eligible: boolean; // synced passkey rather than a device-bound security key
+	created_at: string;
+	last_used_at?: string;
+}
+
+// AuthProviders lists the login methods besides username and password
+export interface AuthProviders {
+	oidc?: {
+		name: string;
+		login_url: string;
+	};
+}
+
+// ReauthResponse confirms a re-authentication. Sensitive actions (powering off, deleting devices,
+// creating tokens) answer 403 "Recent authentication required" once valid_until has passed.
+export interface ReauthResponse {
+	auth_time: string;
+	valid_until: string;
+}
+
+// ShareLink wakes one device without an account, until it expires, is used up or revoked
+export interface ShareLink {
+	id: string;
+	name: string; // who the link is for
+	mac_address: string;
+	max_uses: number;
+	uses: { time: string; ip?: string; user_agent?: string }[];
+	pin_failures?: number;
+	has_pin: boolean;
+	status: 'active' | 'expired' | 'used_up' | 'revoked' | 'locked';
+	created_at: string;
+	expires_at: string;
+	revoked_at?: string;
+	token?: string; // only when created
+	url?: string; // only when created
+}
+
+// ShareLinkInfo is what the public landing page of a share link shows
+export interface ShareLinkInfo {
+	name: string;
+	device_name: string;
+	expires_at: string;
+	uses_left: number;
+	pin_required: boolean;
+	status: ShareLink['status'];
+}
+
+// DeviceAccess is a user's level on a shared device: owners control it, requesters may only ask for a wake
+export interface DeviceAccess {
+	username: string;
+	access: 'owner' | 'request';
+}
+
+// WakeRequest asks the owners of a device to wake it, approved or denied through the API
+export interface WakeRequest {
+	id: string;
+	mac_address: string;
+	device_name: string;
+	requester: string;
+	reason?: string;
+	status: 'pending' | 'approved' | 'denied' | 'cancelled' | 'expired';
+	created_at: string;
+	expires_at: string;
+	decided_by?: string;
+	decided_at?: string;
+	note?: string;
+	error?: string; // the approved wake failed
+}
+
+// Notification is an in-app message, e.g. about a wake request
+export interface Notification {
+	id: string;
+	kind: 'wake_requested' | 'wake_approved' | 'wake_denied';
+	message: string;
+	ref_id?: string;
+	created_at: string;
+	read_at?: string;
+}
+
+// AuditEntry is one line of the audit log
+export interface AuditEntry {
+	time: string;
+	action: string;
+	actor?: string;
+	username?: string;
+	target?: string;
+	ip?: string;
+	result?: 'success' | 'failure';
+	detail?: string;
+}
+
+export interface AuditPage {
+	entries: AuditEntry[];
+	total: number;
+	offset: number;
+	limit: number;
+}
+
+// DeviceConfig is the versioned part of a device
+export interface DeviceConfig {
+	name: string;
+	description: string;
+	ip_address: string;
+	broadcast_ip: string;
+	tags: string[] | null;
+	depends_on: string[] | null;
+	idle_policy: IdlePolicy | null;
+}
+
+export interface DeviceRevision {
+	id: string;
+	mac_address: string;
+	number: number;
+	kind: 'created' | 'updated' | 'restored' | 'deleted' | 'recovered';
+	username: string;
+	time: string;
+	changes: { field: keyof DeviceConfig; old: unknown; new: unknown }[];
+	config: DeviceConfig;
+	restored_from?: number;
+}
+
+// DeletedDevice is a device in the trash
+export interface DeletedDevice {
+	device: Device;
+	dependents?: string[];
+	deleted_by: string;
+	deleted_at: string;
+	purge_at?: string;
+}
+
+// BackupImportResult counts the records of a restored backup by collection, e.g. "users" or "devices"
+export interface BackupImportResult {
+	imported: Record<string, number>;
+	skipped: Record<string, number>;
+}